@@ -2,46 +2,149 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	gosync "sync"
 	"syscall"
 	"time"
 
 	"krelease-tracker/internal/api"
+	"krelease-tracker/internal/archive"
 	"krelease-tracker/internal/config"
 	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/federation"
 	"krelease-tracker/internal/kubernetes"
+	"krelease-tracker/internal/logging"
+	"krelease-tracker/internal/metrics"
+	"krelease-tracker/internal/notify"
 	"krelease-tracker/internal/ping"
+	"krelease-tracker/internal/registry"
 	"krelease-tracker/internal/sync"
+	"krelease-tracker/internal/tracing"
+	"krelease-tracker/internal/version"
 )
 
 func main() {
-	log.Println("Starting Release Tracker...")
-
 	// Load configuration
 	cfg := config.Load()
-	log.Printf("Configuration loaded: Port=%s, DatabasePath=%s, Namespaces=%v, Mode=%s",
-		cfg.Port, cfg.DatabasePath, cfg.Namespaces, cfg.Mode)
+	logging.Setup(cfg.LogFormat, cfg.LogLevel)
+	slog.Info("Starting Release Tracker...", "version", version.Version)
+	slog.Info("Configuration loaded", "port", cfg.Port, "database_path", cfg.DatabasePath, "namespaces", cfg.Namespaces, "mode", cfg.Mode)
+
+	// Export trace spans of collections, syncs and requests if configured
+	if cfg.TracingEndpoint != "" {
+		if err := tracing.Setup(cfg.TracingEndpoint); err != nil {
+			slog.Error("Failed to set up tracing", "endpoint", cfg.TracingEndpoint, "error", err)
+		} else {
+			slog.Info("Tracing enabled", "endpoint", cfg.TracingEndpoint)
+		}
+	}
 
 	// Initialize database
 	db, err := database.New(cfg.DatabasePath)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logging.Fatal("Failed to initialize database", "error", err)
 	}
-	log.Println("Database initialized")
+	db.SetPoolLimits(cfg.DBMaxOpenConns, cfg.DBMaxIdleConns, time.Duration(cfg.DBConnMaxLifetime)*time.Minute)
+	db.SetPingThresholds(time.Duration(cfg.PingWarningMinutes)*time.Minute, time.Duration(cfg.PingOfflineMinutes)*time.Minute)
+	slog.Info("Database initialized")
+
+	// Lowercase stored names once so they match the normalized incoming names
+	if cfg.LowercaseNames {
+		if err := db.NormalizeClientEnvNames(true); err != nil {
+			logging.Fatal("Failed to normalize client and environment names", "error", err)
+		}
+	}
+
+	// Prometheus metrics served on /metrics
+	m := metrics.New()
 
 	// Initialize Kubernetes client
 	k8s, err := kubernetes.New(cfg.InCluster, cfg.KubeconfigPath, cfg.Namespaces, cfg.Mode)
 	if err != nil {
-		log.Fatalf("Failed to initialize Kubernetes client: %v", err)
+		logging.Fatal("Failed to initialize Kubernetes client", "error", err)
 	}
-	log.Println("Kubernetes client initialized")
+	k8s.SetExcludedNamespaces(cfg.NamespacesExclude)
+	k8s.SetNamespaceLabelSelector(cfg.NamespaceSelector)
+	k8s.SetSHAAcceptPhases(cfg.SHAAcceptPhases)
+	k8s.SetPodLabelSelectors(cfg.PodLabelSelectors)
+	k8s.SetTrackRestarts(cfg.TrackRestarts)
+	k8s.SetTrackScaling(cfg.TrackScaling)
+	k8s.SetLowercaseNames(cfg.LowercaseNames)
+	k8s.SetMaxConcurrentCalls(cfg.MaxConcurrentCalls)
+	k8s.SetCollectionConcurrency(cfg.CollectionWorkers)
+	k8s.SetStaleThreshold(time.Duration(cfg.StaleThreshold) * time.Hour)
+	k8s.SetCleanupMinAge(time.Duration(cfg.CleanupMinAge) * time.Minute)
+	k8s.SetHistoryRetention(cfg.HistoryRetention)
+	k8s.SetVersionLabels(cfg.ChartVersionLabel, cfg.AppVersionLabel)
+	k8s.SetOrderLabel(cfg.OrderLabel)
+	k8s.SetTrackedAnnotations(cfg.TrackedAnnotations)
+	if len(cfg.ImageLabels) > 0 {
+		k8s.SetRegistry(registry.New(cfg.ImageLabels))
+		slog.Info("Image label enrichment enabled", "labels", cfg.ImageLabels)
+	}
+	k8s.SetMetrics(m)
+	slog.Info("Kubernetes client initialized")
 
 	// Initialize API server
 	apiServer := api.New(db, k8s, cfg)
-	log.Println("API server initialized")
+	apiServer.SetMetrics(m)
+	slog.Info("API server initialized")
+
+	// Federate read endpoints with upstream masters if configured
+	if cfg.Mode == "master" && len(cfg.UpstreamMasters) > 0 {
+		apiServer.SetFederation(federation.New(cfg.UpstreamMasters, cfg.UpstreamAPIKey, time.Duration(cfg.UpstreamCacheTTL)*time.Second))
+		slog.Info("Federation enabled", "upstream_masters", cfg.UpstreamMasters)
+	}
+
+	// Export version changes to OpenTelemetry if configured
+	if cfg.OTLPEndpoint != "" {
+		exporter := notify.NewOTLPExporter(cfg.OTLPEndpoint)
+		k8s.SetNotifier(exporter)
+		apiServer.SetNotifier(exporter)
+		slog.Info("OTLP export of version changes enabled", "endpoint", cfg.OTLPEndpoint)
+	}
+
+	// Background workers run until shutdown cancels workersCtx; workers tracks those that must return
+	// before the database is closed
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers gosync.WaitGroup
+
+	// Reload the API keys and namespaces when the configuration file changes; in slave mode a namespace
+	// change reschedules the periodic collection
+	namespacesChanged := make(chan struct{}, 1)
+	if cfg.ConfigFile != "" {
+		namespaces := cfg.Namespaces
+		err := config.WatchFile(workersCtx, cfg.ConfigFile, func() {
+			slog.Info("Configuration file changed", "file", cfg.ConfigFile)
+			reloaded := config.Load()
+			if len(cfg.APIKeys) > 0 || len(reloaded.APIKeys) > 0 {
+				if added, removed, err := apiServer.ReloadAPIKeys(); err != nil {
+					slog.Warn("API keys not reloaded", "error", err)
+				} else {
+					slog.Info("API keys reloaded", "added", added, "removed", removed)
+				}
+			}
+			if !slices.Equal(namespaces, reloaded.Namespaces) {
+				namespaces = reloaded.Namespaces
+				k8s.SetNamespaces(namespaces)
+				slog.Info("Namespaces reloaded", "namespaces", namespaces)
+				select {
+				case namespacesChanged <- struct{}{}:
+				default:
+				}
+			}
+		})
+		if err != nil {
+			slog.Error("Configuration file changes will not be reloaded", "error", err)
+		} else {
+			slog.Info("Watching configuration file", "file", cfg.ConfigFile)
+		}
+	}
 
 	// Create HTTP server
 	server := &http.Server{
@@ -54,67 +157,164 @@ func main() {
 
 	// Start periodic collection in background (only in slave mode)
 	if cfg.Mode == "slave" {
-		log.Println("Starting periodic collection (slave mode)")
+		slog.Info("Starting periodic collection (slave mode)", "collection_mode", cfg.CollectionMode)
+		overrides := make(map[string]time.Duration)
+		for namespace, minutes := range cfg.NamespaceIntervals {
+			overrides[namespace] = time.Duration(minutes) * time.Minute
+		}
+		groups := kubernetes.GroupNamespaces(k8s.Namespaces(), time.Duration(cfg.CollectionInterval)*time.Minute, overrides)
+
+		// Any group collecting successfully counts, so /health expects a success within the shortest interval
+		var healthInterval time.Duration
+		for _, group := range groups {
+			if healthInterval == 0 || group.Interval < healthInterval {
+				healthInterval = group.Interval
+			}
+		}
+		apiServer.WorkerStarted(api.WorkerCollection, healthInterval)
+
+		workers.Add(1)
 		go func() {
-			ticker := time.NewTicker(time.Duration(cfg.CollectionInterval) * time.Minute)
-			defer ticker.Stop()
+			defer workers.Done()
+			defer apiServer.WorkerStopped(api.WorkerCollection)
 
-			// Initial collection and sync
-			log.Println("Performing initial collection...")
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			// Initial collection and sync; like the periodic collections below, it is finished rather
+			// than aborted when shutdown starts
+			slog.Info("Performing initial collection...")
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(workersCtx), 5*time.Minute)
 			if err := k8s.CollectReleases(ctx, db); err != nil {
-				log.Printf("Initial collection failed: %v", err)
+				slog.Error("Initial collection failed", "error", err)
 			} else {
-				log.Println("Initial collection completed")
+				slog.Info("Initial collection completed")
+				apiServer.WorkerSucceeded(api.WorkerCollection)
 				// Force first sync after initial collection
 				syncClient := sync.New(cfg.MasterURL, cfg.MasterAPIKey, db, cfg.ProxyURL, cfg.TLSInsecure)
+				syncClient.SetSchemaVersion(cfg.SyncSchemaVersion)
+				syncClient.SetExtraHeaders(cfg.SyncExtraHeaders)
+				syncClient.SetMaxRetries(cfg.SyncMaxRetries)
+				syncClient.SetMaxAttempts(cfg.SyncMaxAttempts)
+				syncClient.SetDedupWindow(time.Duration(cfg.SyncDedupWindow) * time.Minute)
+				syncClient.SetMetrics(m)
 				if err := syncClient.SyncPendingReleases(ctx); err != nil {
-					log.Printf("Initial sync failed: %v", err)
+					slog.Error("Initial sync failed", "error", err)
 				} else {
-					log.Println("Initial sync completed")
+					slog.Info("Initial sync completed")
+					apiServer.WorkerSucceeded(api.WorkerSync)
 				}
 			}
 			cancel()
 
-			// Periodic collection
-			for {
+			// React to workload changes between collections in watch mode
+			if cfg.CollectionMode == config.CollectionWatch && workersCtx.Err() == nil {
+				if err := k8s.WatchWorkloads(workersCtx, db); err != nil {
+					slog.Error("Failed to watch workloads, relying on periodic collection", "error", err)
+				}
+			}
+
+			// A collection in progress is not cancelled with ctx, so shutdown waits for it to complete
+			collect := func(ctx context.Context, namespaces []string) {
+				slog.Info("Starting periodic collection...", "namespaces", namespaces)
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
+				defer cancel()
+				if err := k8s.CollectNamespaces(ctx, db, namespaces); err != nil {
+					slog.Error("Periodic collection failed", "namespaces", namespaces, "error", err)
+				} else {
+					slog.Info("Periodic collection completed", "namespaces", namespaces)
+					apiServer.WorkerSucceeded(api.WorkerCollection)
+				}
+			}
+
+			// Periodic collection, one ticker per namespace interval group, regrouped and run once right
+			// away when the namespaces are reloaded, until shutdown
+			for workersCtx.Err() == nil {
+				for _, group := range groups {
+					slog.Info("Scheduling namespace collection", "namespaces", group.Namespaces, "interval", group.Interval.String())
+				}
+				groupsCtx, stopGroups := context.WithCancel(workersCtx)
+				stopped := make(chan struct{})
+				go func() {
+					defer close(stopped)
+					kubernetes.RunCollectionGroups(groupsCtx, groups, collect)
+				}()
+
 				select {
-				case <-ticker.C:
-					log.Println("Starting periodic collection...")
-					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-					if err := k8s.CollectReleases(ctx, db); err != nil {
-						log.Printf("Periodic collection failed: %v", err)
-					} else {
-						log.Println("Periodic collection completed")
-					}
-					cancel()
+				case <-namespacesChanged:
+				case <-workersCtx.Done():
+				}
+				stopGroups()
+				<-stopped
+				if workersCtx.Err() != nil {
+					break
 				}
+				groups = kubernetes.GroupNamespaces(k8s.Namespaces(), time.Duration(cfg.CollectionInterval)*time.Minute, overrides)
+				collect(workersCtx, k8s.Namespaces())
 			}
 		}()
 	} else {
-		log.Println("Periodic collection disabled (master mode)")
+		slog.Info("Periodic collection disabled (master mode)")
 	}
 
 	// Start sync worker in slave mode
+	var pingClient *ping.Client
 	if cfg.Mode == "slave" && cfg.MasterURL != "" {
-		log.Printf("Starting sync worker (slave mode) - Master URL: %s, Sync Interval: %d minutes", cfg.MasterURL, cfg.SyncInterval)
+		slog.Info("Starting sync worker (slave mode)", "master", cfg.MasterURL, "interval_minutes", cfg.SyncInterval)
 
 		syncClient := sync.New(cfg.MasterURL, cfg.MasterAPIKey, db, cfg.ProxyURL, cfg.TLSInsecure)
-		go syncClient.StartSyncWorker(context.Background(), time.Duration(cfg.SyncInterval)*time.Minute)
+		syncClient.SetSchemaVersion(cfg.SyncSchemaVersion)
+		syncClient.SetExtraHeaders(cfg.SyncExtraHeaders)
+		syncClient.SetMaxRetries(cfg.SyncMaxRetries)
+		syncClient.SetMaxAttempts(cfg.SyncMaxAttempts)
+		syncClient.SetDedupWindow(time.Duration(cfg.SyncDedupWindow) * time.Minute)
+		syncClient.SetFailedRetention(time.Duration(cfg.FailedRetention) * 24 * time.Hour)
+		syncClient.SetMetrics(m)
+		syncClient.SetOnSynced(func() { apiServer.WorkerSucceeded(api.WorkerSync) })
+		apiServer.WorkerStarted(api.WorkerSync, time.Duration(cfg.SyncInterval)*time.Minute)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			defer apiServer.WorkerStopped(api.WorkerSync)
+			syncClient.StartSyncWorker(workersCtx, time.Duration(cfg.SyncInterval)*time.Minute)
+		}()
 
 		// Start ping worker for health monitoring
-		log.Printf("Starting ping worker (slave mode) - Ping Interval: 5 minutes")
-		pingClient := ping.New(cfg.MasterURL, cfg.MasterAPIKey, cfg.ClientName, cfg.EnvName, "v1.0.0", cfg.ProxyURL, cfg.TLSInsecure)
-		go pingClient.StartPingWorker(context.Background(), 5*time.Minute)
+		slog.Info("Starting ping worker (slave mode)", "interval_minutes", 5)
+		pingClient = ping.New(cfg.MasterURL, cfg.MasterAPIKey, cfg.ClientName, cfg.EnvName, "v"+version.Version, cfg.ProxyURL, cfg.TLSInsecure)
+		pingClient.SetSchemaVersion(cfg.SyncSchemaVersion)
+		pingClient.SetExtraHeaders(cfg.SyncExtraHeaders)
+		pingClient.SetClusterName(cfg.ClusterName)
+		// Namespaces are resolved at each ping, so reloads and namespaces matched since are reported
+		pingClient.SetCollectionInfo(k8s.ResolvedNamespaces, cfg.CollectionInterval)
+		pingClient.SetMetrics(m)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			pingClient.StartPingWorker(workersCtx, 5*time.Minute)
+		}()
 	} else if cfg.Mode == "slave" {
-		log.Println("Sync worker disabled - MASTER_URL not configured")
+		slog.Warn("Sync worker disabled - MASTER_URL not configured")
+	}
+
+	// Start archive worker if an archive target is configured
+	if cfg.ArchiveS3Endpoint != "" {
+		uploader, err := archive.NewS3Uploader(cfg.ArchiveS3Endpoint, cfg.ArchiveS3Bucket, cfg.ArchiveS3Region,
+			cfg.ArchiveS3AccessKey, cfg.ArchiveS3SecretKey, cfg.ArchiveS3Prefix)
+		if err != nil {
+			logging.Fatal("Failed to initialize archive uploader", "error", err)
+		}
+		slog.Info("Starting archive worker", "bucket", cfg.ArchiveS3Bucket, "interval_minutes", cfg.ArchiveInterval)
+		archiveWorker := archive.New(db, uploader)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			archiveWorker.StartArchiveWorker(workersCtx, time.Duration(cfg.ArchiveInterval)*time.Minute)
+		}()
 	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
+		slog.Info("Server starting", "port", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+			logging.Fatal("Server failed to start", "error", err)
 		}
 	}()
 
@@ -122,20 +322,52 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server...")
+
+	// Stop scheduling collections, syncs and pings; work already in progress is drained below
+	stopWorkers()
+
+	// Tell master right away rather than letting it notice the missing pings
+	if pingClient != nil {
+		offlineCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := pingClient.SendOffline(offlineCtx); err != nil {
+			slog.Warn("Failed to notify master of the shutdown", "error", err)
+		}
+		cancel()
+	}
 
 	// Give outstanding requests a deadline for completion
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		logging.Fatal("Server forced to shutdown", "error", err)
+	}
+
+	// Wait for an in-flight collection or sync, periodic or triggered through the API, to finish before
+	// closing the database under it
+	drained := make(chan struct{})
+	go func() {
+		workers.Wait()
+		apiServer.WaitCollections()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		slog.Info("Background workers stopped")
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for background workers to stop", "error", ctx.Err())
+	}
+
+	// Export the spans still queued
+	if err := tracing.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to export trace spans", "error", err)
 	}
 
 	// Close database connection
 	if err := db.Close(); err != nil {
-		log.Printf("Error closing database: %v", err)
+		slog.Error("Error closing database", "error", err)
 	}
 
-	log.Println("Server exited")
+	slog.Info("Server exited")
 }
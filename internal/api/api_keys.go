@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	// errAuthDisabled is returned by ReloadAPIKeys when the server started without API keys: the
+	// authentication middleware is only installed at startup, so keys loaded later would not be enforced
+	errAuthDisabled = errors.New("authentication is disabled, set API_KEYS or API_KEYS_FILE and restart to enable it")
+	// errNoAPIKeys is returned by ReloadAPIKeys when no valid key is configured anymore, which would lock
+	// every client out
+	errNoAPIKeys = errors.New("no valid API keys configured, keeping the current keys")
+)
+
+// currentAPIKeys returns the valid API keys
+func (s *Server) currentAPIKeys() []string {
+	s.apiKeysMu.RLock()
+	defer s.apiKeysMu.RUnlock()
+	return s.apiKeys
+}
+
+// ReloadAPIKeys re-reads the API keys from API_KEYS and API_KEYS_FILE and swaps them in at once, so a
+// leaked key can be revoked without a restart. It returns the number of keys added and removed.
+func (s *Server) ReloadAPIKeys() (added, removed int, err error) {
+	if len(s.currentAPIKeys()) == 0 {
+		return 0, 0, errAuthDisabled
+	}
+	apiKeys, err := s.loadAPIKeys()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(apiKeys) == 0 {
+		return 0, 0, errNoAPIKeys
+	}
+
+	s.apiKeysMu.Lock()
+	previous := make(map[string]bool, len(s.apiKeys))
+	for _, apiKey := range s.apiKeys {
+		previous[apiKey] = true
+	}
+	for _, apiKey := range apiKeys {
+		if previous[apiKey] {
+			delete(previous, apiKey)
+		} else {
+			added++
+		}
+	}
+	removed = len(previous)
+	s.apiKeys = apiKeys
+	s.apiKeysMu.Unlock()
+
+	s.keyUsage.setKeys(apiKeys, s.config.APIKeyLegacyFormat)
+	warnMalformedAPIKeys(apiKeys, s.config.APIKeyLegacyFormat)
+	return added, removed, nil
+}
+
+// warnMalformedAPIKeys logs the configured keys the authentication middleware will reject
+func warnMalformedAPIKeys(apiKeys []string, legacyFormat bool) {
+	for _, apiKey := range apiKeys {
+		if _, _, _, _, err := parseAPIKey(apiKey, legacyFormat); err != nil {
+			slog.Warn("Configured API key will be rejected", "key", apiKey[:min(8, len(apiKey))]+"...", "error", err)
+		}
+	}
+}
+
+// apiKeyInfos describes the valid API keys without revealing them
+func (s *Server) apiKeyInfos() []APIKeyInfo {
+	apiKeys := s.currentAPIKeys()
+	infos := make([]APIKeyInfo, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		infos = append(infos, newAPIKeyInfo(apiKey, s.config.APIKeyLegacyFormat))
+	}
+	return infos
+}
+
+// handleListAPIKeys lists the valid API keys, masked, with their type (admin only)
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	keys := s.apiKeyInfos()
+	response := map[string]interface{}{
+		"keys":      keys,
+		"total":     len(keys),
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleReloadAPIKeys re-reads the configured API keys and swaps them in (admin only)
+func (s *Server) handleReloadAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	added, removed, err := s.ReloadAPIKeys()
+	if errors.Is(err, errAuthDisabled) || errors.Is(err, errNoAPIKeys) {
+		requestLogger(r).Warn("Rejected API key reload", "error", err)
+		writeError(w, r, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Failed to reload API keys", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to reload API keys")
+		return
+	}
+	requestLogger(r).Info("API keys reloaded", "added", added, "removed", removed)
+
+	keys := s.apiKeyInfos()
+	response := map[string]interface{}{
+		"status":    "success",
+		"added":     added,
+		"removed":   removed,
+		"keys":      keys,
+		"total":     len(keys),
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"krelease-tracker/internal/database"
+)
+
+// releaseLookup fetches the current release of a component for a badge
+type releaseLookup func(workloadKind, workloadName, container, clientName, envName string) (*database.CurrentRelease, error)
+
+// badgeLookupKey identifies a badge lookup
+type badgeLookupKey struct {
+	workloadKind, workloadName, container, clientName, envName string
+}
+
+// badgeLookupResult is the outcome of a lookup, shared by the callers waiting for it and cached until expires
+type badgeLookupResult struct {
+	release *database.CurrentRelease
+	err     error
+	expires time.Time
+	done    chan struct{}
+}
+
+// badgeLookupCache coalesces concurrent identical badge lookups into one database query and keeps
+// the result for a short TTL, so hot badges do not hit the database on every request
+type badgeLookupCache struct {
+	lookup releaseLookup
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	results map[badgeLookupKey]*badgeLookupResult
+}
+
+// newBadgeLookupCache wraps lookup; a ttl of 0 only coalesces lookups that are in flight at the same time
+func newBadgeLookupCache(lookup releaseLookup, ttl time.Duration) *badgeLookupCache {
+	return &badgeLookupCache{
+		lookup:  lookup,
+		ttl:     ttl,
+		results: make(map[badgeLookupKey]*badgeLookupResult),
+	}
+}
+
+// Get returns the current release of a component, joining an identical lookup in flight or reusing a fresh result
+func (c *badgeLookupCache) Get(workloadKind, workloadName, container, clientName, envName string) (*database.CurrentRelease, error) {
+	key := badgeLookupKey{workloadKind, workloadName, container, clientName, envName}
+
+	c.mu.Lock()
+	if result, ok := c.results[key]; ok {
+		select {
+		case <-result.done:
+			if time.Now().Before(result.expires) {
+				c.mu.Unlock()
+				return result.release, result.err
+			}
+		default:
+			// Lookup in flight: wait for it instead of querying again
+			c.mu.Unlock()
+			<-result.done
+			return result.release, result.err
+		}
+	}
+
+	result := &badgeLookupResult{done: make(chan struct{})}
+	c.results[key] = result
+	c.mu.Unlock()
+
+	result.release, result.err = c.lookup(workloadKind, workloadName, container, clientName, envName)
+
+	c.mu.Lock()
+	result.expires = time.Now().Add(c.ttl)
+	if c.ttl <= 0 || result.err != nil {
+		// Errors are not cached so the next request retries
+		delete(c.results, key)
+	}
+	close(result.done)
+	c.pruneExpired()
+	c.mu.Unlock()
+
+	return result.release, result.err
+}
+
+// pruneExpired drops finished results past their TTL; the caller must hold c.mu
+func (c *badgeLookupCache) pruneExpired() {
+	now := time.Now()
+	for key, result := range c.results {
+		select {
+		case <-result.done:
+			if !now.Before(result.expires) {
+				delete(c.results, key)
+			}
+		default:
+		}
+	}
+}
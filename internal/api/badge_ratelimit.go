@@ -0,0 +1,98 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// badgeBucketIdleTimeout is how long the bucket of a client that stopped requesting badges is kept
+const badgeBucketIdleTimeout = 10 * time.Minute
+
+// tokenBucket holds the requests a client may still make; it refills continuously up to its capacity
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// badgeRateLimiter limits the badge requests of each client IP with a token bucket allowing perMinute
+// requests per minute, in bursts of up to perMinute requests
+type badgeRateLimiter struct {
+	perMinute int
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// newBadgeRateLimiter creates a limiter allowing perMinute badge requests per minute to each client IP
+func newBadgeRateLimiter(perMinute int) *badgeRateLimiter {
+	return &badgeRateLimiter{
+		perMinute:   perMinute,
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of ip and reports whether the request may proceed, and otherwise
+// how long until the next token is available
+func (l *badgeRateLimiter) Allow(ip string) (bool, time.Duration) {
+	now := time.Now()
+	capacity := float64(l.perMinute)
+	refillPerSecond := capacity / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) >= badgeBucketIdleTimeout {
+		l.pruneIdle(now)
+	}
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, lastSeen: now}
+		l.buckets[ip] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*refillPerSecond)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / refillPerSecond * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// pruneIdle drops the buckets of clients idle for longer than badgeBucketIdleTimeout; the caller must hold l.mu
+func (l *badgeRateLimiter) pruneIdle(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > badgeBucketIdleTimeout {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// badgeRateLimitMiddleware serves a "rate limited" badge to client IPs exceeding BADGE_RATE_LIMIT
+func (s *Server) badgeRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if ok, retryAfter := s.badgeLimiter.Allow(ip); !ok {
+			requestLogger(r).Warn("Badge rate limit exceeded", "method", r.Method, "path", r.URL.Path, "ip", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			s.serveBadge(w, CreateRateLimitedBadge(mux.Vars(r)["env"], badgeSizeFromRequest(r)))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
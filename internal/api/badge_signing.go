@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultSignedBadgeTTL is the validity of a signed badge URL when no ttl is requested
+const defaultSignedBadgeTTL = 30 * 24 * time.Hour
+
+// signBadge returns the hex HMAC-SHA256 signature of a signed badge target and its expiry (unix seconds)
+func signBadge(secret string, expiry int64, target string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d/%s", expiry, target)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyBadgeSignature checks a badge signature in constant time
+func verifyBadgeSignature(secret, signature string, expiry int64, target string) bool {
+	expected := signBadge(secret, expiry, target)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// badgePath builds the badge path covered by the signature, e.g. "acme/prod/Deployment/web/app"
+func badgePath(clientName, envName, workloadKind, workloadName, container string, digest bool) string {
+	path := fmt.Sprintf("%s/%s/%s/%s/%s", clientName, envName, workloadKind, workloadName, container)
+	if digest {
+		path += "/digest"
+	}
+	return path
+}
+
+// signedBadgeTarget returns what follows the expiry in a signed badge URL, all of it covered by the
+// signature: the badge path and, for a badge summarizing every container, the all_containers query
+func signedBadgeTarget(badgePath string, allContainers bool) string {
+	if allContainers {
+		return badgePath + "?all_containers=true"
+	}
+	return badgePath
+}
+
+// handleSignedBadge serves a badge authenticated by an expiring HMAC signature instead of an API key
+func (s *Server) handleSignedBadge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	envName := vars["env"]
+	digest := vars["variant"] == "digest"
+
+	if s.config.BadgeSigningSecret == "" {
+		requestLogger(r).Warn("Signed badge requested but BADGE_SIGNING_SECRET is not set", "path", r.URL.Path)
+		s.serveBadge(w, CreateErrorBadge(envName, "signing disabled", badgeSizeFromRequest(r)))
+		return
+	}
+
+	// all_containers changes which badge is served, so a URL signed without it cannot be extended with it
+	expiry, err := strconv.ParseInt(vars["expiry"], 10, 64)
+	path := badgePath(vars["client"], envName, vars["workload-kind"], vars["workload-name"], vars["container"], digest)
+	target := signedBadgeTarget(path, r.URL.Query().Get("all_containers") == "true")
+	if err != nil || !verifyBadgeSignature(s.config.BadgeSigningSecret, vars["sig"], expiry, target) {
+		requestLogger(r).Warn("Signed badge authentication failed: invalid signature", "method", r.Method, "path", r.URL.Path)
+		s.serveBadge(w, CreateErrorBadge(envName, "unauthorized", badgeSizeFromRequest(r)))
+		return
+	}
+
+	if time.Now().Unix() > expiry {
+		requestLogger(r).Warn("Signed badge expired", "method", r.Method, "path", r.URL.Path)
+		s.serveBadge(w, CreateExpiredBadge(envName, badgeSizeFromRequest(r)))
+		return
+	}
+
+	render := s.tagBadge
+	if digest {
+		render = digestBadge
+	}
+	s.handleBadgeCore(w, r, vars["workload-kind"], vars["workload-name"], vars["container"], vars["client"], envName, render)
+}
+
+// handleSignBadge issues a signed, expiring badge URL for a component
+func (s *Server) handleSignBadge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+
+	// Check client access permissions; a signed URL serves the badge without authentication, so
+	// environment-scoped keys only sign badges of their environment
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, vars["env"]) {
+		return
+	}
+
+	if s.config.BadgeSigningSecret == "" {
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Badge signing is not configured (set BADGE_SIGNING_SECRET)")
+		return
+	}
+
+	ttl := defaultSignedBadgeTTL
+	if ttlStr := r.URL.Query().Get("ttl"); ttlStr != "" {
+		var err error
+		ttl, err = time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid ttl parameter (expected a positive duration such as 24h)")
+			return
+		}
+	}
+
+	digest := r.URL.Query().Get("variant") == "digest"
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	path := badgePath(requestedClientName, vars["env"], vars["workload-kind"], vars["workload-name"], vars["container"], digest)
+	target := signedBadgeTarget(path, r.URL.Query().Get("all_containers") == "true")
+	signature := signBadge(s.config.BadgeSigningSecret, expiresAt.Unix(), target)
+
+	response := map[string]interface{}{
+		"url":        fmt.Sprintf("%s/badges/signed/%s/%d/%s", s.config.BasePath, signature, expiresAt.Unix(), target),
+		"expires_at": expiresAt,
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
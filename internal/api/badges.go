@@ -20,11 +20,47 @@ var (
 	BadgeColorGray    = BadgeColor{Left: "#555", Right: "#9f9f9f"} // Gray for unknown
 )
 
+// Default and allowed badge dimensions, in pixels
+const (
+	defaultBadgeHeight   = 20
+	defaultBadgeFontSize = 11
+	minBadgeHeight       = 10
+	maxBadgeHeight       = 100
+	minBadgeFontSize     = 6
+	maxBadgeFontSize     = 60
+)
+
+// BadgeSize sets the height and font size of a badge in pixels. A zero field is derived from the other
+// one, keeping the default 20px/11px proportions; both zero renders the default size.
+type BadgeSize struct {
+	Height   int
+	FontSize int
+}
+
+// resolve fills in unset dimensions and bounds both to the allowed range
+func (size BadgeSize) resolve() (height, fontSize int) {
+	height, fontSize = size.Height, size.FontSize
+	switch {
+	case height <= 0 && fontSize <= 0:
+		height, fontSize = defaultBadgeHeight, defaultBadgeFontSize
+	case fontSize <= 0:
+		fontSize = height * defaultBadgeFontSize / defaultBadgeHeight
+	case height <= 0:
+		height = fontSize * defaultBadgeHeight / defaultBadgeFontSize
+	}
+	return clamp(height, minBadgeHeight, maxBadgeHeight), clamp(fontSize, minBadgeFontSize, maxBadgeFontSize)
+}
+
+func clamp(value, low, high int) int {
+	return max(low, min(value, high))
+}
+
 // BadgeOptions holds configuration for badge generation
 type BadgeOptions struct {
 	Label string     // Left side text (e.g., "production")
 	Value string     // Right side text (e.g., "v1.2.3")
 	Color BadgeColor // Color scheme
+	Size  BadgeSize  // Dimensions (default: 20px high with 11px text)
 }
 
 // GenerateSVGBadge creates a shields.io style SVG badge
@@ -33,19 +69,26 @@ func GenerateSVGBadge(opts BadgeOptions) string {
 	label := html.EscapeString(opts.Label)
 	value := html.EscapeString(opts.Value)
 
-	// Calculate text widths (approximate)
-	labelWidth := calculateTextWidth(label)
-	valueWidth := calculateTextWidth(value)
+	// Text is drawn at ten times its size and scaled down, so positions below are in tenths of a pixel
+	height, fontSize := opts.Size.resolve()
 
-	// Add padding
-	labelPadding := 12
-	valuePadding := 12
+	// Calculate text widths (approximate), which grow with the font size
+	labelWidth := calculateTextWidth(label) * fontSize / defaultBadgeFontSize
+	valueWidth := calculateTextWidth(value) * fontSize / defaultBadgeFontSize
+
+	// Add padding, proportional to the font size
+	labelPadding := 12 * fontSize / defaultBadgeFontSize
+	valuePadding := 12 * fontSize / defaultBadgeFontSize
 
 	// Calculate total dimensions
 	labelBoxWidth := labelWidth + labelPadding
 	valueBoxWidth := valueWidth + valuePadding
 	totalWidth := labelBoxWidth + valueBoxWidth
-	height := 20
+	radius := max(1, 3*height/defaultBadgeHeight)
+
+	// Vertically center the text: the baseline sits below the middle by about a third of the font size
+	textY := height*5 + fontSize*40/defaultBadgeFontSize
+	shadowY := textY + fontSize*10/defaultBadgeFontSize
 
 	// Generate SVG
 	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%d" height="%d" role="img" aria-label="%s: %s">
@@ -55,33 +98,34 @@ func GenerateSVGBadge(opts BadgeOptions) string {
     <stop offset="1" stop-opacity=".1"/>
   </linearGradient>
   <clipPath id="r">
-    <rect width="%d" height="%d" rx="3" fill="#fff"/>
+    <rect width="%d" height="%d" rx="%d" fill="#fff"/>
   </clipPath>
   <g clip-path="url(#r)">
     <rect width="%d" height="%d" fill="%s"/>
     <rect x="%d" width="%d" height="%d" fill="%s"/>
     <rect width="%d" height="%d" fill="url(#s)"/>
   </g>
-  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" text-rendering="geometricPrecision" font-size="110">
-    <text aria-hidden="true" x="%d" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="%d">%s</text>
-    <text x="%d" y="140" transform="scale(.1)" fill="#fff" textLength="%d">%s</text>
-    <text aria-hidden="true" x="%d" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="%d">%s</text>
-    <text x="%d" y="140" transform="scale(.1)" fill="#fff" textLength="%d">%s</text>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" text-rendering="geometricPrecision" font-size="%d">
+    <text aria-hidden="true" x="%d" y="%d" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="%d">%s</text>
+    <text x="%d" y="%d" transform="scale(.1)" fill="#fff" textLength="%d">%s</text>
+    <text aria-hidden="true" x="%d" y="%d" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="%d">%s</text>
+    <text x="%d" y="%d" transform="scale(.1)" fill="#fff" textLength="%d">%s</text>
   </g>
 </svg>`,
 		totalWidth, height, label, value, label, value,
-		totalWidth, height,
+		totalWidth, height, radius,
 		labelBoxWidth, height, opts.Color.Left,
 		labelBoxWidth, valueBoxWidth, height, opts.Color.Right,
 		totalWidth, height,
+		fontSize*10,
 		// Label text (shadow)
-		(labelBoxWidth*10)/2, labelWidth*10, label,
+		(labelBoxWidth*10)/2, shadowY, labelWidth*10, label,
 		// Label text (main)
-		(labelBoxWidth*10)/2, labelWidth*10, label,
+		(labelBoxWidth*10)/2, textY, labelWidth*10, label,
 		// Value text (shadow)
-		(labelBoxWidth*10)+(valueBoxWidth*10)/2, valueWidth*10, value,
+		(labelBoxWidth*10)+(valueBoxWidth*10)/2, shadowY, valueWidth*10, value,
 		// Value text (main)
-		(labelBoxWidth*10)+(valueBoxWidth*10)/2, valueWidth*10, value,
+		(labelBoxWidth*10)+(valueBoxWidth*10)/2, textY, valueWidth*10, value,
 	)
 
 	return svg
@@ -118,37 +162,103 @@ func calculateTextWidth(text string) int {
 }
 
 // CreateSuccessBadge creates a green badge for successful deployments
-func CreateSuccessBadge(envName, version string) string {
+func CreateSuccessBadge(envName, version string, size BadgeSize) string {
 	return GenerateSVGBadge(BadgeOptions{
 		Label: envName,
 		Value: version,
 		Color: BadgeColorSuccess,
+		Size:  size,
 	})
 }
 
+// CreateVersionBadge creates a green badge for a deployed version, or a gray badge showing
+// unknownText when the version is empty or the implicit "latest" tag carrying no real version
+func CreateVersionBadge(envName, version, unknownText string, size BadgeSize) string {
+	if isUnknownVersion(version) {
+		if unknownText == "" {
+			unknownText = "unknown"
+		}
+		return GenerateSVGBadge(BadgeOptions{
+			Label: envName,
+			Value: unknownText,
+			Color: BadgeColorGray,
+			Size:  size,
+		})
+	}
+	return CreateSuccessBadge(envName, version, size)
+}
+
+// isUnknownVersion reports whether a resolved image tag carries no version information
+func isUnknownVersion(version string) bool {
+	return version == "" || version == "latest"
+}
+
 // CreateErrorBadge creates a red badge for errors
-func CreateErrorBadge(envName, message string) string {
+func CreateErrorBadge(envName, message string, size BadgeSize) string {
 	return GenerateSVGBadge(BadgeOptions{
 		Label: envName,
 		Value: message,
 		Color: BadgeColorError,
+		Size:  size,
 	})
 }
 
 // CreateNotFoundBadge creates a gray badge for when no deployment is found
-func CreateNotFoundBadge(envName string) string {
+func CreateNotFoundBadge(envName string, size BadgeSize) string {
 	return GenerateSVGBadge(BadgeOptions{
 		Label: envName,
 		Value: "not deployed",
 		Color: BadgeColorGray,
+		Size:  size,
+	})
+}
+
+// CreateNoDigestBadge creates a gray badge for releases recorded without an image digest
+func CreateNoDigestBadge(envName string, size BadgeSize) string {
+	return GenerateSVGBadge(BadgeOptions{
+		Label: envName,
+		Value: "no digest",
+		Color: BadgeColorGray,
+		Size:  size,
+	})
+}
+
+// CreateExpiredBadge creates a gray badge for signed badge URLs past their expiry
+func CreateExpiredBadge(envName string, size BadgeSize) string {
+	return GenerateSVGBadge(BadgeOptions{
+		Label: envName,
+		Value: "expired",
+		Color: BadgeColorGray,
+		Size:  size,
+	})
+}
+
+// CreateRateLimitedBadge creates a gray badge for clients exceeding the badge rate limit
+func CreateRateLimitedBadge(envName string, size BadgeSize) string {
+	return GenerateSVGBadge(BadgeOptions{
+		Label: envName,
+		Value: "rate limited",
+		Color: BadgeColorGray,
+		Size:  size,
+	})
+}
+
+// CreateMixedBadge creates a warning badge for workloads whose containers run different releases
+func CreateMixedBadge(envName string, size BadgeSize) string {
+	return GenerateSVGBadge(BadgeOptions{
+		Label: envName,
+		Value: "mixed",
+		Color: BadgeColorWarning,
+		Size:  size,
 	})
 }
 
 // CreateMultipleFoundBadge creates a warning badge for when multiple deployments are found
-func CreateMultipleFoundBadge(envName string) string {
+func CreateMultipleFoundBadge(envName string, size BadgeSize) string {
 	return GenerateSVGBadge(BadgeOptions{
 		Label: envName,
 		Value: "multiple found",
 		Color: BadgeColorWarning,
+		Size:  size,
 	})
 }
@@ -2,16 +2,26 @@ package api
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"krelease-tracker/internal/config"
 	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/federation"
 	"krelease-tracker/internal/kubernetes"
+	"krelease-tracker/internal/metrics"
+	"krelease-tracker/internal/notify"
+	"krelease-tracker/internal/version"
 
 	"github.com/gorilla/mux"
 )
@@ -22,9 +32,39 @@ type Server struct {
 	k8s        *kubernetes.Client
 	router     *mux.Router
 	namespaces []string
-	apiKeys    []string
 	envName    string
 	config     *config.Config
+
+	// apiKeys are the valid API keys, swapped by a reload; guarded by apiKeysMu
+	apiKeys   []string
+	apiKeysMu sync.RWMutex
+	// loadAPIKeys reads the API keys configured at the time of a reload
+	loadAPIKeys func() ([]string, error)
+
+	// collectReleases runs a full collection; nil when no kubernetes client is available
+	collectReleases func(ctx context.Context) error
+	// collectWorkload collects a single workload; nil when no kubernetes client is available
+	collectWorkload func(ctx context.Context, namespace, kind, name string) error
+	// monitorsNamespace reports whether a namespace is collected
+	monitorsNamespace func(ctx context.Context, namespace string) (bool, error)
+	// collectionMu ensures only one background collection runs at a time
+	collectionMu sync.Mutex
+	// collections tracks the background collections triggered through the API, see WaitCollections
+	collections sync.WaitGroup
+	// notifier receives version change events for releases collected through the API; nil disables them
+	notifier notify.Notifier
+	// federation merges read endpoints with the data of upstream masters; nil serves the local database only
+	federation *federation.Client
+	// badgeLookups coalesces concurrent identical badge queries and caches their results briefly
+	badgeLookups *badgeLookupCache
+	// badgeLimiter limits the badge requests of each client IP; nil when BADGE_RATE_LIMIT is 0
+	badgeLimiter *badgeRateLimiter
+	// keyUsage counts the authenticated requests of each configured API key
+	keyUsage *keyUsageTracker
+	// metrics is served on /metrics and counts releases recorded through the API; nil disables it
+	metrics *metrics.Metrics
+	// workers tracks the background collection and sync workers reported by /health
+	workers workerTracker
 }
 
 // New creates a new API server
@@ -38,11 +78,55 @@ func New(db *database.DB, k8s *kubernetes.Client, cfg *config.Config) *Server {
 		envName:    cfg.EnvName,
 		config:     cfg,
 	}
+	s.badgeLookups = newBadgeLookupCache(db.GetCurrentReleaseByWorkload, time.Duration(cfg.BadgeCacheTTL)*time.Millisecond)
+	s.keyUsage = newKeyUsageTracker(cfg.APIKeys, cfg.APIKeyLegacyFormat)
+	warnMalformedAPIKeys(cfg.APIKeys, cfg.APIKeyLegacyFormat)
+	s.loadAPIKeys = func() ([]string, error) {
+		return config.LoadAPIKeys(cfg.APIKeysFile)
+	}
+	if cfg.BadgeRateLimit > 0 {
+		s.badgeLimiter = newBadgeRateLimiter(cfg.BadgeRateLimit)
+	}
+	s.monitorsNamespace = func(ctx context.Context, namespace string) (bool, error) {
+		return kubernetes.IsMonitored(cfg.Namespaces, cfg.NamespacesExclude, namespace), nil
+	}
+	if k8s != nil {
+		s.monitorsNamespace = k8s.MonitorsNamespace
+		s.collectReleases = func(ctx context.Context) error {
+			return k8s.CollectReleases(ctx, db)
+		}
+		s.collectWorkload = func(ctx context.Context, namespace, kind, name string) error {
+			return k8s.CollectWorkload(ctx, db, namespace, kind, name)
+		}
+	}
 
 	s.setupRoutes()
 	return s
 }
 
+// normalizeName trims a client or environment name and lowercases it when LOWERCASE_NAMES is set
+func (s *Server) normalizeName(name string) string {
+	return database.NormalizeName(name, s.config.LowercaseNames)
+}
+
+// SetNotifier sets the notifier receiving version change events for releases collected through the API
+func (s *Server) SetNotifier(notifier notify.Notifier) {
+	s.notifier = notifier
+}
+
+// SetFederation sets the client used to merge current releases and clients/environments from upstream masters
+func (s *Server) SetFederation(client *federation.Client) {
+	s.federation = client
+}
+
+// SetMetrics sets the metrics served on /metrics and exposes the number of pending releases and the
+// database connection pool statistics through them
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+	m.RegisterPendingReleases(s.db.CountPendingReleases)
+	m.RegisterDBStats(s.db.Stats)
+}
+
 // ServeHTTP implements the http.Handler interface
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
@@ -50,10 +134,26 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleCollect triggers collection of current cluster state asynchronously
 func (s *Server) handleCollect(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Collection triggered via API")
+	requestLogger(r).Info("Collection triggered via API")
+
+	// Only one background collection may run at a time; further triggers are acknowledged but not queued
+	if !s.collectionMu.TryLock() {
+		requestLogger(r).Info("Collection already in progress, ignoring trigger")
+		writeJSON(w, r, http.StatusAccepted, map[string]interface{}{
+			"status":    "in_progress",
+			"message":   "Collection already in progress",
+			"timestamp": time.Now().UTC(),
+		})
+		return
+	}
 
 	// Start the collection process in the background
-	go s.runCollectionAsync()
+	s.collections.Add(1)
+	go func() {
+		defer s.collections.Done()
+		defer s.collectionMu.Unlock()
+		s.runCollectionAsync(requestLogger(r))
+	}()
 
 	// Immediately return acknowledgment response
 	response := map[string]interface{}{
@@ -62,41 +162,150 @@ func (s *Server) handleCollect(w http.ResponseWriter, r *http.Request) {
 		"timestamp": time.Now().UTC(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// WaitCollections blocks until the collections triggered through the API have finished, so the database
+// is not closed under them. Call it once the HTTP server is shut down and no new ones can start.
+func (s *Server) WaitCollections() {
+	s.collections.Wait()
 }
 
-// runCollectionAsync runs the collection process in the background
-func (s *Server) runCollectionAsync() {
+// runCollectionAsync runs the collection process in the background, logging to the logger of the triggering request
+func (s *Server) runCollectionAsync(logger *slog.Logger) {
 	// Create a background context with timeout for the collection process
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	log.Printf("Starting background collection process")
+	logger.Info("Starting background collection process")
 
 	// Check if kubernetes client is available
-	if s.k8s == nil {
-		log.Printf("Background collection skipped: kubernetes client not available")
+	if s.collectReleases == nil {
+		logger.Warn("Background collection skipped: kubernetes client not available")
+		return
+	}
+
+	if err := s.collectReleases(ctx); err != nil {
+		logger.Error("Background collection failed", "error", err)
+		return
+	}
+	s.WorkerSucceeded(WorkerCollection)
+
+	logger.Info("Background collection completed successfully")
+}
+
+// handleCollectWorkload triggers a background collection of a single workload, e.g. right after it was deployed
+func (s *Server) handleCollectWorkload(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace := vars["namespace"]
+	workloadName := vars["workload-name"]
+
+	workloadKind, ok := kubernetes.WorkloadKind(vars["workload-kind"])
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedKind, fmt.Sprintf("Unsupported workload kind '%s' (expected one of Deployment, StatefulSet, DaemonSet, Job, CronJob)", vars["workload-kind"]))
 		return
 	}
+	monitored, err := s.monitorsNamespace(r.Context(), namespace)
+	if err != nil {
+		requestLogger(r).Error("Failed to check monitored namespace", "namespace", namespace, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternalError, "Failed to check monitored namespace")
+		return
+	}
+	if !monitored {
+		writeError(w, r, http.StatusBadRequest, codeNotMonitored, fmt.Sprintf("Namespace '%s' is not monitored", namespace))
+		return
+	}
+	if s.collectWorkload == nil {
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Collection is not available: kubernetes client not configured")
+		return
+	}
+
+	requestLogger(r).Info("Workload collection triggered via API", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName)
 
-	if err := s.k8s.CollectReleases(ctx, s.db); err != nil {
-		log.Printf("Background collection failed: %v", err)
+	// Targeted collections share the lock of full collections, which collect the workload anyway
+	if !s.collectionMu.TryLock() {
+		requestLogger(r).Info("Collection already in progress, ignoring trigger")
+		writeJSON(w, r, http.StatusAccepted, map[string]interface{}{
+			"status":    "in_progress",
+			"message":   "Collection already in progress",
+			"timestamp": time.Now().UTC(),
+		})
 		return
 	}
 
-	log.Printf("Background collection completed successfully")
+	s.collections.Add(1)
+	go func() {
+		defer s.collections.Done()
+		defer s.collectionMu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.collectWorkload(ctx, namespace, workloadKind, workloadName); err != nil {
+			requestLogger(r).Error("Workload collection failed", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName, "error", err)
+			return
+		}
+		requestLogger(r).Info("Workload collection completed successfully", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName)
+	}()
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":        "accepted",
+		"message":       "Workload collection started successfully",
+		"namespace":     namespace,
+		"workload_kind": workloadKind,
+		"workload_name": workloadName,
+		"timestamp":     time.Now().UTC(),
+	})
 }
 
 // ManualCollectRequest represents the request body for manual collection
 type ManualCollectRequest struct {
-	ImageTag   string     `json:"image_tag,omitempty"`
-	ImageSHA   string     `json:"image_sha,omitempty"`
-	ReleasedAt *time.Time `json:"released_at,omitempty"`
-	ImageRepo  string     `json:"image_repo,omitempty"`
-	ImageName  string     `json:"image_name,omitempty"`
-	ClientName string     `json:"client_name,omitempty"`
-	EnvName    string     `json:"env_name,omitempty"`
+	SchemaVersion int                  `json:"schema_version,omitempty"`
+	ImageTag      string               `json:"image_tag,omitempty"`
+	ImageSHA      string               `json:"image_sha,omitempty"`
+	SpecDigest    string               `json:"spec_digest,omitempty"` // digest pinned in the workload spec, without "sha256:"
+	ReleasedAt    *time.Time           `json:"released_at,omitempty"`
+	StartedAt     *time.Time           `json:"started_at,omitempty"` // when the first container running the image started
+	ImageRepo     string               `json:"image_repo,omitempty"`
+	ImageName     string               `json:"image_name,omitempty"`
+	ClientName    string               `json:"client_name,omitempty"`
+	EnvName       string               `json:"env_name,omitempty"`
+	ClusterName   string               `json:"cluster_name,omitempty"`
+	ChartVersion  string               `json:"chart_version,omitempty"`
+	AppVersion    string               `json:"app_version,omitempty"`
+	DisplayOrder  int                  `json:"display_order,omitempty"` // position of the workload within its namespace
+	ImageLabels   database.OCILabels   `json:"image_labels,omitempty"`
+	Annotations   database.Annotations `json:"annotations,omitempty"` // tracked pod template annotations, e.g. git-commit
+	Replicas      *int                 `json:"replicas,omitempty"`
+	ReadyReplicas *int                 `json:"ready_replicas,omitempty"`
+}
+
+// BatchCollectItem is one release of a batch collect request: the manual collect body plus the component
+// path parameters of the single-item endpoint
+type BatchCollectItem struct {
+	Namespace     string `json:"namespace"`
+	WorkloadKind  string `json:"workload_kind"`
+	WorkloadName  string `json:"workload_name"`
+	ContainerName string `json:"container_name"`
+	ManualCollectRequest
+}
+
+// BatchCollectResult is the outcome of the item at Index of a batch collect request
+type BatchCollectResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // "success" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// maxCollectBatchSize bounds the number of releases accepted by one batch collect request
+const maxCollectBatchSize = 500
+
+// decodeJSON decodes a request body into v. With STRICT_JSON enabled, unknown fields are rejected
+// so a misnamed field (e.g. "imageTag") is reported instead of silently left empty.
+func (s *Server) decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	if s.config.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(v)
 }
 
 // handleManualCollect manually adds a new workload release to the database
@@ -109,21 +318,214 @@ func (s *Server) handleManualCollect(w http.ResponseWriter, r *http.Request) {
 
 	// Validate path parameters
 	if namespace == "" || workloadKind == "" || workloadName == "" || container == "" {
-		http.Error(w, "Missing required path parameters: namespace, workload-kind, workload-name, container", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required path parameters: namespace, workload-kind, workload-name, container")
+		return
+	}
+
+	// A retry of a request already processed gets the original response
+	idempotencyKey, answered := s.replayIdempotent(w, r)
+	if answered {
 		return
 	}
+	defer s.releaseIdempotent(r, idempotencyKey)
 
 	// Parse request body
 	var req ManualCollectRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+	if err := s.decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	release, err := s.newManualRelease(r, namespace, workloadKind, workloadName, container, &req)
+	if err != nil {
+		requestLogger(r).Warn("Rejected manual collect", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName, "container", container, "error", err)
+		code := codeInvalidRelease
+		if errors.Is(err, version.ErrUnsupportedSchemaVersion) {
+			code = codeUnsupportedSchema
+		}
+		writeError(w, r, http.StatusBadRequest, code, err.Error())
+		return
+	}
+	clientName, envName := release.ClientName, release.EnvName
+
+	// A client API key only records releases of its own client
+	if !authorizeClaimedClient(w, r, clientName, envName) {
+		return
+	}
+
+	// Remember the current release to detect version changes
+	var previous *database.ReleaseProvenance
+	if s.notifier != nil {
+		var err error
+		if previous, err = s.db.GetReleaseProvenance(namespace, workloadName, container, clientName, envName); err != nil {
+			requestLogger(r).Error("Failed to get current release", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName, "container", container, "error", err)
+		}
+	}
+
+	// Save to database
+	if err := s.db.UpsertRelease(release); err != nil {
+		requestLogger(r).Error("Failed to save manual release", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName, "container", container, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, fmt.Sprintf("Failed to save release: %v", err))
+		return
+	}
+
+	s.metrics.ReleaseUpserted()
+	notify.NotifyVersionChange(r.Context(), s.notifier, previous, release)
+
+	if s.config.Mode == "slave" {
+		// In slave mode, also store in pending_releases table as queue
+		if err := s.db.UpsertPendingRelease(pendingFromRelease(release)); err != nil {
+			requestLogger(r).Error("Failed to upsert pending release", "namespace", namespace, "workload_type", workloadKind, "workload", workloadName, "container", container, "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeDBError, fmt.Sprintf("Failed to upsert pending release: %v", err))
+			return
+		}
+	}
+
+	requestLogger(r).Info("Manual release collected", "client", clientName, "env", envName, "namespace", namespace, "workload_type", workloadKind, "workload", workloadName, "container", container, "tag", req.ImageTag)
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": "Release collected successfully",
+		"component": map[string]string{
+			"namespace":      namespace,
+			"workload_kind":  workloadKind,
+			"workload_name":  workloadName,
+			"container_name": container,
+		},
+		"release": map[string]interface{}{
+			"version":     req.ImageTag,
+			"image_repo":  release.ImageRepo,
+			"image_name":  release.ImageName,
+			"image_tag":   release.ImageTag,
+			"image_sha":   release.ImageSHA,
+			"released_at": release.LastSeen,
+		},
+		"timestamp": time.Now().UTC(),
+	}
+
+	s.writeIdempotentJSON(w, r, idempotencyKey, http.StatusOK, response)
+}
+
+// handleBatchCollect records an array of releases in one request. Valid items are saved in a single
+// transaction; the response reports the outcome of every item so a slave knows which releases to dequeue.
+func (s *Server) handleBatchCollect(w http.ResponseWriter, r *http.Request) {
+	// A retry of a batch already recorded gets the original response
+	idempotencyKey, answered := s.replayIdempotent(w, r)
+	if answered {
+		return
+	}
+	defer s.releaseIdempotent(r, idempotencyKey)
+
+	var items []BatchCollectItem
+	if err := s.decodeJSON(r, &items); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if len(items) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Request body must contain at least one release")
+		return
+	}
+	if len(items) > maxCollectBatchSize {
+		writeError(w, r, http.StatusBadRequest, codeBatchTooLarge, fmt.Sprintf("Batch of %d releases exceeds the maximum of %d", len(items), maxCollectBatchSize))
 		return
 	}
 
+	results := make([]BatchCollectResult, len(items))
+	var releases []*database.Release
+	var indexes []int
+	var previous []*database.ReleaseProvenance
+	for i := range items {
+		item := &items[i]
+		results[i] = BatchCollectResult{Index: i, Status: "success"}
+
+		if item.Namespace == "" || item.WorkloadKind == "" || item.WorkloadName == "" || item.ContainerName == "" {
+			results[i] = BatchCollectResult{Index: i, Status: "error", Error: "Missing required field: namespace, workload_kind, workload_name, container_name"}
+			continue
+		}
+		release, err := s.newManualRelease(r, item.Namespace, item.WorkloadKind, item.WorkloadName, item.ContainerName, &item.ManualCollectRequest)
+		if err != nil {
+			requestLogger(r).Warn("Rejected batch collect item", "index", i, "namespace", item.Namespace, "workload_type", item.WorkloadKind, "workload", item.WorkloadName, "container", item.ContainerName, "error", err)
+			results[i] = BatchCollectResult{Index: i, Status: "error", Error: err.Error()}
+			continue
+		}
+		if err := checkClaimedClient(r, release.ClientName, release.EnvName); err != nil {
+			requestLogger(r).Warn("Rejected batch collect item", releaseAttrs(release, "index", i, "error", err)...)
+			results[i] = BatchCollectResult{Index: i, Status: "error", Error: err.Error()}
+			continue
+		}
+
+		// Remember the current release to detect version changes
+		var current *database.ReleaseProvenance
+		if s.notifier != nil {
+			if current, err = s.db.GetReleaseProvenance(release.Namespace, release.WorkloadName, release.ContainerName, release.ClientName, release.EnvName); err != nil {
+				requestLogger(r).Error("Failed to get current release", releaseAttrs(release, "error", err)...)
+			}
+		}
+
+		releases = append(releases, release)
+		indexes = append(indexes, i)
+		previous = append(previous, current)
+	}
+
+	if len(releases) > 0 {
+		if err := s.db.UpsertReleases(releases); err != nil {
+			requestLogger(r).Error("Failed to save batch of releases", "count", len(releases), "error", err)
+			for _, i := range indexes {
+				results[i] = BatchCollectResult{Index: i, Status: "error", Error: fmt.Sprintf("Failed to save release: %v", err)}
+			}
+		} else {
+			for j, release := range releases {
+				s.metrics.ReleaseUpserted()
+				notify.NotifyVersionChange(r.Context(), s.notifier, previous[j], release)
+
+				if s.config.Mode == "slave" {
+					// In slave mode, also store in pending_releases table as queue
+					if err := s.db.UpsertPendingRelease(pendingFromRelease(release)); err != nil {
+						requestLogger(r).Error("Failed to upsert pending release", releaseAttrs(release, "error", err)...)
+						results[indexes[j]] = BatchCollectResult{Index: indexes[j], Status: "error", Error: fmt.Sprintf("Failed to upsert pending release: %v", err)}
+					}
+				}
+			}
+		}
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Status == "success" {
+			succeeded++
+		}
+	}
+	requestLogger(r).Info("Batch collect completed", "recorded", succeeded, "total", len(items))
+
+	status := "success"
+	if succeeded == 0 {
+		status = "error"
+	} else if succeeded < len(items) {
+		status = "partial"
+	}
+	// Only a fully recorded batch is replayed, so the failed items of a retry are processed again
+	if succeeded < len(items) {
+		idempotencyKey = ""
+	}
+	s.writeIdempotentJSON(w, r, idempotencyKey, http.StatusOK, map[string]interface{}{
+		"status":    status,
+		"succeeded": succeeded,
+		"failed":    len(items) - succeeded,
+		"results":   results,
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// newManualRelease validates a manual collect body for a component and builds the release it records
+func (s *Server) newManualRelease(r *http.Request, namespace, workloadKind, workloadName, container string, req *ManualCollectRequest) (*database.Release, error) {
+	// Reject payloads from slaves speaking an incompatible schema before interpreting any field
+	if err := version.CheckSchemaVersion(req.SchemaVersion); err != nil {
+		return nil, err
+	}
+
 	// Validate required fields
 	if req.ImageTag == "" || req.ImageSHA == "" {
-		http.Error(w, "Missing required field: image_tag, image_sha", http.StatusBadRequest)
-		return
+		return nil, errors.New("Missing required field: image_tag, image_sha")
 	}
 
 	// Default released_at to now if not provided
@@ -132,321 +534,1649 @@ func (s *Server) handleManualCollect(w http.ResponseWriter, r *http.Request) {
 		releasedAt = req.ReleasedAt.UTC()
 	}
 
+	// A future released_at would stay the newest last_seen of the component and hide every later release.
+	// Past values are accepted: they are used for historical entries and by slaves syncing a backlog.
+	if maxSkew := time.Duration(s.config.MaxClockSkew) * time.Minute; maxSkew > 0 {
+		if now := time.Now().UTC(); releasedAt.After(now.Add(maxSkew)) {
+			return nil, fmt.Errorf("released_at %s is more than %d minutes ahead of the server time %s (check the sender's clock)",
+				releasedAt.Format(time.RFC3339), s.config.MaxClockSkew, now.Format(time.RFC3339))
+		}
+	}
+
 	imagePath := fmt.Sprintf("%s/%s:%s", req.ImageRepo, req.ImageName, req.ImageTag)
 
 	// Parse the release version (image path) into components
-	repo, name, tag := database.ParseImagePath(imagePath)
+	image := database.ParseImagePath(imagePath)
 
-	// Get client and environment names from request or environment variables
-	clientName := req.ClientName
+	// Get client and environment names from request, the client API key or environment variables
+	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
+	clientName := s.normalizeName(req.ClientName)
 	if clientName == "" {
 		clientName = s.config.ClientName
+		if !isAdmin && authenticatedClientName != "" {
+			clientName = authenticatedClientName
+		}
 	}
-	envName := req.EnvName
+	envName := s.normalizeName(req.EnvName)
 	if envName == "" {
 		envName = s.config.EnvName
+		if allowedEnv := getEnvScopeFromRequest(r); allowedEnv != "" {
+			envName = allowedEnv
+		}
 	}
-
-	// Create release object
-	release := &database.Release{
+	return &database.Release{
 		Namespace:     namespace,
 		WorkloadName:  workloadName,
 		WorkloadType:  workloadKind,
 		ContainerName: container,
-		ImageRepo:     repo,
-		ImageName:     name,
-		ImageTag:      tag,
+		ImageRepo:     image.Repo,
+		ImageName:     image.Name,
+		ImageTag:      image.Tag,
 		ImageSHA:      req.ImageSHA,
+		SpecDigest:    req.SpecDigest,
 		ClientName:    clientName,
 		EnvName:       envName,
+		ClusterName:   req.ClusterName,
+		ChartVersion:  req.ChartVersion,
+		AppVersion:    req.AppVersion,
+		DisplayOrder:  req.DisplayOrder,
+		ImageLabels:   req.ImageLabels,
+		Annotations:   req.Annotations,
+		Replicas:      req.Replicas,
+		ReadyReplicas: req.ReadyReplicas,
+		StartedAt:     req.StartedAt,
+		ReportedBy:    reporterFromRequest(r),
+		Source:        sourceFromRequest(r),
 		FirstSeen:     releasedAt,
 		LastSeen:      releasedAt,
+	}, nil
+}
+
+// pendingFromRelease builds the sync queue entry of a release collected in slave mode
+func pendingFromRelease(release *database.Release) *database.PendingRelease {
+	return &database.PendingRelease{
+		Namespace:     release.Namespace,
+		WorkloadName:  release.WorkloadName,
+		WorkloadType:  release.WorkloadType,
+		ContainerName: release.ContainerName,
+		ImageRepo:     release.ImageRepo,
+		ImageName:     release.ImageName,
+		ImageTag:      release.ImageTag,
+		ImageSHA:      release.ImageSHA,
+		SpecDigest:    release.SpecDigest,
+		ClientName:    release.ClientName,
+		EnvName:       release.EnvName,
+		ClusterName:   release.ClusterName,
+		ChartVersion:  release.ChartVersion,
+		AppVersion:    release.AppVersion,
+		DisplayOrder:  release.DisplayOrder,
+		ImageLabels:   release.ImageLabels,
+		Annotations:   release.Annotations,
+		Replicas:      release.Replicas,
+		ReadyReplicas: release.ReadyReplicas,
+		StartedAt:     release.StartedAt,
+		FirstSeen:     release.FirstSeen,
+		LastSeen:      release.LastSeen,
+	}
+}
+
+// releaseAttrs returns the log fields identifying a release, followed by the given extra fields
+func releaseAttrs(release *database.Release, extra ...any) []any {
+	return append([]any{
+		"client", release.ClientName,
+		"env", release.EnvName,
+		"namespace", release.Namespace,
+		"workload", release.WorkloadName,
+		"container", release.ContainerName,
+	}, extra...)
+}
+
+// sourceFromRequest tells synced releases, marked by the slave's X-Release-Source header, from manual ones
+func sourceFromRequest(r *http.Request) string {
+	if r.Header.Get("X-Release-Source") == database.SourceSync {
+		return database.SourceSync
+	}
+	return database.SourceManual
+}
+
+// reporterFromRequest identifies who reported a release, using the slave's User-Agent
+func reporterFromRequest(r *http.Request) string {
+	reporter := r.Header.Get("User-Agent")
+	if len(reporter) > 256 {
+		reporter = reporter[:256]
+	}
+	return reporter
+}
+
+// defaultCurrentReleasesLimit is the page size of the current releases endpoint when no limit is requested
+const defaultCurrentReleasesLimit = 100
+
+// maxCurrentReleasesLimit is the largest page of current releases served
+const maxCurrentReleasesLimit = 1000
+
+// currentReleasesScope resolves the client and environments of a current releases listing, applying the
+// single-tenant defaults, and checks access to them. It also parses the changed_since filter. It writes
+// the error response and returns false when the request is rejected.
+func (s *Server) currentReleasesScope(w http.ResponseWriter, r *http.Request, clientName, envName string) (string, string, time.Time, bool) {
+	if clientName == "" || envName == "" {
+		if defaultClient, defaultEnv, ok := s.singleTenantDefaults(); ok {
+			if clientName == "" {
+				clientName = defaultClient
+			}
+			if envName == "" {
+				envName = defaultEnv
+			}
+		}
+	}
+
+	if clientName == "" || envName == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required query parameters: client_name, env_name")
+		return "", "", time.Time{}, false
+	}
+
+	// Check client access permissions
+	if !authorizeClient(w, r, clientName) {
+		return "", "", time.Time{}, false
+	}
+	for _, env := range splitEnvNames(envName) {
+		if !authorizeEnv(w, r, env) {
+			return "", "", time.Time{}, false
+		}
+	}
+
+	// Only return components whose SHA changed after changed_since, for incremental refreshes
+	var changedSince time.Time
+	if changedSinceStr := r.URL.Query().Get("changed_since"); changedSinceStr != "" {
+		var err error
+		changedSince, err = time.Parse(time.RFC3339, changedSinceStr)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid changed_since parameter (expected an RFC3339 timestamp)")
+			return "", "", time.Time{}, false
+		}
+	}
+
+	return clientName, envName, changedSince, true
+}
+
+// handleCurrentReleases returns a page of the current deployed images
+func (s *Server) handleCurrentReleases(w http.ResponseWriter, r *http.Request) {
+	// Get client_name and env_name filters from the path or query parameters (required unless single-tenant)
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	if requestedClientName == "" {
+		requestedClientName = r.URL.Query().Get("client_name")
+	}
+	envName := vars["env"]
+	if envName == "" {
+		envName = r.URL.Query().Get("env_name")
+	}
+
+	requestedClientName, envName, changedSince, ok := s.currentReleasesScope(w, r, requestedClientName, envName)
+	if !ok {
+		return
+	}
+
+	// A comma-separated env_name returns the releases of several environments, grouped by environment
+	if envNames := splitEnvNames(envName); len(envNames) > 1 {
+		if r.URL.Query().Has("limit") || r.URL.Query().Has("offset") {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "limit and offset are not supported with multiple env_name values")
+			return
+		}
+		s.writeMultiEnvCurrentReleases(w, r, requestedClientName, envNames, changedSince)
+		return
+	}
+
+	limit := defaultCurrentReleasesLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid limit parameter")
+			return
+		}
+	}
+	if limit > maxCurrentReleasesLimit {
+		limit = maxCurrentReleasesLimit
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid offset parameter")
+			return
+		}
+	}
+
+	// Upstream releases do not tell when their SHA changed, so they are only merged into full responses
+	federated := s.federation != nil && changedSince.IsZero()
+
+	var releases []database.CurrentRelease
+	var total int
+	var lastSeen time.Time
+	var err error
+	if federated {
+		// Upstream releases interleave with local ones, so the page is cut after merging them
+		releases, err = s.db.GetCurrentReleasesChangedSince(requestedClientName, []string{envName}, changedSince)
+		if err == nil {
+			releases = mergeCurrentReleases(releases, s.federation.CurrentReleases(r.Context(), requestedClientName, envName))
+			for _, release := range releases {
+				if release.LastSeen.After(lastSeen) {
+					lastSeen = release.LastSeen
+				}
+			}
+			total = len(releases)
+			releases = releases[min(offset, total):min(offset+limit, total)]
+		}
+	} else {
+		releases, total, err = s.db.GetCurrentReleasesPaginated(requestedClientName, envName, changedSince, limit, offset)
+	}
+	if err != nil {
+		requestLogger(r).Error("Failed to get current releases", "client", requestedClientName, "env", envName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get current releases")
+		return
+	}
+
+	grouped, orderedNamespaces := s.groupByNamespace(releases)
+
+	lastUpdate, err := s.db.GetLastClientEnvUpdate(requestedClientName, envName)
+	if err != nil {
+		requestLogger(r).Error("Failed to get last update", "client", requestedClientName, "env", envName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get last update")
+		return
+	}
+	// Upstream releases only carry last_seen, use it as their update time
+	if federated && lastSeen.After(lastUpdate) {
+		lastUpdate = lastSeen
+	}
+
+	response := map[string]interface{}{
+		"namespaces":         grouped, // Keep for backward compatibility
+		"ordered_namespaces": orderedNamespaces,
+		"total":              total,
+		"limit":              limit,
+		"offset":             offset,
+		"timestamp":          lastUpdate,
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// writeMultiEnvCurrentReleases answers a current releases request covering several environments of a
+// client, with the releases and last update time of each environment in the requested order
+func (s *Server) writeMultiEnvCurrentReleases(w http.ResponseWriter, r *http.Request, clientName string, envNames []string, changedSince time.Time) {
+	releases, err := s.db.GetCurrentReleasesChangedSince(clientName, envNames, changedSince)
+	if err != nil {
+		requestLogger(r).Error("Failed to get current releases", "client", clientName, "envs", envNames, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get current releases")
+		return
+	}
+
+	byEnv := make(map[string][]database.CurrentRelease, len(envNames))
+	for _, release := range releases {
+		byEnv[release.EnvName] = append(byEnv[release.EnvName], release)
+	}
+
+	// Upstream releases do not tell when their SHA changed, so they are only merged into full responses
+	federated := s.federation != nil && changedSince.IsZero()
+
+	environments := make([]map[string]interface{}, 0, len(envNames))
+	total := 0
+	var latestUpdate time.Time
+	for _, envName := range envNames {
+		envReleases := byEnv[envName]
+		if federated {
+			envReleases = mergeCurrentReleases(envReleases, s.federation.CurrentReleases(r.Context(), clientName, envName))
+		}
+
+		lastUpdate, err := s.db.GetLastClientEnvUpdate(clientName, envName)
+		if err != nil {
+			requestLogger(r).Error("Failed to get last update", "client", clientName, "env", envName, "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get last update")
+			return
+		}
+		if federated {
+			for _, release := range envReleases {
+				if release.LastSeen.After(lastUpdate) {
+					lastUpdate = release.LastSeen
+				}
+			}
+		}
+		if lastUpdate.After(latestUpdate) {
+			latestUpdate = lastUpdate
+		}
+
+		grouped, orderedNamespaces := s.groupByNamespace(envReleases)
+		environments = append(environments, map[string]interface{}{
+			"env_name":           envName,
+			"namespaces":         grouped,
+			"ordered_namespaces": orderedNamespaces,
+			"total":              len(envReleases),
+			"timestamp":          lastUpdate,
+		})
+		total += len(envReleases)
+	}
+
+	response := map[string]interface{}{
+		"client_name":  clientName,
+		"environments": environments,
+		"total":        total,
+		"timestamp":    latestUpdate,
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// groupByNamespace groups releases by namespace, each sorted by display order, and lists the namespaces
+// in configuration order followed by those discovered dynamically
+func (s *Server) groupByNamespace(releases []database.CurrentRelease) (map[string][]database.CurrentRelease, []map[string]interface{}) {
+	grouped := make(map[string][]database.CurrentRelease)
+	for _, release := range releases {
+		grouped[release.Namespace] = append(grouped[release.Namespace], release)
+	}
+	for _, namespaceReleases := range grouped {
+		sortByDisplayOrder(namespaceReleases)
+	}
+
+	// Create ordered namespace list based on configuration
+	orderedNamespaces := make([]map[string]interface{}, 0)
+	for _, namespace := range s.namespaces {
+		if releases, exists := grouped[namespace]; exists {
+			orderedNamespaces = append(orderedNamespaces, map[string]interface{}{
+				"name":     namespace,
+				"releases": releases,
+			})
+		}
 	}
 
-	// Save to database
-	if err := s.db.UpsertRelease(release); err != nil {
-		log.Printf("Failed to save manual release for %s/%s/%s/%s: %v", namespace, workloadKind, workloadName, container, err)
-		http.Error(w, fmt.Sprintf("Failed to save release: %v", err), http.StatusInternalServerError)
+	// Add any namespaces not in configuration (in case of dynamic discovery)
+	for namespace, releases := range grouped {
+		found := false
+		for _, configNs := range s.namespaces {
+			if configNs == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			orderedNamespaces = append(orderedNamespaces, map[string]interface{}{
+				"name":     namespace,
+				"releases": releases,
+			})
+		}
+	}
+
+	return grouped, orderedNamespaces
+}
+
+// sortByDisplayOrder orders the releases of a namespace by their workload's display order, then by name
+func sortByDisplayOrder(releases []database.CurrentRelease) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		if releases[i].DisplayOrder != releases[j].DisplayOrder {
+			return releases[i].DisplayOrder < releases[j].DisplayOrder
+		}
+		if releases[i].WorkloadName != releases[j].WorkloadName {
+			return releases[i].WorkloadName < releases[j].WorkloadName
+		}
+		return releases[i].ContainerName < releases[j].ContainerName
+	})
+}
+
+// mergeCurrentReleases adds upstream releases to the local ones. When a component is known to several
+// masters, the release seen most recently wins.
+func mergeCurrentReleases(local, upstream []database.CurrentRelease) []database.CurrentRelease {
+	type componentKey struct {
+		clientName, envName, namespace, workloadName, containerName string
+	}
+
+	index := make(map[componentKey]int, len(local))
+	merged := append([]database.CurrentRelease(nil), local...)
+	for i, release := range merged {
+		index[componentKey{release.ClientName, release.EnvName, release.Namespace, release.WorkloadName, release.ContainerName}] = i
+	}
+
+	for _, release := range upstream {
+		key := componentKey{release.ClientName, release.EnvName, release.Namespace, release.WorkloadName, release.ContainerName}
+		if i, exists := index[key]; exists {
+			if release.LastSeen.After(merged[i].LastSeen) {
+				merged[i] = release
+			}
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, release)
+	}
+
+	return merged
+}
+
+// splitEnvNames splits a comma-separated env_name parameter, dropping blanks and duplicates
+func splitEnvNames(envName string) []string {
+	var envNames []string
+	for _, name := range strings.Split(envName, ",") {
+		if name = strings.TrimSpace(name); name != "" && !containsString(envNames, name) {
+			envNames = append(envNames, name)
+		}
+	}
+	return envNames
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// singleTenantDefaults returns the sole client/environment served by this instance, either forced
+// by SINGLE_TENANT or because the database only holds a single client/environment combination
+func (s *Server) singleTenantDefaults() (clientName, envName string, ok bool) {
+	if s.config.SingleTenant {
+		return s.config.ClientName, s.config.EnvName, true
+	}
+
+	clientEnvs, err := s.db.GetAvailableClientsAndEnvironments()
+	if err != nil {
+		slog.Error("Failed to detect single-tenant defaults", "error", err)
+		return "", "", false
+	}
+	if len(clientEnvs) != 1 {
+		return "", "", false
+	}
+	for client, envs := range clientEnvs {
+		if len(envs) == 1 {
+			return client, envs[0], true
+		}
+	}
+	return "", "", false
+}
+
+// historyRetention returns the number of releases kept per component by the history cleanup
+// (HISTORY_RETENTION_COUNT), also returned by the history endpoint when no limit is requested
+func (s *Server) historyRetention() int {
+	if s.config.HistoryRetention > 0 {
+		return s.config.HistoryRetention
+	}
+	return database.DefaultKeepReleases
+}
+
+// defaultMaxHistoryLimit is the largest history page served when MAX_HISTORY_LIMIT is not set
+const defaultMaxHistoryLimit = 200
+
+// handleReleaseHistory returns release timeline for a specific component
+func (s *Server) handleReleaseHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+	namespace := vars["namespace"]
+	workload := vars["workload"]
+	container := vars["container"]
+
+	if namespace == "" || workload == "" || container == "" || requestedClientName == "" || envName == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required parameters: namespace, workload, container, client_name, env_name")
+		return
+	}
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
+		return
+	}
+
+	limit := s.historyRetention()
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid limit parameter")
+			return
+		}
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid offset parameter")
+			return
+		}
+	}
+
+	// Enforce the server-side maximum regardless of the requested limit
+	maxLimit := s.config.MaxHistoryLimit
+	if maxLimit <= 0 {
+		maxLimit = defaultMaxHistoryLimit
+	}
+	capped := limit > maxLimit && r.URL.Query().Get("limit") != ""
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	history, err := s.db.GetReleaseHistoryPage(namespace, workload, container, requestedClientName, envName, limit, offset)
+	if err != nil {
+		requestLogger(r).Error("Failed to get release history", "client", requestedClientName, "env", envName, "namespace", namespace, "workload", workload, "container", container, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get release history")
+		return
+	}
+	component := map[string]string{
+		"namespace":      namespace,
+		"workload_name":  workload,
+		"container_name": container,
+	}
+	// Tell a component never recorded (e.g. a wrong path) apart from a transient failure
+	if history == nil {
+		writeJSON(w, r, http.StatusNotFound, map[string]interface{}{
+			"error": errorBody{
+				Code:    codeNotFound,
+				Message: fmt.Sprintf("No releases recorded for %s/%s/%s in %s/%s", namespace, workload, container, requestedClientName, envName),
+			},
+			"component": component,
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"component": component,
+		"history":   history,
+		"limit":     limit,
+		"offset":    offset,
+		"capped":    capped,
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleReleaseConsistency compares the current version of every component across a client's environments
+func (s *Server) handleReleaseConsistency(w http.ResponseWriter, r *http.Request) {
+	requestedClientName := mux.Vars(r)["client"]
+	if requestedClientName == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required parameter: client")
+		return
+	}
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) {
+		return
+	}
+
+	// Environment-scoped keys only see their environment
+	components, err := s.db.GetReleaseConsistency(requestedClientName, getEnvScopeFromRequest(r))
+	if err != nil {
+		requestLogger(r).Error("Failed to get release consistency", "client", requestedClientName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get release consistency")
+		return
+	}
+	if components == nil {
+		components = []database.ComponentConsistency{}
+	}
+
+	consistent := 0
+	for _, c := range components {
+		if c.Consistent {
+			consistent++
+		}
+	}
+
+	response := map[string]interface{}{
+		"client_name": requestedClientName,
+		"components":  components,
+		"total":       len(components),
+		"consistent":  consistent,
+		"divergent":   len(components) - consistent,
+		"timestamp":   time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleEnvironmentDiff compares the current version of every component in two environments of a client
+func (s *Server) handleEnvironmentDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	requestedClientName := query.Get("client")
+	envA := query.Get("env_a")
+	envB := query.Get("env_b")
+
+	if requestedClientName == "" || envA == "" || envB == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required query parameters: client, env_a, env_b")
+		return
+	}
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envA) || !authorizeEnv(w, r, envB) {
+		return
+	}
+
+	components, err := s.db.DiffEnvironments(requestedClientName, envA, envB)
+	if err != nil {
+		requestLogger(r).Error("Failed to diff environments", "client", requestedClientName, "env", envA, "other_env", envB, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to diff environments")
+		return
+	}
+
+	summary := map[string]int{
+		database.DiffMatch:   0,
+		database.DiffDiffers: 0,
+		database.DiffOnlyInA: 0,
+		database.DiffOnlyInB: 0,
+	}
+	for _, c := range components {
+		summary[c.Status]++
+	}
+
+	response := map[string]interface{}{
+		"client_name": requestedClientName,
+		"env_a":       envA,
+		"env_b":       envB,
+		"components":  components,
+		"summary":     summary,
+		"total":       len(components),
+		"timestamp":   time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleDeleteComponent removes a decommissioned component and all of its release history
+func (s *Server) handleDeleteComponent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+	namespace := vars["namespace"]
+	workload := vars["workload"]
+	container := vars["container"]
+
+	if namespace == "" || workload == "" || container == "" || requestedClientName == "" || envName == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required parameters: namespace, workload, container, client_name, env_name")
+		return
+	}
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
+		return
+	}
+
+	deleted, err := s.db.DeleteComponent(namespace, workload, container, requestedClientName, envName)
+	if err != nil {
+		requestLogger(r).Error("Failed to delete component", "client", requestedClientName, "env", envName, "namespace", namespace, "workload", workload, "container", container, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to delete component")
+		return
+	}
+
+	requestLogger(r).Info("Deleted component", "client", requestedClientName, "env", envName, "namespace", namespace, "workload", workload, "container", container, "releases", deleted)
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"deleted":   deleted,
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleDetectionLag reports how long it took to record new images of a client/environment after
+// their containers started, per component and overall
+func (s *Server) handleDetectionLag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
+		return
+	}
+
+	components, err := s.db.GetDetectionLags(requestedClientName, envName)
+	if err != nil {
+		requestLogger(r).Error("Failed to get detection lags", "client", requestedClientName, "env", envName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get detection lags")
+		return
+	}
+	if components == nil {
+		components = []database.ComponentDetectionLag{}
+	}
+
+	var lags []float64
+	for _, c := range components {
+		for _, sample := range c.Samples {
+			lags = append(lags, sample.LagSeconds)
+		}
+	}
+	minLag, medianLag, meanLag, maxLag := database.LagStats(lags)
+
+	response := map[string]interface{}{
+		"client_name": requestedClientName,
+		"env_name":    envName,
+		"components":  components,
+		"summary": map[string]interface{}{
+			"samples":        len(lags),
+			"min_seconds":    minLag,
+			"median_seconds": medianLag,
+			"mean_seconds":   meanLag,
+			"max_seconds":    maxLag,
+		},
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handlePurgeFailedReleases bulk deletes failed sync attempts, optionally only those older than older_than_days
+func (s *Server) handlePurgeFailedReleases(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var deleted int64
+	var err error
+	if olderThanStr := r.URL.Query().Get("older_than_days"); olderThanStr != "" {
+		olderThanDays, convErr := strconv.Atoi(olderThanStr)
+		if convErr != nil || olderThanDays < 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid older_than_days parameter")
+			return
+		}
+		deleted, err = s.db.PurgeFailedReleases(time.Now().AddDate(0, 0, -olderThanDays))
+	} else {
+		deleted, err = s.db.DeleteAllFailedReleases()
+	}
+	if err != nil {
+		requestLogger(r).Error("Failed to purge failed releases", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to purge failed releases")
+		return
+	}
+
+	requestLogger(r).Info("Purged failed releases", "count", deleted)
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"deleted":   deleted,
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// RetentionRequest is the body of PUT /api/admin/retention/{client}
+type RetentionRequest struct {
+	KeepCount *int `json:"keep_count"`
+	KeepDays  *int `json:"keep_days"`
+}
+
+// handleListRetention lists the per-client retention policies (admin only)
+func (s *Server) handleListRetention(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	retentions, err := s.db.GetClientRetentions()
+	if err != nil {
+		requestLogger(r).Error("Failed to get client retention", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get client retention")
+		return
+	}
+	if retentions == nil {
+		retentions = []database.ClientRetention{}
+	}
+
+	response := map[string]interface{}{
+		"policies":           retentions,
+		"total":              len(retentions),
+		"default_keep_count": s.historyRetention(),
+		"timestamp":          time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleSetRetention sets the release history retention of a client (admin only)
+func (s *Server) handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	clientName := s.normalizeName(mux.Vars(r)["client"])
+	var req RetentionRequest
+	if err := s.decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.KeepCount == nil && req.KeepDays == nil {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "At least one of keep_count and keep_days is required")
+		return
+	}
+	if (req.KeepCount != nil && *req.KeepCount < 1) || (req.KeepDays != nil && *req.KeepDays < 1) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParams, "keep_count and keep_days must be positive")
+		return
+	}
+
+	retention := &database.ClientRetention{ClientName: clientName, KeepCount: req.KeepCount, KeepDays: req.KeepDays}
+	if err := s.db.SetClientRetention(retention); err != nil {
+		requestLogger(r).Error("Failed to set client retention", "client", clientName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to set client retention")
+		return
+	}
+
+	requestLogger(r).Info("Set client retention", "client", clientName, "keep_count", formatLimit(req.KeepCount), "keep_days", formatLimit(req.KeepDays))
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"policy":    retention,
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleDeleteRetention removes the retention policy of a client, which falls back to the default (admin only)
+func (s *Server) handleDeleteRetention(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	clientName := s.normalizeName(mux.Vars(r)["client"])
+	deleted, err := s.db.DeleteClientRetention(clientName)
+	if err != nil {
+		requestLogger(r).Error("Failed to delete client retention", "client", clientName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to delete client retention")
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "No retention policy for this client")
+		return
+	}
+
+	requestLogger(r).Info("Removed client retention", "client", clientName)
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// formatLimit renders an optional retention limit for logs
+func formatLimit(limit *int) string {
+	if limit == nil {
+		return "none"
+	}
+	return strconv.Itoa(*limit)
+}
+
+// handleSyncFailed lists the releases moved to failed_releases after master kept rejecting them
+func (s *Server) handleSyncFailed(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	releases, err := s.db.GetFailedReleases()
+	if err != nil {
+		requestLogger(r).Error("Failed to get failed releases", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get failed releases")
+		return
+	}
+	if releases == nil {
+		releases = []database.FailedRelease{}
+	}
+
+	response := map[string]interface{}{
+		"failed_releases": releases,
+		"total":           len(releases),
+		"timestamp":       time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleSyncStatus reports the sync backlog of a slave: the releases waiting to be sent to master, how
+// long the oldest has waited and when the last sync succeeded
+func (s *Server) handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if s.config.Mode != "slave" {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Sync status is only available in slave mode")
+		return
+	}
+
+	stats, err := s.db.GetPendingReleaseStats()
+	if err != nil {
+		requestLogger(r).Error("Failed to get pending release stats", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get pending release stats")
+		return
+	}
+
+	now := time.Now().UTC()
+	response := map[string]interface{}{
+		"pending_count":              stats.Count,
+		"syncable_count":             stats.Syncable,
+		"oldest_pending_at":          nil,
+		"oldest_pending_age_seconds": 0,
+		"last_sync_success":          nil,
+		"master_configured":          s.config.MasterURL != "",
+		"timestamp":                  now,
+	}
+	if stats.OldestCreatedAt != nil {
+		response["oldest_pending_at"] = stats.OldestCreatedAt.UTC()
+		response["oldest_pending_age_seconds"] = max(int(now.Sub(*stats.OldestCreatedAt).Seconds()), 0)
+	}
+	if worker, ok := s.workers.status(now)[WorkerSync]; ok && worker.LastSuccess != nil {
+		response["last_sync_success"] = worker.LastSuccess
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// exportBatchSize is the number of release rows read from the database per export batch
+const exportBatchSize = 500
+
+// handleReleasesExport streams every release row as newline-delimited JSON
+func (s *Server) handleReleasesExport(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	sinceID := 0
+	if sinceIDStr := r.URL.Query().Get("since_id"); sinceIDStr != "" {
+		var err error
+		sinceID, err = strconv.Atoi(sinceIDStr)
+		if err != nil || sinceID < 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid since_id parameter")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	exported := 0
+	for {
+		releases, err := s.db.GetReleasesAfterID(sinceID, exportBatchSize)
+		if err != nil {
+			// Headers are already sent, the truncated stream is all we can report
+			requestLogger(r).Error("Failed to export releases", "since_id", sinceID, "error", err)
+			return
+		}
+
+		for _, release := range releases {
+			if err := encoder.Encode(release); err != nil {
+				requestLogger(r).Warn("Release export aborted", "rows", exported, "error", err)
+				return
+			}
+			sinceID = release.ID
+			exported++
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(releases) < exportBatchSize {
+			break
+		}
+	}
+
+	requestLogger(r).Info("Exported releases", "count", exported)
+}
+
+// currentReleasesCSVHeader lists the columns of the CSV export of current releases
+var currentReleasesCSVHeader = []string{"namespace", "workload", "container", "image", "tag", "sha", "last_seen"}
+
+// handleCurrentReleasesExport streams the current releases of a client environment as a CSV or JSON
+// download, for spreadsheets and audits. Federated upstream releases are not included.
+func (s *Server) handleCurrentReleasesExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	requestedClientName := query.Get("client")
+	if requestedClientName == "" {
+		requestedClientName = query.Get("client_name")
+	}
+	envName := query.Get("env")
+	if envName == "" {
+		envName = query.Get("env_name")
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid format parameter (expected csv or json)")
+		return
+	}
+
+	requestedClientName, envName, changedSince, ok := s.currentReleasesScope(w, r, requestedClientName, envName)
+	if !ok {
+		return
+	}
+	envNames := splitEnvNames(envName)
+	if len(envNames) > 1 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Multiple env values are not supported by the export")
+		return
+	}
+
+	filename := fmt.Sprintf("releases-%s-%s-%s.%s", requestedClientName, envNames[0], time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	if format == "csv" {
+		csvWriter.Write(currentReleasesCSVHeader)
+	} else {
+		io.WriteString(w, "[")
+	}
+
+	exported := 0
+	err := s.db.EachCurrentRelease(requestedClientName, envNames, changedSince, func(release database.CurrentRelease) error {
+		if format == "csv" {
+			image := release.ImageName
+			if release.ImageRepo != "" {
+				image = release.ImageRepo + "/" + release.ImageName
+			}
+			csvWriter.Write([]string{
+				release.Namespace, release.WorkloadName, release.ContainerName, image,
+				release.ImageTag, release.ImageSHA, release.LastSeen.UTC().Format(time.RFC3339),
+			})
+		} else {
+			if exported > 0 {
+				io.WriteString(w, ",")
+			}
+			if err := encoder.Encode(release); err != nil {
+				return err
+			}
+		}
+		exported++
+
+		if exported%exportBatchSize == 0 {
+			csvWriter.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return csvWriter.Error()
+	})
+	if err != nil {
+		// Headers are already sent, the truncated download is all we can report
+		requestLogger(r).Warn("Current releases export aborted", "client", requestedClientName, "env", envName, "rows", exported, "error", err)
+		return
+	}
+
+	if format == "csv" {
+		csvWriter.Flush()
+	} else {
+		io.WriteString(w, "]\n")
+	}
+
+	requestLogger(r).Info("Exported current releases", "client", requestedClientName, "env", envName, "format", format, "count", exported)
+}
+
+// handleReleaseProvenance returns when and by which reporter the current release of a component was recorded
+func (s *Server) handleReleaseProvenance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+	namespace := vars["namespace"]
+	workload := vars["workload"]
+	container := vars["container"]
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
+		return
+	}
+
+	provenance, err := s.db.GetReleaseProvenance(namespace, workload, container, requestedClientName, envName)
+	if err != nil {
+		requestLogger(r).Error("Failed to get release provenance", "namespace", namespace, "workload", workload, "container", container, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get release provenance")
+		return
+	}
+	if provenance == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Component not found")
+		return
+	}
+
+	response := map[string]interface{}{
+		"component": map[string]string{
+			"namespace":      namespace,
+			"workload_name":  workload,
+			"container_name": container,
+		},
+		"provenance": provenance,
+		"timestamp":  time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleReleaseByTag returns when a specific tag was deployed for a component and whether it is still current
+func (s *Server) handleReleaseByTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+	namespace := vars["namespace"]
+	workload := vars["workload"]
+	container := vars["container"]
+	tag := vars["tag"]
+
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
 		return
 	}
 
-	if s.config.Mode == "slave" {
-		// In slave mode, also store in pending_releases table as queue
-		pendingRelease := &database.PendingRelease{
-			Namespace:     namespace,
-			WorkloadName:  workloadName,
-			WorkloadType:  workloadKind,
-			ContainerName: container,
-			ImageRepo:     repo,
-			ImageName:     name,
-			ImageTag:      tag,
-			ImageSHA:      req.ImageSHA,
-			ClientName:    clientName,
-			EnvName:       envName,
-			FirstSeen:     releasedAt,
-			LastSeen:      releasedAt,
-		}
-
-		if err := s.db.UpsertPendingRelease(pendingRelease); err != nil {
-			log.Printf("Failed to upsert pending release for %s/%s/%s/%s: %v", namespace, workloadKind, workloadName, container, err)
-			http.Error(w, fmt.Sprintf("Failed to upsert pending release: %v", err), http.StatusInternalServerError)
-			return
-		}
+	deployment, err := s.db.GetReleaseByTag(namespace, workload, container, requestedClientName, envName, tag)
+	if err != nil {
+		requestLogger(r).Error("Failed to get release by tag", "namespace", namespace, "workload", workload, "container", container, "tag", tag, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get release by tag")
+		return
+	}
+	if deployment == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Tag '%s' not found for component", tag))
+		return
 	}
-
-	log.Printf("Manual release collected: %s at %s %s/%s/%s/%s -> %s", clientName, envName, namespace, workloadKind, workloadName, container, req.ImageTag)
 
 	response := map[string]interface{}{
-		"status":  "success",
-		"message": "Release collected successfully",
 		"component": map[string]string{
 			"namespace":      namespace,
-			"workload_kind":  workloadKind,
-			"workload_name":  workloadName,
+			"workload_name":  workload,
 			"container_name": container,
 		},
-		"release": map[string]interface{}{
-			"version":     req.ImageTag,
-			"image_repo":  repo,
-			"image_name":  name,
-			"image_tag":   tag,
-			"image_sha":   req.ImageSHA,
-			"released_at": releasedAt,
-		},
+		"release":   deployment,
 		"timestamp": time.Now().UTC(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// handleCurrentReleases returns all current deployed images
-func (s *Server) handleCurrentReleases(w http.ResponseWriter, r *http.Request) {
-	// Get client_name and env_name filters from query parameters (required)
-	requestedClientName := r.URL.Query().Get("client_name")
-	envName := r.URL.Query().Get("env_name")
+// handleScalingHistory returns the replica count changes recorded for a workload, most recent first
+func (s *Server) handleScalingHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+	namespace := vars["namespace"]
+	workload := vars["workload"]
 
-	if requestedClientName == "" || envName == "" {
-		http.Error(w, "Missing required query parameters: client_name, env_name", http.StatusBadRequest)
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
 		return
 	}
 
-	// Check client access permissions
-	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
-	if !isAdmin && authenticatedClientName != requestedClientName {
-		log.Printf("Access denied for %s %s: API key not authorized for client '%s'", r.Method, r.URL.Path, requestedClientName)
-		http.Error(w, fmt.Sprintf("Access denied: API key is not authorized for client '%s'", requestedClientName), http.StatusForbidden)
-		return
+	// Scaling events share the history page limit
+	limit := s.config.MaxHistoryLimit
+	if limit <= 0 {
+		limit = defaultMaxHistoryLimit
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		requested, err := strconv.Atoi(limitStr)
+		if err != nil || requested <= 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParams, "Invalid limit parameter")
+			return
+		}
+		limit = min(limit, requested)
 	}
 
-	releases, err := s.db.GetCurrentReleasesFiltered(requestedClientName, envName)
+	events, err := s.db.GetScalingHistory(requestedClientName, envName, namespace, workload, limit)
 	if err != nil {
-		log.Printf("Failed to get current releases: %v", err)
-		http.Error(w, "Failed to get current releases", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to get scaling history", "namespace", namespace, "workload", workload, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get scaling history")
 		return
 	}
+	if events == nil {
+		events = []database.ScalingEvent{}
+	}
 
-	// Group releases by namespace for better organization
-	grouped := make(map[string][]database.CurrentRelease)
-	for _, release := range releases {
-		grouped[release.Namespace] = append(grouped[release.Namespace], release)
+	response := map[string]interface{}{
+		"workload": map[string]string{
+			"namespace":     namespace,
+			"workload_name": workload,
+		},
+		"events":    events,
+		"total":     len(events),
+		"timestamp": time.Now().UTC(),
 	}
 
-	// Create ordered namespace list based on configuration
-	orderedNamespaces := make([]map[string]interface{}, 0)
-	for _, namespace := range s.namespaces {
-		if releases, exists := grouped[namespace]; exists {
-			orderedNamespaces = append(orderedNamespaces, map[string]interface{}{
-				"name":     namespace,
-				"releases": releases,
-			})
-		}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleCollectionGaps lists components the collector saw but could not record (e.g. unresolved SHA)
+func (s *Server) handleCollectionGaps(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestedClientName := vars["client"]
+	envName := vars["env"]
+
+	if requestedClientName == "" || envName == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required parameters: client, env")
+		return
 	}
 
-	// Add any namespaces not in configuration (in case of dynamic discovery)
-	for namespace, releases := range grouped {
-		found := false
-		for _, configNs := range s.namespaces {
-			if configNs == namespace {
-				found = true
-				break
-			}
-		}
-		if !found {
-			orderedNamespaces = append(orderedNamespaces, map[string]interface{}{
-				"name":     namespace,
-				"releases": releases,
-			})
-		}
+	// Check client access permissions
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
+		return
 	}
 
-	lastUpdate, err := s.db.GetLastClientEnvUpdate(requestedClientName, envName)
+	gaps, err := s.db.GetCollectionGaps(requestedClientName, envName)
 	if err != nil {
-		log.Printf("Failed to get last update: %v", err)
-		http.Error(w, "Failed to get last update", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to get collection gaps", "client", requestedClientName, "env", envName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get collection gaps")
 		return
 	}
+	if gaps == nil {
+		gaps = []database.CollectionGap{}
+	}
 
 	response := map[string]interface{}{
-		"namespaces":         grouped, // Keep for backward compatibility
-		"ordered_namespaces": orderedNamespaces,
-		"total":              len(releases),
-		"timestamp":          lastUpdate,
+		"client_name": requestedClientName,
+		"env_name":    envName,
+		"gaps":        gaps,
+		"total":       len(gaps),
+		"timestamp":   time.Now().UTC(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// handleReleaseHistory returns release timeline for a specific component
-func (s *Server) handleReleaseHistory(w http.ResponseWriter, r *http.Request) {
+// handleGhostWorkloads lists workloads that exist in the cluster but have no running pods
+func (s *Server) handleGhostWorkloads(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	requestedClientName := vars["client"]
 	envName := vars["env"]
-	namespace := vars["namespace"]
-	workload := vars["workload"]
-	container := vars["container"]
 
-	if namespace == "" || workload == "" || container == "" || requestedClientName == "" || envName == "" {
-		http.Error(w, "Missing required parameters: namespace, workload, container, client_name, env_name", http.StatusBadRequest)
+	if requestedClientName == "" || envName == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "Missing required parameters: client, env")
 		return
 	}
 
 	// Check client access permissions
-	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
-	if !isAdmin && authenticatedClientName != requestedClientName {
-		log.Printf("Access denied for %s %s: API key not authorized for client '%s'", r.Method, r.URL.Path, requestedClientName)
-		http.Error(w, fmt.Sprintf("Access denied: API key is not authorized for client '%s'", requestedClientName), http.StatusForbidden)
+	if !authorizeClient(w, r, requestedClientName) || !authorizeEnv(w, r, envName) {
 		return
 	}
 
-	history, err := s.db.GetReleaseHistory(namespace, workload, container, requestedClientName, envName)
+	ghosts, err := s.db.GetGhostWorkloads(requestedClientName, envName)
 	if err != nil {
-		log.Printf("Failed to get release history for %s/%s/%s: %v", namespace, workload, container, err)
-		http.Error(w, "Failed to get release history", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to get ghost workloads", "client", requestedClientName, "env", envName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get ghost workloads")
 		return
 	}
+	if ghosts == nil {
+		ghosts = []database.GhostWorkload{}
+	}
 
 	response := map[string]interface{}{
-		"component": map[string]string{
-			"namespace":      namespace,
-			"workload_name":  workload,
-			"container_name": container,
-		},
-		"history":   history,
+		"client_name": requestedClientName,
+		"env_name":    envName,
+		"ghosts":      ghosts,
+		"total":       len(ghosts),
+		"timestamp":   time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleSelectorDiagnostics lists, per workload, the pod selectors the last collection tried and how many pods each found
+func (s *Server) handleSelectorDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if s.k8s == nil {
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Kubernetes client not available")
+		return
+	}
+
+	workloads := s.k8s.SelectorDiagnostics()
+	unmatched := 0
+	for _, workload := range workloads {
+		if workload.Matched == "" {
+			unmatched++
+		}
+	}
+
+	response := map[string]interface{}{
+		"workloads": workloads,
+		"total":     len(workloads),
+		"unmatched": unmatched,
 		"timestamp": time.Now().UTC(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// handleMetrics serves the Prometheus metrics
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Metrics are not enabled")
+		return
+	}
+	s.metrics.Handler().ServeHTTP(w, r)
+}
+
+// handleLiveness answers the liveness probe: the process is alive as long as it serves requests
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":    "alive",
+		"timestamp": time.Now().UTC(),
+		"version":   version.Version,
+	})
 }
 
-// handleHealth returns the health status of the application
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+// handleReadiness answers the readiness probe, also served on /health: the database must answer and be
+// fully migrated, and in slave mode the collector must not be wedged
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":    "healthy",
 		"timestamp": time.Now().UTC(),
-		"version":   "1.0.0",
+		"version":   version.Version,
 	}
 
-	// Check database connectivity
-	_, err := s.db.GetCurrentReleases()
+	// Check database connectivity; the full check is opt-in as it scans the releases table
+	status := http.StatusOK
+	var err error
+	if s.config.HealthCheckDepth == config.HealthCheckFull {
+		_, err = s.db.GetCurrentReleases()
+		response["database_check"] = config.HealthCheckFull
+	} else {
+		err = s.db.Ping(r.Context())
+		response["database_check"] = config.HealthCheckPing
+	}
 	if err != nil {
 		response["status"] = "unhealthy"
 		response["database_error"] = err.Error()
-		w.WriteHeader(http.StatusServiceUnavailable)
+		status = http.StatusServiceUnavailable
+	} else if current, latest, err := s.db.SchemaVersion(r.Context()); err != nil || current < latest {
+		response["status"] = "unhealthy"
+		if err != nil {
+			response["database_error"] = err.Error()
+		} else {
+			response["database_error"] = fmt.Sprintf("schema version %d, migrations up to %d not applied", current, latest)
+		}
+		status = http.StatusServiceUnavailable
+	}
+
+	// Report the background workers of slave mode; a collector without success for two intervals is wedged
+	if workers := s.workers.status(time.Now()); len(workers) > 0 {
+		response["workers"] = workers
+		if workers[WorkerCollection].Stale {
+			response["status"] = "unhealthy"
+			response["collection_error"] = "no successful collection within two collection intervals"
+			status = http.StatusServiceUnavailable
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, status, response)
 }
 
 // handleBadgeWithAuth returns an SVG badge with URL-based API key authentication
 func (s *Server) handleBadgeWithAuth(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeBadge(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	s.handleBadgeCore(w, r, vars["workload-kind"], vars["workload-name"], vars["container"], vars["client"], vars["env"], s.tagBadge)
+}
+
+// handleDigestBadgeWithAuth serves a badge showing the short image digest instead of the tag
+func (s *Server) handleDigestBadgeWithAuth(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeBadge(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	s.handleBadgeCore(w, r, vars["workload-kind"], vars["workload-name"], vars["container"], vars["client"], vars["env"], digestBadge)
+}
+
+// handleVersionLabelBadgeWithAuth serves a badge showing the chart or app version recorded from workload labels
+func (s *Server) handleVersionLabelBadgeWithAuth(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeBadge(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	render := s.appVersionBadge
+	if vars["variant"] == "chart" {
+		render = s.chartVersionBadge
+	}
+	s.handleBadgeCore(w, r, vars["workload-kind"], vars["workload-name"], vars["container"], vars["client"], vars["env"], render)
+}
+
+// shieldsEndpoint is the shields.io endpoint badge schema (https://shields.io/badges/endpoint-badge)
+type shieldsEndpoint struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	IsError       bool   `json:"isError,omitempty"`
+}
+
+// handleShieldsBadgeWithAuth serves the badge of a component in the shields.io endpoint format, so
+// shields.io can render it with its own styles. Lookup and authentication match handleBadgeWithAuth.
+func (s *Server) handleShieldsBadgeWithAuth(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workloadKind, workloadName, container := vars["workload-kind"], vars["workload-name"], vars["container"]
+	clientName, envName := vars["client"], vars["env"]
+
+	badge := shieldsEndpoint{SchemaVersion: 1, Label: envName}
+	if denied := s.badgeAccessDenied(r); denied != "" {
+		badge.Message, badge.Color, badge.IsError = denied, "red", true
+	} else if workloadKind == "" || workloadName == "" || container == "" || clientName == "" || envName == "" {
+		badge.Message, badge.Color, badge.IsError = "invalid request", "red", true
+	} else {
+		release, err := s.badgeLookups.Get(workloadKind, workloadName, container, clientName, envName)
+		switch {
+		case err != nil && strings.Contains(err.Error(), "multiple releases found"):
+			badge.Message, badge.Color = "multiple found", "yellow"
+		case err != nil:
+			requestLogger(r).Error("Badge query error", "workload_type", workloadKind, "workload", workloadName, "container", container, "client", clientName, "env", envName, "error", err)
+			badge.Message, badge.Color, badge.IsError = "query error", "red", true
+		case release == nil:
+			badge.Message, badge.Color = "not deployed", "lightgrey"
+		case isUnknownVersion(release.ImageTag):
+			badge.Message, badge.Color = s.config.UnknownVersionText, "lightgrey"
+			if badge.Message == "" {
+				badge.Message = "unknown"
+			}
+		default:
+			badge.Message, badge.Color = release.ImageTag, "green"
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, r, http.StatusOK, badge)
+}
+
+// authorizeBadge validates the API key embedded in a badge URL.
+// It serves an error badge and returns false when access is denied.
+func (s *Server) authorizeBadge(w http.ResponseWriter, r *http.Request) bool {
+	if denied := s.badgeAccessDenied(r); denied != "" {
+		s.serveBadge(w, CreateErrorBadge(mux.Vars(r)["env"], denied, badgeSizeFromRequest(r)))
+		return false
+	}
+	return true
+}
+
+// badgeAccessDenied validates the API key embedded in a badge URL. It returns the message shown when
+// access is denied ("unauthorized" or "access denied"), or "" when the badge may be served.
+func (s *Server) badgeAccessDenied(r *http.Request) string {
 	vars := mux.Vars(r)
 	apiKey := vars["api-key"]
-	workloadKind := vars["workload-kind"]
-	workloadName := vars["workload-name"]
-	container := vars["container"]
 	requestedClientName := vars["client"]
 	envName := vars["env"]
 
 	// Validate API key if authentication is enabled
-	if len(s.apiKeys) > 0 {
+	if len(s.currentAPIKeys()) > 0 {
 		if apiKey == "" {
-			log.Printf("Badge authentication failed for %s %s: missing API key", r.Method, r.URL.Path)
-			badge := CreateErrorBadge(envName, "unauthorized")
-			s.serveBadge(w, badge)
-			return
+			requestLogger(r).Warn("Badge authentication failed: missing API key", "method", r.Method, "path", r.URL.Path)
+			return "unauthorized"
 		}
 
 		// Parse API key to determine type and extract components
-		authenticatedClientName, clientAuth, isAdmin := parseAPIKey(apiKey)
+		authenticatedClientName, authenticatedEnvName, _, isAdmin, err := parseAPIKey(apiKey, s.config.APIKeyLegacyFormat)
 
 		// Validate API key access
-		if !s.validateAPIKeyAccess(authenticatedClientName, clientAuth, isAdmin) {
+		if err != nil || !s.isValidAPIKey(apiKey) {
 			// Log failed authentication attempt with sanitized key
 			keyPreview := apiKey[:min(8, len(apiKey))] + "..."
-			log.Printf("Badge authentication failed for %s %s (key: %s)", r.Method, r.URL.Path, keyPreview)
-			badge := CreateErrorBadge(envName, "unauthorized")
-			s.serveBadge(w, badge)
-			return
+			requestLogger(r).Warn("Badge authentication failed", "method", r.Method, "path", r.URL.Path, "key", keyPreview)
+			return "unauthorized"
 		}
+		s.keyUsage.record(apiKey)
 
 		// Check client access permissions for standard API keys
-		if !isAdmin && authenticatedClientName != requestedClientName {
-			log.Printf("Badge access denied for %s %s: API key not authorized for client '%s'", r.Method, r.URL.Path, requestedClientName)
-			badge := CreateErrorBadge(envName, "access denied")
-			s.serveBadge(w, badge)
-			return
+		if !isAdmin && s.normalizeName(authenticatedClientName) != requestedClientName {
+			requestLogger(r).Warn("Badge access denied: API key not authorized for client", "method", r.Method, "path", r.URL.Path, "client", requestedClientName)
+			return "access denied"
+		}
+
+		// Environment-scoped client keys only serve badges of their environment
+		if !isAdmin && authenticatedEnvName != "" && s.normalizeName(authenticatedEnvName) != envName {
+			requestLogger(r).Warn("Badge access denied: API key not authorized for environment", "method", r.Method, "path", r.URL.Path, "env", envName)
+			return "access denied"
 		}
 	}
 
-	// Call the core badge logic
-	s.handleBadgeCore(w, r, workloadKind, workloadName, container, requestedClientName, envName)
+	return ""
+}
+
+// badgeRenderer builds the success badge for a found release
+type badgeRenderer func(envName string, release *database.CurrentRelease, size BadgeSize) string
+
+// tagBadge renders the image tag of a release, or the configured placeholder when it is unknown
+func (s *Server) tagBadge(envName string, release *database.CurrentRelease, size BadgeSize) string {
+	return CreateVersionBadge(envName, release.ImageTag, s.config.UnknownVersionText, size)
+}
+
+// chartVersionBadge renders the Helm chart version of a release, or the configured placeholder when it is unknown
+func (s *Server) chartVersionBadge(envName string, release *database.CurrentRelease, size BadgeSize) string {
+	return CreateVersionBadge(envName, release.ChartVersion, s.config.UnknownVersionText, size)
+}
+
+// appVersionBadge renders the application version label of a release, or the configured placeholder when it is unknown
+func (s *Server) appVersionBadge(envName string, release *database.CurrentRelease, size BadgeSize) string {
+	return CreateVersionBadge(envName, release.AppVersion, s.config.UnknownVersionText, size)
+}
+
+// digestBadge renders the short image digest of a release
+func digestBadge(envName string, release *database.CurrentRelease, size BadgeSize) string {
+	digest := shortDigest(release.ImageSHA)
+	if digest == "" {
+		return CreateNoDigestBadge(envName, size)
+	}
+	return CreateSuccessBadge(envName, digest, size)
+}
+
+// shortDigest returns the first 12 hex characters of an image digest, without the algorithm prefix
+func shortDigest(imageSHA string) string {
+	digest := strings.TrimPrefix(imageSHA, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
 }
 
 // handleBadgeCore contains the core badge generation logic
-func (s *Server) handleBadgeCore(w http.ResponseWriter, r *http.Request, workloadKind, workloadName, container, clientName, envName string) {
+func (s *Server) handleBadgeCore(w http.ResponseWriter, r *http.Request, workloadKind, workloadName, container, clientName, envName string, render badgeRenderer) {
 	if workloadKind == "" || workloadName == "" || container == "" || clientName == "" || envName == "" {
-		log.Printf("Badge request missing parameters: kind=%s, name=%s, container=%s, client=%s, env=%s", workloadKind, workloadName, container, clientName, envName)
-		badge := CreateErrorBadge(envName, "invalid request")
+		requestLogger(r).Warn("Badge request missing parameters", "workload_type", workloadKind, "workload", workloadName, "container", container, "client", clientName, "env", envName)
+		badge := CreateErrorBadge(envName, "invalid request", badgeSizeFromRequest(r))
 		s.serveBadge(w, badge)
 		return
 	}
 
+	// all_containers=true summarizes every container of the workload instead of the one in the path
+	if r.URL.Query().Get("all_containers") == "true" {
+		s.serveWorkloadBadge(w, r, workloadKind, workloadName, clientName, envName, render)
+		return
+	}
+
 	// Query database for current release
-	release, err := s.db.GetCurrentReleaseByWorkload(workloadKind, workloadName, container, clientName, envName)
+	release, err := s.badgeLookups.Get(workloadKind, workloadName, container, clientName, envName)
 	if err != nil {
-		log.Printf("Badge query error for %s/%s/%s/%s/%s: %v", workloadKind, workloadName, container, clientName, envName, err)
+		requestLogger(r).Error("Badge query error", "workload_type", workloadKind, "workload", workloadName, "container", container, "client", clientName, "env", envName, "error", err)
 
 		// Check if it's a "multiple found" error
 		if strings.Contains(err.Error(), "multiple releases found") {
-			badge := CreateMultipleFoundBadge(envName)
+			badge := CreateMultipleFoundBadge(envName, badgeSizeFromRequest(r))
 			s.serveBadge(w, badge)
 			return
 		}
 
 		// Other database errors
-		badge := CreateErrorBadge(envName, "query error")
+		badge := CreateErrorBadge(envName, "query error", badgeSizeFromRequest(r))
 		s.serveBadge(w, badge)
 		return
 	}
 
 	if release == nil {
 		// No release found
-		log.Printf("No release found for %s/%s/%s/%s/%s", workloadKind, workloadName, container, clientName, envName)
-		badge := CreateNotFoundBadge(envName)
+		requestLogger(r).Info("No release found for badge", "workload_type", workloadKind, "workload", workloadName, "container", container, "client", clientName, "env", envName)
+		badge := CreateNotFoundBadge(envName, badgeSizeFromRequest(r))
 		s.serveBadge(w, badge)
 		return
 	}
 
 	// Success - create badge with version
-	log.Printf("Badge generated for %s/%s/%s/%s/%s: %s", workloadKind, workloadName, container, clientName, envName, release.ImageTag)
-	badge := CreateSuccessBadge(envName, release.ImageTag)
+	requestLogger(r).Info("Badge generated", "workload_type", workloadKind, "workload", workloadName, "container", container, "client", clientName, "env", envName, "tag", release.ImageTag)
+	badge := render(envName, release, badgeSizeFromRequest(r))
 	s.serveBadge(w, badge)
 }
 
+// serveWorkloadBadge renders the badge of every container of a workload, ignoring init containers. When all
+// containers render the same badge (e.g. run the same tag) it is served, otherwise a "mixed" warning badge.
+func (s *Server) serveWorkloadBadge(w http.ResponseWriter, r *http.Request, workloadKind, workloadName, clientName, envName string, render badgeRenderer) {
+	size := badgeSizeFromRequest(r)
+	releases, err := s.db.GetCurrentReleasesForWorkload(workloadKind, workloadName, clientName, envName)
+	if err != nil {
+		requestLogger(r).Error("Badge query error", "workload_type", workloadKind, "workload", workloadName, "client", clientName, "env", envName, "error", err)
+		if strings.Contains(err.Error(), "multiple releases found") {
+			s.serveBadge(w, CreateMultipleFoundBadge(envName, size))
+			return
+		}
+		s.serveBadge(w, CreateErrorBadge(envName, "query error", size))
+		return
+	}
+
+	var badge string
+	for i := range releases {
+		if strings.HasPrefix(releases[i].ContainerName, kubernetes.InitContainerPrefix) {
+			continue
+		}
+		containerBadge := render(envName, &releases[i], size)
+		if badge != "" && containerBadge != badge {
+			requestLogger(r).Info("Badge generated with mixed releases", "workload_type", workloadKind, "workload", workloadName, "client", clientName, "env", envName)
+			s.serveBadge(w, CreateMixedBadge(envName, size))
+			return
+		}
+		badge = containerBadge
+	}
+
+	if badge == "" {
+		requestLogger(r).Info("No release found for badge", "workload_type", workloadKind, "workload", workloadName, "client", clientName, "env", envName)
+		s.serveBadge(w, CreateNotFoundBadge(envName, size))
+		return
+	}
+	s.serveBadge(w, badge)
+}
+
+// badgeSizeFromRequest reads the optional height and font_size query parameters of a badge request.
+// Values that are not positive integers are ignored; the rest is bounded when the badge is rendered.
+func badgeSizeFromRequest(r *http.Request) BadgeSize {
+	var size BadgeSize
+	if height, err := strconv.Atoi(r.URL.Query().Get("height")); err == nil && height > 0 {
+		size.Height = height
+	}
+	if fontSize, err := strconv.Atoi(r.URL.Query().Get("font_size")); err == nil && fontSize > 0 {
+		size.FontSize = fontSize
+	}
+	return size
+}
+
 // serveBadge sends the SVG badge with appropriate headers
 func (s *Server) serveBadge(w http.ResponseWriter, svgContent string) {
 	// Set headers for SVG content
@@ -472,11 +2202,23 @@ func (s *Server) handleClientsEnvironments(w http.ResponseWriter, r *http.Reques
 
 	clientEnvs, err := s.db.GetAvailableClientsAndEnvironments()
 	if err != nil {
-		log.Printf("Failed to get clients and environments: %v", err)
-		http.Error(w, "Failed to get clients and environments", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to get clients and environments", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get clients and environments")
 		return
 	}
 
+	var upstream federation.ClientsEnvironments
+	if s.federation != nil {
+		upstream = s.federation.ClientsEnvironments(r.Context())
+		for clientName, envs := range upstream.ClientsEnvironments {
+			for _, envName := range envs {
+				if !containsString(clientEnvs[clientName], envName) {
+					clientEnvs[clientName] = append(clientEnvs[clientName], envName)
+				}
+			}
+		}
+	}
+
 	// Filter clients based on access permissions
 	if !isAdmin && authenticatedClientName != "" {
 		// For standard API keys, only return the authenticated client
@@ -488,6 +2230,26 @@ func (s *Server) handleClientsEnvironments(w http.ResponseWriter, r *http.Reques
 			// Client not found, return empty result
 			clientEnvs = make(map[string][]string)
 		}
+
+		// Environment-scoped keys only see their environment
+		if allowedEnv := getEnvScopeFromRequest(r); allowedEnv != "" {
+			if containsString(clientEnvs[authenticatedClientName], allowedEnv) {
+				clientEnvs[authenticatedClientName] = []string{allowedEnv}
+			} else {
+				clientEnvs = make(map[string][]string)
+			}
+		}
+	}
+
+	// The ping status and collection setup last reported by every slave, read in one query rather than
+	// one per client/environment combination
+	slaves := make(map[string]database.SlavePing)
+	pings, pingsErr := s.db.GetSlavePings()
+	if pingsErr != nil {
+		requestLogger(r).Error("Failed to get slave pings", "error", pingsErr)
+	}
+	for _, ping := range pings {
+		slaves[ping.ClientName+"/"+ping.EnvName] = ping
 	}
 
 	// Get ping status for accessible client/environment combinations
@@ -495,18 +2257,39 @@ func (s *Server) handleClientsEnvironments(w http.ResponseWriter, r *http.Reques
 	for clientName, envs := range clientEnvs {
 		pingStatuses[clientName] = make(map[string]interface{})
 		for _, envName := range envs {
-			status, lastPing, err := s.db.GetSlavePingStatus(clientName, envName)
-			if err != nil {
-				log.Printf("Failed to get ping status for %s/%s: %v", clientName, envName, err)
+			status, lastPing := "never", time.Time{}
+			if pingsErr != nil {
 				status = "unknown"
 			}
 
+			slave, pinged := slaves[clientName+"/"+envName]
+			if pinged {
+				status, lastPing = slave.Status, slave.LastPingTime
+			}
 			pingInfo := map[string]interface{}{
 				"status": status,
 			}
 			if !lastPing.IsZero() {
 				pingInfo["last_ping"] = lastPing.UTC()
 			}
+			if pinged {
+				if len(slave.Namespaces) > 0 {
+					pingInfo["namespaces"] = slave.Namespaces
+				}
+				if slave.CollectionInterval > 0 {
+					pingInfo["collection_interval"] = slave.CollectionInterval
+				}
+			}
+
+			// Slaves of federated environments ping their own regional master
+			if upstreamStatus, ok := upstream.PingStatuses[clientName][envName]; ok && lastPing.IsZero() {
+				pingInfo = map[string]interface{}{
+					"status": upstreamStatus.Status,
+				}
+				if upstreamStatus.LastPing != nil {
+					pingInfo["last_ping"] = upstreamStatus.LastPing.UTC()
+				}
+			}
 
 			pingStatuses[clientName][envName] = pingInfo
 		}
@@ -522,14 +2305,30 @@ func (s *Server) handleClientsEnvironments(w http.ResponseWriter, r *http.Reques
 	allReleasesCount := 0
 
 	if (isAdmin && authenticatedClientName == "") || (!isAdmin && authenticatedClientName != "") {
-		// Get total releases count for all clients or just the authenticated client
-		allReleases, err := s.db.GetCurrentReleasesFiltered(authenticatedClientName, "")
+		// Get total releases count for all clients or just the authenticated client, or its environment
+		// for environment-scoped keys
+		allowedEnv := getEnvScopeFromRequest(r)
+		allReleases, err := s.db.GetCurrentReleasesFiltered(authenticatedClientName, allowedEnv)
 		if err != nil {
-			log.Printf("Failed to get total releases count: %v", err)
-			http.Error(w, "Failed to get statistics", http.StatusInternalServerError)
+			requestLogger(r).Error("Failed to get total releases count", "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get statistics")
 			return
 		}
 		allReleasesCount = len(allReleases)
+
+		if s.federation != nil {
+			if authenticatedClientName == "" {
+				allReleasesCount += upstream.TotalReleases
+			} else {
+				// Upstream statistics cover all clients, count the authenticated client's releases instead
+				for _, envName := range upstream.ClientsEnvironments[authenticatedClientName] {
+					if allowedEnv != "" && envName != allowedEnv {
+						continue
+					}
+					allReleasesCount += len(s.federation.CurrentReleases(r.Context(), authenticatedClientName, envName))
+				}
+			}
+		}
 	}
 
 	response := map[string]interface{}{
@@ -543,41 +2342,120 @@ func (s *Server) handleClientsEnvironments(w http.ResponseWriter, r *http.Reques
 		"timestamp": time.Now().UTC(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// fleetRecentWindow is how far back the fleet overview counts components as recently deployed
+const fleetRecentWindow = 24 * time.Hour
+
+// handleFleetOverview summarizes every client environment in one call: ping status, components,
+// components deployed within fleetRecentWindow and newest last_seen (admin only)
+func (s *Server) handleFleetOverview(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	now := time.Now()
+	environments, err := s.db.GetFleetOverview(now.Add(-fleetRecentWindow))
+	if err != nil {
+		requestLogger(r).Error("Failed to get fleet overview", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to get fleet overview")
+		return
+	}
+
+	clients := make(map[string]bool)
+	statuses := make(map[string]int)
+	components, deployed := 0, 0
+	for _, env := range environments {
+		clients[env.ClientName] = true
+		statuses[env.PingStatus]++
+		components += env.TotalComponents
+		deployed += env.DeployedRecently
+	}
+
+	response := map[string]interface{}{
+		"environments": environments,
+		"totals": map[string]interface{}{
+			"clients":           len(clients),
+			"environments":      len(environments),
+			"ping_statuses":     statuses,
+			"total_components":  components,
+			"deployed_recently": deployed,
+		},
+		"recent_window_hours": int(fleetRecentWindow.Hours()),
+		"timestamp":           now.UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // PingRequest represents the request body for slave ping
 type PingRequest struct {
-	ClientName   string `json:"client_name"`
-	EnvName      string `json:"env_name"`
-	SlaveVersion string `json:"slave_version,omitempty"`
-	Timestamp    string `json:"timestamp,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	ClientName    string `json:"client_name"`
+	EnvName       string `json:"env_name"`
+	ClusterName   string `json:"cluster_name,omitempty"`
+	SlaveVersion  string `json:"slave_version,omitempty"`
+	Timestamp     string `json:"timestamp,omitempty"`
+
+	Namespaces         []string `json:"namespaces,omitempty"`          // namespaces the slave watches
+	CollectionInterval int      `json:"collection_interval,omitempty"` // collection interval of the slave in minutes
+	Status             string   `json:"status,omitempty"`              // "offline" when the slave is shutting down, "online" otherwise
 }
 
 // handlePing receives health pings from slave instances
 func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
 	var req PingRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+	if err := s.decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, fmt.Sprintf("Invalid JSON payload: %v", err))
+		return
+	}
+
+	if err := version.CheckSchemaVersion(req.SchemaVersion); err != nil {
+		requestLogger(r).Warn("Rejected ping", "client", req.ClientName, "env", req.EnvName, "error", err)
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedSchema, err.Error())
 		return
 	}
 
+	req.ClientName = s.normalizeName(req.ClientName)
+	req.EnvName = s.normalizeName(req.EnvName)
+
 	// Validate required fields
 	if req.ClientName == "" || req.EnvName == "" {
-		http.Error(w, "client_name and env_name are required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeMissingParams, "client_name and env_name are required")
+		return
+	}
+	if req.Status != "" && req.Status != "online" && req.Status != "offline" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParams, "status must be online or offline")
+		return
+	}
+
+	// A client API key only reports the status of its own slaves
+	if !authorizeClaimedClient(w, r, req.ClientName, req.EnvName) {
 		return
 	}
 
 	// Update ping record
-	err := s.db.UpsertSlavePing(req.ClientName, req.EnvName, req.SlaveVersion)
+	err := s.db.UpsertSlavePing(&database.SlavePing{
+		ClientName:         req.ClientName,
+		EnvName:            req.EnvName,
+		ClusterName:        req.ClusterName,
+		SlaveVersion:       req.SlaveVersion,
+		Namespaces:         req.Namespaces,
+		CollectionInterval: req.CollectionInterval,
+		Status:             req.Status,
+	})
 	if err != nil {
-		log.Printf("Failed to update slave ping for %s/%s: %v", req.ClientName, req.EnvName, err)
-		http.Error(w, "Failed to update ping", http.StatusInternalServerError)
+		requestLogger(r).Error("Failed to update slave ping", "client", req.ClientName, "env", req.EnvName, "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeDBError, "Failed to update ping")
 		return
 	}
 
-	log.Printf("Received ping from slave: %s/%s", req.ClientName, req.EnvName)
+	if req.Status == "offline" {
+		requestLogger(r).Info("Slave is going offline", "client", req.ClientName, "env", req.EnvName)
+	} else {
+		requestLogger(r).Info("Received ping from slave", "client", req.ClientName, "env", req.EnvName)
+	}
 
 	// Return success response
 	response := map[string]interface{}{
@@ -585,8 +2463,7 @@ func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
 		"timestamp": time.Now().UTC(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // handleConfig returns application configuration for the frontend
@@ -595,15 +2472,15 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
 
 	response := map[string]interface{}{
-		"mode":        s.config.Mode,
-		"env_name":    s.config.EnvName,
-		"client_name": s.config.ClientName,
+		"mode":         s.config.Mode,
+		"env_name":     s.config.EnvName,
+		"client_name":  s.config.ClientName,
+		"cluster_name": s.config.ClusterName,
 		"api_key_type": map[string]interface{}{
 			"is_admin":             isAdmin,
 			"authenticated_client": authenticatedClientName,
 		},
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
@@ -1,14 +1,32 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"krelease-tracker/internal/config"
 	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/federation"
+	"krelease-tracker/internal/kubernetes"
+	"krelease-tracker/internal/metrics"
+	"krelease-tracker/internal/notify"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 // DatabaseInterface defines the interface for database operations
@@ -199,6 +217,7 @@ func TestImagePathParsing(t *testing.T) {
 		expectedRepo string
 		expectedName string
 		expectedTag  string
+		expectedSHA  string
 	}{
 		{
 			name:         "Simple image with tag",
@@ -235,22 +254,108 @@ func TestImagePathParsing(t *testing.T) {
 			expectedName: "project",
 			expectedTag:  "sha-abc123",
 		},
+		{
+			name:         "Image pinned by digest",
+			imagePath:    "ghcr.io/acme/web:v2.0.0@sha256:0123456789abcdef",
+			expectedRepo: "ghcr.io/acme",
+			expectedName: "web",
+			expectedTag:  "v2.0.0",
+			expectedSHA:  "0123456789abcdef",
+		},
+		{
+			name:         "Digest without tag",
+			imagePath:    "ghcr.io/acme/web@sha256:0123456789abcdef",
+			expectedRepo: "ghcr.io/acme",
+			expectedName: "web",
+			expectedTag:  "latest",
+			expectedSHA:  "0123456789abcdef",
+		},
+		{
+			name:         "Registry port without tag",
+			imagePath:    "localhost:5000/web",
+			expectedRepo: "localhost:5000",
+			expectedName: "web",
+			expectedTag:  "latest",
+		},
+		{
+			name:         "Registry port with tag",
+			imagePath:    "registry.example.com:5000/team/web:v1.0.0",
+			expectedRepo: "registry.example.com:5000/team",
+			expectedName: "web",
+			expectedTag:  "v1.0.0",
+		},
+		{
+			name:         "Registry port with digest only",
+			imagePath:    "registry.example.com:5000/team/web@sha256:0123456789abcdef",
+			expectedRepo: "registry.example.com:5000/team",
+			expectedName: "web",
+			expectedTag:  "latest",
+			expectedSHA:  "0123456789abcdef",
+		},
+		{
+			name:         "Registry port with tag and digest",
+			imagePath:    "registry.example.com:5000/team/web:v1.0.0@sha256:0123456789abcdef",
+			expectedRepo: "registry.example.com:5000/team",
+			expectedName: "web",
+			expectedTag:  "v1.0.0",
+			expectedSHA:  "0123456789abcdef",
+		},
+		{
+			name:         "Registry port with a single segment",
+			imagePath:    "registry.io:5000/app",
+			expectedRepo: "registry.io:5000",
+			expectedName: "app",
+			expectedTag:  "latest",
+		},
+		{
+			name:         "Registry port with a multi-segment repository",
+			imagePath:    "registry.io:5000/platform/team/services/app:2024.06.1",
+			expectedRepo: "registry.io:5000/platform/team/services",
+			expectedName: "app",
+			expectedTag:  "2024.06.1",
+		},
+		{
+			name:         "Localhost registry",
+			imagePath:    "localhost/app:dev",
+			expectedRepo: "localhost",
+			expectedName: "app",
+			expectedTag:  "dev",
+		},
+		{
+			name:         "Docker Hub organization",
+			imagePath:    "bitnami/redis:7.2",
+			expectedRepo: "bitnami",
+			expectedName: "redis",
+			expectedTag:  "7.2",
+		},
+		{
+			name:         "Non-sha256 digest keeps its algorithm",
+			imagePath:    "acme/web:v1@sha512:fedcba",
+			expectedRepo: "acme",
+			expectedName: "web",
+			expectedTag:  "v1",
+			expectedSHA:  "sha512:fedcba",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo, name, tag := database.ParseImagePath(tt.imagePath)
+			image := database.ParseImagePath(tt.imagePath)
+
+			if image.Repo != tt.expectedRepo {
+				t.Errorf("Expected repo %s, got %s", tt.expectedRepo, image.Repo)
+			}
 
-			if repo != tt.expectedRepo {
-				t.Errorf("Expected repo %s, got %s", tt.expectedRepo, repo)
+			if image.Name != tt.expectedName {
+				t.Errorf("Expected name %s, got %s", tt.expectedName, image.Name)
 			}
 
-			if name != tt.expectedName {
-				t.Errorf("Expected name %s, got %s", tt.expectedName, name)
+			if image.Tag != tt.expectedTag {
+				t.Errorf("Expected tag %s, got %s", tt.expectedTag, image.Tag)
 			}
 
-			if tag != tt.expectedTag {
-				t.Errorf("Expected tag %s, got %s", tt.expectedTag, tag)
+			if image.SHA != tt.expectedSHA {
+				t.Errorf("Expected SHA %s, got %s", tt.expectedSHA, image.SHA)
 			}
 		})
 	}
@@ -316,3 +421,3418 @@ func TestHandleCollectResponseFormat(t *testing.T) {
 	// Give any background goroutine a moment to start (though it will fail due to nil k8s client)
 	time.Sleep(10 * time.Millisecond)
 }
+
+// newTestServer creates a server backed by a fresh SQLite database in a temp directory
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if cfg.Mode == "" {
+		cfg.Mode = "master"
+	}
+	if cfg.ClientName == "" {
+		cfg.ClientName = "test-client"
+	}
+	if cfg.EnvName == "" {
+		cfg.EnvName = "test"
+	}
+
+	return New(db, nil, cfg)
+}
+
+func TestSchemaVersionHandling(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Matching schema version",
+			body:           `{"schema_version": 1, "image_tag": "v1.0.0", "image_sha": "abc123"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Older slave without schema version",
+			body:           `{"image_tag": "v1.0.1", "image_sha": "def456"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Incompatible future schema version",
+			body:           `{"schema_version": 99, "image_tag": "v2.0.0", "image_sha": "fff999"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "unsupported schema_version 99",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedError != "" && !strings.Contains(rr.Body.String(), tt.expectedError) {
+				t.Errorf("Expected error containing %q, got %q", tt.expectedError, rr.Body.String())
+			}
+		})
+	}
+
+	// Pings follow the same rules
+	req := httptest.NewRequest("POST", "/api/ping", strings.NewReader(`{"schema_version": 99, "client_name": "a", "env_name": "b"}`))
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected ping with future schema to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestHandleCollectionGaps(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	// A component that was collected before but now fails to resolve
+	now := time.Now()
+	if err := server.db.UpsertRelease(&database.Release{
+		Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+		ImageName: "web", ImageTag: "v1", ImageSHA: "abc123", ClientName: "acme", EnvName: "prod",
+		FirstSeen: now, LastSeen: now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ce := range []database.CollectionError{
+		{Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app", ClientName: "acme", EnvName: "prod", Error: "no ready container"},
+		{Namespace: "default", WorkloadName: "worker", WorkloadType: "Deployment", ContainerName: "main", ClientName: "acme", EnvName: "prod", Error: "no running pods found"},
+		{Namespace: "default", WorkloadName: "other", WorkloadType: "Deployment", ContainerName: "main", ClientName: "acme", EnvName: "dev", Error: "no running pods found"},
+	} {
+		ce := ce
+		if err := server.db.RecordCollectionError(&ce); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/gaps/acme/prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Gaps  []database.CollectionGap `json:"gaps"`
+		Total int                      `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 {
+		t.Fatalf("Expected 2 gaps for acme/prod, got %d", response.Total)
+	}
+	if response.Gaps[0].WorkloadName != "web" || !response.Gaps[0].HasRelease {
+		t.Errorf("Expected web gap with stale release, got %+v", response.Gaps[0])
+	}
+	if response.Gaps[1].WorkloadName != "worker" || response.Gaps[1].HasRelease {
+		t.Errorf("Expected worker gap without release, got %+v", response.Gaps[1])
+	}
+
+	// Clearing the error after a successful collection removes the gap
+	if err := server.db.ClearCollectionError("default", "web", "app", "acme", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	gaps, err := server.db.GetCollectionGaps("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 {
+		t.Errorf("Expected 1 gap after clearing, got %d", len(gaps))
+	}
+}
+
+func TestWriteJSONPrettyPrinting(t *testing.T) {
+	payload := map[string]interface{}{"status": "ok", "nested": map[string]int{"count": 1}}
+
+	tests := []struct {
+		name         string
+		url          string
+		expectIndent bool
+	}{
+		{name: "Compact by default", url: "/api/config", expectIndent: false},
+		{name: "Indented when pretty=true", url: "/api/config?pretty=true", expectIndent: true},
+		{name: "Compact for other pretty values", url: "/api/config?pretty=1", expectIndent: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			writeJSON(rr, httptest.NewRequest("GET", tt.url, nil), http.StatusOK, payload)
+
+			body := rr.Body.String()
+			indented := strings.Contains(body, "\n  \"")
+			if indented != tt.expectIndent {
+				t.Errorf("Expected indented=%v, got body %q", tt.expectIndent, body)
+			}
+			if rr.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Expected JSON content type, got %q", rr.Header().Get("Content-Type"))
+			}
+
+			var decoded map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &decoded); err != nil {
+				t.Errorf("Response is not valid JSON: %v", err)
+			}
+		})
+	}
+
+	// Error responses are indented too
+	server := newTestServer(t, &config.Config{})
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app?pretty=true", strings.NewReader("not json")))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "\n  \"error\"") {
+		t.Errorf("Expected an indented error response, got %d: %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWaitCollectionsDrainsTriggeredCollection(t *testing.T) {
+	release := make(chan struct{})
+	var finished atomic.Bool
+	server := &Server{config: &config.Config{}}
+	server.collectReleases = func(ctx context.Context) error {
+		<-release
+		finished.Store(true)
+		return nil
+	}
+
+	server.handleCollect(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/collect", nil))
+
+	waited := make(chan struct{})
+	go func() {
+		server.WaitCollections()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Expected WaitCollections to wait for the running collection")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("WaitCollections did not return after the collection finished")
+	}
+	if !finished.Load() {
+		t.Error("Expected the collection to have finished when WaitCollections returned")
+	}
+}
+
+func TestHandleCollectRunsOneCollectionAtATime(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning, runs := 0, 0, 0
+	release := make(chan struct{})
+
+	server := &Server{config: &config.Config{}}
+	server.collectReleases = func(ctx context.Context) error {
+		mu.Lock()
+		running++
+		runs++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+
+	statuses := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		server.handleCollect(rr, httptest.NewRequest("POST", "/api/collect", nil))
+
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		statuses[response["status"].(string)]++
+		if response["status"] == "in_progress" && rr.Code != http.StatusAccepted {
+			t.Errorf("Expected 202 for in-progress trigger, got %d", rr.Code)
+		}
+	}
+
+	if statuses["accepted"] != 1 || statuses["in_progress"] != 4 {
+		t.Errorf("Expected 1 accepted and 4 in_progress triggers, got %v", statuses)
+	}
+
+	close(release)
+
+	// Once the running collection finishes a new trigger is accepted again
+	deadline := time.Now().Add(time.Second)
+	for {
+		rr := httptest.NewRecorder()
+		server.handleCollect(rr, httptest.NewRequest("POST", "/api/collect", nil))
+		if strings.Contains(rr.Body.String(), `"accepted"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Collection lock was never released")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Wait for the second collection to finish before inspecting counters
+	for !server.collectionMu.TryLock() {
+		time.Sleep(time.Millisecond)
+	}
+	server.collectionMu.Unlock()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning != 1 {
+		t.Errorf("Expected at most 1 concurrent collection, got %d", maxRunning)
+	}
+	if runs != 2 {
+		t.Errorf("Expected 2 collections to run, got %d", runs)
+	}
+}
+
+func TestHandleCollectWorkload(t *testing.T) {
+	server := newTestServer(t, &config.Config{Namespaces: []string{"default"}})
+	collected := make(chan string, 1)
+	server.collectWorkload = func(ctx context.Context, namespace, kind, name string) error {
+		collected <- namespace + "/" + kind + "/" + name
+		return nil
+	}
+
+	post := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+		return rr
+	}
+
+	if rr := post("/api/collect/default/deployment/web"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"workload_kind":"Deployment"`) {
+		t.Fatalf("Expected the workload collection to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case target := <-collected:
+		if target != "default/Deployment/web" {
+			t.Errorf("Expected default/Deployment/web to be collected, got %s", target)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the targeted collection to run")
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "Unknown workload kind", path: "/api/collect/default/replicaset/web"},
+		{name: "Namespace not monitored", path: "/api/collect/kube-system/deployment/web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := post(tt.path); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			select {
+			case target := <-collected:
+				t.Errorf("Expected no collection, got %s", target)
+			default:
+			}
+		})
+	}
+}
+func TestHandleReleaseProvenance(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	body := `{"image_tag": "v1.2.3", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`
+	req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+	req.Header.Set("User-Agent", "krelease-tracker/1.0.0 (schema 1)")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/releases/acme/prod/default/web/app/provenance", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Provenance database.ReleaseProvenance `json:"provenance"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Provenance.ReportedBy != "krelease-tracker/1.0.0 (schema 1)" {
+		t.Errorf("Expected reporter from User-Agent, got %q", response.Provenance.ReportedBy)
+	}
+	if response.Provenance.ImageTag != "v1.2.3" || response.Provenance.RecordedAt.IsZero() {
+		t.Errorf("Unexpected provenance: %+v", response.Provenance)
+	}
+
+	// Unknown components return 404
+	req = httptest.NewRequest("GET", "/api/releases/acme/prod/default/missing/app/provenance", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown component, got %d", rr.Code)
+	}
+}
+
+// seedRelease stores a release for the given component in the test server's database
+func seedRelease(t *testing.T, server *Server, clientName, envName, namespace, workload, container, tag, sha string, seen time.Time) {
+	t.Helper()
+
+	if err := server.db.UpsertRelease(&database.Release{
+		Namespace: namespace, WorkloadName: workload, WorkloadType: "Deployment", ContainerName: container,
+		ImageName: workload, ImageTag: tag, ImageSHA: sha, ClientName: clientName, EnvName: envName,
+		FirstSeen: seen, LastSeen: seen,
+	}); err != nil {
+		t.Fatalf("Failed to seed release: %v", err)
+	}
+}
+
+func TestHandleCurrentReleasesChangedSince(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seen := time.Now()
+	seedRelease(t, server, "acme", "prod", "default", "api", "app", "v1.0.0", "abc123", seen)
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v2.0.0", "def456", seen)
+
+	// Rows are recorded with second precision, wait for the next second before changing anything
+	cutoff := time.Now().Truncate(time.Second)
+	time.Sleep(time.Until(cutoff.Add(time.Second)))
+
+	// api is seen again with the same SHA, web moves to a new SHA
+	seedRelease(t, server, "acme", "prod", "default", "api", "app", "v1.0.0", "abc123", seen.Add(time.Second))
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v2.1.0", "fff999", seen.Add(time.Second))
+
+	getCurrent := func(query string) []database.CurrentRelease {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/releases/current/acme/prod"+query, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response struct {
+			Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Namespaces["default"]
+	}
+
+	if releases := getCurrent(""); len(releases) != 2 {
+		t.Errorf("Expected all components without changed_since, got %+v", releases)
+	}
+
+	releases := getCurrent("?changed_since=" + cutoff.UTC().Format(time.RFC3339))
+	if len(releases) != 1 || releases[0].WorkloadName != "web" || releases[0].ImageTag != "v2.1.0" {
+		t.Errorf("Expected only the changed web component, got %+v", releases)
+	}
+
+	// web returns to its previous SHA (A -> B -> A): the old row becomes current again and counts as changed
+	cutoff = time.Now().Truncate(time.Second)
+	time.Sleep(time.Until(cutoff.Add(time.Second)))
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v2.0.0", "def456", seen.Add(2*time.Second))
+	releases = getCurrent("?changed_since=" + cutoff.UTC().Format(time.RFC3339))
+	if len(releases) != 1 || releases[0].WorkloadName != "web" || releases[0].ImageTag != "v2.0.0" {
+		t.Errorf("Expected web to be reported after returning to its previous SHA, got %+v", releases)
+	}
+
+	req := httptest.NewRequest("GET", "/api/releases/current/acme/prod?changed_since=yesterday", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid changed_since, got %d", rr.Code)
+	}
+}
+
+func TestHandleCurrentReleasesPagination(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	for i := 0; i < 5; i++ {
+		seedRelease(t, server, "acme", "prod", "default", fmt.Sprintf("web-%d", i), "app", "v1", fmt.Sprintf("sha-%d", i), time.Now())
+	}
+
+	type page struct {
+		Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+		Total      int                                  `json:"total"`
+		Limit      int                                  `json:"limit"`
+		Offset     int                                  `json:"offset"`
+	}
+	getPage := func(query string) page {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/releases/current/acme/prod"+query, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var p page
+		if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	if p := getPage(""); p.Total != 5 || p.Limit != 100 || p.Offset != 0 || len(p.Namespaces["default"]) != 5 {
+		t.Errorf("Expected all releases on the default page, got %+v", p)
+	}
+
+	p := getPage("?limit=2&offset=3")
+	if p.Total != 5 || p.Limit != 2 || p.Offset != 3 {
+		t.Errorf("Unexpected pagination fields: %+v", p)
+	}
+	if releases := p.Namespaces["default"]; len(releases) != 2 || releases[0].WorkloadName != "web-3" || releases[1].WorkloadName != "web-4" {
+		t.Errorf("Expected web-3 and web-4 on the last page, got %+v", releases)
+	}
+
+	if p := getPage("?offset=10"); p.Total != 5 || len(p.Namespaces["default"]) != 0 {
+		t.Errorf("Expected an empty page past the end, got %+v", p)
+	}
+
+	if p := getPage("?limit=5000"); p.Limit != 1000 {
+		t.Errorf("Expected limit to be capped at 1000, got %d", p.Limit)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=abc", "?offset=-1"} {
+		req := httptest.NewRequest("GET", "/api/releases/current/acme/prod"+query, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestHandleCurrentReleasesMultipleEnvs(t *testing.T) {
+	server := newTestServer(t, &config.Config{Namespaces: []string{"default", "backend"}})
+	seedRelease(t, server, "acme", "staging", "default", "web", "app", "v2.0.0", "def456", time.Now())
+	seedRelease(t, server, "acme", "staging", "backend", "api", "app", "v2.1.0", "aaa111", time.Now())
+	seedRelease(t, server, "acme", "dev", "default", "web", "app", "v3.0.0", "bbb222", time.Now())
+
+	// Update times have second precision, record prod a second later than staging
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.0.0", "abc123", time.Now())
+
+	req := httptest.NewRequest("GET", "/api/releases/current?client_name=acme&env_name=staging,prod", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Environments []struct {
+			EnvName           string `json:"env_name"`
+			OrderedNamespaces []struct {
+				Name     string                    `json:"name"`
+				Releases []database.CurrentRelease `json:"releases"`
+			} `json:"ordered_namespaces"`
+			Total     int       `json:"total"`
+			Timestamp time.Time `json:"timestamp"`
+		} `json:"environments"`
+		Total     int       `json:"total"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Environments) != 2 || response.Total != 3 {
+		t.Fatalf("Expected 3 releases across staging and prod, got %s", rr.Body.String())
+	}
+	staging, prod := response.Environments[0], response.Environments[1]
+	if staging.EnvName != "staging" || prod.EnvName != "prod" {
+		t.Errorf("Expected environments in the requested order, got %s and %s", staging.EnvName, prod.EnvName)
+	}
+	if staging.Total != 2 || len(staging.OrderedNamespaces) != 2 || staging.OrderedNamespaces[0].Name != "default" ||
+		staging.OrderedNamespaces[1].Name != "backend" || staging.OrderedNamespaces[1].Releases[0].ImageTag != "v2.1.0" {
+		t.Errorf("Expected staging releases grouped by namespace in configuration order, got %+v", staging)
+	}
+	if prod.Total != 1 || len(prod.OrderedNamespaces) != 1 || prod.OrderedNamespaces[0].Releases[0].ImageTag != "v1.0.0" {
+		t.Errorf("Expected only the prod release under prod, got %+v", prod)
+	}
+	if !prod.Timestamp.After(staging.Timestamp) || !response.Timestamp.Equal(prod.Timestamp) {
+		t.Errorf("Expected independent update times per environment, got staging %v, prod %v, overall %v",
+			staging.Timestamp, prod.Timestamp, response.Timestamp)
+	}
+
+	// Access is checked against the client owning the environments
+	req = httptest.NewRequest("GET", "/api/releases/current?client_name=acme&env_name=staging,prod", nil)
+	req.Header.Set("X-Client-Name", "globex")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client's API key, got %d", rr.Code)
+	}
+
+	// Pagination applies to one environment at a time
+	req = httptest.NewRequest("GET", "/api/releases/current?client_name=acme&env_name=staging,prod&limit=10", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for pagination across environments, got %d", rr.Code)
+	}
+}
+
+func TestHandleCurrentReleasesSingleTenantDefaults(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1", "abc123", time.Now())
+
+	getCurrent := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/releases/current", nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Only one client/env stored: parameters default to it
+	rr := getCurrent()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected single-tenant defaults to apply, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["total"] != float64(1) {
+		t.Errorf("Expected 1 release, got %v", response["total"])
+	}
+
+	// A second environment makes the instance multi-tenant again
+	seedRelease(t, server, "acme", "staging", "default", "web", "app", "v2", "def456", time.Now())
+	if rr := getCurrent(); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for multi-tenant without parameters, got %d", rr.Code)
+	}
+
+	// SINGLE_TENANT forces the configured client/env
+	server.config.SingleTenant = true
+	server.config.ClientName = "acme"
+	server.config.EnvName = "staging"
+	if rr := getCurrent(); rr.Code != http.StatusOK {
+		t.Errorf("Expected configured single-tenant defaults to apply, got %d", rr.Code)
+	}
+}
+
+func TestClusterNameFromSyncToStorage(t *testing.T) {
+	server := newTestServer(t, &config.Config{ClusterName: "master-cluster"})
+
+	body := `{"image_tag": "v1.2.3", "image_sha": "abc123", "client_name": "acme", "env_name": "prod", "cluster_name": "eu-west-1"}`
+	req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/releases/current?client_name=acme&env_name=prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	var response struct {
+		Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	releases := response.Namespaces["default"]
+	if len(releases) != 1 || releases[0].ClusterName != "eu-west-1" {
+		t.Errorf("Expected current release with cluster name 'eu-west-1', got %+v", releases)
+	}
+
+	// A release reported without a cluster name does not take the receiving server's one
+	req = httptest.NewRequest("PUT", "/api/collect/other/Deployment/web/app", strings.NewReader(
+		`{"image_tag": "v1.2.3", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`))
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+	}
+	stored, err := server.db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, release := range stored {
+		if release.Namespace == "other" && release.ClusterName != "" {
+			t.Errorf("Expected no cluster name for a release reported without one, got %q", release.ClusterName)
+		}
+	}
+
+	// Pings record the cluster name next to the slave
+	req = httptest.NewRequest("POST", "/api/ping", strings.NewReader(`{"client_name": "acme", "env_name": "prod", "cluster_name": "eu-west-1",
+		"namespaces": ["default", "payments"], "collection_interval": 30}`))
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Ping failed: %d %s", rr.Code, rr.Body.String())
+	}
+	pings, err := server.db.GetSlavePings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pings) != 1 || pings[0].ClusterName != "eu-west-1" {
+		t.Errorf("Expected slave ping with cluster name 'eu-west-1', got %+v", pings)
+	}
+
+	// ...and the collection setup the slave reported, shown next to its ping status
+	if len(pings) == 1 && (strings.Join(pings[0].Namespaces, ",") != "default,payments" || pings[0].CollectionInterval != 30) {
+		t.Errorf("Expected the watched namespaces and collection interval to be stored, got %+v", pings[0])
+	}
+	req = httptest.NewRequest("GET", "/api/clients-environments", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	var environments struct {
+		PingStatuses map[string]map[string]struct {
+			Namespaces         []string `json:"namespaces"`
+			CollectionInterval int      `json:"collection_interval"`
+		} `json:"ping_statuses"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &environments); err != nil {
+		t.Fatal(err)
+	}
+	if slave := environments.PingStatuses["acme"]["prod"]; len(slave.Namespaces) != 2 || slave.CollectionInterval != 30 {
+		t.Errorf("Expected the collection setup in the ping status, got %s", rr.Body.String())
+	}
+}
+
+func TestPingOfflineStatus(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	ping := func(body string) int {
+		req := httptest.NewRequest("POST", "/api/ping", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	status := func() string {
+		status, _, err := server.db.GetSlavePingStatus("acme", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	if code := ping(`{"client_name": "acme", "env_name": "prod"}`); code != http.StatusOK || status() != "online" {
+		t.Fatalf("Expected online slave after a ping, got %d %s", code, status())
+	}
+
+	// A slave shutting down is reported offline right away
+	if code := ping(`{"client_name": "acme", "env_name": "prod", "status": "offline"}`); code != http.StatusOK || status() != "offline" {
+		t.Errorf("Expected offline slave after its shutdown ping, got %d %s", code, status())
+	}
+	pings, err := server.db.GetSlavePings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pings) != 1 || pings[0].Status != "offline" {
+		t.Errorf("Expected offline slave in the ping list, got %+v", pings)
+	}
+
+	// ...until it pings again
+	if code := ping(`{"client_name": "acme", "env_name": "prod", "status": "online"}`); code != http.StatusOK || status() != "online" {
+		t.Errorf("Expected online slave after a new ping, got %d %s", code, status())
+	}
+
+	if code := ping(`{"client_name": "acme", "env_name": "prod", "status": "sleeping"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown status, got %d", code)
+	}
+}
+
+func TestPingRejectsOtherClient(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	ping := func(clientName, envScope, isAdmin string) int {
+		req := httptest.NewRequest("POST", "/api/ping", strings.NewReader(`{"client_name": "globex", "env_name": "prod"}`))
+		req.Header.Set("X-Client-Name", clientName)
+		req.Header.Set("X-Client-Env", envScope)
+		req.Header.Set("X-Is-Admin", isAdmin)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	pinged := func() bool {
+		pings, err := server.db.GetSlavePings()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(pings) > 0
+	}
+
+	// A key of client acme cannot mark a slave of globex online
+	if code := ping("acme", "", "false"); code != http.StatusForbidden || pinged() {
+		t.Fatalf("Expected 403 without a recorded ping, got %d (pinged: %t)", code, pinged())
+	}
+	// Nor can a globex key scoped to another environment
+	if code := ping("globex", "staging", "false"); code != http.StatusForbidden || pinged() {
+		t.Fatalf("Expected 403 for another environment, got %d (pinged: %t)", code, pinged())
+	}
+
+	if code := ping("globex", "prod", "false"); code != http.StatusOK || !pinged() {
+		t.Errorf("Expected the key of globex to ping as globex, got %d", code)
+	}
+	if code := ping("", "", "true"); code != http.StatusOK {
+		t.Errorf("Expected an admin key to ping as any client, got %d", code)
+	}
+}
+
+func TestHealthWithBasePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		path     string
+	}{
+		{name: "No base path", basePath: "", path: "/health"},
+		{name: "Prefixed with base path", basePath: "/tracker", path: "/tracker/health"},
+		{name: "Unprefixed with base path", basePath: "/tracker", path: "/health"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, &config.Config{BasePath: tt.basePath})
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 for %s, got %d", tt.path, rr.Code)
+			}
+			var response map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Expected JSON health response, got %q", rr.Body.String())
+			}
+			if response["status"] != "healthy" {
+				t.Errorf("Expected healthy status, got %v", response["status"])
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	// A usable ID sent by the caller is kept
+	req := httptest.NewRequest("GET", "/api/config", nil)
+	req.Header.Set("X-Request-ID", "trace-42")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if got := rr.Header().Get("X-Request-ID"); got != "trace-42" {
+		t.Errorf("Expected the caller's request ID, got %q", got)
+	}
+
+	// Missing or unprintable IDs are replaced by a generated one
+	for _, id := range []string{"", "bad id\n"} {
+		req := httptest.NewRequest("GET", "/api/config", nil)
+		req.Header.Set("X-Request-ID", id)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if got := rr.Header().Get("X-Request-ID"); len(got) != 32 {
+			t.Errorf("Expected a generated request ID for %q, got %q", id, got)
+		}
+	}
+}
+
+func TestHealthWorkers(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	health := func() (int, map[string]interface{}) {
+		req := httptest.NewRequest("GET", "/health", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected JSON health response, got %q", rr.Body.String())
+		}
+		return rr.Code, response
+	}
+
+	// Without background workers (master mode) nothing is reported
+	if code, response := health(); code != http.StatusOK || response["workers"] != nil {
+		t.Fatalf("Expected 200 without workers, got %d: %v", code, response)
+	}
+
+	// A collector that started three intervals ago and never succeeded is wedged
+	server.workers.started(WorkerCollection, time.Minute, time.Now().Add(-3*time.Minute))
+	server.WorkerStarted(WorkerSync, 5*time.Minute)
+	code, response := health()
+	if code != http.StatusServiceUnavailable || response["status"] != "unhealthy" {
+		t.Fatalf("Expected 503 for a stale collector, got %d: %v", code, response)
+	}
+	workers := response["workers"].(map[string]interface{})
+	collection := workers[WorkerCollection].(map[string]interface{})
+	if collection["stale"] != true || collection["running"] != true || collection["last_success"] != nil {
+		t.Errorf("Unexpected collection worker state %v", collection)
+	}
+	if sync := workers[WorkerSync].(map[string]interface{}); sync["stale"] != false || sync["interval_seconds"] != float64(300) {
+		t.Errorf("Unexpected sync worker state %v", sync)
+	}
+
+	// A recent success makes it healthy again
+	server.WorkerSucceeded(WorkerCollection)
+	code, response = health()
+	if code != http.StatusOK || response["status"] != "healthy" {
+		t.Fatalf("Expected 200 after a successful collection, got %d: %v", code, response)
+	}
+	collection = response["workers"].(map[string]interface{})[WorkerCollection].(map[string]interface{})
+	if collection["stale"] != false || collection["last_success"] == nil {
+		t.Errorf("Unexpected collection worker state %v", collection)
+	}
+}
+
+func TestHealthDatabaseCheck(t *testing.T) {
+	tests := []struct {
+		depth, want string
+	}{
+		{"", config.HealthCheckPing},
+		{config.HealthCheckFull, config.HealthCheckFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			server := newTestServer(t, &config.Config{HealthCheckDepth: tt.depth})
+			check := func() (int, map[string]interface{}) {
+				req := httptest.NewRequest("GET", "/health", nil)
+				rr := httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+				var response map[string]interface{}
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				return rr.Code, response
+			}
+
+			if code, response := check(); code != http.StatusOK || response["database_check"] != tt.want {
+				t.Errorf("Expected a healthy %s check, got %d %v", tt.want, code, response)
+			}
+
+			// The check still notices a database that stopped answering
+			server.db.Close()
+			if code, response := check(); code != http.StatusServiceUnavailable || response["status"] != "unhealthy" || response["database_error"] == nil {
+				t.Errorf("Expected an unhealthy response once the database is closed, got %d %v", code, response)
+			}
+		})
+	}
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	server := newTestServer(t, &config.Config{BasePath: "/tracker"})
+	probe := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, path := range []string{"/healthz", "/readyz", "/health", "/tracker/healthz", "/tracker/readyz"} {
+		if code := probe(path); code != http.StatusOK {
+			t.Errorf("Expected 200 from %s, got %d", path, code)
+		}
+	}
+
+	// Without database the instance is not ready, the process is still alive
+	server.db.Close()
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected liveness to stay 200 without database, got %d", code)
+	}
+	for _, path := range []string{"/readyz", "/health"} {
+		if code := probe(path); code != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 from %s without database, got %d", path, code)
+		}
+	}
+}
+
+func TestHandleScalingHistory(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	for _, replicas := range []int{3, 10} {
+		if _, err := server.db.RecordScalingEvent(&database.ScalingEvent{
+			Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ClientName: "acme", EnvName: "prod", Replicas: replicas,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/scaling/acme/prod/default/web", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Events []database.ScalingEvent `json:"events"`
+		Total  int                     `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 || response.Events[0].Replicas != 10 || *response.Events[0].PreviousReplicas != 3 {
+		t.Errorf("Expected the scale-up from 3 to 10 first, got %+v", response)
+	}
+
+	req = httptest.NewRequest("GET", "/api/scaling/acme/prod/default/web", nil)
+	req.Header.Set("X-Client-Name", "globex")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client's API key, got %d", rr.Code)
+	}
+}
+
+func TestHandleReleasesExport(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now()
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "default", "api", "app", "v2", "def456", now)
+	seedRelease(t, server, "acme", "staging", "default", "web", "app", "v3", "fff999", now)
+
+	export := func(query string) []database.Release {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/releases/export.jsonl"+query, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Expected Content-Type application/x-ndjson, got %q", ct)
+		}
+
+		var releases []database.Release
+		for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var release database.Release
+			if err := json.Unmarshal([]byte(line), &release); err != nil {
+				t.Fatalf("Failed to parse export line %q: %v", line, err)
+			}
+			releases = append(releases, release)
+		}
+		return releases
+	}
+
+	all := export("")
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 exported releases, got %d", len(all))
+	}
+
+	resumed := export(fmt.Sprintf("?since_id=%d", all[0].ID))
+	if len(resumed) != 2 || resumed[0].ID != all[1].ID || resumed[1].ID != all[2].ID {
+		t.Errorf("Expected export to resume after id %d, got %+v", all[0].ID, resumed)
+	}
+
+	if rest := export(fmt.Sprintf("?since_id=%d", all[2].ID)); len(rest) != 0 {
+		t.Errorf("Expected no releases after the last id, got %d", len(rest))
+	}
+
+	// Client API keys may not export every client's releases
+	req := httptest.NewRequest("GET", "/api/releases/export.jsonl", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for client API key, got %d", rr.Code)
+	}
+}
+
+func TestHandleCurrentReleasesExport(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seen := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1", "abc123", seen)
+	seedRelease(t, server, "acme", "prod", "default", "api", "app", "v2", "def456", seen)
+	seedRelease(t, server, "acme", "staging", "default", "web", "app", "v3", "fff999", seen)
+
+	export := func(query string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/releases/export"+query, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		} else {
+			req.Header.Set("X-Is-Admin", "true")
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := export("?client=acme&env=prod&format=csv")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("Expected a CSV export, got %d %q: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, `attachment; filename="releases-acme-prod-`) ||
+		!strings.HasSuffix(disposition, `.csv"`) {
+		t.Errorf("Expected an attachment named after the client and environment, got %q", disposition)
+	}
+	expected := "namespace,workload,container,image,tag,sha,last_seen\n" +
+		"default,api,app,api,v2,def456,2024-03-01T12:00:00Z\n" +
+		"default,web,app,web,v1,abc123,2024-03-01T12:00:00Z\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected CSV:\n%s\ngot:\n%s", expected, rr.Body.String())
+	}
+
+	// JSON is the default format
+	rr = export("?client=acme&env=staging")
+	var releases []database.CurrentRelease
+	if err := json.Unmarshal(rr.Body.Bytes(), &releases); err != nil {
+		t.Fatalf("Failed to parse JSON export: %v (%s)", err, rr.Body.String())
+	}
+	if len(releases) != 1 || releases[0].ImageTag != "v3" || !strings.HasSuffix(rr.Header().Get("Content-Disposition"), `.json"`) {
+		t.Errorf("Expected the staging release as JSON, got %+v", releases)
+	}
+	if rr = export("?client=acme&env=dev"); strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected an empty JSON array, got %q", rr.Body.String())
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		header     []string
+		expectCode int
+	}{
+		{"Unknown format", "?client=acme&env=prod&format=xml", nil, http.StatusBadRequest},
+		{"Missing environment", "?client=acme", nil, http.StatusBadRequest},
+		{"Several environments", "?client=acme&env=prod,staging", nil, http.StatusBadRequest},
+		{"Another client", "?client=acme&env=prod", []string{"X-Client-Name", "globex"}, http.StatusForbidden},
+		{"Own client", "?client=acme&env=prod&format=csv", []string{"X-Client-Name", "acme"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := export(tt.query, tt.header...); rr.Code != tt.expectCode {
+				t.Errorf("Expected %d, got %d: %s", tt.expectCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestDigestBadge(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "0123456789abcdef0123456789abcdef", time.Now())
+	seedRelease(t, server, "acme", "prod", "default", "legacy", "app", "v0.9.0", "", time.Now())
+
+	getBadge := func(path string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d", path, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "image/svg+xml" {
+			t.Errorf("Expected SVG badge for %s, got Content-Type %q", path, ct)
+		}
+		return rr.Body.String()
+	}
+
+	badge := getBadge("/badges/key/acme/prod/Deployment/web/app/digest")
+	if !strings.Contains(badge, ">0123456789ab<") {
+		t.Errorf("Expected short digest in badge, got %s", badge)
+	}
+	if strings.Contains(badge, "v1.2.3") {
+		t.Errorf("Expected digest badge not to show the tag, got %s", badge)
+	}
+
+	if badge := getBadge("/badges/key/acme/prod/Deployment/legacy/app/digest"); !strings.Contains(badge, "no digest") {
+		t.Errorf("Expected 'no digest' fallback, got %s", badge)
+	}
+
+	// The tag badge is unchanged
+	if badge := getBadge("/badges/key/acme/prod/Deployment/web/app"); !strings.Contains(badge, "v1.2.3") {
+		t.Errorf("Expected tag in default badge, got %s", badge)
+	}
+}
+
+func TestUnknownVersionBadge(t *testing.T) {
+	server := newTestServer(t, &config.Config{UnknownVersionText: "n/a"})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "sha256:abc", time.Now())
+	seedRelease(t, server, "acme", "prod", "default", "latest", "app", "latest", "sha256:def", time.Now())
+	seedRelease(t, server, "acme", "prod", "default", "untagged", "app", "", "sha256:fed", time.Now())
+
+	tests := []struct {
+		workload string
+		expected string
+	}{
+		{"web", ">v1.2.3<"},
+		{"latest", ">n/a<"},
+		{"untagged", ">n/a<"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/badges/key/acme/prod/Deployment/"+tt.workload+"/app", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if !strings.Contains(rr.Body.String(), tt.expected) {
+			t.Errorf("Expected %s badge to contain %q, got %s", tt.workload, tt.expected, rr.Body.String())
+		}
+	}
+
+	if badge := CreateVersionBadge("prod", "latest", "", BadgeSize{}); !strings.Contains(badge, ">unknown<") {
+		t.Errorf("Expected default placeholder for an empty text, got %s", badge)
+	}
+}
+
+func TestBadgeSize(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "sha256:abc", time.Now())
+
+	// badgeGeometry extracts the dimensions and text positions of a badge
+	type badgeGeometry struct {
+		width, height, fontSize, labelBox, valueBox, labelX, valueX, textY int
+	}
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	svgRe := regexp.MustCompile(`<svg [^>]*width="(\d+)" height="(\d+)"`)
+	fontRe := regexp.MustCompile(`font-size="(\d+)"`)
+	valueRectRe := regexp.MustCompile(`<rect x="(\d+)" width="(\d+)"`)
+	textRe := regexp.MustCompile(`<text x="(\d+)" y="(\d+)"`)
+	getBadge := func(path string) badgeGeometry {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		body := rr.Body.String()
+		svg, font, rect, texts := svgRe.FindStringSubmatch(body), fontRe.FindStringSubmatch(body), valueRectRe.FindStringSubmatch(body), textRe.FindAllStringSubmatch(body, -1)
+		if svg == nil || font == nil || rect == nil || len(texts) != 2 || !strings.Contains(body, ">v1.2.3<") {
+			t.Fatalf("Unexpected badge for %s: %s", path, body)
+		}
+		return badgeGeometry{
+			width: atoi(svg[1]), height: atoi(svg[2]), fontSize: atoi(font[1]),
+			labelBox: atoi(rect[1]), valueBox: atoi(rect[2]),
+			labelX: atoi(texts[0][1]), valueX: atoi(texts[1][1]), textY: atoi(texts[0][2]),
+		}
+	}
+
+	base := getBadge("/badges/key/acme/prod/Deployment/web/app")
+	if base.height != 20 || base.fontSize != 110 || base.textY != 140 {
+		t.Errorf("Expected the default 20px badge with 11px text, got %+v", base)
+	}
+
+	// Doubling the height scales the font and every dimension with it
+	for _, path := range []string{
+		"/badges/key/acme/prod/Deployment/web/app?height=40",
+		"/badges/key/acme/prod/Deployment/web/app?height=40&font_size=22",
+		"/badges/key/acme/prod/Deployment/web/app?font_size=22",
+	} {
+		large := getBadge(path)
+		if large.height != 2*base.height || large.fontSize != 2*base.fontSize || large.width != 2*base.width || large.textY != 2*base.textY {
+			t.Errorf("Expected %s to be twice %+v, got %+v", path, base, large)
+		}
+		// Text stays centered in its box
+		if large.labelX != large.labelBox*10/2 || large.valueX != large.labelBox*10+large.valueBox*10/2 {
+			t.Errorf("Expected centered text in %s, got %+v", path, large)
+		}
+		if large.labelBox+large.valueBox != large.width {
+			t.Errorf("Expected the boxes of %s to fill the badge, got %+v", path, large)
+		}
+	}
+
+	// Out of range values are bounded and invalid ones ignored
+	if huge := getBadge("/badges/key/acme/prod/Deployment/web/app?height=5000&font_size=900"); huge.height != maxBadgeHeight || huge.fontSize != maxBadgeFontSize*10 {
+		t.Errorf("Expected the size to be capped, got %+v", huge)
+	}
+	if invalid := getBadge("/badges/key/acme/prod/Deployment/web/app?height=abc&font_size=-3"); invalid != base {
+		t.Errorf("Expected invalid sizes to render the default badge, got %+v", invalid)
+	}
+}
+
+func TestVersionLabelBadges(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	body := `{"image_tag": "v1.2.3", "image_sha": "abc123", "client_name": "acme", "env_name": "prod", "chart_version": "web-4.5.6", "app_version": "1.2.3"}`
+	req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+	}
+	seedRelease(t, server, "acme", "prod", "default", "worker", "app", "v2.0.0", "def456", time.Now())
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/badges/key/acme/prod/Deployment/web/app/chart", ">web-4.5.6<"},
+		{"/badges/key/acme/prod/Deployment/web/app/app", ">1.2.3<"},
+		{"/badges/key/acme/prod/Deployment/worker/app/chart", ">unknown<"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+		if !strings.Contains(rr.Body.String(), tt.expected) {
+			t.Errorf("Expected %s to contain %q, got %s", tt.path, tt.expected, rr.Body.String())
+		}
+	}
+
+	releases, err := server.db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range releases {
+		if r.WorkloadName == "web" && (r.ChartVersion != "web-4.5.6" || r.AppVersion != "1.2.3") {
+			t.Errorf("Expected chart and app versions to be stored, got %+v", r)
+		}
+	}
+}
+
+func TestBadgeLookupsAreCoalesced(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "abc123", time.Now())
+
+	var mu sync.Mutex
+	queries := 0
+	server.badgeLookups = newBadgeLookupCache(func(kind, name, container, clientName, envName string) (*database.CurrentRelease, error) {
+		mu.Lock()
+		queries++
+		mu.Unlock()
+		// Keep the query in flight long enough for the concurrent requests to pile up
+		time.Sleep(50 * time.Millisecond)
+		return server.db.GetCurrentReleaseByWorkload(kind, name, container, clientName, envName)
+	}, time.Minute)
+
+	const requests = 50
+	var wg sync.WaitGroup
+	bodies := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest("GET", "/badges/key/acme/prod/Deployment/web/app", nil))
+			bodies[i] = rr.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, body := range bodies {
+		if !strings.Contains(body, ">v1.2.3<") {
+			t.Fatalf("Expected request %d to render the version, got %s", i, body)
+		}
+	}
+	if queries > 2 {
+		t.Errorf("Expected %d concurrent identical badge requests to share the database query, got %d queries", requests, queries)
+	}
+
+	// Other badges are looked up separately
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/badges/key/acme/prod/Deployment/worker/app", nil))
+	if queries < 2 {
+		t.Errorf("Expected a different badge to query the database, got %d queries", queries)
+	}
+}
+
+func TestBadgeLookupCacheExpires(t *testing.T) {
+	queries := 0
+	cache := newBadgeLookupCache(func(kind, name, container, clientName, envName string) (*database.CurrentRelease, error) {
+		queries++
+		return &database.CurrentRelease{ImageTag: "v1"}, nil
+	}, 20*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get("Deployment", "web", "app", "acme", "prod"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("Expected cached lookups within the TTL, got %d queries", queries)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Get("Deployment", "web", "app", "acme", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 {
+		t.Errorf("Expected a new query after the TTL, got %d queries", queries)
+	}
+}
+
+func TestAllContainersBadge(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now()
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "default", "web", "sidecar", "v1.2.3", "def456", now)
+	seedRelease(t, server, "acme", "prod", "default", "web", "init:migrate", "v0.1.0", "0a0a0a", now)
+	seedRelease(t, server, "acme", "prod", "default", "api", "app", "v2.0.0", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "default", "api", "sidecar", "v1.9.0", "def456", now)
+	seedRelease(t, server, "acme", "prod", "default", "split", "app", "v3.0.0", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "other", "split", "app", "v3.0.0", "abc123", now)
+
+	getBadge := func(path string) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Body.String()
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		expect string
+	}{
+		{"Consistent containers show the shared tag", "/badges/key/acme/prod/Deployment/web/app?all_containers=true", ">v1.2.3<"},
+		{"The container segment is ignored", "/badges/key/acme/prod/Deployment/web/anything?all_containers=true", ">v1.2.3<"},
+		{"Different tags are mixed", "/badges/key/acme/prod/Deployment/api/app?all_containers=true", ">mixed<"},
+		{"Without the parameter the container is shown", "/badges/key/acme/prod/Deployment/api/sidecar", ">v1.9.0<"},
+		{"Unknown workloads are not deployed", "/badges/key/acme/prod/Deployment/missing/app?all_containers=true", "not deployed"},
+		{"Workloads in several namespaces", "/badges/key/acme/prod/Deployment/split/app?all_containers=true", "multiple found"},
+		{"Variants are aggregated too", "/badges/key/acme/prod/Deployment/api/app/digest?all_containers=true", ">mixed<"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if badge := getBadge(tt.path); !strings.Contains(badge, tt.expect) {
+				t.Errorf("Expected %q in badge, got %s", tt.expect, badge)
+			}
+		})
+	}
+}
+
+func TestShieldsEndpointBadge(t *testing.T) {
+	clientKey := "acme::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{clientKey}})
+	now := time.Now()
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "default", "split", "app", "v3.0.0", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "other", "split", "app", "v3.0.0", "abc123", now)
+	seedRelease(t, server, "globex", "prod", "default", "web", "app", "v9.9.9", "fff000", now)
+
+	tests := []struct {
+		name          string
+		path          string
+		expectMessage string
+		expectColor   string
+		expectError   bool
+	}{
+		{"Deployed release", "/badges/" + clientKey + "/acme/prod/Deployment/web/app/json", "v1.2.3", "green", false},
+		{"Unknown workload", "/badges/" + clientKey + "/acme/prod/Deployment/missing/app/json", "not deployed", "lightgrey", false},
+		{"Several namespaces", "/badges/" + clientKey + "/acme/prod/Deployment/split/app/json", "multiple found", "yellow", false},
+		{"Another client", "/badges/" + clientKey + "/globex/prod/Deployment/web/app/json", "access denied", "red", true},
+		{"Invalid key", "/badges/wrong/acme/prod/Deployment/web/app/json", "unauthorized", "red", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+			if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+				t.Fatalf("Expected a JSON response, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+			}
+
+			var badge shieldsEndpoint
+			if err := json.Unmarshal(rr.Body.Bytes(), &badge); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if badge.SchemaVersion != 1 || badge.Label != "prod" || badge.Message != tt.expectMessage ||
+				badge.Color != tt.expectColor || badge.IsError != tt.expectError {
+				t.Errorf("Expected %q in %s, got %+v", tt.expectMessage, tt.expectColor, badge)
+			}
+		})
+	}
+}
+
+func TestBadgeRateLimit(t *testing.T) {
+	server := newTestServer(t, &config.Config{BadgeRateLimit: 2})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "abc123", time.Now())
+
+	getBadge := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/badges/key/acme/prod/Deployment/web/app", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := getBadge("192.0.2.1:1234"); !strings.Contains(rr.Body.String(), "v1.2.3") {
+			t.Fatalf("Expected request %d within the limit to be served, got %s", i+1, rr.Body.String())
+		}
+	}
+	rr := getBadge("192.0.2.1:5678")
+	if !strings.Contains(rr.Body.String(), "rate limited") || rr.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("Expected a rate limited badge once the limit is exceeded, got %s", rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on rate limited badges")
+	}
+
+	// Other clients have their own bucket
+	if rr := getBadge("192.0.2.2:1234"); !strings.Contains(rr.Body.String(), "v1.2.3") {
+		t.Errorf("Expected another client IP to be served, got %s", rr.Body.String())
+	}
+}
+
+func TestBadgeRateLimiterRefillsAndPrunes(t *testing.T) {
+	limiter := newBadgeRateLimiter(60)
+	for i := 0; i < 60; i++ {
+		limiter.Allow("192.0.2.1")
+	}
+	if ok, retryAfter := limiter.Allow("192.0.2.1"); ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Expected an empty bucket to wait about a second, got %t, %v", ok, retryAfter)
+	}
+
+	// A second later one token is back
+	limiter.buckets["192.0.2.1"].lastSeen = time.Now().Add(-time.Second)
+	if ok, _ := limiter.Allow("192.0.2.1"); !ok {
+		t.Error("Expected the bucket to refill over time")
+	}
+
+	// Idle buckets are dropped by the next cleanup
+	limiter.buckets["192.0.2.1"].lastSeen = time.Now().Add(-2 * badgeBucketIdleTimeout)
+	limiter.lastCleanup = time.Now().Add(-badgeBucketIdleTimeout)
+	limiter.Allow("192.0.2.2")
+	if _, ok := limiter.buckets["192.0.2.1"]; ok || len(limiter.buckets) != 1 {
+		t.Errorf("Expected idle buckets to be pruned, got %v", limiter.buckets)
+	}
+}
+
+func TestInitContainerBadge(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "abc123", time.Now())
+	seedRelease(t, server, "acme", "prod", "default", "web", "init:app", "v0.9.0", "def456", time.Now())
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/badges/key/acme/prod/Deployment/web/app", ">v1.2.3<"},
+		{"/badges/key/acme/prod/Deployment/web/init:app", ">v0.9.0<"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+		if !strings.Contains(rr.Body.String(), tt.expected) {
+			t.Errorf("Expected %s to contain %q, got %s", tt.path, tt.expected, rr.Body.String())
+		}
+	}
+}
+
+func TestShortDigest(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0123456789abcdef0123", "0123456789ab"},
+		{"sha256:0123456789abcdef0123", "0123456789ab"},
+		{"abc123", "abc123"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := shortDigest(tt.input); got != tt.expected {
+			t.Errorf("shortDigest(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestHandleReleaseConsistency(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now()
+	for _, env := range []string{"dev", "staging", "prod"} {
+		seedRelease(t, server, "acme", env, "default", "api", "app", "v1.0.0", "abc123", now)
+	}
+	seedRelease(t, server, "acme", "dev", "default", "web", "app", "v2.1.0", "def456", now)
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v2.0.0", "fff999", now)
+	// Other clients must not leak into the result
+	seedRelease(t, server, "other", "prod", "default", "web", "app", "v9.9.9", "999999", now)
+
+	req := httptest.NewRequest("GET", "/api/consistency/acme", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Components []database.ComponentConsistency `json:"components"`
+		Divergent  int                             `json:"divergent"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Components) != 2 {
+		t.Fatalf("Expected 2 components, got %+v", response.Components)
+	}
+
+	byWorkload := make(map[string]database.ComponentConsistency)
+	for _, c := range response.Components {
+		byWorkload[c.WorkloadName] = c
+	}
+	if api := byWorkload["api"]; !api.Consistent || len(api.Environments) != 3 {
+		t.Errorf("Expected api to be consistent across 3 environments, got %+v", api)
+	}
+	web := byWorkload["web"]
+	if web.Consistent || len(web.Environments) != 2 {
+		t.Errorf("Expected web to be divergent across 2 environments, got %+v", web)
+	}
+	if web.Environments[0].EnvName != "dev" || web.Environments[0].ImageTag != "v2.1.0" {
+		t.Errorf("Expected environments sorted by name with their versions, got %+v", web.Environments)
+	}
+	if response.Divergent != 1 {
+		t.Errorf("Expected 1 divergent component, got %d", response.Divergent)
+	}
+
+	// Client API keys are limited to their own client
+	req = httptest.NewRequest("GET", "/api/consistency/acme", nil)
+	req.Header.Set("X-Client-Name", "other")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client's key, got %d", rr.Code)
+	}
+}
+
+func TestHandleDeleteComponent(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now()
+	seedRelease(t, server, "acme", "prod", "default", "legacy", "app", "v1.0.0", "abc123", now.Add(-time.Hour))
+	seedRelease(t, server, "acme", "prod", "default", "legacy", "app", "v1.1.0", "abc456", now)
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v2.0.0", "def456", now)
+	// The same component of another environment is kept
+	seedRelease(t, server, "acme", "dev", "default", "legacy", "app", "v1.1.0", "abc456", now)
+
+	// Client API keys cannot delete another client's components
+	req := httptest.NewRequest("DELETE", "/api/releases/acme/prod/default/legacy/app", nil)
+	req.Header.Set("X-Client-Name", "other")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for another client's key, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/releases/acme/prod/default/legacy/app", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Deleted != 2 {
+		t.Errorf("Expected 2 deleted releases, got %d", response.Deleted)
+	}
+
+	releases, err := server.db.GetCurrentReleasesFiltered("acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("Expected web in prod and legacy in dev to remain, got %+v", releases)
+	}
+	for _, r := range releases {
+		if r.WorkloadName == "legacy" && r.EnvName == "prod" {
+			t.Errorf("Expected the deleted component to be gone, got %+v", r)
+		}
+	}
+}
+
+func TestHandleDetectionLag(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		workload, tag, sha string
+		startedAt          time.Time
+		lag                time.Duration
+	}{
+		{"api", "v1", "aaa111", started, 30 * time.Second},
+		{"api", "v2", "aaa222", started.Add(time.Hour), 90 * time.Second},
+		{"api", "v3", "aaa333", started.Add(2 * time.Hour), 60 * time.Second},
+		// Clock skew puts first_seen before the start time
+		{"web", "v1", "bbb111", started, -5 * time.Second},
+	} {
+		startedAt := c.startedAt
+		seenAt := startedAt.Add(c.lag)
+		body, _ := json.Marshal(ManualCollectRequest{
+			ImageTag: c.tag, ImageSHA: c.sha, ClientName: "acme", EnvName: "prod",
+			StartedAt: &startedAt, ReleasedAt: &seenAt,
+		})
+		req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/"+c.workload+"/app", strings.NewReader(string(body)))
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Failed to collect %s %s: %d %s", c.workload, c.tag, rr.Code, rr.Body.String())
+		}
+	}
+	// Releases without a start time are not part of the distribution
+	seedRelease(t, server, "acme", "prod", "default", "worker", "app", "v1", "ccc111", started)
+
+	req := httptest.NewRequest("GET", "/api/metrics/detection-lag/acme/prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Components []database.ComponentDetectionLag `json:"components"`
+		Summary    struct {
+			Samples       int     `json:"samples"`
+			MedianSeconds float64 `json:"median_seconds"`
+			MaxSeconds    float64 `json:"max_seconds"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Components) != 2 {
+		t.Fatalf("Expected 2 components, got %+v", response.Components)
+	}
+
+	api := response.Components[0]
+	if api.WorkloadName != "api" || len(api.Samples) != 3 {
+		t.Fatalf("Expected 3 api samples, got %+v", api)
+	}
+	if api.MinSeconds != 30 || api.MedianSeconds != 60 || api.MeanSeconds != 60 || api.MaxSeconds != 90 {
+		t.Errorf("Unexpected api lag distribution: %+v", api)
+	}
+	if web := response.Components[1]; web.MaxSeconds != 0 {
+		t.Errorf("Expected negative lag to be clamped to 0, got %+v", web)
+	}
+	if response.Summary.Samples != 4 || response.Summary.MedianSeconds != 45 || response.Summary.MaxSeconds != 90 {
+		t.Errorf("Unexpected summary: %+v", response.Summary)
+	}
+
+	// Client API keys are limited to their own client
+	req = httptest.NewRequest("GET", "/api/metrics/detection-lag/acme/prod", nil)
+	req.Header.Set("X-Client-Name", "other")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client's key, got %d", rr.Code)
+	}
+}
+
+func TestHandlePurgeFailedReleases(t *testing.T) {
+	server := newTestServer(t, &config.Config{Mode: "slave"})
+	now := time.Now()
+	for name, failedAt := range map[string]time.Time{
+		"old":    now.AddDate(0, 0, -10),
+		"recent": now.AddDate(0, 0, -1),
+	} {
+		if err := server.db.InsertFailedRelease(&database.FailedRelease{
+			Namespace: "default", WorkloadName: name, WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: "v1", ImageSHA: "abc123", FirstSeen: failedAt, LastSeen: failedAt, FailedAt: failedAt,
+		}); err != nil {
+			t.Fatalf("Failed to insert failed release: %v", err)
+		}
+	}
+
+	purge := func(query string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("DELETE", "/api/failed-releases"+query, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response
+	}
+
+	if response := purge("?older_than_days=7"); response["deleted"] != float64(1) {
+		t.Errorf("Expected 1 release older than 7 days to be deleted, got %v", response["deleted"])
+	}
+	if response := purge(""); response["deleted"] != float64(1) {
+		t.Errorf("Expected remaining release to be deleted, got %v", response["deleted"])
+	}
+
+	// Client API keys may not purge
+	req := httptest.NewRequest("DELETE", "/api/failed-releases", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for client API key, got %d", rr.Code)
+	}
+}
+
+func TestHandleSyncFailed(t *testing.T) {
+	server := newTestServer(t, &config.Config{Mode: "slave"})
+
+	list := func() map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/sync/failed", nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response
+	}
+
+	if response := list(); response["total"] != float64(0) || response["failed_releases"] == nil {
+		t.Errorf("Expected an empty list of failed releases, got %v", response)
+	}
+
+	now := time.Now()
+	if err := server.db.InsertFailedRelease(&database.FailedRelease{
+		Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+		ImageTag: "v1", ImageSHA: "abc123", SyncAttempts: 5, LastError: "master returned status 400: bad request",
+		FirstSeen: now, LastSeen: now, FailedAt: now,
+	}); err != nil {
+		t.Fatalf("Failed to insert failed release: %v", err)
+	}
+	response := list()
+	failed, _ := response["failed_releases"].([]interface{})
+	if response["total"] != float64(1) || len(failed) != 1 {
+		t.Fatalf("Expected 1 failed release, got %v", response)
+	}
+	if release := failed[0].(map[string]interface{}); release["sync_attempts"] != float64(5) || release["last_error"] == "" {
+		t.Errorf("Expected the attempts and last error to be reported, got %v", release)
+	}
+
+	// Client API keys may not list failed releases
+	req := httptest.NewRequest("GET", "/api/sync/failed", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for client API key, got %d", rr.Code)
+	}
+}
+
+func TestHandleSyncStatus(t *testing.T) {
+	server := newTestServer(t, &config.Config{Mode: "slave", MasterURL: "http://master:8080"})
+
+	status := func() map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/sync/status", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response
+	}
+
+	if response := status(); response["pending_count"] != float64(0) || response["oldest_pending_at"] != nil || response["last_sync_success"] != nil {
+		t.Errorf("Expected an empty backlog, got %v", response)
+	}
+
+	now := time.Now()
+	for _, release := range []*database.PendingRelease{
+		{Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app", ImageTag: "v1", ImageSHA: "abc123", FirstSeen: now, LastSeen: now},
+		{Namespace: "default", WorkloadName: "api", WorkloadType: "Deployment", ContainerName: "app", ImageTag: "v2", FirstSeen: now, LastSeen: now},
+	} {
+		if err := server.db.UpsertPendingRelease(release); err != nil {
+			t.Fatalf("Failed to upsert pending release: %v", err)
+		}
+	}
+	server.WorkerStarted(WorkerSync, time.Minute)
+	server.WorkerSucceeded(WorkerSync)
+
+	response := status()
+	if response["pending_count"] != float64(2) || response["syncable_count"] != float64(1) {
+		t.Errorf("Expected 2 pending releases, 1 syncable, got %v", response)
+	}
+	if response["oldest_pending_at"] == nil || response["last_sync_success"] == nil || response["master_configured"] != true {
+		t.Errorf("Expected the oldest pending release and last sync to be reported, got %v", response)
+	}
+
+	// Masters have no sync queue
+	master := newTestServer(t, &config.Config{Mode: "master"})
+	rr := httptest.NewRecorder()
+	master.ServeHTTP(rr, httptest.NewRequest("GET", "/api/sync/status", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 in master mode, got %d", rr.Code)
+	}
+}
+
+func TestHandleReleaseByTag(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now().Truncate(time.Second)
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.0.0", "abc123", now.Add(-48*time.Hour))
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.1.0", "def456", now)
+
+	getByTag := func(tag string) (*httptest.ResponseRecorder, database.TagDeployment) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/releases/history/acme/prod/default/web/app/by-tag/"+tag, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		var response struct {
+			Release database.TagDeployment `json:"release"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response.Release
+	}
+
+	rr, historical := getByTag("v1.0.0")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if historical.Current {
+		t.Errorf("Expected v1.0.0 not to be current")
+	}
+	if !historical.FirstSeen.Equal(now.Add(-48 * time.Hour)) {
+		t.Errorf("Expected first_seen %v, got %v", now.Add(-48*time.Hour), historical.FirstSeen)
+	}
+
+	rr, current := getByTag("v1.1.0")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !current.Current || current.ImageSHA != "def456" {
+		t.Errorf("Expected v1.1.0 to be current with SHA def456, got %+v", current)
+	}
+
+	if rr, _ := getByTag("v9.9.9"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown tag, got %d", rr.Code)
+	}
+
+	// Client API keys are limited to their own client
+	req := httptest.NewRequest("GET", "/api/releases/history/acme/prod/default/web/app/by-tag/v1.1.0", nil)
+	req.Header.Set("X-Client-Name", "other")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client's key, got %d", rr.Code)
+	}
+}
+
+func TestSignedBadges(t *testing.T) {
+	server := newTestServer(t, &config.Config{BadgeSigningSecret: "test-signing-secret"})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.2.3", "0123456789abcdef0123", time.Now())
+
+	getBadge := func(path string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d", path, rr.Code)
+		}
+		return rr.Body.String()
+	}
+
+	// Issue a signed URL through the API
+	req := httptest.NewRequest("GET", "/api/badges/sign/acme/prod/Deployment/web/app?ttl=1h", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 when signing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var signed struct {
+		URL string `json:"url"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &signed)
+
+	if badge := getBadge(signed.URL); !strings.Contains(badge, "v1.2.3") {
+		t.Errorf("Expected valid signed badge to show the tag, got %s", badge)
+	}
+
+	// Reusing the signature for another component must fail
+	tampered := strings.Replace(signed.URL, "/web/app", "/api/app", 1)
+	if badge := getBadge(tampered); !strings.Contains(badge, "unauthorized") {
+		t.Errorf("Expected tampered path to be rejected, got %s", badge)
+	}
+
+	// Correctly signed but past its expiry
+	expiry := time.Now().Add(-time.Minute).Unix()
+	path := badgePath("acme", "prod", "Deployment", "web", "app", false)
+	expired := fmt.Sprintf("/badges/signed/%s/%d/%s", signBadge("test-signing-secret", expiry, path), expiry, path)
+	if badge := getBadge(expired); !strings.Contains(badge, "expired") {
+		t.Errorf("Expected expired badge, got %s", badge)
+	}
+
+	// Digest variant is signed separately
+	digestPath := badgePath("acme", "prod", "Deployment", "web", "app", true)
+	future := time.Now().Add(time.Hour).Unix()
+	digestURL := fmt.Sprintf("/badges/signed/%s/%d/%s", signBadge("test-signing-secret", future, digestPath), future, digestPath)
+	if badge := getBadge(digestURL); !strings.Contains(badge, ">0123456789ab<") {
+		t.Errorf("Expected signed digest badge to show the short digest, got %s", badge)
+	}
+
+	// all_containers is covered by the signature: it cannot be added to a URL signed without it
+	if badge := getBadge(signed.URL + "?all_containers=true"); !strings.Contains(badge, "unauthorized") {
+		t.Errorf("Expected all_containers on a URL signed without it to be rejected, got %s", badge)
+	}
+	req = httptest.NewRequest("GET", "/api/badges/sign/acme/prod/Deployment/web/app?all_containers=true", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	json.Unmarshal(rr.Body.Bytes(), &signed)
+	if !strings.HasSuffix(signed.URL, "/web/app?all_containers=true") {
+		t.Fatalf("Expected the signed URL to carry all_containers, got %q", signed.URL)
+	}
+	if badge := getBadge(signed.URL); !strings.Contains(badge, "v1.2.3") {
+		t.Errorf("Expected the signed all_containers badge to show the tag, got %s", badge)
+	}
+	if badge := getBadge(strings.TrimSuffix(signed.URL, "?all_containers=true")); !strings.Contains(badge, "unauthorized") {
+		t.Errorf("Expected all_containers not to be removable from a URL signed with it, got %s", badge)
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		apiKey       string
+		legacyFormat bool
+		expectClient string
+		expectEnv    string
+		expectAdmin  bool
+		expectErr    bool
+	}{
+		{name: "Separator client key", apiKey: "acme::secret", legacyFormat: true, expectClient: "acme"},
+		{name: "Hyphenated client name", apiKey: "foo-bar::baz", legacyFormat: true, expectClient: "foo-bar"},
+		{name: "Hyphenated client name without legacy format", apiKey: "foo-bar::baz-qux", legacyFormat: false, expectClient: "foo-bar"},
+		{name: "Legacy client key", apiKey: "client1-authkey", legacyFormat: true, expectClient: "client1"},
+		{name: "Legacy client key disabled", apiKey: "client1-authkey", legacyFormat: false, expectErr: true},
+		{name: "Admin key with several hyphens", apiKey: "admin-master-key", legacyFormat: true, expectAdmin: true},
+		{name: "Empty client name", apiKey: "::secret", legacyFormat: true, expectErr: true},
+		{name: "Empty secret", apiKey: "acme::", legacyFormat: true, expectErr: true},
+		{name: "Empty secret without legacy format", apiKey: "acme::", legacyFormat: false, expectErr: true},
+		{name: "Environment-scoped client key", apiKey: "acme::dev::secret", legacyFormat: true, expectClient: "acme", expectEnv: "dev"},
+		{name: "Empty environment", apiKey: "acme::::secret", legacyFormat: true, expectErr: true},
+		{name: "Secret after environment separator missing", apiKey: "acme::dev::", legacyFormat: true, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientName, envName, _, isAdmin, err := parseAPIKey(tt.apiKey, tt.legacyFormat)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Expected error %t, got %v", tt.expectErr, err)
+			}
+			if isAdmin != tt.expectAdmin {
+				t.Errorf("Expected isAdmin %t, got %t", tt.expectAdmin, isAdmin)
+			}
+			if clientName != tt.expectClient {
+				t.Errorf("Expected client %q, got %q", tt.expectClient, clientName)
+			}
+			if envName != tt.expectEnv {
+				t.Errorf("Expected environment %q, got %q", tt.expectEnv, envName)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsMalformedKeys(t *testing.T) {
+	legacyKey := "client1-authkey12345678901234567890"
+	emptyClientKey := "::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{legacyKey, emptyClientKey}})
+
+	for _, apiKey := range []string{legacyKey, emptyClientKey} {
+		req := httptest.NewRequest("GET", "/api/admin/keys", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s... to be rejected, got %d: %s", apiKey[:8], rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestAuthMiddlewareHyphenatedClientKey(t *testing.T) {
+	clientKey := "foo-bar::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{clientKey}})
+
+	seedRelease(t, server, "foo-bar", "prod", "default", "web", "app", "v1", "abc123", time.Now())
+
+	get := func(clientName string) int {
+		req := httptest.NewRequest("GET", "/api/releases/current?client_name="+clientName+"&env_name=prod", nil)
+		req.Header.Set("X-API-Key", clientKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := get("foo-bar"); code != http.StatusOK {
+		t.Errorf("Expected hyphenated client key to access its own client, got %d", code)
+	}
+	if code := get("other"); code != http.StatusForbidden {
+		t.Errorf("Expected hyphenated client key to be restricted to its client, got %d", code)
+	}
+}
+
+func TestAuthMiddlewareEnvScopedClientKey(t *testing.T) {
+	clientKey := "acme::dev::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{clientKey}})
+
+	seedRelease(t, server, "acme", "dev", "default", "web", "app", "v2", "def456", time.Now())
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1", "abc123", time.Now())
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", clientKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(path string) *httptest.ResponseRecorder { return do("GET", path) }
+
+	tests := []struct {
+		path       string
+		expectCode int
+	}{
+		{"/api/releases/current?client_name=acme&env_name=dev", http.StatusOK},
+		{"/api/releases/history/acme/prod/default/web/app/by-tag/v1", http.StatusForbidden},
+		{"/api/releases/acme/prod/default/web/app/provenance", http.StatusForbidden},
+		{"/api/releases/diff?client=acme&env_a=dev&env_b=prod", http.StatusForbidden},
+		{"/api/scaling/acme/prod/default/web", http.StatusForbidden},
+		{"/api/gaps/acme/prod", http.StatusForbidden},
+		{"/api/ghosts/acme/prod", http.StatusForbidden},
+		{"/api/metrics/detection-lag/acme/prod", http.StatusForbidden},
+		{"/api/badges/sign/acme/prod/deployment/web/app", http.StatusForbidden},
+		{"/api/releases/current?client_name=acme&env_name=prod", http.StatusForbidden},
+		{"/api/releases/current?client_name=acme&env_name=dev,prod", http.StatusForbidden},
+		{"/api/releases/history/acme/dev/default/web/app", http.StatusOK},
+		{"/api/releases/history/acme/prod/default/web/app", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if rr := get(tt.path); rr.Code != tt.expectCode {
+			t.Errorf("GET %s: expected %d, got %d: %s", tt.path, tt.expectCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := do("DELETE", "/api/releases/acme/prod/default/web/app"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected deleting a prod component to be denied, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := get("/api/clients-environments")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected clients-environments to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		ClientsEnvironments map[string][]string `json:"clients_environments"`
+		Statistics          struct {
+			TotalReleases int `json:"total_releases"`
+		} `json:"statistics"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if envs := response.ClientsEnvironments["acme"]; len(response.ClientsEnvironments) != 1 || len(envs) != 1 || envs[0] != "dev" {
+		t.Errorf("Expected only acme/dev to be listed, got %v", response.ClientsEnvironments)
+	}
+	if response.Statistics.TotalReleases != 1 {
+		t.Errorf("Expected only the acme/dev release to be counted, got %d", response.Statistics.TotalReleases)
+	}
+
+	rr = get("/api/consistency/acme")
+	var consistency struct {
+		Components []database.ComponentConsistency `json:"components"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &consistency); err != nil {
+		t.Fatalf("Failed to decode consistency response %q: %v", rr.Body.String(), err)
+	}
+	if len(consistency.Components) != 1 || len(consistency.Components[0].Environments) != 1 || consistency.Components[0].Environments[0].EnvName != "dev" {
+		t.Errorf("Expected the consistency of acme/dev only, got %+v", consistency.Components)
+	}
+}
+
+func TestReloadAPIKeys(t *testing.T) {
+	adminKey := "admin-key-1234567890123456789012345"
+	leakedKey := "acme::authkey12345678901234567890"
+	rotatedKey := "acme::rotated12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{adminKey, leakedKey}})
+	configured := []string{adminKey, rotatedKey}
+	server.loadAPIKeys = func() ([]string, error) { return configured, nil }
+
+	do := func(apiKey, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(leakedKey, "POST", "/api/admin/keys/reload"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected client keys to be denied the reload, got %d", rr.Code)
+	}
+
+	rr := do(adminKey, "GET", "/api/admin/keys")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), leakedKey) || !strings.Contains(rr.Body.String(), `"key_preview":"acme::au..."`) {
+		t.Errorf("Expected the masked keys, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(adminKey, "POST", "/api/admin/keys/reload")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the reload to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Added   int          `json:"added"`
+		Removed int          `json:"removed"`
+		Keys    []APIKeyInfo `json:"keys"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Added != 1 || response.Removed != 1 || len(response.Keys) != 2 {
+		t.Errorf("Expected 1 key added and 1 removed, got %+v", response)
+	}
+
+	if rr := do(leakedKey, "GET", "/api/releases/current?client_name=acme&env_name=prod"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked key to be rejected, got %d", rr.Code)
+	}
+	if rr := do(rotatedKey, "GET", "/api/releases/current?client_name=acme&env_name=prod"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the new key to be accepted, got %d", rr.Code)
+	}
+
+	// A reload leaving no valid key would lock every client out and is refused
+	configured = nil
+	if rr := do(adminKey, "POST", "/api/admin/keys/reload"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected a reload without keys to be refused, got %d", rr.Code)
+	}
+	if rr := do(rotatedKey, "GET", "/api/releases/current?client_name=acme&env_name=prod"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the keys to be kept after a refused reload, got %d", rr.Code)
+	}
+}
+
+func TestHandleKeyUsage(t *testing.T) {
+	adminKey := "admin-key-1234567890123456789012345"
+	clientKey := "acme::authkey12345678901234567890"
+	unusedKey := "other::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{adminKey, clientKey, unusedKey}})
+
+	get := func(apiKey, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		get(clientKey, "/api/releases/current?client_name=acme&env_name=prod")
+	}
+	get("acme::wrong-secret-123456789012345678", "/api/releases/current?client_name=acme&env_name=prod")
+	get(clientKey, "/badges/"+clientKey+"/acme/prod/Deployment/web/app")
+
+	if rr := get(clientKey, "/api/admin/keys/usage"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected client keys to be denied key usage, got %d", rr.Code)
+	}
+
+	rr := get(adminKey, "/api/admin/keys/usage")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, key := range []string{adminKey, clientKey, unusedKey} {
+		if strings.Contains(rr.Body.String(), key) {
+			t.Errorf("Expected the raw key %s... not to be exposed", key[:8])
+		}
+	}
+
+	var response struct {
+		Keys   []KeyUsage `json:"keys"`
+		Total  int        `json:"total"`
+		Unused int        `json:"unused"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Total != 3 || response.Unused != 1 {
+		t.Errorf("Expected 3 keys with 1 unused, got %d and %d", response.Total, response.Unused)
+	}
+	usage := make(map[string]KeyUsage)
+	for _, key := range response.Keys {
+		usage[key.KeyPreview] = key
+	}
+	if acme := usage["acme::au..."]; acme.Requests != 5 || acme.LastUsed == nil || acme.Type != "client" || acme.ClientName != "acme" || acme.KeyID != keyID(clientKey) {
+		t.Errorf("Expected 4 API and 1 badge request for the acme key, got %+v", acme)
+	}
+	if admin := usage["admin-ke..."]; admin.Requests != 1 || admin.Type != "admin" {
+		t.Errorf("Expected the usage request to count for the admin key, got %+v", admin)
+	}
+	if other := usage["other::a..."]; other.Requests != 0 || other.LastUsed != nil {
+		t.Errorf("Expected the unused key to have no requests, got %+v", other)
+	}
+	if response.Keys[0].KeyPreview != "other::a..." {
+		t.Errorf("Expected unused keys to be listed first, got %+v", response.Keys)
+	}
+}
+
+func TestHandleClientRetention(t *testing.T) {
+	adminKey := "admin-key-1234567890123456789012345"
+	clientKey := "acme::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{adminKey, clientKey}})
+
+	do := func(apiKey, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(clientKey, "PUT", "/api/admin/retention/acme", `{"keep_count": 100}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected client keys to be denied, got %d", rr.Code)
+	}
+	for _, body := range []string{`{}`, `{"keep_count": 0}`, `{"keep_days": -1}`, `not json`} {
+		if rr := do(adminKey, "PUT", "/api/admin/retention/acme", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+	if rr := do(adminKey, "PUT", "/api/admin/retention/acme", `{"keep_days": 365}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do(adminKey, "GET", "/api/admin/retention", "")
+	var response struct {
+		Policies         []database.ClientRetention `json:"policies"`
+		DefaultKeepCount int                        `json:"default_keep_count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Policies) != 1 || response.Policies[0].ClientName != "acme" || response.Policies[0].KeepCount != nil ||
+		response.Policies[0].KeepDays == nil || *response.Policies[0].KeepDays != 365 || response.DefaultKeepCount != database.DefaultKeepReleases {
+		t.Errorf("Expected the acme policy keeping 365 days, got %s", rr.Body.String())
+	}
+
+	if rr := do(adminKey, "DELETE", "/api/admin/retention/acme", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the policy to be deleted, got %d", rr.Code)
+	}
+	if rr := do(adminKey, "DELETE", "/api/admin/retention/acme", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once the policy is gone, got %d", rr.Code)
+	}
+}
+
+// recordingNotifier collects the events it receives
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestManualCollectNotifiesVersionChanges(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	notifier := &recordingNotifier{}
+	server.SetNotifier(notifier)
+
+	collect := func(tag, sha string) {
+		t.Helper()
+		body := fmt.Sprintf(`{"image_tag": %q, "image_sha": %q, "client_name": "acme", "env_name": "prod"}`, tag, sha)
+		req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	collect("v1", "sha-1")
+	collect("v1", "sha-1")
+	if len(notifier.events) != 0 {
+		t.Fatalf("Expected no events for a first-seen or unchanged release, got %d", len(notifier.events))
+	}
+
+	collect("v2", "sha-2")
+	if len(notifier.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(notifier.events))
+	}
+	event := notifier.events[0]
+	if event.PreviousTag != "v1" || event.ImageTag != "v2" || event.ClientName != "acme" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestFederatedReadEndpoints(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	const upstreamKey = "federation-admin-key-0123456789abcdef"
+
+	eu := newTestServer(t, &config.Config{APIKeys: []string{upstreamKey}})
+	seedRelease(t, eu, "acme", "prod", "default", "web", "app", "v1", "sha-eu", now.Add(-time.Hour))
+	seedRelease(t, eu, "acme", "staging", "default", "web", "app", "v2", "sha-eu-staging", now)
+	us := newTestServer(t, &config.Config{APIKeys: []string{upstreamKey}})
+	seedRelease(t, us, "acme", "prod", "default", "api", "app", "v3", "sha-us", now)
+	seedRelease(t, us, "globex", "prod", "default", "web", "app", "v4", "sha-globex", now)
+
+	euMaster := httptest.NewServer(eu)
+	defer euMaster.Close()
+	usMaster := httptest.NewServer(us)
+	defer usMaster.Close()
+
+	// The federating master knows a newer release of the EU web component
+	global := newTestServer(t, &config.Config{})
+	seedRelease(t, global, "acme", "prod", "default", "web", "app", "v1.1", "sha-local", now.Add(-time.Minute))
+	global.SetFederation(federation.New([]string{euMaster.URL, usMaster.URL + "/"}, upstreamKey, time.Minute))
+
+	req := httptest.NewRequest("GET", "/api/releases/current?client_name=acme&env_name=prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	global.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var current struct {
+		Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+		Total      int                                  `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &current); err != nil {
+		t.Fatal(err)
+	}
+	tags := make(map[string]string)
+	for _, release := range current.Namespaces["default"] {
+		tags[release.WorkloadName] = release.ImageTag
+	}
+	if current.Total != 2 || tags["web"] != "v1.1" || tags["api"] != "v3" {
+		t.Errorf("Expected merged releases web=v1.1 and api=v3, got total %d: %v", current.Total, tags)
+	}
+
+	req = httptest.NewRequest("GET", "/api/clients-environments", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	global.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var clientsEnvs struct {
+		ClientsEnvironments map[string][]string `json:"clients_environments"`
+		Statistics          struct {
+			TotalClients  int `json:"total_clients"`
+			TotalReleases int `json:"total_releases"`
+		} `json:"statistics"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &clientsEnvs); err != nil {
+		t.Fatal(err)
+	}
+	if clientsEnvs.Statistics.TotalClients != 2 || len(clientsEnvs.ClientsEnvironments["acme"]) != 2 || len(clientsEnvs.ClientsEnvironments["globex"]) != 1 {
+		t.Errorf("Expected acme with 2 environments and globex merged, got %v", clientsEnvs.ClientsEnvironments)
+	}
+	if clientsEnvs.Statistics.TotalReleases != 5 {
+		t.Errorf("Expected 5 releases across masters, got %d", clientsEnvs.Statistics.TotalReleases)
+	}
+
+	// Upstream responses are cached: an unreachable upstream still serves the cached data
+	usMaster.Close()
+	req = httptest.NewRequest("GET", "/api/releases/current?client_name=acme&env_name=prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	global.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `"image_tag":"v3"`) {
+		t.Errorf("Expected cached upstream release, got %s", rr.Body.String())
+	}
+}
+
+func TestNormalizedClientEnvNames(t *testing.T) {
+	server := newTestServer(t, &config.Config{LowercaseNames: true})
+
+	// Collects for "Prod " and "prod" key the same component
+	for _, envName := range []string{"Prod ", "prod"} {
+		body := fmt.Sprintf(`{"image_tag": "v1", "image_sha": "sha-1", "client_name": " Acme", "env_name": %q}`, envName)
+		req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	clientEnvs, err := server.db.GetAvailableClientsAndEnvironments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clientEnvs) != 1 || len(clientEnvs["acme"]) != 1 || clientEnvs["acme"][0] != "prod" {
+		t.Errorf("Expected a single acme/prod combination, got %v", clientEnvs)
+	}
+
+	// Read handlers normalize query and path values the same way
+	req := httptest.NewRequest("GET", "/api/releases/current?client_name=ACME&env_name=Prod%20", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"total":1`) {
+		t.Errorf("Expected the release for ACME/Prod, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/badges/key/Acme/PROD/Deployment/web/app", nil))
+	if !strings.Contains(rr.Body.String(), ">v1<") {
+		t.Errorf("Expected badge for Acme/PROD, got %s", rr.Body.String())
+	}
+
+	// Existing rows differing only in case or whitespace are merged
+	now := time.Now()
+	seedRelease(t, server, "Globex", "Prod ", "default", "web", "app", "v2", "sha-2", now)
+	seedRelease(t, server, "globex", "prod", "default", "web", "app", "v2", "sha-2", now)
+	seedRelease(t, server, "GLOBEX", "prod", "default", "api", "app", "v3", "sha-3", now)
+	if err := server.db.NormalizeClientEnvNames(true); err != nil {
+		t.Fatalf("NormalizeClientEnvNames failed: %v", err)
+	}
+	releases, err := server.db.GetCurrentReleasesFiltered("globex", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 {
+		t.Errorf("Expected 2 globex/prod releases after normalization, got %+v", releases)
+	}
+	clientEnvs, err = server.db.GetAvailableClientsAndEnvironments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clientEnvs) != 2 {
+		t.Errorf("Expected acme and globex only, got %v", clientEnvs)
+	}
+}
+
+func TestHandleReleaseHistoryLimit(t *testing.T) {
+	server := newTestServer(t, &config.Config{MaxHistoryLimit: 3})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		seedRelease(t, server, "acme", "prod", "default", "web", "app", fmt.Sprintf("v%d", i), fmt.Sprintf("sha-%d", i), now.Add(time.Duration(i)*time.Minute))
+	}
+
+	tests := []struct {
+		query     string
+		total     int
+		capped    bool
+		firstTag  string
+		wantLimit int
+	}{
+		{"", 3, false, "v4", 3}, // the default of 10 is lowered to the maximum without flagging
+		{"?limit=1000", 3, true, "v4", 3},
+		{"?limit=2&offset=1", 2, false, "v3", 2},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/releases/history/acme/prod/default/web/app"+tt.query, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d: %s", tt.query, rr.Code, rr.Body.String())
+		}
+
+		var response struct {
+			History database.ReleaseHistory `json:"history"`
+			Limit   int                     `json:"limit"`
+			Capped  bool                    `json:"capped"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.History.Total != tt.total || response.Capped != tt.capped || response.Limit != tt.wantLimit {
+			t.Errorf("%q: expected total %d, limit %d, capped %t, got %d, %d, %t", tt.query, tt.total, tt.wantLimit, tt.capped, response.History.Total, response.Limit, response.Capped)
+		}
+		if len(response.History.Releases) > 0 && response.History.Releases[0].ImageTag != tt.firstTag {
+			t.Errorf("%q: expected first release %s, got %s", tt.query, tt.firstTag, response.History.Releases[0].ImageTag)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/releases/history/acme/prod/default/web/app?limit=0", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for limit=0, got %d", rr.Code)
+	}
+}
+
+func TestReleaseHistoryUnknownComponent(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	body := `{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = get("/api/releases/history/acme/prod/default/api/app")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a component never recorded, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response errorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.Error.Code != codeNotFound || response.Error.Message == "" {
+		t.Errorf("Expected a JSON error message, got %q", rr.Body.String())
+	}
+
+	// Paging past the releases of a known component still succeeds
+	if rr := get("/api/releases/history/acme/prod/default/web/app?offset=5"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 past the releases of a known component, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestManualCollectIdempotencyKey(t *testing.T) {
+	server := newTestServer(t, &config.Config{IdempotencyTTL: 60})
+
+	put := func(key, releasedAt string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod", "released_at": "` + releasedAt + `"}`
+		req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+	lastSeen := func() time.Time {
+		t.Helper()
+		history, err := server.db.GetReleaseHistory("default", "web", "app", "acme", "prod", 0)
+		if err != nil || history == nil || len(history.Releases) != 1 {
+			t.Fatalf("Expected one release in history, got %+v (%v)", history, err)
+		}
+		return history.Releases[0].LastSeen
+	}
+
+	first := put("retry-1", "2026-10-01T10:00:00Z")
+	// A retry with a slightly different released_at gets the original response and records nothing
+	retry := put("retry-1", "2026-10-01T10:00:05Z")
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the original response to be replayed, got %q", retry.Body.String())
+	}
+	if want := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC); !lastSeen().Equal(want) {
+		t.Errorf("Expected the retry not to move last_seen from %s, got %s", want, lastSeen())
+	}
+
+	// Another key is processed
+	if rr := put("retry-2", "2026-10-01T11:00:00Z"); rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Expected a new key to be processed")
+	}
+	if want := time.Date(2026, 10, 1, 11, 0, 0, 0, time.UTC); !lastSeen().Equal(want) {
+		t.Errorf("Expected last_seen %s after a new key, got %s", want, lastSeen())
+	}
+
+	// A retry arriving while the first attempt is still processed is turned away, not recorded twice
+	scope := "PUT /api/collect/default/Deployment/web/app "
+	if _, claimed, err := server.db.ClaimIdempotencyKey("in-flight", scope, time.Hour); err != nil || !claimed {
+		t.Fatalf("Failed to claim idempotency key: %v", err)
+	}
+	req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(`{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`))
+	req.Header.Set("Idempotency-Key", "in-flight")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a key still being processed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A request failing before its response is recorded releases its key, so the retry is processed
+	req = httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(`{`))
+	req.Header.Set("Idempotency-Key", "retry-3")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an invalid body, got %d", rr.Code)
+	}
+	if rr := put("retry-3", "2026-10-01T12:00:00Z"); rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Expected the retry of a failed request to be processed")
+	}
+}
+
+func TestManualCollectRecordsSource(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	tests := []struct {
+		workload string
+		header   string
+		expected string
+	}{
+		{"web", "", database.SourceManual},
+		{"worker", database.SourceSync, database.SourceSync},
+		// Unknown values are not trusted
+		{"cron", "collection", database.SourceManual},
+	}
+	for _, tt := range tests {
+		body := `{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`
+		req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/"+tt.workload+"/app", strings.NewReader(body))
+		req.Header.Set("X-Is-Admin", "true")
+		if tt.header != "" {
+			req.Header.Set("X-Release-Source", tt.header)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+		}
+
+		req = httptest.NewRequest("GET", "/api/releases/history/acme/prod/default/"+tt.workload+"/app", nil)
+		req.Header.Set("X-Is-Admin", "true")
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		var response struct {
+			History database.ReleaseHistory `json:"history"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.History.Releases) != 1 || response.History.Releases[0].Source != tt.expected {
+			t.Errorf("%s: expected source %q in history, got %+v", tt.workload, tt.expected, response.History.Releases)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := newTestServer(t, &config.Config{APIKeys: []string{"admin-key-0123456789abcdef0123456789abcdef"}})
+
+	// Without metrics configured the endpoint does not exist
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without metrics, got %d", rr.Code)
+	}
+
+	server.SetMetrics(metrics.New())
+	body := `{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`
+	req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+	req.Header.Set("X-API-Key", "admin-key-0123456789abcdef0123456789abcdef")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Metrics are served without authentication, like /health
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	for _, expected := range []string{"krelease_releases_upserted_total 1", "krelease_pending_releases 0"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, rr.Body.String())
+		}
+	}
+}
+
+func TestMetricsWithBasePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		path     string
+	}{
+		{name: "No base path", basePath: "", path: "/metrics"},
+		{name: "Prefixed with base path", basePath: "/tracker", path: "/tracker/metrics"},
+		{name: "Unprefixed with base path", basePath: "/tracker", path: "/metrics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, &config.Config{BasePath: tt.basePath})
+			server.SetMetrics(metrics.New())
+
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 for %s, got %d", tt.path, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), "krelease_releases_upserted_total") {
+				t.Errorf("Expected Prometheus metrics for %s, got:\n%s", tt.path, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestManualCollectRejectsClockSkew(t *testing.T) {
+	server := newTestServer(t, &config.Config{MaxClockSkew: 5})
+
+	tests := []struct {
+		name       string
+		releasedAt time.Time
+		expected   int
+	}{
+		{"now", time.Now(), http.StatusOK},
+		{"within skew", time.Now().Add(3 * time.Minute), http.StatusOK},
+		{"historical", time.Now().AddDate(-1, 0, 0), http.StatusOK},
+		{"far future", time.Now().AddDate(1, 0, 0), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod", "released_at": %q}`,
+				tt.releasedAt.UTC().Format(time.RFC3339))
+			req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body))
+			req.Header.Set("X-Is-Admin", "true")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expected, rr.Code, rr.Body.String())
+			}
+			if tt.expected == http.StatusBadRequest && !strings.Contains(rr.Body.String(), "ahead of the server time") {
+				t.Errorf("Expected a clock skew error, got %s", rr.Body.String())
+			}
+		})
+	}
+
+	// The rejected release must not have become the current one
+	releases, err := server.db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range releases {
+		if r.LastSeen.After(time.Now().Add(time.Hour)) {
+			t.Errorf("Expected no future release to be stored, got %+v", r)
+		}
+	}
+}
+
+func TestHandleGhostWorkloads(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	// worker ran before it was scaled to zero
+	seedRelease(t, server, "acme", "prod", "default", "worker", "main", "v1", "abc123", time.Now().Add(-time.Hour))
+	for _, ce := range []database.CollectionError{
+		{Namespace: "default", WorkloadName: "worker", WorkloadType: "Deployment", ContainerName: "main", ClientName: "acme", EnvName: "prod",
+			Error: "no running pods found for Deployment/worker", Category: database.CollectionErrorNoRunningPods},
+		{Namespace: "default", WorkloadName: "worker", WorkloadType: "Deployment", ContainerName: "sidecar", ClientName: "acme", EnvName: "prod",
+			Error: "no running pods found for Deployment/worker", Category: database.CollectionErrorNoRunningPods},
+		{Namespace: "jobs", WorkloadName: "pending", WorkloadType: "StatefulSet", ContainerName: "db", ClientName: "acme", EnvName: "prod",
+			Error: "no running pods found for StatefulSet/pending (1 pods, none running)", Category: database.CollectionErrorNoRunningPods},
+		{Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app", ClientName: "acme", EnvName: "prod",
+			Error: "no ready container app found in running pods for Deployment/web", Category: database.CollectionErrorUnresolved},
+		{Namespace: "default", WorkloadName: "other", WorkloadType: "Deployment", ContainerName: "main", ClientName: "acme", EnvName: "dev",
+			Error: "no running pods found for Deployment/other", Category: database.CollectionErrorNoRunningPods},
+	} {
+		ce := ce
+		if err := server.db.RecordCollectionError(&ce); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/ghosts/acme/prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Ghosts []database.GhostWorkload `json:"ghosts"`
+		Total  int                      `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 {
+		t.Fatalf("Expected 2 ghost workloads for acme/prod, got %d: %+v", response.Total, response.Ghosts)
+	}
+	worker := response.Ghosts[0]
+	if worker.WorkloadName != "worker" || len(worker.Containers) != 2 || !worker.HasRelease {
+		t.Errorf("Expected worker with both containers and a stored release, got %+v", worker)
+	}
+	if pending := response.Ghosts[1]; pending.WorkloadName != "pending" || pending.HasRelease {
+		t.Errorf("Expected pending workload without release, got %+v", pending)
+	}
+
+	// Client keys cannot list the ghosts of another client
+	req = httptest.NewRequest("GET", "/api/ghosts/acme/prod", nil)
+	req.Header.Set("X-Client-Name", "other")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client, got %d", rr.Code)
+	}
+}
+
+func TestHandleFleetOverview(t *testing.T) {
+	clientKey := "acme::authkey12345678901234567890"
+	adminKey := "admin-key-1234567890123456789012345"
+	server := newTestServer(t, &config.Config{APIKeys: []string{clientKey, adminKey}})
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v1.0.0", "abc123", time.Now())
+	seedRelease(t, server, "globex", "dev", "default", "web", "app", "v2.0.0", "def456", time.Now().Add(-48*time.Hour))
+
+	get := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/overview", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get(clientKey); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected client keys to be denied the fleet overview, got %d", rr.Code)
+	}
+
+	rr := get(adminKey)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Environments []database.FleetEnvironment `json:"environments"`
+		Totals       struct {
+			Clients          int            `json:"clients"`
+			Environments     int            `json:"environments"`
+			PingStatuses     map[string]int `json:"ping_statuses"`
+			TotalComponents  int            `json:"total_components"`
+			DeployedRecently int            `json:"deployed_recently"`
+		} `json:"totals"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Environments) != 2 || response.Environments[0].ClientName != "acme" || response.Environments[0].DeployedRecently != 1 ||
+		response.Environments[1].ClientName != "globex" || response.Environments[1].DeployedRecently != 0 {
+		t.Errorf("Expected acme/prod deployed recently and globex/dev not, got %+v", response.Environments)
+	}
+	if response.Totals.Clients != 2 || response.Totals.Environments != 2 || response.Totals.TotalComponents != 2 ||
+		response.Totals.DeployedRecently != 1 || response.Totals.PingStatuses["never"] != 2 {
+		t.Errorf("Unexpected totals %+v", response.Totals)
+	}
+}
+
+func TestErrorResponsesUseJSONEnvelope(t *testing.T) {
+	clientKey := "acme::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{clientKey}})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		apiKey       string
+		body         string
+		expectStatus int
+		expectCode   string
+	}{
+		{"missing API key", "GET", "/api/releases/current/acme/prod", "", "", http.StatusUnauthorized, codeUnauthorized},
+		{"other client", "GET", "/api/releases/current/globex/prod", clientKey, "", http.StatusForbidden, codeAccessDenied},
+		{"admin endpoint", "GET", "/api/admin/keys", clientKey, "", http.StatusForbidden, codeAccessDenied},
+		{"missing parameters", "GET", "/api/releases/diff?client=acme", clientKey, "", http.StatusBadRequest, codeMissingParams},
+		{"invalid limit", "GET", "/api/releases/current/acme/prod?limit=abc", clientKey, "", http.StatusBadRequest, codeInvalidParams},
+		{"invalid body", "POST", "/api/ping", clientKey, "{", http.StatusBadRequest, codeInvalidBody},
+		{"unsupported schema", "POST", "/api/ping", clientKey, `{"client_name": "acme", "env_name": "prod", "schema_version": 99}`, http.StatusBadRequest, codeUnsupportedSchema},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectStatus, rr.Code, rr.Body.String())
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Expected a JSON error, got Content-Type %q", contentType)
+			}
+			var response errorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode error response %q: %v", rr.Body.String(), err)
+			}
+			if response.Error.Code != tt.expectCode || response.Error.Message == "" {
+				t.Errorf("Expected error code %q with a message, got %+v", tt.expectCode, response.Error)
+			}
+		})
+	}
+}
+
+func TestStrictJSONRejectsUnknownFields(t *testing.T) {
+	requests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		field  string
+	}{
+		{"manual collect", "PUT", "/api/collect/default/Deployment/web/app",
+			`{"imageTag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"}`, "imageTag"},
+		{"ping", "POST", "/api/ping",
+			`{"client_name": "acme", "env_name": "prod", "clusterName": "eu-west-1"}`, "clusterName"},
+	}
+
+	for _, strict := range []bool{true, false} {
+		server := newTestServer(t, &config.Config{StrictJSON: strict})
+		for _, tt := range requests {
+			t.Run(fmt.Sprintf("%s strict=%v", tt.name, strict), func(t *testing.T) {
+				req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+				req.Header.Set("X-Is-Admin", "true")
+				rr := httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+
+				unknownField := strings.Contains(rr.Body.String(), `unknown field \"`+tt.field+`\"`)
+				if strict && (rr.Code != http.StatusBadRequest || !unknownField) {
+					t.Errorf("Expected 400 naming the unknown field, got %d: %s", rr.Code, rr.Body.String())
+				}
+				if !strict && unknownField {
+					t.Errorf("Expected lenient mode to ignore the unknown field, got %d: %s", rr.Code, rr.Body.String())
+				}
+			})
+		}
+	}
+
+	// Lenient mode keeps accepting well-formed bodies carrying extra fields
+	server := newTestServer(t, &config.Config{})
+	req := httptest.NewRequest("POST", "/api/ping", strings.NewReader(`{"client_name": "acme", "env_name": "prod", "extra": true}`))
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected lenient ping with an extra field to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleEnvironmentDiff(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now()
+	seedRelease(t, server, "acme", "staging", "default", "api", "app", "v1.0.0", "abc123", now)
+	seedRelease(t, server, "acme", "prod", "default", "api", "app", "v1.0.0", "abc123", now)
+	seedRelease(t, server, "acme", "staging", "default", "web", "app", "v2.1.0", "def456", now)
+	seedRelease(t, server, "acme", "prod", "default", "web", "app", "v2.0.0", "fff999", now)
+	seedRelease(t, server, "acme", "staging", "default", "worker", "main", "v0.1.0", "aaa111", now)
+	seedRelease(t, server, "acme", "prod", "default", "legacy", "main", "v9.0.0", "bbb222", now)
+	seedRelease(t, server, "globex", "prod", "default", "other", "main", "v1.0.0", "ccc333", now)
+
+	req := httptest.NewRequest("GET", "/api/releases/diff?client=acme&env_a=staging&env_b=prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Components []database.ComponentDiff `json:"components"`
+		Summary    map[string]int           `json:"summary"`
+		Total      int                      `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 4 {
+		t.Fatalf("Expected 4 components, got %d: %+v", response.Total, response.Components)
+	}
+
+	statuses := make(map[string]database.ComponentDiff)
+	for _, c := range response.Components {
+		statuses[c.WorkloadName] = c
+	}
+	expected := map[string]string{
+		"api":    database.DiffMatch,
+		"web":    database.DiffDiffers,
+		"worker": database.DiffOnlyInA,
+		"legacy": database.DiffOnlyInB,
+	}
+	for workload, status := range expected {
+		if statuses[workload].Status != status {
+			t.Errorf("Expected %s to be %q, got %+v", workload, status, statuses[workload])
+		}
+	}
+	if web := statuses["web"]; web.EnvA == nil || web.EnvA.ImageTag != "v2.1.0" || web.EnvB == nil || web.EnvB.ImageTag != "v2.0.0" {
+		t.Errorf("Expected both versions of web, got %+v", web)
+	}
+	if worker := statuses["worker"]; worker.EnvB != nil {
+		t.Errorf("Expected no prod version of worker, got %+v", worker.EnvB)
+	}
+	if response.Summary[database.DiffMatch] != 1 || response.Summary[database.DiffDiffers] != 1 {
+		t.Errorf("Unexpected summary: %v", response.Summary)
+	}
+
+	// Missing parameters and foreign clients are rejected
+	req = httptest.NewRequest("GET", "/api/releases/diff?client=acme&env_a=staging", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without env_b, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/releases/diff?client=acme&env_a=staging&env_b=prod", nil)
+	req.Header.Set("X-Client-Name", "globex")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another client, got %d", rr.Code)
+	}
+}
+
+func TestHandleCurrentReleasesDisplayOrder(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+	now := time.Now()
+	for _, c := range []struct {
+		workload, container string
+		order               int
+	}{
+		{"proxy", "envoy", 10}, // sidecar listed last
+		{"zeta", "app", -1},    // primary app listed first
+		{"beta", "app", 0},
+		{"alpha", "worker", 0},
+		{"alpha", "app", 0},
+	} {
+		if err := server.db.UpsertRelease(&database.Release{
+			Namespace: "default", WorkloadName: c.workload, WorkloadType: "Deployment", ContainerName: c.container,
+			ImageTag: "v1", ImageSHA: c.workload + c.container, ClientName: "acme", EnvName: "prod",
+			DisplayOrder: c.order, FirstSeen: now, LastSeen: now,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/releases/current/acme/prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, r := range response.Namespaces["default"] {
+		order = append(order, r.WorkloadName+"/"+r.ContainerName)
+	}
+	// Components without a display order fall back to workload and container name ordering
+	expected := []string{"zeta/app", "alpha/app", "alpha/worker", "beta/app", "proxy/envoy"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected order %v, got %v", expected, order)
+	}
+}
+
+func TestHandleSelectorDiagnostics(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// Without a Kubernetes client there is nothing to diagnose
+	req := httptest.NewRequest("GET", "/api/admin/diagnostics/selectors", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	newTestServer(t, &config.Config{}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a Kubernetes client, got %d", rr.Code)
+	}
+
+	scaledDown := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: "worker:v1"}}},
+			},
+		},
+	}
+	k8s := kubernetes.NewFromClientset(fake.NewSimpleClientset(scaledDown), []string{"default"}, "master")
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	server := New(db, k8s, &config.Config{Mode: "master"})
+	if err := k8s.CollectReleases(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Workloads []kubernetes.SelectorDiagnostic `json:"workloads"`
+		Total     int                             `json:"total"`
+		Unmatched int                             `json:"unmatched"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 1 || response.Unmatched != 1 || response.Workloads[0].WorkloadName != "worker" {
+		t.Fatalf("Expected the unmatched worker deployment, got %+v", response)
+	}
+	if attempts := response.Workloads[0].Attempts; len(attempts) != 3 || attempts[0].Selector != "app=worker" {
+		t.Errorf("Expected the full selector chain, got %+v", attempts)
+	}
+
+	// Selector diagnostics expose workloads of every client and are reserved to admins
+	req = httptest.NewRequest("GET", "/api/admin/diagnostics/selectors", nil)
+	req.Header.Set("X-Client-Name", "acme")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a client key, got %d", rr.Code)
+	}
+}
+
+func TestManualCollectEnforcesAuthenticatedClient(t *testing.T) {
+	server := newTestServer(t, &config.Config{ClientName: "master", EnvName: "master"})
+
+	collect := func(workload, clientName, envName, envScope string) int {
+		t.Helper()
+		body := fmt.Sprintf(`{"image_tag": "v1.0.0", "image_sha": "abc123", "client_name": %q, "env_name": %q}`, clientName, envName)
+		req := httptest.NewRequest("PUT", "/api/collect/default/Deployment/"+workload+"/app", strings.NewReader(body))
+		req.Header.Set("X-Client-Name", "acme")
+		req.Header.Set("X-Client-Env", envScope)
+		req.Header.Set("X-Is-Admin", "false")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	recorded := func(clientName, envName string) int {
+		t.Helper()
+		releases, err := server.db.GetCurrentReleasesFiltered(clientName, envName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(releases)
+	}
+
+	// A key of acme cannot write releases of globex, nor an environment outside its scope
+	if code := collect("web", "globex", "prod", ""); code != http.StatusForbidden || recorded("globex", "prod") != 0 {
+		t.Errorf("Expected 403 without a recorded release for another client, got %d", code)
+	}
+	if code := collect("web", "acme", "staging", "prod"); code != http.StatusForbidden || recorded("acme", "staging") != 0 {
+		t.Errorf("Expected 403 without a recorded release for another environment, got %d", code)
+	}
+
+	if code := collect("web", "acme", "prod", ""); code != http.StatusOK || recorded("acme", "prod") != 1 {
+		t.Errorf("Expected the key of acme to record its own release, got %d", code)
+	}
+	// Without names in the body the release goes to the client and environment of the key, not to the server defaults
+	if code := collect("api", "", "", "prod"); code != http.StatusOK || recorded("acme", "prod") != 2 || recorded("master", "master") != 0 {
+		t.Errorf("Expected the release to default to the client of the key, got %d", code)
+	}
+
+	// Batch items of other clients are rejected one by one
+	body := `[
+		{"namespace": "default", "workload_kind": "Deployment", "workload_name": "cron", "container_name": "app", "image_tag": "v1", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"},
+		{"namespace": "default", "workload_kind": "Deployment", "workload_name": "cron", "container_name": "app", "image_tag": "v1", "image_sha": "abc123", "client_name": "globex", "env_name": "prod"}
+	]`
+	req := httptest.NewRequest("POST", "/api/collect/batch", strings.NewReader(body))
+	req.Header.Set("X-Client-Name", "acme")
+	req.Header.Set("X-Is-Admin", "false")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	var response struct {
+		Results []BatchCollectResult `json:"results"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Results) != 2 || response.Results[0].Status != "success" || !strings.Contains(response.Results[1].Error, "not authorized for client 'globex'") {
+		t.Errorf("Expected only the item of acme to be recorded, got %s", rr.Body.String())
+	}
+	if recorded("globex", "prod") != 0 {
+		t.Error("Expected no release recorded for globex")
+	}
+}
+
+func TestHandleBatchCollect(t *testing.T) {
+	server := newTestServer(t, &config.Config{MaxClockSkew: 5})
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	body := `[
+		{"namespace": "default", "workload_kind": "Deployment", "workload_name": "web", "container_name": "app", "image_tag": "v1.0.0", "image_sha": "abc123", "client_name": "acme", "env_name": "prod"},
+		{"namespace": "default", "workload_kind": "Deployment", "workload_name": "worker", "container_name": "app", "image_tag": "v2.0.0", "client_name": "acme", "env_name": "prod"},
+		{"workload_kind": "Deployment", "workload_name": "api", "container_name": "app", "image_tag": "v3.0.0", "image_sha": "def456"},
+		{"namespace": "default", "workload_kind": "Deployment", "workload_name": "cron", "container_name": "app", "image_tag": "v4.0.0", "image_sha": "fed789", "client_name": "acme", "env_name": "prod", "released_at": "` + future + `"},
+		{"namespace": "default", "workload_kind": "StatefulSet", "workload_name": "db", "container_name": "postgres", "image_tag": "16.2", "image_sha": "cafe00", "client_name": "acme", "env_name": "prod"}
+	]`
+	req := httptest.NewRequest("POST", "/api/collect/batch", strings.NewReader(body))
+	req.Header.Set("X-Release-Source", database.SourceSync)
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Status    string               `json:"status"`
+		Succeeded int                  `json:"succeeded"`
+		Failed    int                  `json:"failed"`
+		Results   []BatchCollectResult `json:"results"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "partial" || response.Succeeded != 2 || response.Failed != 3 || len(response.Results) != 5 {
+		t.Fatalf("Unexpected batch response: %+v", response)
+	}
+	for i, wantSuccess := range []bool{true, false, false, false, true} {
+		result := response.Results[i]
+		if result.Index != i || (result.Status == "success") != wantSuccess {
+			t.Errorf("Unexpected result for item %d: %+v", i, result)
+		}
+		if !wantSuccess && result.Error == "" {
+			t.Errorf("Expected an error message for item %d", i)
+		}
+	}
+	if !strings.Contains(response.Results[3].Error, "ahead of the server time") {
+		t.Errorf("Expected the clock skew check to apply to batch items, got %q", response.Results[3].Error)
+	}
+
+	releases, err := server.db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("Expected the 2 valid releases to be recorded, got %+v", releases)
+	}
+	for _, release := range releases {
+		if release.WorkloadName == "db" && (release.WorkloadType != "StatefulSet" || release.ImageTag != "16.2" || release.ImageSHA != "cafe00") {
+			t.Errorf("Expected the batch item fields to be recorded, got %+v", release)
+		}
+	}
+
+	// Malformed and empty batches are rejected as a whole
+	for _, body := range []string{`{"image_tag": "v1"}`, `[]`} {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("POST", "/api/collect/batch", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+}
+
+func TestManualCollectRecordsImageLabels(t *testing.T) {
+	server := newTestServer(t, &config.Config{})
+
+	put := func(sha, labels string) {
+		t.Helper()
+		body := `{"image_tag": "v1.2.3", "image_sha": "` + sha + `", "client_name": "acme", "env_name": "prod"` + labels + `}`
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("PUT", "/api/collect/default/Deployment/web/app", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Manual collect failed: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	put("abc123", `, "image_labels": {"org.opencontainers.image.revision": "0123abc"}, "annotations": {"git-commit": "0123abc"}`)
+	// A later report without labels, e.g. after a registry error, keeps the recorded ones
+	put("abc123", "")
+
+	req := httptest.NewRequest("GET", "/api/releases/current/acme/prod", nil)
+	req.Header.Set("X-Is-Admin", "true")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	var response struct {
+		Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	releases := response.Namespaces["default"]
+	if len(releases) != 1 || releases[0].ImageLabels["org.opencontainers.image.revision"] != "0123abc" {
+		t.Errorf("Expected the image labels in current releases, got %+v", releases)
+	}
+	if len(releases) == 1 && releases[0].Annotations["git-commit"] != "0123abc" {
+		t.Errorf("Expected the annotations in current releases, got %+v", releases[0].Annotations)
+	}
+
+	history, err := server.db.GetReleaseHistory("default", "web", "app", "acme", "prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Releases) != 1 || history.Releases[0].ImageLabels["org.opencontainers.image.revision"] != "0123abc" {
+		t.Errorf("Expected the image labels in the release history, got %+v", history.Releases)
+	}
+}
+
+func TestHandleTestWebhook(t *testing.T) {
+	adminKey := "admin-key-1234567890123456789012345"
+	clientKey := "acme::authkey12345678901234567890"
+	server := newTestServer(t, &config.Config{APIKeys: []string{adminKey, clientKey}})
+
+	post := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/admin/test-webhook", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+	type result struct {
+		Delivered  bool         `json:"delivered"`
+		StatusCode int          `json:"status_code"`
+		Error      string       `json:"error"`
+		Event      notify.Event `json:"event"`
+	}
+	decode := func(rr *httptest.ResponseRecorder) result {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var got result
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if rr := post(adminKey); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a notifier, got %d", rr.Code)
+	}
+
+	var mu sync.Mutex
+	var received []string
+	status := http.StatusOK
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload bytes.Buffer
+		payload.ReadFrom(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, payload.String())
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+	server.SetNotifier(notify.NewOTLPExporter(webhook.URL))
+
+	if rr := post(clientKey); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected client keys to be denied, got %d", rr.Code)
+	}
+
+	got := decode(post(adminKey))
+	if !got.Delivered || got.StatusCode != http.StatusOK || got.Error != "" || got.Event.Type != notify.EventTest {
+		t.Errorf("Expected the test event to be delivered, got %+v", got)
+	}
+	mu.Lock()
+	if len(received) != 1 || !strings.Contains(received[0], `"krelease.test"`) {
+		t.Errorf("Expected the webhook to receive the test event, got %v", received)
+	}
+	mu.Unlock()
+
+	// Rejected deliveries are reported with the status code of the webhook
+	mu.Lock()
+	status = http.StatusInternalServerError
+	mu.Unlock()
+	got = decode(post(adminKey))
+	if got.Delivered || got.StatusCode != http.StatusInternalServerError || !strings.Contains(got.Error, "500") {
+		t.Errorf("Expected the failed delivery to be reported, got %+v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// idempotencyKeyHeader carries a client-chosen key identifying a request, so retries of it are answered
+// with the recorded response instead of being processed again
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys recorded in the database
+const maxIdempotencyKeyLength = 255
+
+// idempotencyScope limits a key to the endpoint and client it was first used with, so keys chosen by
+// different clients never collide
+func idempotencyScope(r *http.Request) string {
+	clientName, _ := getClientAccessFromRequest(r)
+	return r.Method + " " + r.URL.Path + " " + clientName
+}
+
+// replayIdempotent claims the Idempotency-Key of a request, or answers the request with the response
+// recorded for the key, and reports whether the request was answered. A request whose key is still claimed
+// by one being processed (e.g. a retry sent after a client timeout) is answered 409. The returned key, empty
+// when the request has none or IDEMPOTENCY_TTL is 0, is passed to writeIdempotentJSON to record the response
+// and to releaseIdempotent, deferred, so a request failing before that can be retried.
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request) (key string, answered bool) {
+	key = strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	if key == "" || s.config.IdempotencyTTL <= 0 {
+		return "", false
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParams, fmt.Sprintf("%s exceeds %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+		return "", true
+	}
+
+	// A claim failure only costs the protection against duplicates, the request is processed
+	recorded, claimed, err := s.db.ClaimIdempotencyKey(key, idempotencyScope(r), s.idempotencyTTL())
+	if err != nil {
+		requestLogger(r).Error("Failed to claim idempotency key", "error", err)
+		return "", false
+	}
+	if claimed {
+		return key, false
+	}
+	if recorded == nil {
+		writeError(w, r, http.StatusConflict, codeConflict, "A request with this "+idempotencyKeyHeader+" is still being processed")
+		return "", true
+	}
+
+	requestLogger(r).Info("Replaying response of idempotent request", "recorded_at", recorded.CreatedAt)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(recorded.StatusCode)
+	w.Write(recorded.Body)
+	return "", true
+}
+
+// writeIdempotentJSON writes v as the JSON response and, when key is set, records it so retries of the
+// request carrying the same Idempotency-Key get it back
+func (s *Server) writeIdempotentJSON(w http.ResponseWriter, r *http.Request, key string, status int, v interface{}) {
+	if key != "" {
+		body, err := json.Marshal(v)
+		if err == nil {
+			err = s.db.SaveIdempotentResponse(key, idempotencyScope(r), status, append(body, '\n'))
+		}
+		if err != nil {
+			requestLogger(r).Error("Failed to record idempotency key", "error", err)
+		}
+	}
+	writeJSON(w, r, status, v)
+}
+
+// releaseIdempotent drops the claim on key unless a response was recorded for it
+func (s *Server) releaseIdempotent(r *http.Request, key string) {
+	if key == "" {
+		return
+	}
+	if err := s.db.ReleaseIdempotencyKey(key, idempotencyScope(r)); err != nil {
+		requestLogger(r).Error("Failed to release idempotency key", "error", err)
+	}
+}
+
+// idempotencyTTL returns how long the responses of idempotent requests are replayed
+func (s *Server) idempotencyTTL() time.Duration {
+	return time.Duration(s.config.IdempotencyTTL) * time.Minute
+}
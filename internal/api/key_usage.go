@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// APIKeyInfo describes a configured API key. Keys are identified by a hash and a short preview, never
+// by the raw key.
+type APIKeyInfo struct {
+	KeyID      string `json:"key_id"`      // first 12 hex characters of the SHA-256 of the key
+	KeyPreview string `json:"key_preview"` // first 8 characters of the key, as in authentication logs
+	Type       string `json:"type"`        // "admin", "client" or "invalid" for malformed keys, which are rejected
+	ClientName string `json:"client_name,omitempty"`
+	EnvName    string `json:"env_name,omitempty"` // environment of environment-scoped client keys
+}
+
+// newAPIKeyInfo describes an API key
+func newAPIKeyInfo(apiKey string, legacyFormat bool) APIKeyInfo {
+	clientName, envName, _, isAdmin, err := parseAPIKey(apiKey, legacyFormat)
+	info := APIKeyInfo{
+		KeyID:      keyID(apiKey),
+		KeyPreview: apiKey[:min(8, len(apiKey))] + "...",
+		Type:       "client",
+		ClientName: clientName,
+		EnvName:    envName,
+	}
+	if err != nil {
+		info.Type = "invalid"
+	} else if isAdmin {
+		info.Type = "admin"
+	}
+	return info
+}
+
+// KeyUsage summarizes the use of one configured API key since the server started
+type KeyUsage struct {
+	APIKeyInfo
+	Requests int64      `json:"requests"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// keyUsageTracker counts the authenticated requests of each API key in memory
+type keyUsageTracker struct {
+	since time.Time // when counting started
+
+	mu    sync.Mutex
+	usage map[string]*KeyUsage
+}
+
+// keyID returns the identifier of an API key used in usage reports
+func keyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// newKeyUsageTracker creates a tracker listing each configured key, so unused keys are reported too
+func newKeyUsageTracker(apiKeys []string, legacyFormat bool) *keyUsageTracker {
+	t := &keyUsageTracker{since: time.Now().UTC()}
+	t.setKeys(apiKeys, legacyFormat)
+	return t
+}
+
+// setKeys replaces the tracked keys after a reload, keeping the usage of the keys still configured
+func (t *keyUsageTracker) setKeys(apiKeys []string, legacyFormat bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := make(map[string]*KeyUsage, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		id := keyID(apiKey)
+		if existing, ok := t.usage[id]; ok {
+			usage[id] = existing
+			continue
+		}
+		usage[id] = &KeyUsage{APIKeyInfo: newAPIKeyInfo(apiKey, legacyFormat)}
+	}
+	t.usage = usage
+}
+
+// record counts an authenticated request made with a configured API key
+func (t *keyUsageTracker) record(apiKey string) {
+	now := time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if usage, ok := t.usage[keyID(apiKey)]; ok {
+		usage.Requests++
+		usage.LastUsed = &now
+	}
+}
+
+// snapshot returns the usage of every configured key, least recently used first so stale keys come first
+func (t *keyUsageTracker) snapshot() []KeyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usages := make([]KeyUsage, 0, len(t.usage))
+	for _, usage := range t.usage {
+		copied := *usage
+		if usage.LastUsed != nil {
+			lastUsed := *usage.LastUsed
+			copied.LastUsed = &lastUsed
+		}
+		usages = append(usages, copied)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		a, b := usages[i], usages[j]
+		if (a.LastUsed == nil) != (b.LastUsed == nil) {
+			return a.LastUsed == nil
+		}
+		if a.LastUsed != nil && !a.LastUsed.Equal(*b.LastUsed) {
+			return a.LastUsed.Before(*b.LastUsed)
+		}
+		return a.KeyID < b.KeyID
+	})
+	return usages
+}
+
+// handleKeyUsage returns the request count and last use of each configured API key (admin only)
+func (s *Server) handleKeyUsage(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	keys := s.keyUsage.snapshot()
+	unused := 0
+	for _, key := range keys {
+		if key.LastUsed == nil {
+			unused++
+		}
+	}
+
+	response := map[string]interface{}{
+		"keys":      keys,
+		"total":     len(keys),
+		"unused":    unused,
+		"since":     s.keyUsage.since,
+		"timestamp": time.Now().UTC(),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"krelease-tracker/internal/notify"
+)
+
+// handleTestWebhook sends a sample event through the configured notifier and reports whether it was
+// delivered, so notifications can be checked without waiting for a version change (admin only)
+func (s *Server) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if s.notifier == nil {
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Notifications are not configured (set OTLP_ENDPOINT)")
+		return
+	}
+
+	event := notify.TestEvent(time.Now())
+	var statusCode int
+	var err error
+	if reporter, ok := s.notifier.(notify.DeliveryReporter); ok {
+		statusCode, err = reporter.Deliver(r.Context(), event)
+	} else {
+		err = s.notifier.Notify(r.Context(), event)
+	}
+
+	response := map[string]interface{}{
+		"delivered": err == nil,
+		"event":     event,
+		"timestamp": time.Now().UTC(),
+	}
+	if statusCode != 0 {
+		response["status_code"] = statusCode
+	}
+	if err != nil {
+		requestLogger(r).Warn("Test notification not delivered", "status_code", statusCode, "error", err)
+		response["error"] = err.Error()
+	} else {
+		requestLogger(r).Info("Test notification delivered", "status_code", statusCode)
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
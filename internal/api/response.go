@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON encodes v as the JSON response body with the given status code.
+// Output is compact unless the request asks for ?pretty=true.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	if r != nil && r.URL.Query().Get("pretty") == "true" {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.Encode(v)
+}
+
+// Machine-readable codes of error responses. They are part of the API: clients match on them, so
+// existing codes must not be renamed.
+const (
+	codeUnauthorized      = "unauthorized"               // missing or invalid API key
+	codeAccessDenied      = "access_denied"              // API key not authorized for the client, environment or endpoint
+	codeMissingParams     = "missing_params"             // required path, query or body parameters missing
+	codeInvalidParams     = "invalid_params"             // parameters present but malformed or out of range
+	codeInvalidBody       = "invalid_body"               // request body not valid JSON or empty
+	codeInvalidRelease    = "invalid_release"            // collected release rejected (missing fields, timestamps)
+	codeUnsupportedSchema = "unsupported_schema_version" // payload schema version not accepted by this master
+	codeUnsupportedKind   = "unsupported_kind"           // workload kind not tracked
+	codeNotMonitored      = "not_monitored"              // namespace outside the monitored namespaces
+	codeBatchTooLarge     = "batch_too_large"            // batch exceeds the maximum number of releases
+	codeNotFound          = "not_found"                  // requested resource does not exist
+	codeConflict          = "conflict"                   // request conflicts with the server state
+	codeUnavailable       = "unavailable"                // feature not configured or dependency not available
+	codeDBError           = "db_error"                   // database query failed
+	codeInternalError     = "internal_error"             // any other server-side failure
+)
+
+// errorBody is the error object of an error response
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorResponse is the JSON envelope of every error response: {"error":{"code":"...","message":"..."}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+// writeError writes a JSON error response with a stable code and a human-readable message, pretty-printed
+// like writeJSON when r asks for it
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, r, status, errorResponse{Error: errorBody{Code: code, Message: message}})
+}
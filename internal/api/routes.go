@@ -1,16 +1,23 @@
 package api
 
 import (
+	"crypto/rand"
 	"crypto/subtle"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"krelease-tracker/internal/logging"
+	"krelease-tracker/internal/tracing"
+
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // setupRoutes configures the API routes
@@ -23,28 +30,95 @@ func (s *Server) setupRoutes() {
 		baseRouter = s.router
 	}
 
+	// Log every request with its ID, status and duration
+	s.router.Use(s.loggingMiddleware)
+
+	// Record a trace span per request when tracing is enabled
+	s.router.Use(s.tracingMiddleware)
+
+	// Normalize client and environment names in paths and query parameters before any handler reads them
+	baseRouter.Use(s.normalizeNamesMiddleware)
+
 	// API routes with authentication middleware
 	api := baseRouter.PathPrefix("/api").Subrouter()
 
 	// Apply authentication middleware to API routes if API keys are configured
-	if len(s.apiKeys) > 0 {
+	if len(s.currentAPIKeys()) > 0 {
 		api.Use(s.authMiddleware)
 	}
 
 	api.HandleFunc("/collect", s.handleCollect).Methods("POST")
+	api.HandleFunc("/collect/batch", s.handleBatchCollect).Methods("POST")
+	api.HandleFunc("/collect/{namespace}/{workload-kind}/{workload-name}", s.handleCollectWorkload).Methods("POST")
 	api.HandleFunc("/collect/{namespace}/{workload-kind}/{workload-name}/{container}", s.handleManualCollect).Methods("PUT")
 
 	api.HandleFunc("/releases/current", s.handleCurrentReleases).Methods("GET")
+	api.HandleFunc("/releases/current/{client}/{env}", s.handleCurrentReleases).Methods("GET")
+	api.HandleFunc("/releases/export.jsonl", s.handleReleasesExport).Methods("GET")
+	api.HandleFunc("/releases/export", s.handleCurrentReleasesExport).Methods("GET")
+	api.HandleFunc("/releases/diff", s.handleEnvironmentDiff).Methods("GET")
 	api.HandleFunc("/releases/history/{client}/{env}/{namespace}/{workload}/{container}", s.handleReleaseHistory).Methods("GET")
+	api.HandleFunc("/releases/history/{client}/{env}/{namespace}/{workload}/{container}/by-tag/{tag}", s.handleReleaseByTag).Methods("GET")
+	api.HandleFunc("/releases/{client}/{env}/{namespace}/{workload}/{container}/provenance", s.handleReleaseProvenance).Methods("GET")
+	api.HandleFunc("/releases/{client}/{env}/{namespace}/{workload}/{container}", s.handleDeleteComponent).Methods("DELETE")
+	api.HandleFunc("/scaling/{client}/{env}/{namespace}/{workload}", s.handleScalingHistory).Methods("GET")
+	api.HandleFunc("/gaps/{client}/{env}", s.handleCollectionGaps).Methods("GET")
+	api.HandleFunc("/ghosts/{client}/{env}", s.handleGhostWorkloads).Methods("GET")
+	api.HandleFunc("/consistency/{client}", s.handleReleaseConsistency).Methods("GET")
+	api.HandleFunc("/metrics/detection-lag/{client}/{env}", s.handleDetectionLag).Methods("GET")
 	api.HandleFunc("/clients-environments", s.handleClientsEnvironments).Methods("GET")
+	api.HandleFunc("/overview", s.handleFleetOverview).Methods("GET")
+	api.HandleFunc("/failed-releases", s.handlePurgeFailedReleases).Methods("DELETE")
+	api.HandleFunc("/sync/failed", s.handleSyncFailed).Methods("GET")
+	api.HandleFunc("/sync/status", s.handleSyncStatus).Methods("GET")
+	api.HandleFunc("/admin/diagnostics/selectors", s.handleSelectorDiagnostics).Methods("GET")
+	api.HandleFunc("/admin/keys", s.handleListAPIKeys).Methods("GET")
+	api.HandleFunc("/admin/keys/reload", s.handleReloadAPIKeys).Methods("POST")
+	api.HandleFunc("/admin/keys/usage", s.handleKeyUsage).Methods("GET")
+	api.HandleFunc("/admin/retention", s.handleListRetention).Methods("GET")
+	api.HandleFunc("/admin/retention/{client}", s.handleSetRetention).Methods("PUT")
+	api.HandleFunc("/admin/retention/{client}", s.handleDeleteRetention).Methods("DELETE")
+	api.HandleFunc("/admin/test-webhook", s.handleTestWebhook).Methods("POST")
+	api.HandleFunc("/badges/sign/{client}/{env}/{workload-kind}/{workload-name}/{container}", s.handleSignBadge).Methods("GET")
 	api.HandleFunc("/ping", s.handlePing).Methods("POST")
 	api.HandleFunc("/config", s.handleConfig).Methods("GET")
 
-	// Health check (no authentication required)
-	baseRouter.HandleFunc("/health", s.handleHealth).Methods("GET")
+	// Liveness and readiness probes (no authentication required); /health is kept as an alias of /readyz
+	probes := map[string]http.HandlerFunc{
+		"/healthz": s.handleLiveness,
+		"/readyz":  s.handleReadiness,
+		"/health":  s.handleReadiness,
+	}
+	for path, handler := range probes {
+		baseRouter.HandleFunc(path, handler).Methods("GET")
+		if s.config.BasePath != "" {
+			// Also serve the unprefixed path for probes that are not aware of the base path
+			s.router.HandleFunc(path, handler).Methods("GET")
+		}
+	}
+
+	// Prometheus metrics (no authentication required)
+	baseRouter.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+	if s.config.BasePath != "" {
+		// Also serve the unprefixed path for scrapers that are not aware of the base path
+		s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+	}
+
+	// Badge endpoints, rate limited per client IP when BADGE_RATE_LIMIT is set
+	badges := baseRouter.PathPrefix("/badges").Subrouter()
+	if s.badgeLimiter != nil {
+		badges.Use(s.badgeRateLimitMiddleware)
+	}
+
+	// Signed badge endpoints, authenticated by an expiring HMAC signature instead of an API key
+	badges.HandleFunc("/signed/{sig}/{expiry}/{client}/{env}/{workload-kind}/{workload-name}/{container}", s.handleSignedBadge).Methods("GET")
+	badges.HandleFunc("/signed/{sig}/{expiry}/{client}/{env}/{workload-kind}/{workload-name}/{container}/{variant:digest}", s.handleSignedBadge).Methods("GET")
 
 	// Badge endpoint with URL-based API key authentication
-	baseRouter.HandleFunc("/badges/{api-key}/{client}/{env}/{workload-kind}/{workload-name}/{container}", s.handleBadgeWithAuth).Methods("GET")
+	badges.HandleFunc("/{api-key}/{client}/{env}/{workload-kind}/{workload-name}/{container}", s.handleBadgeWithAuth).Methods("GET")
+	badges.HandleFunc("/{api-key}/{client}/{env}/{workload-kind}/{workload-name}/{container}/digest", s.handleDigestBadgeWithAuth).Methods("GET")
+	badges.HandleFunc("/{api-key}/{client}/{env}/{workload-kind}/{workload-name}/{container}/json", s.handleShieldsBadgeWithAuth).Methods("GET")
+	badges.HandleFunc("/{api-key}/{client}/{env}/{workload-kind}/{workload-name}/{container}/{variant:chart|app}", s.handleVersionLabelBadgeWithAuth).Methods("GET")
 
 	// Static files (no authentication required)
 	if s.config.BasePath != "" {
@@ -73,12 +147,141 @@ func (s *Server) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-// LoggingMiddleware logs HTTP requests
+// requestIDHeader carries the ID tagging the log lines of a request, echoed in the response
+const requestIDHeader = "X-Request-ID"
+
+// loggingMiddleware assigns each request an ID, taken from X-Request-ID when the caller sent a usable
+// one, threads it into the log lines of the handlers through the request context and logs the request
+// with its status and duration once served. Probe and scrape requests are logged at the debug level.
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(logging.WithLogger(r.Context(), logger)))
+
+		level := slog.LevelInfo
+		if path := strings.TrimPrefix(r.URL.Path, s.config.BasePath); isProbePath(path) || path == "/metrics" {
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "HTTP request", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.status, "duration", time.Since(start).String())
+	})
+}
+
+// tracingMiddleware records a server span for each request, named after its route template and
+// continuing the trace of a caller sending trace context headers. Probes and /metrics are not traced.
+func (s *Server) tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, s.config.BasePath)
+		if !tracing.Enabled() || isProbePath(path) || path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx, span := tracing.StartServer(r.Context(), r.Method+" "+route, r.Header, trace.WithAttributes(
+			attribute.String("http.request.method", r.Method), attribute.String("http.route", route), attribute.String("url.path", r.URL.Path)))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		var err error
+		if recorder.status >= http.StatusInternalServerError {
+			err = fmt.Errorf("HTTP %d %s", recorder.status, http.StatusText(recorder.status))
+		}
+		tracing.End(span, err)
+	})
+}
+
+// isProbePath reports whether path is one of the liveness and readiness probe endpoints
+func isProbePath(path string) bool {
+	return path == "/healthz" || path == "/readyz" || path == "/health"
+}
+
+// validRequestID reports whether a caller supplied request ID is short and printable enough to be logged
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestLogger returns the logger of a request, tagged with its request ID
+func requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
+
+// normalizeNamesMiddleware trims (and optionally lowercases) the client and environment names of the
+// {client}/{env} path variables and client_name/env_name query parameters
+func (s *Server) normalizeNamesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		for _, name := range []string{"client", "env"} {
+			if value, ok := vars[name]; ok {
+				vars[name] = s.normalizeName(value)
+			}
+		}
+
+		query := r.URL.Query()
+		changed := false
+		for _, name := range []string{"client_name", "env_name", "client", "env_a", "env_b"} {
+			if value := query.Get(name); value != "" && value != s.normalizeName(value) {
+				query.Set(name, s.normalizeName(value))
+				changed = true
+			}
+		}
+		if changed {
+			r.URL.RawQuery = query.Encode()
+		}
+
 		next.ServeHTTP(w, r)
-		log.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(start))
 	})
 }
 
@@ -88,25 +291,31 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 		apiKey := s.extractAPIKey(r)
 
 		if apiKey == "" {
-			s.sendUnauthorizedResponse(w, "Missing API key")
+			s.sendUnauthorizedResponse(w, r, "Missing API key")
 			return
 		}
 
 		// Parse API key to determine type and extract components
-		clientName, clientAuth, isAdmin := parseAPIKey(apiKey)
+		clientName, envName, _, isAdmin, err := parseAPIKey(apiKey, s.config.APIKeyLegacyFormat)
 
 		// Validate API key access
-		if !s.validateAPIKeyAccess(clientName, clientAuth, isAdmin) {
+		if err != nil || !s.isValidAPIKey(apiKey) {
 			// Log failed authentication attempt with sanitized key
 			keyPreview := apiKey[:min(8, len(apiKey))] + "..."
-			log.Printf("Authentication failed for %s %s (key: %s)", r.Method, r.URL.Path, keyPreview)
-			s.sendUnauthorizedResponse(w, "Invalid API key")
+			requestLogger(r).Warn("Authentication failed", "method", r.Method, "path", r.URL.Path, "key", keyPreview)
+			s.sendUnauthorizedResponse(w, r, "Invalid API key")
 			return
 		}
+		s.keyUsage.record(apiKey)
 
 		// Set client context in request headers for downstream handlers
 		if !isAdmin && clientName != "" {
-			r.Header.Set("X-Client-Name", clientName)
+			r.Header.Set("X-Client-Name", s.normalizeName(clientName))
+		}
+		// Environment-scoped client keys only access one environment of their client
+		r.Header.Del("X-Client-Env")
+		if !isAdmin && envName != "" {
+			r.Header.Set("X-Client-Env", s.normalizeName(envName))
 		}
 		r.Header.Set("X-Is-Admin", fmt.Sprintf("%t", isAdmin))
 
@@ -115,12 +324,8 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 }
 
 // sendUnauthorizedResponse sends a standardized unauthorized response
-func (s *Server) sendUnauthorizedResponse(w http.ResponseWriter, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusUnauthorized)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": message,
-	})
+func (s *Server) sendUnauthorizedResponse(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, http.StatusUnauthorized, codeUnauthorized, message)
 }
 
 // getClientAccessFromRequest extracts client access information from request headers
@@ -135,6 +340,81 @@ func getClientAccessFromRequest(r *http.Request) (clientName string, isAdmin boo
 	return clientName, isAdmin
 }
 
+// authorizeClient checks that the request's API key may access the given client.
+// It writes a 403 response and returns false when access is denied.
+func authorizeClient(w http.ResponseWriter, r *http.Request, requestedClientName string) bool {
+	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
+	if !isAdmin && authenticatedClientName != requestedClientName {
+		requestLogger(r).Warn("Access denied: API key not authorized for client", "method", r.Method, "path", r.URL.Path, "client", requestedClientName)
+		writeError(w, r, http.StatusForbidden, codeAccessDenied, fmt.Sprintf("Access denied: API key is not authorized for client '%s'", requestedClientName))
+		return false
+	}
+	return true
+}
+
+// authorizeClaimedClient checks that a client API key only acts as its own client and, for
+// environment-scoped keys, environment, for requests naming them in their body. Admin keys and requests
+// without authentication (no API keys configured) may act as any client.
+// It writes a 403 response and returns false when access is denied.
+func authorizeClaimedClient(w http.ResponseWriter, r *http.Request, claimedClientName, claimedEnvName string) bool {
+	if err := checkClaimedClient(r, claimedClientName, claimedEnvName); err != nil {
+		requestLogger(r).Warn("Access denied: API key not authorized for claimed client", "method", r.Method, "path", r.URL.Path,
+			"client", claimedClientName, "env", claimedEnvName)
+		writeError(w, r, http.StatusForbidden, codeAccessDenied, err.Error())
+		return false
+	}
+	return true
+}
+
+// checkClaimedClient returns the error of authorizeClaimedClient without writing a response, for
+// requests reporting the outcome of each item
+func checkClaimedClient(r *http.Request, claimedClientName, claimedEnvName string) error {
+	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
+	if isAdmin || authenticatedClientName == "" {
+		return nil
+	}
+	if authenticatedClientName != claimedClientName {
+		return fmt.Errorf("Access denied: API key is not authorized for client '%s'", claimedClientName)
+	}
+	if allowedEnv := getEnvScopeFromRequest(r); allowedEnv != "" && allowedEnv != claimedEnvName {
+		return fmt.Errorf("Access denied: API key is not authorized for environment '%s'", claimedEnvName)
+	}
+	return nil
+}
+
+// authorizeEnv checks that the request's API key may access the given environment. Only
+// environment-scoped client keys are restricted; other keys access every environment.
+// It writes a 403 response and returns false when access is denied.
+func authorizeEnv(w http.ResponseWriter, r *http.Request, requestedEnvName string) bool {
+	if allowedEnv := getEnvScopeFromRequest(r); allowedEnv != "" && allowedEnv != requestedEnvName {
+		requestLogger(r).Warn("Access denied: API key not authorized for environment", "method", r.Method, "path", r.URL.Path, "env", requestedEnvName)
+		writeError(w, r, http.StatusForbidden, codeAccessDenied, fmt.Sprintf("Access denied: API key is not authorized for environment '%s'", requestedEnvName))
+		return false
+	}
+	return true
+}
+
+// getEnvScopeFromRequest returns the environment an environment-scoped client key is restricted to,
+// or "" when the key accesses every environment
+func getEnvScopeFromRequest(r *http.Request) string {
+	if _, isAdmin := getClientAccessFromRequest(r); isAdmin {
+		return ""
+	}
+	return r.Header.Get("X-Client-Env")
+}
+
+// requireAdmin rejects requests authenticated with a client API key.
+// It writes a 403 response and returns false when access is denied.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	authenticatedClientName, isAdmin := getClientAccessFromRequest(r)
+	if !isAdmin && authenticatedClientName != "" {
+		requestLogger(r).Warn("Access denied: admin API key required", "method", r.Method, "path", r.URL.Path)
+		writeError(w, r, http.StatusForbidden, codeAccessDenied, "Access denied: admin API key required")
+		return false
+	}
+	return true
+}
+
 // extractAPIKey extracts API key from request headers or query parameters
 func (s *Server) extractAPIKey(r *http.Request) string {
 	// Check Authorization header (Bearer token)
@@ -161,39 +441,56 @@ func (s *Server) extractAPIKey(r *http.Request) string {
 	return ""
 }
 
+// clientKeySeparator separates the client name, the optional environment and the secret in client API keys
+// ("clientName::clientAuth" or "clientName::envName::clientAuth")
+const clientKeySeparator = "::"
+
+// errMalformedAPIKey is returned by parseAPIKey for keys that look like client keys but cannot be parsed
+// as one; they are rejected rather than treated as admin keys
+var errMalformedAPIKey = errors.New("malformed API key")
+
 // parseAPIKey parses an API key to determine its type and extract components
-// Returns: clientName, clientAuth, isAdmin
-// For admin keys (format: "clientAuth"): "", clientAuth, true
-// For client keys (format: "clientName-clientAuth"): clientName, clientAuth, false
+// Returns: clientName, envName, clientAuth, isAdmin
+// For admin keys (format: "clientAuth"): "", "", clientAuth, true
+// For client keys (format: "clientName::clientAuth"): clientName, "", clientAuth, false
+// For environment-scoped client keys (format: "clientName::envName::clientAuth"): clientName, envName, clientAuth, false
 //
-// Logic: If the key contains exactly one hyphen and both parts are non-empty,
-// treat it as a client key. Otherwise, treat it as an admin key.
-func parseAPIKey(apiKey string) (clientName string, clientAuth string, isAdmin bool) {
-	parts := strings.Split(apiKey, "-")
+// Logic: If the key contains "::", it is a client key and both sides of the first one must be
+// non-empty. Client names may therefore contain hyphens. A second "::" restricts the key to one
+// environment, and both sides of it must be non-empty too.
+// Keys containing exactly one hyphen with both parts non-empty ("clientName-clientAuth") are
+// legacy client keys, accepted with legacyFormat and rejected otherwise so that a client's key
+// never becomes an admin key. Only the remaining keys are admin keys.
+func parseAPIKey(apiKey string, legacyFormat bool) (clientName string, envName string, clientAuth string, isAdmin bool, err error) {
+	if name, auth, found := strings.Cut(apiKey, clientKeySeparator); found {
+		if name == "" || auth == "" {
+			return "", "", "", false, fmt.Errorf("%w: client name and secret around %q must not be empty", errMalformedAPIKey, clientKeySeparator)
+		}
+		if env, envAuth, scoped := strings.Cut(auth, clientKeySeparator); scoped {
+			// A typo such as "acme::dev::" must not widen the key to every environment
+			if env == "" || envAuth == "" {
+				return "", "", "", false, fmt.Errorf("%w: environment and secret around the second %q must not be empty", errMalformedAPIKey, clientKeySeparator)
+			}
+			return name, env, envAuth, false, nil // Environment-scoped client key
+		}
+		return name, "", auth, false, nil // Client key
+	}
 
-	// If exactly 2 parts and both are non-empty, treat as client key
-	if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
-		return parts[0], parts[1], false // Standard client key
+	// If exactly 2 parts and both are non-empty, it is a legacy client key
+	if parts := strings.Split(apiKey, "-"); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		if !legacyFormat {
+			return "", "", "", false, fmt.Errorf("%w: legacy client keys (clientName-clientAuth) require API_KEY_LEGACY_FORMAT=true, use clientName::clientAuth", errMalformedAPIKey)
+		}
+		return parts[0], "", parts[1], false, nil // Legacy client key
 	}
 
 	// Otherwise, treat as admin key (including keys with multiple hyphens)
-	return "", apiKey, true // Admin key
-}
-
-// validateAPIKeyAccess validates API key access based on type
-func (s *Server) validateAPIKeyAccess(clientName, clientAuth string, isAdmin bool) bool {
-	if isAdmin {
-		// For admin keys, check if clientAuth exists in API_KEYS
-		return s.isValidAPIKey(clientAuth)
-	}
-	// For client keys, check if clientName-clientAuth combination exists
-	fullKey := clientName + "-" + clientAuth
-	return s.isValidAPIKey(fullKey)
+	return "", "", apiKey, true, nil // Admin key
 }
 
 // isValidAPIKey checks if the provided API key is valid using constant-time comparison
 func (s *Server) isValidAPIKey(providedKey string) bool {
-	for _, validKey := range s.apiKeys {
+	for _, validKey := range s.currentAPIKeys() {
 		if len(providedKey) == len(validKey) &&
 			subtle.ConstantTimeCompare([]byte(providedKey), []byte(validKey)) == 1 {
 			return true
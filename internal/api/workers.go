@@ -0,0 +1,112 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// Background workers reported by /health
+const (
+	WorkerCollection = "collection" // periodic collection of the monitored namespaces (slave mode)
+	WorkerSync       = "sync"       // periodic sync of pending releases to master (slave mode)
+)
+
+// WorkerStatus is the state of a background worker as reported by /health
+type WorkerStatus struct {
+	Running         bool       `json:"running"`
+	IntervalSeconds int        `json:"interval_seconds"`
+	LastSuccess     *time.Time `json:"last_success"` // nil until the worker first succeeded
+	Stale           bool       `json:"stale"`        // no success within two intervals
+}
+
+// workerState tracks one background worker
+type workerState struct {
+	interval    time.Duration
+	started     time.Time
+	running     bool
+	lastSuccess time.Time
+}
+
+// workerTracker records the runs of the background workers registered with the server; the zero value is ready to use
+type workerTracker struct {
+	mu      sync.Mutex
+	workers map[string]*workerState
+}
+
+// started registers a worker expected to succeed at least once per interval
+func (t *workerTracker) started(name string, interval time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	worker := t.worker(name)
+	worker.interval = interval
+	worker.started = now
+	worker.running = true
+}
+
+// stopped marks a worker as no longer running
+func (t *workerTracker) stopped(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.worker(name).running = false
+}
+
+// succeeded records a successful run of a worker
+func (t *workerTracker) succeeded(name string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.worker(name).lastSuccess = now
+}
+
+func (t *workerTracker) worker(name string) *workerState {
+	if t.workers == nil {
+		t.workers = make(map[string]*workerState)
+	}
+	worker, ok := t.workers[name]
+	if !ok {
+		worker = &workerState{}
+		t.workers[name] = worker
+	}
+	return worker
+}
+
+// status returns the state of the registered workers. A worker without success yet is stale once two
+// intervals have passed since it started; workers never started are not reported.
+func (t *workerTracker) status(now time.Time) map[string]WorkerStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make(map[string]WorkerStatus)
+	for name, worker := range t.workers {
+		if worker.started.IsZero() {
+			continue
+		}
+		status := WorkerStatus{Running: worker.running, IntervalSeconds: int(worker.interval.Seconds())}
+		since := worker.started
+		if !worker.lastSuccess.IsZero() {
+			lastSuccess := worker.lastSuccess.UTC()
+			status.LastSuccess = &lastSuccess
+			since = worker.lastSuccess
+		}
+		status.Stale = worker.interval > 0 && now.Sub(since) > 2*worker.interval
+		statuses[name] = status
+	}
+	return statuses
+}
+
+// WorkerStarted registers a background worker reported by /health, expected to succeed at least once per interval
+func (s *Server) WorkerStarted(name string, interval time.Duration) {
+	s.workers.started(name, interval, time.Now())
+}
+
+// WorkerStopped marks a background worker as no longer running
+func (s *Server) WorkerStopped(name string) {
+	s.workers.stopped(name)
+}
+
+// WorkerSucceeded records a successful run of a background worker
+func (s *Server) WorkerSucceeded(name string) {
+	s.workers.succeeded(name, time.Now())
+}
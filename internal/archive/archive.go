@@ -0,0 +1,123 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"krelease-tracker/internal/database"
+)
+
+// Uploader stores a database snapshot in an archive target (e.g. S3-compatible object storage)
+type Uploader interface {
+	Upload(ctx context.Context, key string, body io.ReadSeeker, size int64) error
+}
+
+// Worker periodically snapshots the database and hands the snapshot to an uploader
+type Worker struct {
+	db         *database.DB
+	uploader   Uploader
+	attempts   int
+	retryDelay time.Duration
+}
+
+// New creates an archival worker. Failed uploads are retried 3 times, one minute apart, by default.
+func New(db *database.DB, uploader Uploader) *Worker {
+	return &Worker{
+		db:         db,
+		uploader:   uploader,
+		attempts:   3,
+		retryDelay: time.Minute,
+	}
+}
+
+// SetRetry sets how many times an archive is attempted and the delay between attempts
+func (w *Worker) SetRetry(attempts int, delay time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	w.attempts = attempts
+	w.retryDelay = delay
+}
+
+// snapshotKey names a snapshot after the time it was taken, e.g. "releases-20240501T120000Z.db"
+func snapshotKey(takenAt time.Time) string {
+	return "releases-" + takenAt.UTC().Format("20060102T150405Z") + ".db"
+}
+
+// Archive snapshots the database and uploads the snapshot, retrying failed attempts
+func (w *Worker) Archive(ctx context.Context) error {
+	var err error
+	for attempt := 1; attempt <= w.attempts; attempt++ {
+		if err = w.archiveOnce(ctx); err == nil {
+			return nil
+		}
+		slog.Warn("Archive attempt failed", "attempt", attempt, "attempts", w.attempts, "error", err)
+
+		if attempt < w.attempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.retryDelay):
+			}
+		}
+	}
+	return err
+}
+
+// archiveOnce takes a fresh snapshot in a temporary directory and uploads it
+func (w *Worker) archiveOnce(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "krelease-archive-")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	takenAt := time.Now()
+	path := filepath.Join(dir, snapshotKey(takenAt))
+	if err := w.db.Snapshot(path); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+
+	if err := w.uploader.Upload(ctx, snapshotKey(takenAt), file, info.Size()); err != nil {
+		return fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+
+	slog.Info("Archived database snapshot", "key", snapshotKey(takenAt), "bytes", info.Size())
+	return nil
+}
+
+// StartArchiveWorker archives the database every interval until the context is cancelled
+func (w *Worker) StartArchiveWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	slog.Info("Starting archive worker", "interval", interval.String())
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Archive worker stopped")
+			return
+		case <-ticker.C:
+			if err := w.Archive(ctx); err != nil {
+				slog.Error("Archive failed", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"krelease-tracker/internal/database"
+)
+
+// fakeUploader records uploaded snapshots, failing the first `failures` calls
+type fakeUploader struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	keys     []string
+	bodies   [][]byte
+}
+
+func (f *fakeUploader) Upload(ctx context.Context, key string, body io.ReadSeeker, size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("bucket unavailable")
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return errors.New("size does not match body")
+	}
+	f.keys = append(f.keys, key)
+	f.bodies = append(f.bodies, data)
+	return nil
+}
+
+func (f *fakeUploader) uploads() ([]string, [][]byte, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.keys...), append([][]byte(nil), f.bodies...), f.calls
+}
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestArchiveWorkerUploadsSnapshotsOnSchedule(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	if err := db.UpsertRelease(&database.Release{
+		Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+		ImageTag: "v1.0.0", ImageSHA: "abc123", ClientName: "acme", EnvName: "prod", FirstSeen: now, LastSeen: now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first upload fails and must be retried
+	uploader := &fakeUploader{failures: 1}
+	worker := New(db, uploader)
+	worker.SetRetry(2, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.StartArchiveWorker(ctx, 20*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if keys, _, _ := uploader.uploads(); len(keys) >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	keys, bodies, calls := uploader.uploads()
+	if len(keys) < 2 {
+		t.Fatalf("Expected at least 2 snapshots to be uploaded on schedule, got %d (%d calls)", len(keys), calls)
+	}
+	if calls < len(keys)+1 {
+		t.Errorf("Expected the failed upload to be retried, got %d calls for %d uploads", calls, len(keys))
+	}
+	for i, key := range keys {
+		if !strings.HasPrefix(key, "releases-") || !strings.HasSuffix(key, ".db") {
+			t.Errorf("Unexpected snapshot key %q", key)
+		}
+		if !bytes.HasPrefix(bodies[i], []byte("SQLite format 3\x00")) {
+			t.Errorf("Expected snapshot %q to be a SQLite database", key)
+		}
+	}
+
+	// The snapshot is a complete copy of the database
+	path := filepath.Join(t.TempDir(), "restored.db")
+	if err := os.WriteFile(path, bodies[0], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := database.New(path)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer restored.Close()
+	releases, err := restored.GetCurrentReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 || releases[0].ImageTag != "v1.0.0" {
+		t.Errorf("Expected the snapshot to contain the seeded release, got %+v", releases)
+	}
+}
+
+func TestArchiveGivesUpAfterRetries(t *testing.T) {
+	uploader := &fakeUploader{failures: 10}
+	worker := New(newTestDB(t), uploader)
+	worker.SetRetry(3, time.Millisecond)
+
+	if err := worker.Archive(context.Background()); err == nil {
+		t.Fatal("Expected archive to fail")
+	}
+	if _, _, calls := uploader.uploads(); calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestS3UploaderSignsPutRequests(t *testing.T) {
+	var gotMethod, gotPath, gotAuth, gotPayloadHash, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotPayloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer server.Close()
+
+	uploader, err := NewS3Uploader(server.URL, "backups", "eu-west-1", "AKIDEXAMPLE", "secret", "krelease/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploader.Upload(context.Background(), "releases-20240501T120000Z.db", strings.NewReader("snapshot"), 8); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if gotMethod != "PUT" || gotPath != "/backups/krelease/releases-20240501T120000Z.db" {
+		t.Errorf("Unexpected request %s %s", gotMethod, gotPath)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(gotAuth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Unexpected Authorization header %q", gotAuth)
+	}
+	// The signature covers the snapshot itself, not UNSIGNED-PAYLOAD
+	if sum := sha256.Sum256([]byte("snapshot")); gotPayloadHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the payload hash of the snapshot, got %q", gotPayloadHash)
+	}
+	if gotBody != "snapshot" {
+		t.Errorf("Unexpected body %q", gotBody)
+	}
+}
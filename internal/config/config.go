@@ -1,69 +1,299 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/logging"
+	"krelease-tracker/internal/version"
 )
 
 // Config holds the application configuration
 type Config struct {
 	Port               string
+	ConfigFile         string // YAML or JSON file supplying settings not set in the environment (disabled if empty)
 	DatabasePath       string
-	Namespaces         []string
+	DBMaxOpenConns     int      // Maximum open database connections (unlimited if 0)
+	DBMaxIdleConns     int      // Maximum idle database connections kept for reuse
+	DBConnMaxLifetime  int      // Minutes a database connection is reused before being closed (forever if 0)
+	Namespaces         []string // Namespaces to monitor; "*" monitors every namespace of the cluster
+	NamespacesExclude  []string // Namespaces skipped when Namespaces holds "*" or matched by NamespaceSelector
+	NamespaceSelector  string   // Label selector of namespaces monitored in addition to Namespaces (disabled if empty)
 	InCluster          bool
 	KubeconfigPath     string
-	CollectionInterval int      // in minutes
-	APIKeys            []string // API keys for authentication
-	EnvName            string   // Environment name for badges
-	ClientName         string   // Client name for releases
-	BasePath           string   // Base path for serving (e.g., "/tracker")
-	Mode               string   // Application mode: "master" or "slave"
-	MasterURL          string   // Master URL for sync (slave mode only)
-	MasterAPIKey       string   // Master API key for sync (slave mode only)
-	SyncInterval       int      // Sync interval in minutes (slave mode only)
-	ProxyURL           string   // HTTP/HTTPS proxy URL for sync requests (slave mode only)
-	TLSInsecure        bool     // Skip TLS certificate verification for sync requests (slave mode only)
+	CollectionInterval int               // in minutes
+	CollectionMode     string            // "poll" (collect at each interval) or "watch" (also react to workload changes)
+	StaleThreshold     int               // Hours after which components no longer seen are pruned (disabled if 0)
+	CleanupMinAge      int               // Minutes a release is protected from the history cleanup (disabled if 0)
+	HistoryRetention   int               // Releases kept per component by the history cleanup and returned by default history requests
+	NamespaceIntervals map[string]int    // Per-namespace collection interval overrides in minutes
+	APIKeys            []string          // API keys for authentication
+	APIKeysFile        string            // File listing API keys in addition to API_KEYS, re-read by /api/admin/keys/reload
+	APIKeyLegacyFormat bool              // Also accept "clientName-clientAuth" client keys
+	EnvName            string            // Environment name for badges
+	UnknownVersionText string            // Badge text shown when a release has no usable version
+	ClientName         string            // Client name for releases
+	ClusterName        string            // Kubernetes cluster name recorded with releases and pings
+	LowercaseNames     bool              // Lowercase client and environment names in addition to trimming them
+	BasePath           string            // Base path for serving (e.g., "/tracker")
+	Mode               string            // Application mode: "master" or "slave"
+	MasterURL          string            // Master URL for sync (slave mode only)
+	MasterAPIKey       string            // Master API key for sync (slave mode only)
+	UpstreamMasters    []string          // Upstream master URLs merged into read endpoints (master mode only)
+	UpstreamAPIKey     string            // API key sent to upstream masters (master mode only)
+	UpstreamCacheTTL   int               // Seconds upstream master responses are cached (master mode only)
+	SyncInterval       int               // Sync interval in minutes (slave mode only)
+	SyncMaxRetries     int               // Retries of sync requests failing with a network error or 5xx (slave mode only)
+	SyncMaxAttempts    int               // Rejected sync attempts before a release is moved to failed_releases, 0 = never (slave mode only)
+	SyncDedupWindow    int               // Minutes a release synced to master is not re-sent while only its timestamps change, 0 = always re-send (slave mode only)
+	ProxyURL           string            // HTTP/HTTPS proxy URL for sync requests (slave mode only)
+	TLSInsecure        bool              // Skip TLS certificate verification for sync requests (slave mode only)
+	SyncSchemaVersion  int               // Payload schema version sent to master (slave mode only)
+	SyncExtraHeaders   map[string]string // Extra HTTP headers added to sync and ping requests (slave mode only)
+	SingleTenant       bool              // Default client/env query parameters to ClientName/EnvName
+	SHAAcceptPhases    []string          // Extra pod phases whose containers may provide the image SHA
+	PodLabelSelectors  []string          // Label selector templates tried first when looking up the pods of a workload
+	TrackRestarts      bool              // Resolve image SHAs of restarted containers that are not ready
+	TrackScaling       bool              // Record a scaling event whenever the desired replicas of a workload change
+	MaxConcurrentCalls int               // Maximum concurrent Kubernetes API calls made by the collector
+	CollectionWorkers  int               // Namespaces collected at the same time
+	MaxHistoryLimit    int               // Maximum number of releases returned by one history request
+	MaxClockSkew       int               // Minutes a released_at may be ahead of the server clock (disabled if 0)
+	StrictJSON         bool              // Reject manual collect and ping bodies with unknown fields
+	IdempotencyTTL     int               // Minutes the response to an Idempotency-Key is replayed to retries (disabled if 0)
+	HealthCheckDepth   string            // Database check run by /health: "ping" (SELECT 1) or "full" (current releases query)
+	PingWarningMinutes int               // Minutes after its last ping a slave is reported as warning
+	PingOfflineMinutes int               // Minutes after its last ping a slave is reported as offline
+	OTLPEndpoint       string            // OTLP/HTTP endpoint receiving version change events (disabled if empty)
+	TracingEndpoint    string            // OTLP/HTTP endpoint receiving trace spans (tracing disabled if empty)
+	ChartVersionLabel  string            // Workload label holding the Helm chart version
+	AppVersionLabel    string            // Workload label holding the application version
+	OrderLabel         string            // Workload label holding the display order of a workload within its namespace
+	ImageLabels        []string          // OCI labels read from image configs in the registry (enrichment disabled if empty)
+	TrackedAnnotations []string          // Pod template annotations recorded with each release (disabled if empty)
+	FailedRetention    int               // Days failed sync attempts are kept in the database (slave mode only)
+	BadgeSigningSecret string            // HMAC secret for signed badge URLs (signed badges disabled if empty)
+	BadgeCacheTTL      int               // Milliseconds badge lookups are cached (0 only coalesces concurrent lookups)
+	BadgeRateLimit     int               // Badge requests per minute allowed per client IP (disabled if 0)
+	ArchiveInterval    int               // Minutes between database snapshots archived to S3
+	ArchiveS3Endpoint  string            // S3-compatible endpoint receiving database snapshots (archival disabled if empty)
+	ArchiveS3Bucket    string            // Bucket receiving database snapshots
+	ArchiveS3Region    string            // Region used to sign archive uploads
+	ArchiveS3Prefix    string            // Object key prefix of archived snapshots
+	ArchiveS3AccessKey string            // Access key used to sign archive uploads
+	ArchiveS3SecretKey string            // Secret key used to sign archive uploads
+	LogFormat          string            // Log output format: "text" (log package lines) or "json" (one JSON object per line)
+	LogLevel           string            // Minimum level of logged records: debug, info, warn or error
 }
 
-// Load loads configuration from environment variables
+// Database checks run by the health endpoint
+const (
+	HealthCheckPing = "ping" // cheap SELECT 1 proving the database answers
+	HealthCheckFull = "full" // current releases query, exercising the releases table
+)
+
+// Collection modes of slaves
+const (
+	CollectionPoll  = "poll"  // collect the monitored namespaces at each interval only
+	CollectionWatch = "watch" // also collect Deployments, StatefulSets and DaemonSets as soon as they change
+)
+
+// Load loads configuration from environment variables and, when CONFIG_FILE is set, from that file,
+// environment variables taking precedence
 func Load() *Config {
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile != "" {
+		if err := LoadFile(configFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
 	config := &Config{
+		ConfigFile:         configFile,
 		Port:               getEnv("PORT", "8080"),
 		DatabasePath:       getEnv("DATABASE_PATH", "/data/releases.db"),
+		DBMaxOpenConns:     getEnvCount("DB_MAX_OPEN_CONNS", 0),
+		DBMaxIdleConns:     getEnvCount("DB_MAX_IDLE_CONNS", 2),
+		DBConnMaxLifetime:  getEnvCount("DB_CONN_MAX_LIFETIME", 0),
 		InCluster:          getEnv("IN_CLUSTER", "true") == "true",
 		KubeconfigPath:     getEnv("KUBECONFIG", ""),
 		CollectionInterval: getEnvInt("COLLECTION_INTERVAL", 60), // 1 hour default
+		CollectionMode:     getEnv("COLLECTION_MODE", CollectionPoll),
+		StaleThreshold:     getEnvInt("STALE_THRESHOLD_HOURS", 0),
+		CleanupMinAge:      getEnvInt("CLEANUP_MIN_AGE", 0),
+		HistoryRetention:   getEnvInt("HISTORY_RETENTION_COUNT", database.DefaultKeepReleases),
 		EnvName:            getEnv("ENV_NAME", "master"),
+		UnknownVersionText: getEnv("UNKNOWN_VERSION_TEXT", "unknown"),
 		ClientName:         getEnv("CLIENT_NAME", "master"),
+		ClusterName:        getEnv("CLUSTER_NAME", ""),
 		BasePath:           normalizeBasePath(getEnv("BASE_PATH", "")),
 		Mode:               getEnv("MODE", "slave"), // Default to slave mode
 		MasterURL:          getEnv("MASTER_URL", ""),
 		MasterAPIKey:       getEnv("MASTER_API_KEY", ""),
+		UpstreamAPIKey:     getEnv("UPSTREAM_API_KEY", ""),
+		UpstreamCacheTTL:   getEnvInt("UPSTREAM_CACHE_TTL", 30),
 		SyncInterval:       getEnvInt("SYNC_INTERVAL", 5), // 5 minutes default
+		SyncMaxRetries:     getEnvInt("SYNC_MAX_RETRIES", 3),
+		SyncMaxAttempts:    getEnvCount("SYNC_MAX_ATTEMPTS", 5),
+		SyncDedupWindow:    getEnvInt("SYNC_DEDUP_WINDOW", 0),
 		ProxyURL:           getEnv("PROXY_URL", ""),
 		TLSInsecure:        getEnv("TLS_INSECURE", "false") == "true",
+		SyncSchemaVersion:  getEnvInt("SYNC_SCHEMA_VERSION", version.SchemaVersion),
+		SingleTenant:       getEnv("SINGLE_TENANT", "false") == "true",
+		LowercaseNames:     getEnv("LOWERCASE_NAMES", "false") == "true",
+		APIKeyLegacyFormat: getEnv("API_KEY_LEGACY_FORMAT", "true") == "true",
+		FailedRetention:    getEnvInt("FAILED_RETENTION_DAYS", 30),
+		BadgeSigningSecret: getEnv("BADGE_SIGNING_SECRET", ""),
+		BadgeCacheTTL:      getEnvCount("BADGE_CACHE_TTL_MS", 1000),
+		IdempotencyTTL:     getEnvCount("IDEMPOTENCY_TTL", 60),
+		BadgeRateLimit:     getEnvInt("BADGE_RATE_LIMIT", 0),
+		MaxConcurrentCalls: getEnvInt("MAX_CONCURRENT_K8S_CALLS", 10),
+		CollectionWorkers:  getEnvInt("COLLECTION_CONCURRENCY", 4),
+		MaxHistoryLimit:    getEnvInt("MAX_HISTORY_LIMIT", 200),
+		MaxClockSkew:       getEnvCount("MAX_CLOCK_SKEW", 5),
+		TrackRestarts:      getEnv("TRACK_RESTARTS", "false") == "true",
+		TrackScaling:       getEnv("TRACK_SCALING", "false") == "true",
+		StrictJSON:         getEnv("STRICT_JSON", "false") == "true",
+		HealthCheckDepth:   getEnv("HEALTH_CHECK_DEPTH", HealthCheckPing),
+		PingWarningMinutes: getEnvInt("PING_WARNING_MINUTES", 10),
+		PingOfflineMinutes: getEnvInt("PING_OFFLINE_MINUTES", 15),
+		OTLPEndpoint:       getEnv("OTLP_ENDPOINT", ""),
+		TracingEndpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		ChartVersionLabel:  getEnv("CHART_VERSION_LABEL", "helm.sh/chart"),
+		AppVersionLabel:    getEnv("APP_VERSION_LABEL", "app.kubernetes.io/version"),
+		OrderLabel:         getEnv("ORDER_LABEL", "krelease-tracker/order"),
+		ArchiveInterval:    getEnvInt("ARCHIVE_INTERVAL", 1440), // daily default
+		ArchiveS3Endpoint:  getEnv("ARCHIVE_S3_ENDPOINT", ""),
+		ArchiveS3Bucket:    getEnv("ARCHIVE_S3_BUCKET", ""),
+		ArchiveS3Region:    getEnv("ARCHIVE_S3_REGION", "us-east-1"),
+		ArchiveS3Prefix:    getEnv("ARCHIVE_S3_PREFIX", ""),
+		ArchiveS3AccessKey: getEnv("ARCHIVE_S3_ACCESS_KEY", ""),
+		ArchiveS3SecretKey: getEnv("ARCHIVE_S3_SECRET_KEY", ""),
+		LogFormat:          getEnv("LOG_FORMAT", logging.FormatText),
+		LogLevel:           getEnv("LOG_LEVEL", "info"),
 	}
 
-	// Parse namespaces from environment variable or use default
-	namespacesStr := getEnv("NAMESPACES", "default")
-	config.Namespaces = strings.Split(namespacesStr, ",")
-	for i := range config.Namespaces {
-		config.Namespaces[i] = strings.TrimSpace(config.Namespaces[i])
+	// Normalize client and environment names so they key the same rows as normalized incoming names
+	config.ClientName = database.NormalizeName(config.ClientName, config.LowercaseNames)
+	config.EnvName = database.NormalizeName(config.EnvName, config.LowercaseNames)
+
+	if config.SyncSchemaVersion > version.SchemaVersion {
+		log.Printf("Warning: SYNC_SCHEMA_VERSION %d is newer than supported version %d, using %d",
+			config.SyncSchemaVersion, version.SchemaVersion, version.SchemaVersion)
+		config.SyncSchemaVersion = version.SchemaVersion
 	}
 
-	// Parse API keys from environment variable
-	apiKeysStr := getEnv("API_KEYS", "")
-	if apiKeysStr != "" {
-		keys := strings.Split(apiKeysStr, ",")
-		for _, key := range keys {
-			key = strings.TrimSpace(key)
-			if isValidAPIKey(key) {
-				config.APIKeys = append(config.APIKeys, key)
-			} else if key != "" {
-				log.Printf("Warning: Invalid API key format (key must be at least 32 characters and contain only alphanumeric, hyphens, and underscores): %s...", key[:min(8, len(key))])
+	if config.HealthCheckDepth != HealthCheckPing && config.HealthCheckDepth != HealthCheckFull {
+		log.Printf("Warning: unknown HEALTH_CHECK_DEPTH %q, using %q", config.HealthCheckDepth, HealthCheckPing)
+		config.HealthCheckDepth = HealthCheckPing
+	}
+
+	if config.CollectionMode != CollectionPoll && config.CollectionMode != CollectionWatch {
+		log.Printf("Warning: unknown COLLECTION_MODE %q, using %q", config.CollectionMode, CollectionPoll)
+		config.CollectionMode = CollectionPoll
+	}
+
+	if config.LogFormat != logging.FormatText && config.LogFormat != logging.FormatJSON {
+		log.Printf("Warning: unknown LOG_FORMAT %q, using %q", config.LogFormat, logging.FormatText)
+		config.LogFormat = logging.FormatText
+	}
+
+	if _, ok := logging.ParseLevel(config.LogLevel); !ok {
+		log.Printf("Warning: unknown LOG_LEVEL %q, using %q", config.LogLevel, "info")
+		config.LogLevel = "info"
+	}
+
+	if value := lookupEnv("HISTORY_RETENTION_COUNT"); value != "" && parseInt(value) < 1 {
+		log.Printf("Warning: HISTORY_RETENTION_COUNT %q is not a positive integer, using %d", value, config.HistoryRetention)
+	}
+
+	if config.PingOfflineMinutes < config.PingWarningMinutes {
+		log.Printf("Warning: PING_OFFLINE_MINUTES %d is below PING_WARNING_MINUTES %d, using %d",
+			config.PingOfflineMinutes, config.PingWarningMinutes, config.PingWarningMinutes)
+		config.PingOfflineMinutes = config.PingWarningMinutes
+	}
+
+	// Parse namespaces from environment variable or use default; with a namespace label selector the
+	// explicit list is optional
+	config.NamespaceSelector = strings.TrimSpace(getEnv("NAMESPACE_LABEL_SELECTOR", ""))
+	defaultNamespaces := "default"
+	if config.NamespaceSelector != "" {
+		defaultNamespaces = ""
+	}
+	for _, namespace := range strings.Split(getEnv("NAMESPACES", defaultNamespaces), ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			config.Namespaces = append(config.Namespaces, namespace)
+		}
+	}
+
+	// Parse namespaces skipped by the "*" wildcard (e.g. "kube-system,kube-public")
+	if excludeStr := getEnv("NAMESPACES_EXCLUDE", ""); excludeStr != "" {
+		for _, namespace := range strings.Split(excludeStr, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace != "" {
+				config.NamespacesExclude = append(config.NamespacesExclude, namespace)
 			}
 		}
+	}
+
+	// Parse upstream masters federated by this master (e.g. "https://eu.example.com,https://us.example.com")
+	if upstreamsStr := getEnv("UPSTREAM_MASTERS", ""); upstreamsStr != "" {
+		for _, upstream := range strings.Split(upstreamsStr, ",") {
+			if upstream = strings.TrimSpace(upstream); upstream != "" {
+				config.UpstreamMasters = append(config.UpstreamMasters, upstream)
+			}
+		}
+	}
+
+	// Parse per-namespace collection intervals (e.g. "prod=1m,infra=30m")
+	config.NamespaceIntervals = parseNamespaceIntervals(getEnv("NAMESPACE_INTERVALS", ""))
+
+	// Parse OCI labels recorded from image configs (e.g. "org.opencontainers.image.revision,org.opencontainers.image.source")
+	if labelsStr := getEnv("IMAGE_LABELS", ""); labelsStr != "" {
+		for _, label := range strings.Split(labelsStr, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				config.ImageLabels = append(config.ImageLabels, label)
+			}
+		}
+	}
+
+	// Parse pod template annotations recorded with releases (e.g. "git-commit,ci.example.com/pipeline")
+	if annotationsStr := getEnv("TRACK_ANNOTATIONS", ""); annotationsStr != "" {
+		for _, key := range strings.Split(annotationsStr, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				config.TrackedAnnotations = append(config.TrackedAnnotations, key)
+			}
+		}
+	}
+
+	// Parse pod label selector templates, separated by semicolons since a selector may itself contain
+	// commas (e.g. "app.kubernetes.io/instance={name};app.kubernetes.io/name={name},tier=web")
+	if selectorsStr := getEnv("POD_LABEL_SELECTORS", ""); selectorsStr != "" {
+		for _, selector := range strings.Split(selectorsStr, ";") {
+			if selector = strings.TrimSpace(selector); selector != "" {
+				config.PodLabelSelectors = append(config.PodLabelSelectors, selector)
+			}
+		}
+	}
+
+	// Parse pod phases accepted when resolving image SHAs (e.g. "Pending,Succeeded")
+	config.SHAAcceptPhases = parsePodPhases(getEnv("SHA_ACCEPT_PHASES", ""))
+
+	// Parse extra headers for outgoing master requests (e.g. "X-Tenant-ID=acme,X-Env=prod")
+	config.SyncExtraHeaders = parseHeaders(getEnv("SYNC_EXTRA_HEADERS", ""))
+
+	// Parse API keys from environment variable and keys file
+	config.APIKeysFile = getEnv("API_KEYS_FILE", "")
+	if getEnv("API_KEYS", "") != "" || config.APIKeysFile != "" {
+		apiKeys, err := LoadAPIKeys(config.APIKeysFile)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		config.APIKeys = apiKeys
 		if len(config.APIKeys) == 0 {
 			log.Println("Warning: No valid API keys found, authentication will be disabled")
 		} else {
@@ -76,15 +306,51 @@ func Load() *Config {
 	return config
 }
 
+// LoadAPIKeys reads the API keys of the API_KEYS environment variable and, when keysFile is set, of that
+// file, one key per line or comma-separated, "#" starting a comment. Invalid keys are logged and skipped.
+func LoadAPIKeys(keysFile string) ([]string, error) {
+	sources := []string{getEnv("API_KEYS", "")}
+	if keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API keys file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			sources = append(sources, line)
+		}
+	}
+
+	var apiKeys []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		for _, key := range strings.Split(source, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" || seen[key] {
+				continue
+			}
+			if !isValidAPIKey(key) {
+				log.Printf("Warning: Invalid API key format (key must be at least 32 characters and contain only alphanumeric, hyphens, underscores, and the \"::\" client separator): %s...", key[:min(8, len(key))])
+				continue
+			}
+			seen[key] = true
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	return apiKeys, nil
+}
+
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		if intValue := parseInt(value); intValue > 0 {
 			return intValue
 		}
@@ -92,6 +358,21 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvCount is getEnvInt for settings where 0 is a meaningful value, such as keeping no idle database
+// connections: any non-negative integer is accepted and invalid values fall back to defaultValue
+func getEnvCount(key string, defaultValue int) int {
+	value := lookupEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		log.Printf("Warning: %s %q is not a non-negative integer, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return count
+}
+
 func parseInt(s string) int {
 	var result int
 	for _, char := range s {
@@ -104,6 +385,116 @@ func parseInt(s string) int {
 	return result
 }
 
+// parseHeaders parses comma-separated key=value pairs into a header map, skipping invalid entries
+func parseHeaders(headersStr string) map[string]string {
+	headers := make(map[string]string)
+	if headersStr == "" {
+		return headers
+	}
+
+	for _, pair := range strings.Split(headersStr, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || !isValidHeaderName(name) {
+			log.Printf("Warning: Invalid extra header (expected key=value with a valid header name): %s", name)
+			continue
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+
+	if len(headers) > 0 {
+		log.Printf("Loaded %d extra header(s) for master requests", len(headers))
+	}
+
+	return headers
+}
+
+// parsePodPhases parses a comma-separated list of pod phases, skipping unknown ones.
+// Phase names are matched case-insensitively and returned in their canonical form.
+func parsePodPhases(phasesStr string) []string {
+	var phases []string
+	if phasesStr == "" {
+		return phases
+	}
+
+	for _, phase := range strings.Split(phasesStr, ",") {
+		phase = strings.TrimSpace(phase)
+		if phase == "" {
+			continue
+		}
+
+		canonical := ""
+		for _, known := range []string{"Pending", "Running", "Succeeded", "Failed"} {
+			if strings.EqualFold(phase, known) {
+				canonical = known
+				break
+			}
+		}
+		if canonical == "" {
+			log.Printf("Warning: Invalid pod phase in SHA_ACCEPT_PHASES (expected Pending, Running, Succeeded or Failed): %s", phase)
+			continue
+		}
+		phases = append(phases, canonical)
+	}
+
+	return phases
+}
+
+// parseNamespaceIntervals parses comma-separated namespace=duration pairs into intervals in minutes,
+// skipping invalid entries. Durations use Go syntax ("90s", "1m", "2h"); a bare number means minutes.
+func parseNamespaceIntervals(intervalsStr string) map[string]int {
+	intervals := make(map[string]int)
+	if intervalsStr == "" {
+		return intervals
+	}
+
+	for _, pair := range strings.Split(intervalsStr, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		namespace, value, found := strings.Cut(pair, "=")
+		namespace = strings.TrimSpace(namespace)
+		value = strings.TrimSpace(value)
+
+		minutes := parseInt(value)
+		if duration, err := time.ParseDuration(value); err == nil {
+			minutes = int(duration / time.Minute)
+		}
+		if !found || namespace == "" || minutes < 1 {
+			log.Printf("Warning: Invalid namespace interval in NAMESPACE_INTERVALS (expected namespace=duration of at least 1m): %s", pair)
+			continue
+		}
+		intervals[namespace] = minutes
+	}
+
+	return intervals
+}
+
+// isValidHeaderName validates an HTTP header name (RFC 7230 token characters)
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, char := range name {
+		if !((char >= 'a' && char <= 'z') ||
+			(char >= 'A' && char <= 'Z') ||
+			(char >= '0' && char <= '9') ||
+			strings.ContainsRune("!#$%&'*+-.^_`|~", char)) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // isValidAPIKey validates API key format
 func isValidAPIKey(key string) bool {
 	// API key must be at least 32 characters
@@ -111,8 +502,9 @@ func isValidAPIKey(key string) bool {
 		return false
 	}
 
-	// API key should contain only alphanumeric characters, hyphens, and underscores
-	for _, char := range key {
+	// API key should contain only alphanumeric characters, hyphens, and underscores,
+	// plus the "::" separator of client keys
+	for _, char := range strings.ReplaceAll(key, "::", "") {
 		if !((char >= 'a' && char <= 'z') ||
 			(char >= 'A' && char <= 'Z') ||
 			(char >= '0' && char <= '9') ||
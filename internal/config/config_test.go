@@ -0,0 +1,202 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseHeaders(t *testing.T) {
+	headers := parseHeaders("X-Tenant-ID=acme, X-Trace = on ,bad header=x,missing-value,=novalue")
+
+	if len(headers) != 2 {
+		t.Fatalf("Expected 2 valid headers, got %d: %v", len(headers), headers)
+	}
+	if headers["X-Tenant-ID"] != "acme" {
+		t.Errorf("Expected X-Tenant-ID=acme, got %q", headers["X-Tenant-ID"])
+	}
+	if headers["X-Trace"] != "on" {
+		t.Errorf("Expected X-Trace=on, got %q", headers["X-Trace"])
+	}
+}
+
+func TestParsePodPhases(t *testing.T) {
+	phases := parsePodPhases("pending, Succeeded,bogus,,FAILED")
+
+	expected := []string{"Pending", "Succeeded", "Failed"}
+	if len(phases) != len(expected) {
+		t.Fatalf("Expected phases %v, got %v", expected, phases)
+	}
+	for i := range expected {
+		if phases[i] != expected[i] {
+			t.Errorf("Expected phase %q at index %d, got %q", expected[i], i, phases[i])
+		}
+	}
+}
+
+func TestParseNamespaceIntervals(t *testing.T) {
+	intervals := parseNamespaceIntervals("prod=1m, infra=2h,staging=15,bad=30s,=5m,broken")
+
+	expected := map[string]int{"prod": 1, "infra": 120, "staging": 15}
+	if len(intervals) != len(expected) {
+		t.Fatalf("Expected intervals %v, got %v", expected, intervals)
+	}
+	for namespace, minutes := range expected {
+		if intervals[namespace] != minutes {
+			t.Errorf("Expected %d minutes for %s, got %d", minutes, namespace, intervals[namespace])
+		}
+	}
+}
+
+func TestIsValidAPIKeyAcceptsClientSeparator(t *testing.T) {
+	if !isValidAPIKey("foo-bar::authkey12345678901234567890") {
+		t.Error("Expected client key with :: separator to be valid")
+	}
+	if isValidAPIKey("foo-bar:authkey12345678901234567890123") {
+		t.Error("Expected a single colon to be rejected")
+	}
+}
+
+func TestLoadAPIKeysReadsKeysFile(t *testing.T) {
+	t.Setenv("API_KEYS", "adminkey123456789012345678901234567, acme::authkey12345678901234567890")
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated 2023-12-01\nacme::authkey12345678901234567890\nglobex::authkey1234567890123456789 # ci\ntooshort, other::authkey12345678901234567890\n"
+	if err := os.WriteFile(keysFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+
+	apiKeys, err := LoadAPIKeys(keysFile)
+	if err != nil {
+		t.Fatalf("Failed to load API keys: %v", err)
+	}
+	expected := []string{"adminkey123456789012345678901234567", "acme::authkey12345678901234567890", "globex::authkey1234567890123456789", "other::authkey12345678901234567890"}
+	if len(apiKeys) != len(expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, apiKeys)
+	}
+	for i := range expected {
+		if apiKeys[i] != expected[i] {
+			t.Errorf("Expected key %q at index %d, got %q", expected[i], i, apiKeys[i])
+		}
+	}
+
+	if _, err := LoadAPIKeys(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing keys file")
+	}
+}
+
+func TestLoadConfigFileWithEnvOverrides(t *testing.T) {
+	t.Cleanup(func() { fileValues = nil })
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `namespaces: [prod, staging]
+COLLECTION_INTERVAL: 15
+TRACK_RESTARTS: true
+CLIENT_NAME: acme
+NAMESPACE_INTERVALS:
+  prod: 5m
+  staging: 2h
+`
+	if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", configFile)
+	t.Setenv("CLIENT_NAME", "globex")
+
+	config := Load()
+
+	if len(config.Namespaces) != 2 || config.Namespaces[0] != "prod" || config.Namespaces[1] != "staging" {
+		t.Errorf("Expected namespaces [prod staging] from the file, got %v", config.Namespaces)
+	}
+	if config.CollectionInterval != 15 {
+		t.Errorf("Expected collection interval 15 from the file, got %d", config.CollectionInterval)
+	}
+	if !config.TrackRestarts {
+		t.Error("Expected TRACK_RESTARTS from the file")
+	}
+	if config.NamespaceIntervals["prod"] != 5 || config.NamespaceIntervals["staging"] != 120 {
+		t.Errorf("Expected namespace intervals from the file, got %v", config.NamespaceIntervals)
+	}
+	if config.ClientName != "globex" {
+		t.Errorf("Expected CLIENT_NAME from the environment to override the file, got %q", config.ClientName)
+	}
+
+	// A broken file keeps the values loaded before
+	if err := os.WriteFile(configFile, []byte("namespaces: [unclosed"), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := LoadFile(configFile); err == nil {
+		t.Error("Expected an error for an invalid config file")
+	}
+	if config := Load(); len(config.Namespaces) != 2 {
+		t.Errorf("Expected the previous namespaces to be kept, got %v", config.Namespaces)
+	}
+}
+
+func TestDatabasePoolSettingsAcceptZero(t *testing.T) {
+	t.Setenv("DB_MAX_IDLE_CONNS", "0")
+	t.Setenv("DB_MAX_OPEN_CONNS", "8")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "-5")
+
+	config := Load()
+
+	if config.DBMaxIdleConns != 0 {
+		t.Errorf("Expected DB_MAX_IDLE_CONNS=0 to keep no idle connections, got %d", config.DBMaxIdleConns)
+	}
+	if config.DBMaxOpenConns != 8 {
+		t.Errorf("Expected 8 open connections, got %d", config.DBMaxOpenConns)
+	}
+	if config.DBConnMaxLifetime != 0 {
+		t.Errorf("Expected an invalid lifetime to fall back to the default, got %d", config.DBConnMaxLifetime)
+	}
+}
+
+func TestZeroDisablesOptionalLimits(t *testing.T) {
+	tests := []struct {
+		key   string
+		value func(*Config) int
+	}{
+		{"SYNC_MAX_ATTEMPTS", func(c *Config) int { return c.SyncMaxAttempts }},
+		{"MAX_CLOCK_SKEW", func(c *Config) int { return c.MaxClockSkew }},
+		{"IDEMPOTENCY_TTL", func(c *Config) int { return c.IdempotencyTTL }},
+		{"BADGE_CACHE_TTL_MS", func(c *Config) int { return c.BadgeCacheTTL }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Setenv(tt.key, "0")
+			if value := tt.value(Load()); value != 0 {
+				t.Errorf("Expected %s=0 to be kept, got %d", tt.key, value)
+			}
+		})
+	}
+}
+
+func TestWatchFileCallsOnChange(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"NAMESPACES": ["prod"]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	if err := WatchFile(ctx, configFile, func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("Failed to watch config file: %v", err)
+	}
+
+	// Replace the file atomically, like editors and ConfigMap updates do
+	next := configFile + ".tmp"
+	if err := os.WriteFile(next, []byte(`{"NAMESPACES": ["prod", "staging"]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := os.Rename(next, configFile); err != nil {
+		t.Fatalf("Failed to replace config file: %v", err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected onChange to be called after the config file was replaced")
+	}
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/yaml"
+)
+
+// fileValues holds the settings read from CONFIG_FILE, keyed by environment variable name. Environment
+// variables override them.
+var (
+	fileValuesMu sync.RWMutex
+	fileValues   map[string]string
+)
+
+// fileReloadDelay groups the bursts of events editors and ConfigMap updates produce into one reload
+const fileReloadDelay = 200 * time.Millisecond
+
+// LoadFile reads a YAML or JSON configuration file mapping environment variable names to their values,
+// e.g. "NAMESPACES: [prod, staging]" or "COLLECTION_INTERVAL: 30". Lists are joined with commas and maps
+// with "key=value" pairs, matching the formats of the environment variables. On error the previously
+// loaded values are kept.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		formatted, err := formatFileValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of %s in config file %s: %w", key, path, err)
+		}
+		values[strings.ToUpper(strings.TrimSpace(key))] = formatted
+	}
+
+	fileValuesMu.Lock()
+	fileValues = values
+	fileValuesMu.Unlock()
+	return nil
+}
+
+// formatFileValue converts a value of the config file into its environment variable form
+func formatFileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			formatted, err := formatFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, formatted)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(v))
+		for _, key := range keys {
+			formatted, err := formatFileValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+formatted)
+		}
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// lookupEnv returns the value of the environment variable key, or the value the config file sets for it
+func lookupEnv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	fileValuesMu.RLock()
+	defer fileValuesMu.RUnlock()
+	return fileValues[key]
+}
+
+// WatchFile calls onChange after path is written, created or replaced, until ctx is done. The directory
+// of the file is watched so that atomic replacements by editors and the symlink swaps of mounted
+// ConfigMaps are seen too.
+func WatchFile(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch config file: %w", err)
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config file: %w", err)
+	}
+
+	// A mounted ConfigMap only changes the target of the symlinks leading to the file
+	target, _ := filepath.EvalSymlinks(path)
+
+	go func() {
+		defer watcher.Close()
+
+		reload := time.NewTimer(fileReloadDelay)
+		reload.Stop()
+		defer reload.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				changed := filepath.Clean(event.Name) == path && (event.Has(fsnotify.Write) || event.Has(fsnotify.Create))
+				if current, _ := filepath.EvalSymlinks(path); current != target {
+					target, changed = current, current != ""
+				}
+				if changed {
+					reload.Reset(fileReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Error watching config file", "file", path, "error", err)
+			case <-reload.C:
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
@@ -2,7 +2,8 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 )
 
 // Migration represents a database migration
@@ -185,6 +186,367 @@ var migrations = []Migration{
 		-- Manual intervention would be required
 		`,
 	},
+	{
+		Version:     4,
+		Description: "Add collection_errors table",
+		Up: `
+		CREATE TABLE IF NOT EXISTS collection_errors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_name TEXT NOT NULL,
+			env_name TEXT NOT NULL,
+			namespace TEXT NOT NULL,
+			workload_name TEXT NOT NULL,
+			workload_type TEXT NOT NULL,
+			container_name TEXT NOT NULL,
+			error TEXT NOT NULL,
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL,
+			UNIQUE(namespace, workload_name, container_name, client_name, env_name)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_collection_errors_client_env ON collection_errors(client_name, env_name);
+		`,
+		Down: `
+		DROP TABLE IF EXISTS collection_errors;
+		`,
+	},
+	{
+		Version:     5,
+		Description: "Add reported_by column to releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN reported_by TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN reported_by;
+		`,
+	},
+	{
+		Version:     6,
+		Description: "Add cluster_name column to releases, pending_releases and slave_pings",
+		Up: `
+		ALTER TABLE releases ADD COLUMN cluster_name TEXT NOT NULL DEFAULT '';
+		ALTER TABLE pending_releases ADD COLUMN cluster_name TEXT NOT NULL DEFAULT '';
+		ALTER TABLE slave_pings ADD COLUMN cluster_name TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN cluster_name;
+		ALTER TABLE pending_releases DROP COLUMN cluster_name;
+		ALTER TABLE slave_pings DROP COLUMN cluster_name;
+		`,
+	},
+	{
+		Version:     7,
+		Description: "Add failed_releases table",
+		Up: `
+		CREATE TABLE IF NOT EXISTS failed_releases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_name TEXT NOT NULL,
+			env_name TEXT NOT NULL,
+			cluster_name TEXT NOT NULL DEFAULT '',
+			namespace TEXT NOT NULL,
+			workload_name TEXT NOT NULL,
+			workload_type TEXT NOT NULL,
+			container_name TEXT NOT NULL,
+			image_repo TEXT NOT NULL,
+			image_name TEXT NOT NULL,
+			image_tag TEXT NOT NULL,
+			image_sha TEXT NOT NULL DEFAULT '',
+			sync_attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL,
+			failed_at DATETIME NOT NULL,
+			UNIQUE(namespace, workload_name, container_name, client_name, env_name, image_sha)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_failed_releases_failed_at ON failed_releases(failed_at);
+		`,
+		Down: `
+		DROP TABLE IF EXISTS failed_releases;
+		`,
+	},
+	{
+		Version:     8,
+		Description: "Add chart_version and app_version columns to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN chart_version TEXT NOT NULL DEFAULT '';
+		ALTER TABLE releases ADD COLUMN app_version TEXT NOT NULL DEFAULT '';
+		ALTER TABLE pending_releases ADD COLUMN chart_version TEXT NOT NULL DEFAULT '';
+		ALTER TABLE pending_releases ADD COLUMN app_version TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN chart_version;
+		ALTER TABLE releases DROP COLUMN app_version;
+		ALTER TABLE pending_releases DROP COLUMN chart_version;
+		ALTER TABLE pending_releases DROP COLUMN app_version;
+		`,
+	},
+	{
+		Version:     9,
+		Description: "Trim whitespace from client and environment names",
+		Up:          normalizeClientEnvSQL("trim(%s)"),
+		Down: `
+		-- Trimmed names cannot be restored
+		`,
+	},
+	{
+		Version:     10,
+		Description: "Add started_at column to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN started_at DATETIME;
+		ALTER TABLE pending_releases ADD COLUMN started_at DATETIME;
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN started_at;
+		ALTER TABLE pending_releases DROP COLUMN started_at;
+		`,
+	},
+	{
+		Version:     11,
+		Description: "Add source column to releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN source TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN source;
+		`,
+	},
+	{
+		Version:     12,
+		Description: "Add category column to collection_errors",
+		Up: `
+		ALTER TABLE collection_errors ADD COLUMN category TEXT NOT NULL DEFAULT 'unresolved';
+		UPDATE collection_errors SET category = 'no_running_pods' WHERE error LIKE 'no running pods found%';
+		`,
+		Down: `
+		ALTER TABLE collection_errors DROP COLUMN category;
+		`,
+	},
+	{
+		Version:     13,
+		Description: "Add display_order column to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN display_order INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE pending_releases ADD COLUMN display_order INTEGER NOT NULL DEFAULT 0;
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN display_order;
+		ALTER TABLE pending_releases DROP COLUMN display_order;
+		`,
+	},
+	{
+		Version:     14,
+		Description: "Add image_labels column to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN image_labels TEXT NOT NULL DEFAULT '';
+		ALTER TABLE pending_releases ADD COLUMN image_labels TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN image_labels;
+		ALTER TABLE pending_releases DROP COLUMN image_labels;
+		`,
+	},
+	{
+		Version:     15,
+		Description: "Add sync_attempts and last_error columns to pending_releases",
+		Up: `
+		ALTER TABLE pending_releases ADD COLUMN sync_attempts INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE pending_releases ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE pending_releases DROP COLUMN sync_attempts;
+		ALTER TABLE pending_releases DROP COLUMN last_error;
+		`,
+	},
+	{
+		Version:     16,
+		Description: "Add scaling_events table",
+		Up: `
+		CREATE TABLE IF NOT EXISTS scaling_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_name TEXT NOT NULL,
+			env_name TEXT NOT NULL,
+			namespace TEXT NOT NULL,
+			workload_name TEXT NOT NULL,
+			workload_type TEXT NOT NULL,
+			replicas INTEGER NOT NULL,
+			previous_replicas INTEGER,
+			recorded_at DATETIME NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_scaling_events_workload ON scaling_events(client_name, env_name, namespace, workload_name, recorded_at);
+		`,
+		Down: `
+		DROP TABLE IF EXISTS scaling_events;
+		`,
+	},
+	{
+		Version:     17,
+		Description: "Add replicas and ready_replicas columns to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN replicas INTEGER;
+		ALTER TABLE releases ADD COLUMN ready_replicas INTEGER;
+		ALTER TABLE pending_releases ADD COLUMN replicas INTEGER;
+		ALTER TABLE pending_releases ADD COLUMN ready_replicas INTEGER;
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN replicas;
+		ALTER TABLE releases DROP COLUMN ready_replicas;
+		ALTER TABLE pending_releases DROP COLUMN replicas;
+		ALTER TABLE pending_releases DROP COLUMN ready_replicas;
+		`,
+	},
+	{
+		Version:     18,
+		Description: "Add client_retention table",
+		Up: `
+		CREATE TABLE IF NOT EXISTS client_retention (
+			client_name TEXT PRIMARY KEY,
+			keep_count INTEGER,
+			keep_days INTEGER,
+			updated_at DATETIME NOT NULL
+		);
+		`,
+		Down: `
+		DROP TABLE IF EXISTS client_retention;
+		`,
+	},
+	{
+		Version:     19,
+		Description: "Add spec_digest column to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN spec_digest TEXT NOT NULL DEFAULT '';
+		ALTER TABLE pending_releases ADD COLUMN spec_digest TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN spec_digest;
+		ALTER TABLE pending_releases DROP COLUMN spec_digest;
+		`,
+	},
+	{
+		Version:     20,
+		Description: "Add is_rollback column to releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN is_rollback BOOLEAN NOT NULL DEFAULT 0;
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN is_rollback;
+		`,
+	},
+	{
+		Version:     21,
+		Description: "Add namespaces and collection_interval columns to slave_pings",
+		Up: `
+		ALTER TABLE slave_pings ADD COLUMN namespaces TEXT NOT NULL DEFAULT '';
+		ALTER TABLE slave_pings ADD COLUMN collection_interval INTEGER NOT NULL DEFAULT 0;
+		`,
+		Down: `
+		ALTER TABLE slave_pings DROP COLUMN namespaces;
+		ALTER TABLE slave_pings DROP COLUMN collection_interval;
+		`,
+	},
+	{
+		Version:     22,
+		Description: "Add annotations column to releases and pending_releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN annotations TEXT NOT NULL DEFAULT '';
+		ALTER TABLE pending_releases ADD COLUMN annotations TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN annotations;
+		ALTER TABLE pending_releases DROP COLUMN annotations;
+		`,
+	},
+	{
+		Version:     23,
+		Description: "Add idempotency_keys table",
+		Up: `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			idempotency_key TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			status_code INTEGER NOT NULL,
+			response TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (idempotency_key, scope)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+		`,
+		Down: `
+		DROP TABLE IF EXISTS idempotency_keys;
+		`,
+	},
+	{
+		Version:     24,
+		Description: "Add changed_at column to releases",
+		Up: `
+		ALTER TABLE releases ADD COLUMN changed_at DATETIME;
+		UPDATE releases SET changed_at = created_at;
+		`,
+		Down: `
+		ALTER TABLE releases DROP COLUMN changed_at;
+		`,
+	},
+}
+
+// clientEnvTable is a table keyed by client and environment names
+type clientEnvTable struct {
+	name     string
+	keys     []string // the other columns of the unique key
+	latest   []string // timestamps of which duplicates keep the most recent
+	earliest []string // timestamps of which duplicates keep the oldest
+}
+
+// clientEnvTables lists the tables keyed by client and environment names
+var clientEnvTables = []clientEnvTable{
+	{"releases", []string{"namespace", "workload_name", "container_name", "image_sha"}, []string{"last_seen"}, []string{"first_seen"}},
+	{"pending_releases", []string{"namespace", "workload_name", "container_name", "image_sha"}, []string{"last_seen"}, []string{"first_seen"}},
+	{"failed_releases", []string{"namespace", "workload_name", "container_name", "image_sha"}, []string{"last_seen"}, []string{"first_seen"}},
+	{"slave_pings", nil, []string{"last_ping_time"}, nil},
+	{"collection_errors", []string{"namespace", "workload_name", "container_name"}, []string{"last_seen"}, []string{"first_seen"}},
+}
+
+// normalizeClientEnvSQL returns statements rewriting client_name and env_name in every table with the
+// given SQL expression, e.g. "lower(trim(%s))". Rows whose normalized key already exists are duplicates
+// of a normalized row: their timestamps are merged into it (most recent last seen, oldest first seen)
+// before they are dropped.
+func normalizeClientEnvSQL(expr string) string {
+	clientExpr := fmt.Sprintf(expr, "client_name")
+	envExpr := fmt.Sprintf(expr, "env_name")
+
+	var statements strings.Builder
+	for _, table := range clientEnvTables {
+		fmt.Fprintf(&statements, `
+		UPDATE OR IGNORE %[1]s SET client_name = %[2]s, env_name = %[3]s WHERE client_name != %[2]s OR env_name != %[3]s;
+		`, table.name, clientExpr, envExpr)
+
+		// The rows left un-normalized (d) are duplicates of the normalized row holding their key
+		dupClientExpr := fmt.Sprintf(expr, "d.client_name")
+		dupEnvExpr := fmt.Sprintf(expr, "d.env_name")
+		conditions := []string{
+			fmt.Sprintf("%s = %s.client_name", dupClientExpr, table.name),
+			fmt.Sprintf("%s = %s.env_name", dupEnvExpr, table.name),
+		}
+		for _, key := range table.keys {
+			conditions = append(conditions, fmt.Sprintf("d.%[1]s = %[2]s.%[1]s", key, table.name))
+		}
+		match := strings.Join(conditions, " AND ")
+
+		var assignments []string
+		for _, column := range table.latest {
+			assignments = append(assignments, fmt.Sprintf("%[1]s = (SELECT d.%[1]s FROM %[2]s d WHERE %[3]s ORDER BY datetime(d.%[1]s) DESC LIMIT 1)", column, table.name, match))
+		}
+		for _, column := range table.earliest {
+			assignments = append(assignments, fmt.Sprintf("%[1]s = (SELECT d.%[1]s FROM %[2]s d WHERE %[3]s ORDER BY datetime(d.%[1]s) LIMIT 1)", column, table.name, match))
+		}
+
+		fmt.Fprintf(&statements, `
+		UPDATE %[1]s SET %[4]s WHERE client_name = %[2]s AND env_name = %[3]s
+			AND EXISTS (SELECT 1 FROM %[1]s d WHERE %[5]s AND (d.client_name != %[6]s OR d.env_name != %[7]s));
+		DELETE FROM %[1]s WHERE client_name != %[2]s OR env_name != %[3]s;
+		`, table.name, clientExpr, envExpr, strings.Join(assignments, ", "), match, dupClientExpr, dupEnvExpr)
+	}
+	return statements.String()
 }
 
 // createMigrationsTable creates the migrations tracking table
@@ -222,14 +584,14 @@ func (db *DB) runMigrations() error {
 		return fmt.Errorf("failed to get current version: %w", err)
 	}
 
-	log.Printf("Current database schema version: %d", currentVersion)
+	slog.Info("Current database schema version", "version", currentVersion)
 
 	for _, migration := range migrations {
 		if migration.Version <= currentVersion {
 			continue
 		}
 
-		log.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+		slog.Info("Applying migration", "version", migration.Version, "description", migration.Description)
 
 		// Execute migration in a transaction
 		tx, err := db.conn.Begin()
@@ -254,7 +616,7 @@ func (db *DB) runMigrations() error {
 			return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
 		}
 
-		log.Printf("Successfully applied migration %d", migration.Version)
+		slog.Info("Applied migration", "version", migration.Version)
 	}
 
 	return nil
@@ -1,26 +1,98 @@
 package database
 
 import (
+	"cmp"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Sources of a recorded release
+const (
+	SourceCollection = "collection" // collected from the cluster
+	SourceManual     = "manual"     // registered through the manual collect API
+	SourceSync       = "sync"       // synced from a slave
+)
+
+// OCILabels holds selected labels of an image config (e.g. org.opencontainers.image.revision),
+// stored as a JSON object
+type OCILabels map[string]string
+
+// Value stores the labels as JSON, or as an empty string when there are none
+func (l OCILabels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(map[string]string(l))
+	return string(data), err
+}
+
+// Scan reads labels stored by Value
+func (l *OCILabels) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into OCILabels", src)
+	}
+	*l = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]string)(l))
+}
+
+// Annotations holds the tracked annotations of a workload's pod template (e.g. git-commit), stored
+// as a JSON object like OCILabels
+type Annotations map[string]string
+
+// Value stores the annotations as JSON, or as an empty string when there are none
+func (a Annotations) Value() (driver.Value, error) {
+	return OCILabels(a).Value()
+}
+
+// Scan reads annotations stored by Value
+func (a *Annotations) Scan(src interface{}) error {
+	return (*OCILabels)(a).Scan(src)
+}
+
 // Release represents a container image release in the database
 type Release struct {
-	ID            int       `json:"id" db:"id"`
-	Namespace     string    `json:"namespace" db:"namespace"`
-	WorkloadName  string    `json:"workload_name" db:"workload_name"`
-	WorkloadType  string    `json:"workload_type" db:"workload_type"`
-	ContainerName string    `json:"container_name" db:"container_name"`
-	ImageRepo     string    `json:"image_repo" db:"image_repo"`
-	ImageName     string    `json:"image_name" db:"image_name"`
-	ImageTag      string    `json:"image_tag" db:"image_tag"`
-	ImageSHA      string    `json:"image_sha" db:"image_sha"`
-	ClientName    string    `json:"client_name" db:"client_name"`
-	EnvName       string    `json:"env_name" db:"env_name"`
-	FirstSeen     time.Time `json:"first_seen" db:"first_seen"`
-	LastSeen      time.Time `json:"last_seen" db:"last_seen"`
-	CreatedAt     time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	ID            int         `json:"id" db:"id"`
+	Namespace     string      `json:"namespace" db:"namespace"`
+	WorkloadName  string      `json:"workload_name" db:"workload_name"`
+	WorkloadType  string      `json:"workload_type" db:"workload_type"`
+	ContainerName string      `json:"container_name" db:"container_name"`
+	ImageRepo     string      `json:"image_repo" db:"image_repo"`
+	ImageName     string      `json:"image_name" db:"image_name"`
+	ImageTag      string      `json:"image_tag" db:"image_tag"`
+	ImageSHA      string      `json:"image_sha" db:"image_sha"`
+	SpecDigest    string      `json:"spec_digest,omitempty" db:"spec_digest"` // digest pinned in the workload spec (image@sha256:...)
+	ClientName    string      `json:"client_name" db:"client_name"`
+	EnvName       string      `json:"env_name" db:"env_name"`
+	ClusterName   string      `json:"cluster_name,omitempty" db:"cluster_name"`
+	ChartVersion  string      `json:"chart_version,omitempty" db:"chart_version"`
+	AppVersion    string      `json:"app_version,omitempty" db:"app_version"`
+	DisplayOrder  int         `json:"display_order,omitempty" db:"display_order"`
+	ImageLabels   OCILabels   `json:"image_labels,omitempty" db:"image_labels"`
+	Annotations   Annotations `json:"annotations,omitempty" db:"annotations"` // tracked pod template annotations
+	Replicas      *int        `json:"replicas,omitempty" db:"replicas"`       // desired replicas of the workload, nil for Jobs
+	ReadyReplicas *int        `json:"ready_replicas,omitempty" db:"ready_replicas"`
+	StartedAt     *time.Time  `json:"started_at,omitempty" db:"started_at"` // when the first container running the image started
+	ReportedBy    string      `json:"reported_by,omitempty" db:"reported_by"`
+	Source        string      `json:"source,omitempty" db:"source"` // how the release was recorded: collection, manual or sync
+	IsRollback    bool        `json:"is_rollback" db:"is_rollback"` // the tag is lower than the one it replaced
+	FirstSeen     time.Time   `json:"first_seen" db:"first_seen"`
+	LastSeen      time.Time   `json:"last_seen" db:"last_seen"`
+	CreatedAt     time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at" db:"updated_at"`
 }
 
 // ImageFullPath returns the full image path constructed from repo, name, and tag
@@ -33,17 +105,30 @@ func (r *Release) ImageFullPath() string {
 
 // CurrentRelease represents the current state of deployed images
 type CurrentRelease struct {
-	Namespace     string    `json:"namespace"`
-	WorkloadName  string    `json:"workload_name"`
-	WorkloadType  string    `json:"workload_type"`
-	ContainerName string    `json:"container_name"`
-	ImageRepo     string    `json:"image_repo"`
-	ImageName     string    `json:"image_name"`
-	ImageTag      string    `json:"image_tag"`
-	ImageSHA      string    `json:"image_sha"`
-	ClientName    string    `json:"client_name"`
-	EnvName       string    `json:"env_name"`
-	LastSeen      time.Time `json:"last_seen"`
+	Namespace     string      `json:"namespace"`
+	WorkloadName  string      `json:"workload_name"`
+	WorkloadType  string      `json:"workload_type"`
+	ContainerName string      `json:"container_name"`
+	ImageRepo     string      `json:"image_repo"`
+	ImageName     string      `json:"image_name"`
+	ImageTag      string      `json:"image_tag"`
+	ImageSHA      string      `json:"image_sha"`
+	SpecDigest    string      `json:"spec_digest,omitempty"`
+	ClientName    string      `json:"client_name"`
+	EnvName       string      `json:"env_name"`
+	ClusterName   string      `json:"cluster_name,omitempty"`
+	ChartVersion  string      `json:"chart_version,omitempty"`
+	AppVersion    string      `json:"app_version,omitempty"`
+	DisplayOrder  int         `json:"display_order,omitempty"`
+	ImageLabels   OCILabels   `json:"image_labels,omitempty"`
+	Annotations   Annotations `json:"annotations,omitempty"`
+	Replicas      *int        `json:"replicas,omitempty"`
+	ReadyReplicas *int        `json:"ready_replicas,omitempty"`
+	LastSeen      time.Time   `json:"last_seen"`
+
+	// DigestMismatch is true when the spec pins a digest other than the one running, e.g. because a
+	// node runs an old image it had cached
+	DigestMismatch bool `json:"digest_mismatch,omitempty"`
 }
 
 // ImageFullPath returns the full image path constructed from repo, name, and tag
@@ -54,8 +139,84 @@ func (r *CurrentRelease) ImageFullPath() string {
 	return r.ImageRepo + "/" + r.ImageName + ":" + r.ImageTag
 }
 
+// ReleaseProvenance describes when and by whom the current release of a component was recorded
+type ReleaseProvenance struct {
+	ImageTag   string    `json:"image_tag"`
+	ImageSHA   string    `json:"image_sha"`
+	ReportedBy string    `json:"reported_by"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	RecordedAt time.Time `json:"recorded_at"` // updated_at of the release row
+}
+
+// TagDeployment summarizes when a specific image tag was deployed for a component
+type TagDeployment struct {
+	ImageTag  string    `json:"image_tag"`
+	ImageSHA  string    `json:"image_sha"` // SHA of the most recent release with this tag
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"` // true if the tag is the currently deployed release
+}
+
 // PendingRelease represents a release pending to be sent to master (used in slave mode)
 type PendingRelease struct {
+	ID            int         `json:"id" db:"id"`
+	Namespace     string      `json:"namespace" db:"namespace"`
+	WorkloadName  string      `json:"workload_name" db:"workload_name"`
+	WorkloadType  string      `json:"workload_type" db:"workload_type"`
+	ContainerName string      `json:"container_name" db:"container_name"`
+	ImageRepo     string      `json:"image_repo" db:"image_repo"`
+	ImageName     string      `json:"image_name" db:"image_name"`
+	ImageTag      string      `json:"image_tag" db:"image_tag"`
+	ImageSHA      string      `json:"image_sha" db:"image_sha"`
+	SpecDigest    string      `json:"spec_digest,omitempty" db:"spec_digest"` // digest pinned in the workload spec (image@sha256:...)
+	ClientName    string      `json:"client_name" db:"client_name"`
+	EnvName       string      `json:"env_name" db:"env_name"`
+	ClusterName   string      `json:"cluster_name,omitempty" db:"cluster_name"`
+	ChartVersion  string      `json:"chart_version,omitempty" db:"chart_version"`
+	AppVersion    string      `json:"app_version,omitempty" db:"app_version"`
+	DisplayOrder  int         `json:"display_order,omitempty" db:"display_order"`
+	ImageLabels   OCILabels   `json:"image_labels,omitempty" db:"image_labels"`
+	Annotations   Annotations `json:"annotations,omitempty" db:"annotations"` // tracked pod template annotations
+	Replicas      *int        `json:"replicas,omitempty" db:"replicas"`
+	ReadyReplicas *int        `json:"ready_replicas,omitempty" db:"ready_replicas"`
+	StartedAt     *time.Time  `json:"started_at,omitempty" db:"started_at"`
+	SyncAttempts  int         `json:"sync_attempts" db:"sync_attempts"` // sync attempts master rejected
+	LastError     string      `json:"last_error,omitempty" db:"last_error"`
+	FirstSeen     time.Time   `json:"first_seen" db:"first_seen"`
+	LastSeen      time.Time   `json:"last_seen" db:"last_seen"`
+	CreatedAt     time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+// ImageFullPath returns the full image path constructed from repo, name, and tag
+func (r *PendingRelease) ImageFullPath() string {
+	if r.ImageRepo == "" {
+		return r.ImageName + ":" + r.ImageTag
+	}
+	return r.ImageRepo + "/" + r.ImageName + ":" + r.ImageTag
+}
+
+// PendingReleaseStats summarizes the sync queue of a slave
+type PendingReleaseStats struct {
+	Count           int        `json:"count"`
+	Syncable        int        `json:"syncable"`                    // pending releases with a resolved image SHA, sent at the next sync
+	OldestCreatedAt *time.Time `json:"oldest_created_at,omitempty"` // when the oldest pending release was queued; nil if none
+}
+
+// FleetEnvironment summarizes one client environment for the fleet overview
+type FleetEnvironment struct {
+	ClientName       string     `json:"client_name"`
+	EnvName          string     `json:"env_name"`
+	PingStatus       string     `json:"ping_status"`         // online, warning or offline; never if its slave never pinged
+	LastPing         *time.Time `json:"last_ping,omitempty"` // nil if its slave never pinged
+	TotalComponents  int        `json:"total_components"`
+	DeployedRecently int        `json:"deployed_recently"`   // components whose current release was first seen since the overview's cutoff
+	LastSeen         *time.Time `json:"last_seen,omitempty"` // newest last_seen of its current releases; nil without releases
+}
+
+// FailedRelease represents a pending release the master kept rejecting, moved out of the sync queue (used in slave mode)
+type FailedRelease struct {
 	ID            int       `json:"id" db:"id"`
 	Namespace     string    `json:"namespace" db:"namespace"`
 	WorkloadName  string    `json:"workload_name" db:"workload_name"`
@@ -67,30 +228,101 @@ type PendingRelease struct {
 	ImageSHA      string    `json:"image_sha" db:"image_sha"`
 	ClientName    string    `json:"client_name" db:"client_name"`
 	EnvName       string    `json:"env_name" db:"env_name"`
+	ClusterName   string    `json:"cluster_name,omitempty" db:"cluster_name"`
+	SyncAttempts  int       `json:"sync_attempts" db:"sync_attempts"`
+	LastError     string    `json:"last_error" db:"last_error"`
 	FirstSeen     time.Time `json:"first_seen" db:"first_seen"`
 	LastSeen      time.Time `json:"last_seen" db:"last_seen"`
-	CreatedAt     time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
-}
-
-// ImageFullPath returns the full image path constructed from repo, name, and tag
-func (r *PendingRelease) ImageFullPath() string {
-	if r.ImageRepo == "" {
-		return r.ImageName + ":" + r.ImageTag
-	}
-	return r.ImageRepo + "/" + r.ImageName + ":" + r.ImageTag
+	FailedAt      time.Time `json:"failed_at" db:"failed_at"`
 }
 
 // SlavePing represents a health ping from a slave instance
 type SlavePing struct {
-	ID           int       `json:"id" db:"id"`
-	ClientName   string    `json:"client_name" db:"client_name"`
-	EnvName      string    `json:"env_name" db:"env_name"`
-	LastPingTime time.Time `json:"last_ping_time" db:"last_ping_time"`
-	Status       string    `json:"status" db:"status"`
-	SlaveVersion string    `json:"slave_version" db:"slave_version"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID                 int       `json:"id" db:"id"`
+	ClientName         string    `json:"client_name" db:"client_name"`
+	EnvName            string    `json:"env_name" db:"env_name"`
+	ClusterName        string    `json:"cluster_name,omitempty" db:"cluster_name"`
+	LastPingTime       time.Time `json:"last_ping_time" db:"last_ping_time"`
+	Status             string    `json:"status" db:"status"`
+	SlaveVersion       string    `json:"slave_version" db:"slave_version"`
+	Namespaces         []string  `json:"namespaces,omitempty" db:"namespaces"`                   // namespaces the slave watches, stored comma-separated
+	CollectionInterval int       `json:"collection_interval,omitempty" db:"collection_interval"` // collection interval of the slave in minutes
+	CreatedAt          time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Collection error categories
+const (
+	// CollectionErrorNoRunningPods marks workloads that exist in the cluster but have no running pods
+	// (e.g. scaled to zero or failing to schedule)
+	CollectionErrorNoRunningPods = "no_running_pods"
+	// CollectionErrorUnresolved marks containers whose pods run but expose no usable image SHA
+	CollectionErrorUnresolved = "unresolved"
+)
+
+// CollectionError represents a container the collector saw in a workload spec but could not record
+type CollectionError struct {
+	ID            int       `json:"id" db:"id"`
+	Namespace     string    `json:"namespace" db:"namespace"`
+	WorkloadName  string    `json:"workload_name" db:"workload_name"`
+	WorkloadType  string    `json:"workload_type" db:"workload_type"`
+	ContainerName string    `json:"container_name" db:"container_name"`
+	ClientName    string    `json:"client_name" db:"client_name"`
+	EnvName       string    `json:"env_name" db:"env_name"`
+	Error         string    `json:"error" db:"error"`
+	Category      string    `json:"category" db:"category"`
+	FirstSeen     time.Time `json:"first_seen" db:"first_seen"`
+	LastSeen      time.Time `json:"last_seen" db:"last_seen"`
+}
+
+// IdempotentResponse is the response recorded for a request carrying an Idempotency-Key, replayed to
+// retries of that request
+type IdempotentResponse struct {
+	StatusCode int
+	Body       []byte
+	CreatedAt  time.Time
+}
+
+// ScalingEvent records a change of the desired replica count of a workload
+type ScalingEvent struct {
+	ID               int       `json:"id" db:"id"`
+	Namespace        string    `json:"namespace" db:"namespace"`
+	WorkloadName     string    `json:"workload_name" db:"workload_name"`
+	WorkloadType     string    `json:"workload_type" db:"workload_type"`
+	ClientName       string    `json:"client_name" db:"client_name"`
+	EnvName          string    `json:"env_name" db:"env_name"`
+	Replicas         int       `json:"replicas" db:"replicas"`
+	PreviousReplicas *int      `json:"previous_replicas" db:"previous_replicas"` // nil for the first count seen
+	RecordedAt       time.Time `json:"recorded_at" db:"recorded_at"`
+}
+
+// ClientRetention is the release history retention policy of a client. A nil limit does not prune;
+// clients without a policy keep the HISTORY_RETENTION_COUNT most recent releases of each component.
+type ClientRetention struct {
+	ClientName string    `json:"client_name" db:"client_name"`
+	KeepCount  *int      `json:"keep_count,omitempty" db:"keep_count"` // releases kept per component
+	KeepDays   *int      `json:"keep_days,omitempty" db:"keep_days"`   // days a superseded release is kept after it was last seen
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// GhostWorkload represents a workload found in the cluster without any running pods
+type GhostWorkload struct {
+	Namespace    string    `json:"namespace"`
+	WorkloadName string    `json:"workload_name"`
+	WorkloadType string    `json:"workload_type"`
+	ClientName   string    `json:"client_name"`
+	EnvName      string    `json:"env_name"`
+	Containers   []string  `json:"containers"`
+	Error        string    `json:"error"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	HasRelease   bool      `json:"has_release"` // true if a release is stored, i.e. the workload ran before
+}
+
+// CollectionGap represents a component whose SHA could not be resolved during collection
+type CollectionGap struct {
+	CollectionError
+	HasRelease bool `json:"has_release"` // true if an older release is stored, i.e. the stored data is stale
 }
 
 // ReleaseHistory represents historical releases for a specific component
@@ -111,29 +343,187 @@ func (ck ComponentKey) String() string {
 	return ck.Namespace + "/" + ck.WorkloadName + "/" + ck.ContainerName
 }
 
-// ParseImagePath parses a full image path into repository, name, and tag
-func ParseImagePath(imagePath string) (repo, name, tag string) {
-	// Default tag if not specified
-	tag = "latest"
+// EnvironmentVersion is the current version of a component in one environment
+type EnvironmentVersion struct {
+	EnvName  string `json:"env_name"`
+	ImageTag string `json:"image_tag"`
+	ImageSHA string `json:"image_sha"`
+}
+
+// DetectionLag is the delay between a container starting with an image and the tracker recording it
+type DetectionLag struct {
+	ImageTag   string    `json:"image_tag"`
+	ImageSHA   string    `json:"image_sha"`
+	StartedAt  time.Time `json:"started_at"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LagSeconds float64   `json:"lag_seconds"`
+}
+
+// ComponentDetectionLag summarizes the detection lags of a component's releases
+type ComponentDetectionLag struct {
+	ComponentKey
+	Samples       []DetectionLag `json:"samples"`
+	MinSeconds    float64        `json:"min_seconds"`
+	MedianSeconds float64        `json:"median_seconds"`
+	MeanSeconds   float64        `json:"mean_seconds"`
+	MaxSeconds    float64        `json:"max_seconds"`
+}
+
+// ComponentConsistency compares the current version of a component across a client's environments
+type ComponentConsistency struct {
+	ComponentKey
+	Environments []EnvironmentVersion `json:"environments"`
+	Consistent   bool                 `json:"consistent"` // true if every environment runs the same tag and SHA
+}
 
-	// Split by tag separator
-	parts := splitLast(imagePath, ":")
-	if len(parts) == 2 {
-		imagePath = parts[0]
-		tag = parts[1]
+// Statuses of a component compared across two environments
+const (
+	DiffMatch   = "match"     // same tag and SHA in both environments
+	DiffDiffers = "differs"   // deployed in both environments with a different tag or SHA
+	DiffOnlyInA = "only_in_a" // only deployed in the first environment
+	DiffOnlyInB = "only_in_b" // only deployed in the second environment
+)
+
+// ComponentDiff compares the current version of a component in two environments
+type ComponentDiff struct {
+	ComponentKey
+	Status string              `json:"status"`
+	EnvA   *EnvironmentVersion `json:"env_a,omitempty"` // nil if the component is not deployed in the first environment
+	EnvB   *EnvironmentVersion `json:"env_b,omitempty"` // nil if the component is not deployed in the second environment
+}
+
+// NormalizeName trims a client or environment name and lowercases it when requested, so that
+// names differing only in case or surrounding whitespace key the same rows
+func NormalizeName(name string, lowercase bool) string {
+	name = strings.TrimSpace(name)
+	if lowercase {
+		name = strings.ToLower(name)
 	}
+	return name
+}
+
+// ImageReference is a container image reference split into its parts
+type ImageReference struct {
+	Repo string // registry (with its port) and path, e.g. "registry.example.com:5000/team"
+	Name string
+	Tag  string // "latest" when the reference has no tag
+	SHA  string // digest of a reference pinned by digest, without the "sha256:" prefix; empty otherwise
+}
 
-	// Split by repository separator
+// ParseImagePath parses a full image path (e.g. "registry:5000/team/app:v1@sha256:...") into its
+// repository, name, tag and digest
+func ParseImagePath(imagePath string) ImageReference {
+	ref := ImageReference{Tag: "latest"}
+
+	// A digest (image:tag@sha256:...) is recorded as the image SHA, not as part of the tag
+	imagePath, digest, found := strings.Cut(imagePath, "@")
+	if found {
+		ref.SHA = strings.TrimPrefix(digest, "sha256:")
+	}
+
+	// The registry host is the first segment when it looks like one: it may carry a port, so its
+	// colon must not be taken for a tag separator
+	var host string
+	if first, rest, found := strings.Cut(imagePath, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host, imagePath = first, rest
+	}
+
+	// Split by repository separator; only the final segment carries a tag
 	repoParts := splitLast(imagePath, "/")
 	if len(repoParts) == 2 {
-		repo = repoParts[0]
-		name = repoParts[1]
-	} else {
-		repo = ""
-		name = imagePath
+		ref.Repo = repoParts[0]
+		imagePath = repoParts[1]
+	}
+	if host != "" {
+		ref.Repo = strings.TrimSuffix(host+"/"+ref.Repo, "/")
+	}
+
+	ref.Name = imagePath
+	if name, tag, found := strings.Cut(imagePath, ":"); found {
+		ref.Name, ref.Tag = name, tag
+	}
+
+	return ref
+}
+
+// semver is a parsed semantic version (e.g. "v1.2.3-rc.1"); prerelease is empty for releases
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+// parseSemver parses a tag as a semantic version with an optional "v" prefix. Missing minor and patch
+// numbers are zero and build metadata is ignored.
+func parseSemver(tag string) (semver, bool) {
+	var v semver
+	tag, _, _ = strings.Cut(strings.TrimPrefix(tag, "v"), "+")
+	core, prerelease, found := strings.Cut(tag, "-")
+	if found {
+		if prerelease == "" {
+			return v, false
+		}
+		v.prerelease = strings.Split(prerelease, ".")
 	}
 
-	return repo, name, tag
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.core[i] = n
+	}
+	return v, true
+}
+
+// compareSemver returns -1, 0 or 1 as a is lower than, equal to or greater than b, following the
+// semver precedence rules: a prerelease is lower than its release
+func compareSemver(a, b semver) int {
+	for i := range a.core {
+		if c := cmp.Compare(a.core[i], b.core[i]); c != 0 {
+			return c
+		}
+	}
+	if len(a.prerelease) == 0 || len(b.prerelease) == 0 {
+		return cmp.Compare(len(b.prerelease), len(a.prerelease))
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		x, y := a.prerelease[i], b.prerelease[i]
+		xn, xErr := strconv.Atoi(x)
+		yn, yErr := strconv.Atoi(y)
+		var c int
+		switch {
+		case xErr == nil && yErr == nil:
+			c = cmp.Compare(xn, yn)
+		case xErr == nil:
+			c = -1 // numeric identifiers are lower than alphanumeric ones
+		case yErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(x, y)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a.prerelease), len(b.prerelease))
+}
+
+// detectRollback reports whether tag is lower than previousTag, the most recent tag stored for the
+// component. Tags compare as semantic versions when both parse, as strings otherwise.
+func detectRollback(previousTag, tag string) bool {
+	if previousTag == "" || tag == "" || previousTag == tag {
+		return false
+	}
+	previous, previousOK := parseSemver(previousTag)
+	current, currentOK := parseSemver(tag)
+	if previousOK && currentOK {
+		return compareSemver(current, previous) < 0
+	}
+	return tag < previousTag
 }
 
 // splitLast splits a string by the last occurrence of a separator
@@ -1,9 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,6 +16,85 @@ import (
 // DB wraps the SQLite database connection
 type DB struct {
 	conn *sql.DB
+
+	// pingWarning and pingOffline are how long after its last ping a slave is reported as warning and offline
+	pingWarning time.Duration
+	pingOffline time.Duration
+}
+
+// Default ping status thresholds
+const (
+	DefaultPingWarning = 10 * time.Minute
+	DefaultPingOffline = 15 * time.Minute
+)
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// currentReleaseColumns lists the columns read by scanCurrentRelease
+const currentReleaseColumns = `namespace, workload_name, workload_type, container_name,
+		image_repo, image_name, image_tag, image_sha, spec_digest, client_name, env_name, cluster_name,
+		chart_version, app_version, display_order, image_labels, annotations, replicas, ready_replicas, last_seen`
+
+// scanCurrentRelease scans a row selected with currentReleaseColumns
+func scanCurrentRelease(row rowScanner) (CurrentRelease, error) {
+	var r CurrentRelease
+	var replicas, readyReplicas sql.NullInt64
+	err := row.Scan(
+		&r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
+		&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.SpecDigest, &r.ClientName, &r.EnvName, &r.ClusterName,
+		&r.ChartVersion, &r.AppVersion, &r.DisplayOrder, &r.ImageLabels, &r.Annotations, &replicas, &readyReplicas, &r.LastSeen,
+	)
+	r.Replicas, r.ReadyReplicas = nullIntPtr(replicas), nullIntPtr(readyReplicas)
+	r.DigestMismatch = r.SpecDigest != "" && r.ImageSHA != "" && r.SpecDigest != r.ImageSHA
+	return r, err
+}
+
+// releaseColumns lists the columns read by scanRelease
+const releaseColumns = `id, namespace, workload_name, workload_type, container_name,
+		   image_repo, image_name, image_tag, image_sha, spec_digest, client_name, env_name, cluster_name,
+		   chart_version, app_version, display_order, image_labels, annotations, replicas, ready_replicas, started_at, reported_by, source, is_rollback, first_seen, last_seen, created_at, updated_at`
+
+// scanRelease scans a row selected with releaseColumns
+func scanRelease(row rowScanner) (Release, error) {
+	var r Release
+	var startedAt sql.NullTime
+	var replicas, readyReplicas sql.NullInt64
+	err := row.Scan(
+		&r.ID, &r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
+		&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.SpecDigest, &r.ClientName, &r.EnvName, &r.ClusterName,
+		&r.ChartVersion, &r.AppVersion, &r.DisplayOrder, &r.ImageLabels, &r.Annotations, &replicas, &readyReplicas, &startedAt, &r.ReportedBy, &r.Source, &r.IsRollback, &r.FirstSeen, &r.LastSeen, &r.CreatedAt, &r.UpdatedAt,
+	)
+	r.StartedAt = nullTimePtr(startedAt)
+	r.Replicas, r.ReadyReplicas = nullIntPtr(replicas), nullIntPtr(readyReplicas)
+	return r, err
+}
+
+// nullableTime formats an optional time for storage, NULL when unset
+func nullableTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// nullTimePtr converts a scanned nullable time to a pointer, nil when NULL
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// nullIntPtr converts a scanned nullable integer to a pointer, nil when NULL
+func nullIntPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	value := int(n.Int64)
+	return &value
 }
 
 // New creates a new database connection and runs migrations
@@ -21,7 +104,7 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, pingWarning: DefaultPingWarning, pingOffline: DefaultPingOffline}
 	if err := db.runMigrations(); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
@@ -30,33 +113,116 @@ func New(dbPath string) (*DB, error) {
 	return db, nil
 }
 
+// SetPoolLimits tunes the connection pool: the maximum number of open and idle connections (0 open
+// connections means no limit) and how long a connection is reused before being closed (0 means forever)
+func (db *DB) SetPoolLimits(maxOpen, maxIdle int, maxLifetime time.Duration) {
+	db.conn.SetMaxOpenConns(maxOpen)
+	db.conn.SetMaxIdleConns(maxIdle)
+	db.conn.SetConnMaxLifetime(maxLifetime)
+}
+
+// Stats returns the statistics of the connection pool, e.g. to expose connections waited for
+func (db *DB) Stats() sql.DBStats {
+	return db.conn.Stats()
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
+// Ping runs a trivial query to check that the database answers
+func (db *DB) Ping(ctx context.Context) error {
+	var one int
+	return db.conn.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// SchemaVersion returns the schema version of the database and the latest version known to this build;
+// the database is fully migrated when both are equal
+func (db *DB) SchemaVersion(ctx context.Context) (current, latest int, err error) {
+	if err := db.conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return 0, 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return current, migrations[len(migrations)-1].Version, nil
+}
+
 // UpsertRelease inserts or updates a release record
 func (db *DB) UpsertRelease(release *Release) error {
+	return upsertRelease(db.conn, release)
+}
+
+// UpsertReleases inserts or updates several releases in a single transaction: either all are saved or none
+func (db *DB) UpsertReleases(releases []*Release) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for _, release := range releases {
+		if err := upsertRelease(tx, release); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to upsert release %s/%s/%s: %w", release.Namespace, release.WorkloadName, release.ContainerName, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// upsertRelease inserts or updates a release through conn, which may be a transaction
+func upsertRelease(conn execer, release *Release) error {
 	// parse time like "2006-01-02 15:04:05+00:00"
 	now := time.Now().Format(time.RFC3339)
 
+	// A new SHA replacing the most recent release of the component is a rollback when its tag is lower.
+	// Seeing the current SHA again keeps the flag it was recorded with.
+	var previousTag, previousSHA string
+	err := conn.QueryRow(`
+	SELECT image_tag, image_sha FROM releases
+	WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ?
+	ORDER BY last_seen DESC
+	LIMIT 1
+	`, release.Namespace, release.WorkloadName, release.ContainerName, release.ClientName, release.EnvName).Scan(&previousTag, &previousSHA)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get previous release: %w", err)
+	}
+	replacesCurrent := err == nil && previousSHA != release.ImageSHA
+	release.IsRollback = replacesCurrent && detectRollback(previousTag, release.ImageTag)
+
 	query := `
 	INSERT INTO releases (
 		namespace, workload_name, workload_type, container_name,
-		image_repo, image_name, image_tag, image_sha, client_name, env_name,
-		first_seen, last_seen, created_at, updated_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		image_repo, image_name, image_tag, image_sha, spec_digest, client_name, env_name, cluster_name,
+		chart_version, app_version, display_order, image_labels, annotations, replicas, ready_replicas, started_at, reported_by, source, is_rollback, first_seen, last_seen, created_at, updated_at, changed_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(namespace, workload_name, container_name, client_name, env_name, image_sha)
 	DO UPDATE SET
+		spec_digest = excluded.spec_digest,
+		cluster_name = excluded.cluster_name,
+		chart_version = excluded.chart_version,
+		app_version = excluded.app_version,
+		display_order = excluded.display_order,
+		image_labels = CASE WHEN excluded.image_labels != '' THEN excluded.image_labels ELSE releases.image_labels END,
+		annotations = CASE WHEN excluded.annotations != '' THEN excluded.annotations ELSE releases.annotations END,
+		replicas = COALESCE(excluded.replicas, releases.replicas),
+		ready_replicas = COALESCE(excluded.ready_replicas, releases.ready_replicas),
+		started_at = COALESCE(releases.started_at, excluded.started_at),
+		reported_by = excluded.reported_by,
+		source = excluded.source,
+		is_rollback = CASE WHEN ? THEN excluded.is_rollback ELSE releases.is_rollback END,
+		changed_at = CASE WHEN ? THEN excluded.changed_at ELSE releases.changed_at END,
 		last_seen = ?,
 		updated_at = ?
 	`
 
-	_, err := db.conn.Exec(query,
+	_, err = conn.Exec(query,
 		release.Namespace, release.WorkloadName, release.WorkloadType, release.ContainerName,
-		release.ImageRepo, release.ImageName, release.ImageTag, release.ImageSHA, release.ClientName, release.EnvName,
-		release.FirstSeen.Format(time.RFC3339), release.LastSeen.Format(time.RFC3339), now, now,
-		release.LastSeen.Format(time.RFC3339), now,
+		release.ImageRepo, release.ImageName, release.ImageTag, release.ImageSHA, release.SpecDigest, release.ClientName, release.EnvName, release.ClusterName,
+		release.ChartVersion, release.AppVersion, release.DisplayOrder, release.ImageLabels, release.Annotations, release.Replicas, release.ReadyReplicas, nullableTime(release.StartedAt), release.ReportedBy, release.Source, release.IsRollback, release.FirstSeen.Format(time.RFC3339), release.LastSeen.Format(time.RFC3339), now, now, now,
+		replacesCurrent, replacesCurrent, release.LastSeen.Format(time.RFC3339), now,
 	)
 
 	return err
@@ -70,9 +236,7 @@ func (db *DB) GetCurrentReleases() ([]CurrentRelease, error) {
 	}
 
 	query := `
-	SELECT DISTINCT
-		namespace, workload_name, workload_type, container_name,
-		image_repo, image_name, image_tag, image_sha, client_name, env_name, last_seen
+	SELECT DISTINCT ` + currentReleaseColumns + `
 	FROM releases r1
 	WHERE last_seen = (
 		SELECT MAX(last_seen)
@@ -95,11 +259,7 @@ func (db *DB) GetCurrentReleases() ([]CurrentRelease, error) {
 
 	var releases []CurrentRelease
 	for rows.Next() {
-		var r CurrentRelease
-		err := rows.Scan(
-			&r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
-			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.ClientName, &r.EnvName, &r.LastSeen,
-		)
+		r, err := scanCurrentRelease(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -109,17 +269,56 @@ func (db *DB) GetCurrentReleases() ([]CurrentRelease, error) {
 	return releases, rows.Err()
 }
 
-// GetCurrentReleasesFiltered returns current deployed images filtered by client and environment
-func (db *DB) GetCurrentReleasesFiltered(clientName, envName string) ([]CurrentRelease, error) {
+// GetCurrentReleasesFiltered returns current deployed images filtered by client and environments;
+// without environment names, the releases of every environment of the client are returned
+func (db *DB) GetCurrentReleasesFiltered(clientName string, envNames ...string) ([]CurrentRelease, error) {
+	return db.GetCurrentReleasesChangedSince(clientName, envNames, time.Time{})
+}
+
+// GetCurrentReleasesChangedSince returns the current releases whose SHA became current after since.
+// updated_at is refreshed by every collection and created_at is kept when a component returns to a SHA
+// recorded before, so the row's changed_at marks when the SHA changed. A zero since returns all current releases.
+func (db *DB) GetCurrentReleasesChangedSince(clientName string, envNames []string, since time.Time) ([]CurrentRelease, error) {
 	// Check if connection is still valid
 	if err := db.conn.Ping(); err != nil {
 		return nil, fmt.Errorf("database connection lost: %w", err)
 	}
 
+	query, args := currentReleasesQuery(clientName, envNames, since)
+	query += " ORDER BY namespace, workload_name, container_name"
+
+	return db.queryCurrentReleases(query, args...)
+}
+
+// GetCurrentReleasesPaginated returns up to limit current releases changed after since (all if since is zero),
+// skipping the first offset, along with the total number of matching releases
+func (db *DB) GetCurrentReleasesPaginated(clientName, envName string, since time.Time, limit, offset int) ([]CurrentRelease, int, error) {
+	// Check if connection is still valid
+	if err := db.conn.Ping(); err != nil {
+		return nil, 0, fmt.Errorf("database connection lost: %w", err)
+	}
+
+	query, args := currentReleasesQuery(clientName, []string{envName}, since)
+
+	var total int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM ("+query+")", args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count current releases: %w", err)
+	}
+
+	query += " ORDER BY namespace, display_order, workload_name, container_name LIMIT ? OFFSET ?"
+	releases, err := db.queryCurrentReleases(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return releases, total, nil
+}
+
+// currentReleasesQuery builds the unordered query selecting the current releases of a client and its
+// environments changed after since; empty names and a zero since disable the corresponding filter
+func currentReleasesQuery(clientName string, envNames []string, since time.Time) (string, []interface{}) {
 	query := `
-	SELECT DISTINCT
-		namespace, workload_name, workload_type, container_name,
-		image_repo, image_name, image_tag, image_sha, client_name, env_name, last_seen
+	SELECT DISTINCT ` + currentReleaseColumns + `
 	FROM releases r1
 	WHERE last_seen = (
 		SELECT MAX(last_seen)
@@ -136,33 +335,226 @@ func (db *DB) GetCurrentReleasesFiltered(clientName, envName string) ([]CurrentR
 		query += " AND client_name = ?"
 		args = append(args, clientName)
 	}
-	if envName != "" {
-		query += " AND env_name = ?"
-		args = append(args, envName)
+	var placeholders []string
+	for _, envName := range envNames {
+		if envName != "" {
+			placeholders = append(placeholders, "?")
+			args = append(args, envName)
+		}
+	}
+	if len(placeholders) > 0 {
+		query += " AND env_name IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if !since.IsZero() {
+		query += " AND datetime(changed_at) > datetime(?)"
+		args = append(args, since.UTC().Format(time.RFC3339))
 	}
 
-	query += " ORDER BY namespace, workload_name, container_name"
+	return query, args
+}
 
+// EachCurrentRelease calls fn with each current release of a client and its environments changed after
+// since (all if since is zero), in listing order, as rows are read. It stops at the first error of fn.
+func (db *DB) EachCurrentRelease(clientName string, envNames []string, since time.Time, fn func(CurrentRelease) error) error {
+	query, args := currentReleasesQuery(clientName, envNames, since)
+	query += " ORDER BY namespace, display_order, workload_name, container_name"
+
+	return db.eachCurrentRelease(query, args, fn)
+}
+
+// queryCurrentReleases runs a query selecting currentReleaseColumns
+func (db *DB) queryCurrentReleases(query string, args ...interface{}) ([]CurrentRelease, error) {
+	var releases []CurrentRelease
+	err := db.eachCurrentRelease(query, args, func(r CurrentRelease) error {
+		releases = append(releases, r)
+		return nil
+	})
+	return releases, err
+}
+
+// eachCurrentRelease runs a query selecting currentReleaseColumns and calls fn with each row
+func (db *DB) eachCurrentRelease(query string, args []interface{}, fn func(CurrentRelease) error) error {
 	rows, err := db.conn.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query current releases: %w", err)
+		return fmt.Errorf("failed to query current releases: %w", err)
 	}
 	defer rows.Close()
 
-	var releases []CurrentRelease
 	for rows.Next() {
-		var r CurrentRelease
-		err := rows.Scan(
-			&r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
-			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.ClientName, &r.EnvName, &r.LastSeen,
-		)
+		r, err := scanCurrentRelease(rows)
 		if err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetReleaseConsistency pivots the current releases of a client across its environments and
+// reports, per component, whether every environment runs the same version. A non-empty envName limits
+// the pivot to that environment.
+func (db *DB) GetReleaseConsistency(clientName, envName string) ([]ComponentConsistency, error) {
+	releases, err := db.GetCurrentReleasesFiltered(clientName, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Releases are ordered by component, so each component's environments are adjacent
+	var components []ComponentConsistency
+	for _, r := range releases {
+		key := ComponentKey{Namespace: r.Namespace, WorkloadName: r.WorkloadName, ContainerName: r.ContainerName}
+		if len(components) == 0 || components[len(components)-1].ComponentKey != key {
+			components = append(components, ComponentConsistency{ComponentKey: key, Consistent: true})
+		}
+
+		c := &components[len(components)-1]
+		if len(c.Environments) > 0 {
+			first := c.Environments[0]
+			if first.ImageTag != r.ImageTag || first.ImageSHA != r.ImageSHA {
+				c.Consistent = false
+			}
+		}
+		c.Environments = append(c.Environments, EnvironmentVersion{
+			EnvName:  r.EnvName,
+			ImageTag: r.ImageTag,
+			ImageSHA: r.ImageSHA,
+		})
+	}
+
+	for i := range components {
+		sort.Slice(components[i].Environments, func(a, b int) bool {
+			return components[i].Environments[a].EnvName < components[i].Environments[b].EnvName
+		})
+	}
+
+	return components, nil
+}
+
+// DiffEnvironments compares the current releases of two environments of a client, component by component
+func (db *DB) DiffEnvironments(clientName, envA, envB string) ([]ComponentDiff, error) {
+	releasesA, err := db.GetCurrentReleasesFiltered(clientName, envA)
+	if err != nil {
+		return nil, err
+	}
+	releasesB, err := db.GetCurrentReleasesFiltered(clientName, envB)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make(map[ComponentKey]*ComponentDiff)
+	for _, r := range releasesA {
+		key := ComponentKey{Namespace: r.Namespace, WorkloadName: r.WorkloadName, ContainerName: r.ContainerName}
+		diffs[key] = &ComponentDiff{
+			ComponentKey: key,
+			Status:       DiffOnlyInA,
+			EnvA:         &EnvironmentVersion{EnvName: r.EnvName, ImageTag: r.ImageTag, ImageSHA: r.ImageSHA},
+		}
+	}
+	for _, r := range releasesB {
+		key := ComponentKey{Namespace: r.Namespace, WorkloadName: r.WorkloadName, ContainerName: r.ContainerName}
+		d, ok := diffs[key]
+		if !ok {
+			d = &ComponentDiff{ComponentKey: key, Status: DiffOnlyInB}
+			diffs[key] = d
+		}
+		d.EnvB = &EnvironmentVersion{EnvName: r.EnvName, ImageTag: r.ImageTag, ImageSHA: r.ImageSHA}
+
+		if d.EnvA != nil {
+			if d.EnvA.ImageTag == d.EnvB.ImageTag && d.EnvA.ImageSHA == d.EnvB.ImageSHA {
+				d.Status = DiffMatch
+			} else {
+				d.Status = DiffDiffers
+			}
+		}
+	}
+
+	components := make([]ComponentDiff, 0, len(diffs))
+	for _, d := range diffs {
+		components = append(components, *d)
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].String() < components[j].String()
+	})
+
+	return components, nil
+}
+
+// GetDetectionLags returns, per component of a client/environment, the delay between the first
+// container starting with each recorded image and the tracker first seeing it. Releases collected
+// before start times were recorded are skipped.
+func (db *DB) GetDetectionLags(clientName, envName string) ([]ComponentDetectionLag, error) {
+	query := `
+	SELECT namespace, workload_name, container_name, image_tag, image_sha, started_at, first_seen
+	FROM releases
+	WHERE client_name = ? AND env_name = ? AND started_at IS NOT NULL
+	ORDER BY namespace, workload_name, container_name, first_seen
+	`
+
+	rows, err := db.conn.Query(query, clientName, envName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query detection lags: %w", err)
+	}
+	defer rows.Close()
+
+	// Rows are ordered by component, so each component's samples are adjacent
+	var components []ComponentDetectionLag
+	for rows.Next() {
+		var key ComponentKey
+		var lag DetectionLag
+		if err := rows.Scan(&key.Namespace, &key.WorkloadName, &key.ContainerName,
+			&lag.ImageTag, &lag.ImageSHA, &lag.StartedAt, &lag.FirstSeen); err != nil {
 			return nil, err
 		}
-		releases = append(releases, r)
+
+		// Clock skew between nodes and the tracker can put first_seen before the start time
+		lag.LagSeconds = math.Max(lag.FirstSeen.Sub(lag.StartedAt).Seconds(), 0)
+
+		if len(components) == 0 || components[len(components)-1].ComponentKey != key {
+			components = append(components, ComponentDetectionLag{ComponentKey: key})
+		}
+		c := &components[len(components)-1]
+		c.Samples = append(c.Samples, lag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return releases, rows.Err()
+	for i := range components {
+		c := &components[i]
+		lags := make([]float64, len(c.Samples))
+		for j, sample := range c.Samples {
+			lags[j] = sample.LagSeconds
+		}
+		c.MinSeconds, c.MedianSeconds, c.MeanSeconds, c.MaxSeconds = LagStats(lags)
+	}
+
+	return components, nil
+}
+
+// LagStats returns the minimum, median, mean and maximum of a set of lags in seconds (all zero when empty)
+func LagStats(lags []float64) (min, median, mean, max float64) {
+	if len(lags) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	sorted := append([]float64(nil), lags...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, lag := range sorted {
+		sum += lag
+	}
+
+	mid := len(sorted) / 2
+	median = sorted[mid]
+	if len(sorted)%2 == 0 {
+		median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	return sorted[0], median, sum / float64(len(sorted)), sorted[len(sorted)-1]
 }
 
 // GetAvailableClientsAndEnvironments returns all unique client/environment combinations
@@ -216,9 +608,7 @@ func (db *DB) GetCurrentReleaseByWorkload(workloadType, workloadName, containerN
 	}
 
 	query := `
-	SELECT DISTINCT
-		namespace, workload_name, workload_type, container_name,
-		image_repo, image_name, image_tag, image_sha, client_name, env_name, last_seen
+	SELECT DISTINCT ` + currentReleaseColumns + `
 	FROM releases r1
 	WHERE workload_type = ? AND workload_name = ? AND container_name = ?
 	AND client_name = ? AND env_name = ?
@@ -242,11 +632,7 @@ func (db *DB) GetCurrentReleaseByWorkload(workloadType, workloadName, containerN
 
 	var releases []CurrentRelease
 	for rows.Next() {
-		var r CurrentRelease
-		err := rows.Scan(
-			&r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
-			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.ClientName, &r.EnvName, &r.LastSeen,
-		)
+		r, err := scanCurrentRelease(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -274,19 +660,75 @@ func (db *DB) GetCurrentReleaseByWorkload(workloadType, workloadName, containerN
 	return &releases[0], nil
 }
 
-// GetReleaseHistory returns the release history for a specific component
-func (db *DB) GetReleaseHistory(namespace, workloadName, containerName, clientName, envName string) (*ReleaseHistory, error) {
+// GetCurrentReleasesForWorkload returns the current release of every container of a workload, ordered by
+// container name. Like GetCurrentReleaseByWorkload, it fails when the workload runs in several namespaces.
+func (db *DB) GetCurrentReleasesForWorkload(workloadType, workloadName, clientName, envName string) ([]CurrentRelease, error) {
 	query := `
-	SELECT id, namespace, workload_name, workload_type, container_name,
-		   image_repo, image_name, image_tag, image_sha, client_name, env_name,
-		   first_seen, last_seen, created_at, updated_at
+	SELECT DISTINCT ` + currentReleaseColumns + `
+	FROM releases r1
+	WHERE workload_type = ? AND workload_name = ?
+	AND client_name = ? AND env_name = ?
+	AND last_seen = (
+		SELECT MAX(last_seen)
+		FROM releases r2
+		WHERE r2.namespace = r1.namespace
+		AND r2.workload_name = r1.workload_name
+		AND r2.container_name = r1.container_name
+		AND r2.client_name = r1.client_name
+		AND r2.env_name = r1.env_name
+	)
+	ORDER BY namespace, container_name
+	`
+
+	rows, err := db.conn.Query(query, workloadType, workloadName, clientName, envName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query current releases of workload: %w", err)
+	}
+	defer rows.Close()
+
+	var releases []CurrentRelease
+	for rows.Next() {
+		r, err := scanCurrentRelease(rows)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range releases {
+		if r.Namespace != releases[0].Namespace {
+			return nil, fmt.Errorf("multiple releases found for %s/%s in namespaces: %s, %s",
+				workloadType, workloadName, releases[0].Namespace, r.Namespace)
+		}
+	}
+
+	return releases, nil
+}
+
+// GetReleaseHistory returns the limit most recent releases of a specific component, or the
+// DefaultKeepReleases most recent if limit is not positive
+func (db *DB) GetReleaseHistory(namespace, workloadName, containerName, clientName, envName string, limit int) (*ReleaseHistory, error) {
+	if limit <= 0 {
+		limit = DefaultKeepReleases
+	}
+	return db.GetReleaseHistoryPage(namespace, workloadName, containerName, clientName, envName, limit, 0)
+}
+
+// GetReleaseHistoryPage returns up to limit releases of a component, most recent first, skipping the first offset.
+// It returns nil when the component has never been recorded; an offset past its releases gives an empty page.
+func (db *DB) GetReleaseHistoryPage(namespace, workloadName, containerName, clientName, envName string, limit, offset int) (*ReleaseHistory, error) {
+	query := `
+	SELECT ` + releaseColumns + `
 	FROM releases
 	WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ?
 	ORDER BY last_seen DESC
-	LIMIT 10
+	LIMIT ? OFFSET ?
 	`
 
-	rows, err := db.conn.Query(query, namespace, workloadName, containerName, clientName, envName)
+	rows, err := db.conn.Query(query, namespace, workloadName, containerName, clientName, envName, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -294,112 +736,383 @@ func (db *DB) GetReleaseHistory(namespace, workloadName, containerName, clientNa
 
 	var releases []Release
 	for rows.Next() {
-		var r Release
-		err := rows.Scan(
-			&r.ID, &r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
-			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.ClientName, &r.EnvName,
-			&r.FirstSeen, &r.LastSeen, &r.CreatedAt, &r.UpdatedAt,
-		)
+		r, err := scanRelease(rows)
 		if err != nil {
 			return nil, err
 		}
 		releases = append(releases, r)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(releases) == 0 {
+		var exists bool
+		err := db.conn.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM releases
+			WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ?
+		)`, namespace, workloadName, containerName, clientName, envName).Scan(&exists)
+		if err != nil || !exists {
+			return nil, err
+		}
+	}
 
 	return &ReleaseHistory{
 		Releases: releases,
 		Total:    len(releases),
-	}, rows.Err()
+	}, nil
 }
 
-// CleanupOldReleases removes old releases, keeping only the 10 most recent per component
-func (db *DB) CleanupOldReleases() error {
+// GetReleasesAfterID returns up to limit releases with an id greater than afterID, ordered by id.
+// It is used to cursor through the whole releases table in bounded batches.
+func (db *DB) GetReleasesAfterID(afterID, limit int) ([]Release, error) {
 	query := `
-	DELETE FROM releases
-	WHERE id NOT IN (
-		SELECT id FROM (
-			SELECT id,
-				ROW_NUMBER() OVER (
-					PARTITION BY namespace, workload_name, container_name
-					ORDER BY last_seen DESC
-				) as rn
-			FROM releases
-		) ranked
-		WHERE rn <= 10
-	)
+	SELECT ` + releaseColumns + `
+	FROM releases
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?
 	`
 
-	result, err := db.conn.Exec(query)
+	rows, err := db.conn.Query(query, afterID, limit)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	rowsAffected, _ := result.RowsAffected()
-	log.Printf("Cleaned up %d old release records", rowsAffected)
+	var releases []Release
+	for rows.Next() {
+		r, err := scanRelease(rows)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
 
-	return nil
+	return releases, rows.Err()
 }
 
-// UpsertPendingRelease inserts or updates a pending release record (used in slave mode)
-func (db *DB) UpsertPendingRelease(release *PendingRelease) error {
-	now := time.Now().Format(time.RFC3339)
-
+// GetReleaseProvenance returns when and by whom the current release of a component was recorded.
+// Returns nil if the component has never been seen.
+func (db *DB) GetReleaseProvenance(namespace, workloadName, containerName, clientName, envName string) (*ReleaseProvenance, error) {
 	query := `
-	INSERT INTO pending_releases (
-		namespace, workload_name, workload_type, container_name,
-		image_repo, image_name, image_tag, image_sha, client_name, env_name,
-		first_seen, last_seen, created_at, updated_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(namespace, workload_name, container_name, client_name, env_name, image_sha)
-	DO UPDATE SET
-		last_seen = ?,
-		updated_at = ?
+	SELECT image_tag, image_sha, reported_by, first_seen, last_seen, updated_at
+	FROM releases
+	WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ?
+	ORDER BY last_seen DESC
+	LIMIT 1
 	`
 
-	_, err := db.conn.Exec(query,
-		release.Namespace, release.WorkloadName, release.WorkloadType, release.ContainerName,
-		release.ImageRepo, release.ImageName, release.ImageTag, release.ImageSHA, release.ClientName, release.EnvName,
-		release.FirstSeen.Format(time.RFC3339), release.LastSeen.Format(time.RFC3339), now, now,
-		release.LastSeen.Format(time.RFC3339), now,
+	var p ReleaseProvenance
+	err := db.conn.QueryRow(query, namespace, workloadName, containerName, clientName, envName).Scan(
+		&p.ImageTag, &p.ImageSHA, &p.ReportedBy, &p.FirstSeen, &p.LastSeen, &p.RecordedAt,
 	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query release provenance: %w", err)
+	}
 
-	return err
+	return &p, nil
 }
 
-// GetPendingReleases returns all pending releases (used in slave mode)
-func (db *DB) GetPendingReleases() ([]PendingRelease, error) {
+// GetReleaseByTag returns when the given tag was deployed for a component and whether it is still current.
+// A tag may have been deployed under several SHAs; first_seen/last_seen span all of them.
+// Returns nil if the tag was never seen for the component.
+func (db *DB) GetReleaseByTag(namespace, workloadName, containerName, clientName, envName, imageTag string) (*TagDeployment, error) {
 	query := `
-	SELECT id, namespace, workload_name, workload_type, container_name,
-		   image_repo, image_name, image_tag, image_sha, client_name, env_name,
-		   first_seen, last_seen, created_at, updated_at
-	FROM pending_releases
-	WHERE length(image_sha) > 0
-	ORDER BY created_at ASC
+	SELECT image_sha, first_seen, last_seen
+	FROM releases
+	WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ? AND image_tag = ?
+	ORDER BY last_seen DESC
 	`
 
-	rows, err := db.conn.Query(query)
+	rows, err := db.conn.Query(query, namespace, workloadName, containerName, clientName, envName, imageTag)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to query releases by tag: %w", err)
 	}
 	defer rows.Close()
 
-	var releases []PendingRelease
+	var deployment *TagDeployment
 	for rows.Next() {
-		var r PendingRelease
-		err := rows.Scan(
-			&r.ID, &r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
-			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.ClientName, &r.EnvName,
-			&r.FirstSeen, &r.LastSeen, &r.CreatedAt, &r.UpdatedAt,
-		)
-		if err != nil {
+		var imageSHA string
+		var firstSeen, lastSeen time.Time
+		if err := rows.Scan(&imageSHA, &firstSeen, &lastSeen); err != nil {
 			return nil, err
 		}
-		releases = append(releases, r)
+
+		if deployment == nil {
+			deployment = &TagDeployment{ImageTag: imageTag, ImageSHA: imageSHA, FirstSeen: firstSeen, LastSeen: lastSeen}
+			continue
+		}
+		if firstSeen.Before(deployment.FirstSeen) {
+			deployment.FirstSeen = firstSeen
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if deployment == nil {
+		return nil, nil
+	}
+
+	current, err := db.GetReleaseProvenance(namespace, workloadName, containerName, clientName, envName)
+	if err != nil {
+		return nil, err
+	}
+	deployment.Current = current != nil && current.ImageTag == imageTag && current.ImageSHA == deployment.ImageSHA
+
+	return deployment, nil
+}
+
+// DefaultKeepReleases is the default number of releases kept per component for clients without a
+// retention policy (HISTORY_RETENTION_COUNT)
+const DefaultKeepReleases = 10
+
+// CleanupOldReleases removes old releases according to the retention policy of each client, by default
+// keeping only the keepCount most recent per component (DefaultKeepReleases if keepCount is not positive).
+// The current release of a component is never removed. Rows recorded less than minAge ago are kept even
+// beyond the policy, so that rows written by an import still in progress are not deleted under it; 0
+// disables the protection.
+func (db *DB) CleanupOldReleases(keepCount int, minAge time.Duration) error {
+	if keepCount <= 0 {
+		keepCount = DefaultKeepReleases
+	}
+	args := []interface{}{keepCount}
+	ageFilter := ""
+	if minAge > 0 {
+		ageFilter = "datetime(created_at) < datetime(?) AND"
+		args = append(args, time.Now().Add(-minAge).UTC().Format(time.RFC3339))
+	}
+
+	query := `
+	WITH ranked AS (
+		SELECT r.id, r.last_seen,
+			ROW_NUMBER() OVER (
+				PARTITION BY r.namespace, r.workload_name, r.container_name, r.client_name, r.env_name
+				ORDER BY r.last_seen DESC
+			) as rn,
+			CASE WHEN cr.client_name IS NULL THEN ? ELSE cr.keep_count END as keep_count,
+			cr.keep_days
+		FROM releases r
+		LEFT JOIN client_retention cr ON cr.client_name = r.client_name
+	)
+	DELETE FROM releases
+	WHERE ` + ageFilter + ` id IN (
+		SELECT id FROM ranked
+		WHERE rn > 1 AND (
+			(keep_count IS NOT NULL AND rn > keep_count) OR
+			(keep_days IS NOT NULL AND datetime(last_seen) < datetime('now', '-' || keep_days || ' days'))
+		)
+	)
+	`
+
+	result, err := db.conn.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	slog.Info("Cleaned up old release records", "count", rowsAffected)
+
+	return nil
+}
+
+// SetClientRetention creates or replaces the retention policy of a client
+func (db *DB) SetClientRetention(retention *ClientRetention) error {
+	query := `
+	INSERT INTO client_retention (client_name, keep_count, keep_days, updated_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(client_name) DO UPDATE SET
+		keep_count = excluded.keep_count,
+		keep_days = excluded.keep_days,
+		updated_at = excluded.updated_at
+	`
+
+	retention.UpdatedAt = time.Now().UTC()
+	if _, err := db.conn.Exec(query, retention.ClientName, retention.KeepCount, retention.KeepDays, retention.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to set retention of client %s: %w", retention.ClientName, err)
+	}
+	return nil
+}
+
+// GetClientRetentions returns the retention policies of all clients that have one
+func (db *DB) GetClientRetentions() ([]ClientRetention, error) {
+	rows, err := db.conn.Query(`SELECT client_name, keep_count, keep_days, updated_at FROM client_retention ORDER BY client_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query client retention: %w", err)
+	}
+	defer rows.Close()
+
+	var retentions []ClientRetention
+	for rows.Next() {
+		var retention ClientRetention
+		var keepCount, keepDays sql.NullInt64
+		if err := rows.Scan(&retention.ClientName, &keepCount, &keepDays, &retention.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if keepCount.Valid {
+			count := int(keepCount.Int64)
+			retention.KeepCount = &count
+		}
+		if keepDays.Valid {
+			days := int(keepDays.Int64)
+			retention.KeepDays = &days
+		}
+		retentions = append(retentions, retention)
+	}
+
+	return retentions, rows.Err()
+}
+
+// DeleteClientRetention removes the retention policy of a client, which falls back to the default.
+// It reports whether the client had a policy.
+func (db *DB) DeleteClientRetention(clientName string) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM client_retention WHERE client_name = ?`, clientName)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete retention of client %s: %w", clientName, err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// PruneStaleComponents deletes all releases of components whose newest release was last seen longer
+// ago than threshold, returning the number of rows deleted
+func (db *DB) PruneStaleComponents(threshold time.Duration) (int64, error) {
+	query := `
+	DELETE FROM releases
+	WHERE (namespace, workload_name, container_name, client_name, env_name) IN (
+		SELECT namespace, workload_name, container_name, client_name, env_name
+		FROM releases
+		GROUP BY namespace, workload_name, container_name, client_name, env_name
+		HAVING MAX(datetime(last_seen)) < datetime(?)
+	)
+	`
+
+	result, err := db.conn.Exec(query, time.Now().Add(-threshold).UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	slog.Info("Pruned releases of stale components", "count", rowsAffected)
+
+	return rowsAffected, nil
+}
+
+// UpsertPendingRelease inserts or updates a pending release record (used in slave mode). Releases
+// moved to failed_releases are not queued again until they are purged from it.
+func (db *DB) UpsertPendingRelease(release *PendingRelease) error {
+	now := time.Now().Format(time.RFC3339)
+
+	query := `
+	INSERT INTO pending_releases (
+		namespace, workload_name, workload_type, container_name,
+		image_repo, image_name, image_tag, image_sha, spec_digest, client_name, env_name, cluster_name,
+		chart_version, app_version, display_order, image_labels, annotations, replicas, ready_replicas, started_at, first_seen, last_seen, created_at, updated_at
+	) SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+	WHERE NOT EXISTS (
+		SELECT 1 FROM failed_releases
+		WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ? AND image_sha = ?
+	)
+	ON CONFLICT(namespace, workload_name, container_name, client_name, env_name, image_sha)
+	DO UPDATE SET
+		spec_digest = excluded.spec_digest,
+		cluster_name = excluded.cluster_name,
+		chart_version = excluded.chart_version,
+		app_version = excluded.app_version,
+		display_order = excluded.display_order,
+		image_labels = CASE WHEN excluded.image_labels != '' THEN excluded.image_labels ELSE pending_releases.image_labels END,
+		annotations = CASE WHEN excluded.annotations != '' THEN excluded.annotations ELSE pending_releases.annotations END,
+		replicas = COALESCE(excluded.replicas, pending_releases.replicas),
+		ready_replicas = COALESCE(excluded.ready_replicas, pending_releases.ready_replicas),
+		started_at = COALESCE(pending_releases.started_at, excluded.started_at),
+		last_seen = ?,
+		updated_at = ?
+	`
+
+	_, err := db.conn.Exec(query,
+		release.Namespace, release.WorkloadName, release.WorkloadType, release.ContainerName,
+		release.ImageRepo, release.ImageName, release.ImageTag, release.ImageSHA, release.SpecDigest, release.ClientName, release.EnvName, release.ClusterName,
+		release.ChartVersion, release.AppVersion, release.DisplayOrder, release.ImageLabels, release.Annotations, release.Replicas, release.ReadyReplicas, nullableTime(release.StartedAt), release.FirstSeen.Format(time.RFC3339), release.LastSeen.Format(time.RFC3339), now, now,
+		release.Namespace, release.WorkloadName, release.ContainerName, release.ClientName, release.EnvName, release.ImageSHA,
+		release.LastSeen.Format(time.RFC3339), now,
+	)
+
+	return err
+}
+
+// GetPendingReleases returns all pending releases (used in slave mode)
+func (db *DB) GetPendingReleases() ([]PendingRelease, error) {
+	query := `
+	SELECT id, namespace, workload_name, workload_type, container_name,
+		   image_repo, image_name, image_tag, image_sha, spec_digest, client_name, env_name, cluster_name,
+		   chart_version, app_version, display_order, image_labels, annotations, replicas, ready_replicas, started_at, sync_attempts, last_error, first_seen, last_seen, created_at, updated_at
+	FROM pending_releases
+	WHERE length(image_sha) > 0
+	ORDER BY created_at ASC
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var releases []PendingRelease
+	for rows.Next() {
+		var r PendingRelease
+		var startedAt sql.NullTime
+		var replicas, readyReplicas sql.NullInt64
+		err := rows.Scan(
+			&r.ID, &r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
+			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.SpecDigest, &r.ClientName, &r.EnvName, &r.ClusterName,
+			&r.ChartVersion, &r.AppVersion, &r.DisplayOrder, &r.ImageLabels, &r.Annotations, &replicas, &readyReplicas, &startedAt, &r.SyncAttempts, &r.LastError, &r.FirstSeen, &r.LastSeen, &r.CreatedAt, &r.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.StartedAt = nullTimePtr(startedAt)
+		r.Replicas, r.ReadyReplicas = nullIntPtr(replicas), nullIntPtr(readyReplicas)
+		releases = append(releases, r)
 	}
 
 	return releases, rows.Err()
 }
 
+// CountPendingReleases returns the number of releases waiting to be synced (used in slave mode)
+func (db *DB) CountPendingReleases() (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM pending_releases WHERE length(image_sha) > 0`).Scan(&count)
+	return count, err
+}
+
+// GetPendingReleaseStats summarizes the releases waiting to be synced, so a growing backlog (e.g. master
+// unreachable) can be alerted on (used in slave mode)
+func (db *DB) GetPendingReleaseStats() (*PendingReleaseStats, error) {
+	stats := &PendingReleaseStats{}
+	err := db.conn.QueryRow(`
+	SELECT COUNT(*), COALESCE(SUM(CASE WHEN length(image_sha) > 0 THEN 1 ELSE 0 END), 0)
+	FROM pending_releases
+	`).Scan(&stats.Count, &stats.Syncable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pending releases: %w", err)
+	}
+	if stats.Count == 0 {
+		return stats, nil
+	}
+
+	var oldest time.Time
+	err = db.conn.QueryRow(`SELECT created_at FROM pending_releases ORDER BY created_at LIMIT 1`).Scan(&oldest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get oldest pending release: %w", err)
+	}
+	stats.OldestCreatedAt = &oldest
+	return stats, nil
+}
+
 // DeletePendingRelease removes a pending release by ID (used in slave mode after successful sync)
 func (db *DB) DeletePendingRelease(id int) error {
 	query := `DELETE FROM pending_releases WHERE id = ?`
@@ -407,34 +1120,435 @@ func (db *DB) DeletePendingRelease(id int) error {
 	return err
 }
 
-// UpsertSlavePing inserts or updates a slave ping record
-func (db *DB) UpsertSlavePing(clientName, envName, slaveVersion string) error {
+// RecordSyncFailure counts a failed sync attempt of a pending release and returns its number of attempts
+func (db *DB) RecordSyncFailure(id int, lastError string) (int, error) {
+	query := `
+	UPDATE pending_releases SET sync_attempts = sync_attempts + 1, last_error = ?
+	WHERE id = ?
+	RETURNING sync_attempts
+	`
+	var attempts int
+	err := db.conn.QueryRow(query, lastError, id).Scan(&attempts)
+	return attempts, err
+}
+
+// MoveToFailedReleases moves a pending release master keeps rejecting to failed_releases
+func (db *DB) MoveToFailedReleases(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	query := `
+	INSERT INTO failed_releases (
+		namespace, workload_name, workload_type, container_name,
+		image_repo, image_name, image_tag, image_sha, client_name, env_name, cluster_name,
+		sync_attempts, last_error, first_seen, last_seen, failed_at
+	)
+	SELECT namespace, workload_name, workload_type, container_name,
+		   image_repo, image_name, image_tag, image_sha, client_name, env_name, cluster_name,
+		   sync_attempts, last_error, first_seen, last_seen, ?
+	FROM pending_releases
+	WHERE id = ?
+	ON CONFLICT(namespace, workload_name, container_name, client_name, env_name, image_sha)
+	DO UPDATE SET
+		sync_attempts = excluded.sync_attempts,
+		last_error = excluded.last_error,
+		last_seen = excluded.last_seen,
+		failed_at = excluded.failed_at
+	`
+	if _, err := tx.Exec(query, time.Now().Format(time.RFC3339), id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to store failed release: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_releases WHERE id = ?`, id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete pending release: %w", err)
+	}
+	return tx.Commit()
+}
+
+// InsertFailedRelease stores a release that could not be synced to master
+func (db *DB) InsertFailedRelease(release *FailedRelease) error {
+	failedAt := release.FailedAt
+	if failedAt.IsZero() {
+		failedAt = time.Now()
+	}
+
+	query := `
+	INSERT INTO failed_releases (
+		namespace, workload_name, workload_type, container_name,
+		image_repo, image_name, image_tag, image_sha, client_name, env_name, cluster_name,
+		sync_attempts, last_error, first_seen, last_seen, failed_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(namespace, workload_name, container_name, client_name, env_name, image_sha)
+	DO UPDATE SET
+		sync_attempts = excluded.sync_attempts,
+		last_error = excluded.last_error,
+		last_seen = excluded.last_seen,
+		failed_at = excluded.failed_at
+	`
+
+	_, err := db.conn.Exec(query,
+		release.Namespace, release.WorkloadName, release.WorkloadType, release.ContainerName,
+		release.ImageRepo, release.ImageName, release.ImageTag, release.ImageSHA, release.ClientName, release.EnvName, release.ClusterName,
+		release.SyncAttempts, release.LastError, release.FirstSeen.Format(time.RFC3339), release.LastSeen.Format(time.RFC3339),
+		failedAt.Format(time.RFC3339),
+	)
+
+	return err
+}
+
+// GetFailedReleases returns all failed releases, most recently failed first
+func (db *DB) GetFailedReleases() ([]FailedRelease, error) {
+	query := `
+	SELECT id, namespace, workload_name, workload_type, container_name,
+		   image_repo, image_name, image_tag, image_sha, client_name, env_name, cluster_name,
+		   sync_attempts, last_error, first_seen, last_seen, failed_at
+	FROM failed_releases
+	ORDER BY failed_at DESC
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var releases []FailedRelease
+	for rows.Next() {
+		var r FailedRelease
+		err := rows.Scan(
+			&r.ID, &r.Namespace, &r.WorkloadName, &r.WorkloadType, &r.ContainerName,
+			&r.ImageRepo, &r.ImageName, &r.ImageTag, &r.ImageSHA, &r.ClientName, &r.EnvName, &r.ClusterName,
+			&r.SyncAttempts, &r.LastError, &r.FirstSeen, &r.LastSeen, &r.FailedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+
+	return releases, rows.Err()
+}
+
+// PurgeFailedReleases deletes failed releases that failed before the given time and returns the number removed
+func (db *DB) PurgeFailedReleases(before time.Time) (int64, error) {
+	query := `DELETE FROM failed_releases WHERE datetime(failed_at) < datetime(?)`
+	result, err := db.conn.Exec(query, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteComponent removes every recorded release of a component, returning the number of rows deleted
+func (db *DB) DeleteComponent(namespace, workloadName, containerName, clientName, envName string) (int64, error) {
+	query := `
+	DELETE FROM releases
+	WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ?
+	`
+	result, err := db.conn.Exec(query, namespace, workloadName, containerName, clientName, envName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteAllFailedReleases deletes every failed release and returns the number removed
+func (db *DB) DeleteAllFailedReleases() (int64, error) {
+	result, err := db.conn.Exec(`DELETE FROM failed_releases`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// NormalizeClientEnvNames trims stored client and environment names and, if lowercase is set, lowercases
+// them. Rows that become duplicates of an already normalized row are merged into it.
+func (db *DB) NormalizeClientEnvNames(lowercase bool) error {
+	expr := "trim(%s)"
+	if lowercase {
+		expr = "lower(trim(%s))"
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.Exec(normalizeClientEnvSQL(expr)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to normalize client and environment names: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RecordCollectionError inserts or updates the collection error for a component
+func (db *DB) RecordCollectionError(collectionError *CollectionError) error {
+	now := time.Now().Format(time.RFC3339)
+
+	query := `
+	INSERT INTO collection_errors (
+		namespace, workload_name, workload_type, container_name,
+		client_name, env_name, error, category, first_seen, last_seen
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(namespace, workload_name, container_name, client_name, env_name)
+	DO UPDATE SET
+		workload_type = ?,
+		error = ?,
+		category = ?,
+		last_seen = ?
+	`
+
+	category := collectionError.Category
+	if category == "" {
+		category = CollectionErrorUnresolved
+	}
+
+	_, err := db.conn.Exec(query,
+		collectionError.Namespace, collectionError.WorkloadName, collectionError.WorkloadType, collectionError.ContainerName,
+		collectionError.ClientName, collectionError.EnvName, collectionError.Error, category, now, now,
+		collectionError.WorkloadType, collectionError.Error, category, now,
+	)
+
+	return err
+}
+
+// ClearCollectionError removes the collection error for a component once it has been collected successfully
+func (db *DB) ClearCollectionError(namespace, workloadName, containerName, clientName, envName string) error {
+	query := `
+	DELETE FROM collection_errors
+	WHERE namespace = ? AND workload_name = ? AND container_name = ? AND client_name = ? AND env_name = ?
+	`
+	_, err := db.conn.Exec(query, namespace, workloadName, containerName, clientName, envName)
+	return err
+}
+
+// RecordScalingEvent stores the replica count of a workload when it differs from the last one recorded,
+// and reports whether an event was stored. The first count seen is stored without a previous count.
+func (db *DB) RecordScalingEvent(event *ScalingEvent) (bool, error) {
+	var previous int
+	err := db.conn.QueryRow(`
+	SELECT replicas FROM scaling_events
+	WHERE client_name = ? AND env_name = ? AND namespace = ? AND workload_name = ?
+	ORDER BY recorded_at DESC, id DESC
+	LIMIT 1
+	`, event.ClientName, event.EnvName, event.Namespace, event.WorkloadName).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		event.PreviousReplicas = nil
+	case err != nil:
+		return false, fmt.Errorf("failed to query last scaling event: %w", err)
+	case previous == event.Replicas:
+		return false, nil
+	default:
+		event.PreviousReplicas = &previous
+	}
+
+	if event.RecordedAt.IsZero() {
+		event.RecordedAt = time.Now()
+	}
+	_, err = db.conn.Exec(`
+	INSERT INTO scaling_events (
+		client_name, env_name, namespace, workload_name, workload_type, replicas, previous_replicas, recorded_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.ClientName, event.EnvName, event.Namespace, event.WorkloadName, event.WorkloadType,
+		event.Replicas, event.PreviousReplicas, event.RecordedAt.Format(time.RFC3339))
+	if err != nil {
+		return false, fmt.Errorf("failed to insert scaling event: %w", err)
+	}
+	return true, nil
+}
+
+// GetScalingHistory returns up to limit scaling events of a workload, most recent first
+func (db *DB) GetScalingHistory(clientName, envName, namespace, workloadName string, limit int) ([]ScalingEvent, error) {
+	query := `
+	SELECT id, namespace, workload_name, workload_type, client_name, env_name, replicas, previous_replicas, recorded_at
+	FROM scaling_events
+	WHERE client_name = ? AND env_name = ? AND namespace = ? AND workload_name = ?
+	ORDER BY recorded_at DESC, id DESC
+	LIMIT ?
+	`
+
+	rows, err := db.conn.Query(query, clientName, envName, namespace, workloadName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scaling history: %w", err)
+	}
+	defer rows.Close()
+
+	var events []ScalingEvent
+	for rows.Next() {
+		var e ScalingEvent
+		var previous sql.NullInt64
+		err := rows.Scan(
+			&e.ID, &e.Namespace, &e.WorkloadName, &e.WorkloadType, &e.ClientName, &e.EnvName,
+			&e.Replicas, &previous, &e.RecordedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if previous.Valid {
+			replicas := int(previous.Int64)
+			e.PreviousReplicas = &replicas
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// GetCollectionGaps returns the components that failed collection for a client/environment,
+// flagging those that still have an older release stored
+func (db *DB) GetCollectionGaps(clientName, envName string) ([]CollectionGap, error) {
+	query := `
+	SELECT ce.id, ce.namespace, ce.workload_name, ce.workload_type, ce.container_name,
+		   ce.client_name, ce.env_name, ce.error, ce.category, ce.first_seen, ce.last_seen,
+		   EXISTS (
+			   SELECT 1 FROM releases r
+			   WHERE r.namespace = ce.namespace
+			   AND r.workload_name = ce.workload_name
+			   AND r.container_name = ce.container_name
+			   AND r.client_name = ce.client_name
+			   AND r.env_name = ce.env_name
+		   ) AS has_release
+	FROM collection_errors ce
+	WHERE ce.client_name = ? AND ce.env_name = ?
+	ORDER BY ce.namespace, ce.workload_name, ce.container_name
+	`
+
+	rows, err := db.conn.Query(query, clientName, envName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection gaps: %w", err)
+	}
+	defer rows.Close()
+
+	var gaps []CollectionGap
+	for rows.Next() {
+		var g CollectionGap
+		err := rows.Scan(
+			&g.ID, &g.Namespace, &g.WorkloadName, &g.WorkloadType, &g.ContainerName,
+			&g.ClientName, &g.EnvName, &g.Error, &g.Category, &g.FirstSeen, &g.LastSeen,
+			&g.HasRelease,
+		)
+		if err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps, rows.Err()
+}
+
+// GetGhostWorkloads returns the workloads of a client/environment that the collector found without any
+// running pods, grouping the collection errors of their containers
+func (db *DB) GetGhostWorkloads(clientName, envName string) ([]GhostWorkload, error) {
+	gaps, err := db.GetCollectionGaps(clientName, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ghosts []GhostWorkload
+	index := make(map[string]int)
+	for _, g := range gaps {
+		if g.Category != CollectionErrorNoRunningPods {
+			continue
+		}
+
+		key := g.Namespace + "/" + g.WorkloadType + "/" + g.WorkloadName
+		i, ok := index[key]
+		if !ok {
+			i = len(ghosts)
+			index[key] = i
+			ghosts = append(ghosts, GhostWorkload{
+				Namespace:    g.Namespace,
+				WorkloadName: g.WorkloadName,
+				WorkloadType: g.WorkloadType,
+				ClientName:   g.ClientName,
+				EnvName:      g.EnvName,
+				Error:        g.Error,
+				FirstSeen:    g.FirstSeen,
+				LastSeen:     g.LastSeen,
+			})
+		}
+
+		ghost := &ghosts[i]
+		ghost.Containers = append(ghost.Containers, g.ContainerName)
+		ghost.HasRelease = ghost.HasRelease || g.HasRelease
+		if g.FirstSeen.Before(ghost.FirstSeen) {
+			ghost.FirstSeen = g.FirstSeen
+		}
+		if g.LastSeen.After(ghost.LastSeen) {
+			ghost.LastSeen = g.LastSeen
+			ghost.Error = g.Error
+		}
+	}
+
+	return ghosts, nil
+}
+
+// UpsertSlavePing inserts or updates a slave ping record, along with the namespaces the slave watches
+// and its collection interval in minutes as last reported. A ping with the "offline" status, sent by a
+// slave shutting down, marks the slave offline until its next ping; any other status is stored as online.
+func (db *DB) UpsertSlavePing(ping *SlavePing) error {
 	now := time.Now().Format(time.RFC3339)
+	watched := strings.Join(ping.Namespaces, ",")
+	status := "online"
+	if ping.Status == "offline" {
+		status = "offline"
+	}
 
 	query := `
 	INSERT INTO slave_pings (
-		client_name, env_name, last_ping_time, status, slave_version, created_at, updated_at
-	) VALUES (?, ?, ?, 'online', ?, ?, ?)
+		client_name, env_name, cluster_name, last_ping_time, status, slave_version, namespaces, collection_interval, created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(client_name, env_name)
 	DO UPDATE SET
+		cluster_name = ?,
 		last_ping_time = ?,
-		status = 'online',
+		status = ?,
 		slave_version = ?,
+		namespaces = ?,
+		collection_interval = ?,
 		updated_at = ?
 	`
 
 	_, err := db.conn.Exec(query,
-		clientName, envName, now, slaveVersion, now, now,
-		now, slaveVersion, now,
+		ping.ClientName, ping.EnvName, ping.ClusterName, now, status, ping.SlaveVersion, watched, ping.CollectionInterval, now, now,
+		ping.ClusterName, now, status, ping.SlaveVersion, watched, ping.CollectionInterval, now,
 	)
 
 	return err
 }
 
+// SetPingThresholds sets how long after its last ping a slave is reported as "warning" and "offline";
+// non-positive values keep the current thresholds
+func (db *DB) SetPingThresholds(warning, offline time.Duration) {
+	if warning > 0 {
+		db.pingWarning = warning
+	}
+	if offline > 0 {
+		db.pingOffline = offline
+	}
+}
+
+// pingStatus returns the status of a slave whose last ping is timeSinceLastPing old and stored the
+// given status; slaves that announced their shutdown stay offline
+func (db *DB) pingStatus(stored string, timeSinceLastPing time.Duration) string {
+	if stored == "offline" {
+		return "offline"
+	}
+	if timeSinceLastPing <= db.pingWarning {
+		return "online"
+	} else if timeSinceLastPing <= db.pingOffline {
+		return "warning"
+	}
+	return "offline"
+}
+
 // GetSlavePings returns all slave ping records with calculated status
 func (db *DB) GetSlavePings() ([]SlavePing, error) {
 	query := `
-	SELECT id, client_name, env_name, last_ping_time, status, slave_version, created_at, updated_at
+	SELECT id, client_name, env_name, cluster_name, last_ping_time, status, slave_version, namespaces, collection_interval, created_at, updated_at
 	FROM slave_pings
 	ORDER BY client_name, env_name
 	`
@@ -450,23 +1564,20 @@ func (db *DB) GetSlavePings() ([]SlavePing, error) {
 
 	for rows.Next() {
 		var ping SlavePing
+		var namespaces string
 		err := rows.Scan(
-			&ping.ID, &ping.ClientName, &ping.EnvName, &ping.LastPingTime,
-			&ping.Status, &ping.SlaveVersion, &ping.CreatedAt, &ping.UpdatedAt,
+			&ping.ID, &ping.ClientName, &ping.EnvName, &ping.ClusterName, &ping.LastPingTime,
+			&ping.Status, &ping.SlaveVersion, &namespaces, &ping.CollectionInterval, &ping.CreatedAt, &ping.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if namespaces != "" {
+			ping.Namespaces = strings.Split(namespaces, ",")
+		}
 
 		// Calculate current status based on last ping time
-		timeSinceLastPing := now.Sub(ping.LastPingTime)
-		if timeSinceLastPing <= 10*time.Minute {
-			ping.Status = "online"
-		} else if timeSinceLastPing <= 15*time.Minute {
-			ping.Status = "warning"
-		} else {
-			ping.Status = "offline"
-		}
+		ping.Status = db.pingStatus(ping.Status, now.Sub(ping.LastPingTime))
 
 		pings = append(pings, ping)
 	}
@@ -477,13 +1588,14 @@ func (db *DB) GetSlavePings() ([]SlavePing, error) {
 // GetSlavePingStatus returns the status for a specific client/environment
 func (db *DB) GetSlavePingStatus(clientName, envName string) (string, time.Time, error) {
 	query := `
-	SELECT last_ping_time
+	SELECT last_ping_time, status
 	FROM slave_pings
 	WHERE client_name = ? AND env_name = ?
 	`
 
 	var lastPingTime time.Time
-	err := db.conn.QueryRow(query, clientName, envName).Scan(&lastPingTime)
+	var status string
+	err := db.conn.QueryRow(query, clientName, envName).Scan(&lastPingTime, &status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "never", time.Time{}, nil
@@ -492,17 +1604,78 @@ func (db *DB) GetSlavePingStatus(clientName, envName string) (string, time.Time,
 	}
 
 	// Calculate status based on last ping time
-	timeSinceLastPing := time.Now().Sub(lastPingTime)
-	var status string
-	if timeSinceLastPing <= 10*time.Minute {
-		status = "online"
-	} else if timeSinceLastPing <= 15*time.Minute {
-		status = "warning"
-	} else {
-		status = "offline"
+	return db.pingStatus(status, time.Since(lastPingTime)), lastPingTime, nil
+}
+
+// GetFleetOverview summarizes every client environment known from releases or slave pings: its ping
+// status, number of components, components deployed since the given time and newest last_seen. It runs
+// one aggregate query over the releases and one over the pings, whatever the size of the fleet.
+func (db *DB) GetFleetOverview(since time.Time) ([]FleetEnvironment, error) {
+	query := `
+	WITH current AS (
+		SELECT client_name, env_name, first_seen, last_seen,
+			ROW_NUMBER() OVER (
+				PARTITION BY client_name, env_name, namespace, workload_name, container_name
+				ORDER BY datetime(last_seen) DESC
+			) AS rn
+		FROM releases
+		WHERE length(image_sha) > 0
+	)
+	SELECT client_name, env_name, COUNT(*),
+		SUM(CASE WHEN datetime(first_seen) >= datetime(?) THEN 1 ELSE 0 END),
+		MAX(datetime(last_seen))
+	FROM current
+	WHERE rn = 1
+	GROUP BY client_name, env_name
+	`
+
+	rows, err := db.conn.Query(query, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fleet overview: %w", err)
+	}
+	defer rows.Close()
+
+	environments := make(map[string]*FleetEnvironment)
+	for rows.Next() {
+		env := &FleetEnvironment{PingStatus: "never"}
+		var lastSeen string
+		if err := rows.Scan(&env.ClientName, &env.EnvName, &env.TotalComponents, &env.DeployedRecently, &lastSeen); err != nil {
+			return nil, err
+		}
+		if parsed, err := time.ParseInLocation("2006-01-02 15:04:05", lastSeen, time.UTC); err == nil {
+			env.LastSeen = &parsed
+		}
+		environments[env.ClientName+"/"+env.EnvName] = env
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return status, lastPingTime, nil
+	pings, err := db.GetSlavePings()
+	if err != nil {
+		return nil, err
+	}
+	for _, ping := range pings {
+		env, ok := environments[ping.ClientName+"/"+ping.EnvName]
+		if !ok {
+			env = &FleetEnvironment{ClientName: ping.ClientName, EnvName: ping.EnvName}
+			environments[ping.ClientName+"/"+ping.EnvName] = env
+		}
+		lastPing := ping.LastPingTime.UTC()
+		env.PingStatus, env.LastPing = ping.Status, &lastPing
+	}
+
+	overview := make([]FleetEnvironment, 0, len(environments))
+	for _, env := range environments {
+		overview = append(overview, *env)
+	}
+	sort.Slice(overview, func(i, j int) bool {
+		if overview[i].ClientName != overview[j].ClientName {
+			return overview[i].ClientName < overview[j].ClientName
+		}
+		return overview[i].EnvName < overview[j].EnvName
+	})
+	return overview, nil
 }
 
 // GetLastClientEnvUpdate returns the last update time for a specific client/environment
@@ -519,7 +1692,7 @@ func (db *DB) GetLastClientEnvUpdate(clientName, envName string) (time.Time, err
 	}
 
 	if !lastUpdateStr.Valid || lastUpdateStr.String == "" {
-		log.Printf("No last update found for %s/%s", clientName, envName)
+		slog.Debug("No last update found", "client", clientName, "env", envName)
 		return time.Time{}, nil
 	}
 
@@ -535,3 +1708,68 @@ func (db *DB) GetLastClientEnvUpdate(clientName, envName string) (time.Time, err
 
 	return lastUpdate, nil
 }
+
+// ClaimIdempotencyKey claims an idempotency key within scope for a request about to be processed, removing
+// the keys claimed more than ttl ago first. claimed is true when the key was free; otherwise the response
+// recorded for it is returned, or nil while the request that claimed it is still being processed. The claim
+// is a single INSERT on the primary key, so concurrent requests with the same key cannot both claim it.
+func (db *DB) ClaimIdempotencyKey(key, scope string, ttl time.Duration) (recorded *IdempotentResponse, claimed bool, err error) {
+	now := time.Now().UTC()
+	if _, err := db.conn.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, now.Add(-ttl).Format(time.RFC3339)); err != nil {
+		return nil, false, fmt.Errorf("failed to remove expired idempotency keys: %w", err)
+	}
+
+	// A pending claim has no response yet, recorded with status code 0
+	result, err := db.conn.Exec(`
+	INSERT INTO idempotency_keys (idempotency_key, scope, status_code, response, created_at)
+	VALUES (?, ?, 0, '', ?)
+	ON CONFLICT(idempotency_key, scope) DO NOTHING
+	`, key, scope, now.Format(time.RFC3339))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 1 {
+		return nil, true, nil
+	}
+
+	var response IdempotentResponse
+	var body string
+	err = db.conn.QueryRow(`
+	SELECT status_code, response, created_at
+	FROM idempotency_keys
+	WHERE idempotency_key = ? AND scope = ?
+	`, key, scope).Scan(&response.StatusCode, &body, &response.CreatedAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if response.StatusCode == 0 {
+		return nil, false, nil
+	}
+	response.Body = []byte(body)
+	return &response, false, nil
+}
+
+// SaveIdempotentResponse records the response of the request that claimed an idempotency key
+func (db *DB) SaveIdempotentResponse(key, scope string, statusCode int, body []byte) error {
+	_, err := db.conn.Exec(`
+	UPDATE idempotency_keys SET status_code = ?, response = ?
+	WHERE idempotency_key = ? AND scope = ? AND status_code = 0
+	`, statusCode, string(body), key, scope)
+	return err
+}
+
+// ReleaseIdempotencyKey removes the claim of a request that ended without a recorded response, so a retry
+// is processed again. A key whose response was recorded is kept.
+func (db *DB) ReleaseIdempotencyKey(key, scope string) error {
+	_, err := db.conn.Exec(`DELETE FROM idempotency_keys WHERE idempotency_key = ? AND scope = ? AND status_code = 0`, key, scope)
+	return err
+}
+
+// Snapshot writes a consistent copy of the database to path using VACUUM INTO.
+// The target file must not already exist.
+func (db *DB) Snapshot(path string) error {
+	if _, err := db.conn.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,632 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// seedHistory records count releases of one component, one hour apart, and backdates the created_at
+// of the oldest `backdated` of them by two hours
+func seedHistory(t *testing.T, db *DB, count, backdated int) {
+	t.Helper()
+	start := time.Now().Add(-time.Duration(count) * time.Hour)
+	for i := 0; i < count; i++ {
+		seen := start.Add(time.Duration(i) * time.Hour)
+		sha := fmt.Sprintf("sha%02d", i)
+		if err := db.UpsertRelease(&Release{
+			Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: fmt.Sprintf("v%d", i), ImageSHA: sha, ClientName: "acme", EnvName: "prod",
+			FirstSeen: seen, LastSeen: seen,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if i < backdated {
+			if _, err := db.conn.Exec(`UPDATE releases SET created_at = ? WHERE image_sha = ?`,
+				time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339), sha); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func storedSHAs(t *testing.T, db *DB) map[string]bool {
+	t.Helper()
+	rows, err := db.conn.Query(`SELECT image_sha FROM releases`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	shas := make(map[string]bool)
+	for rows.Next() {
+		var sha string
+		if err := rows.Scan(&sha); err != nil {
+			t.Fatal(err)
+		}
+		shas[sha] = true
+	}
+	return shas
+}
+
+func TestSchemaVersion(t *testing.T) {
+	db := newTestDB(t)
+
+	current, latest, err := db.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != latest {
+		t.Errorf("Expected a new database to be fully migrated, got version %d of %d", current, latest)
+	}
+
+	if _, err := db.conn.Exec(`DELETE FROM schema_migrations WHERE version = ?`, latest); err != nil {
+		t.Fatal(err)
+	}
+	if current, _, _ := db.SchemaVersion(context.Background()); current != latest-1 {
+		t.Errorf("Expected version %d once the last migration is forgotten, got %d", latest-1, current)
+	}
+}
+
+func TestCleanupOldReleasesKeepsYoungRows(t *testing.T) {
+	db := newTestDB(t)
+	// 15 releases: the 5 oldest exceed the 10 kept per component, and only 3 of those were recorded long ago
+	seedHistory(t, db, 15, 3)
+
+	if err := db.CleanupOldReleases(0, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	shas := storedSHAs(t, db)
+	if len(shas) != 12 {
+		t.Errorf("Expected 12 releases to be kept, got %d: %v", len(shas), shas)
+	}
+	for _, sha := range []string{"sha00", "sha01", "sha02"} {
+		if shas[sha] {
+			t.Errorf("Expected old excess release %s to be removed", sha)
+		}
+	}
+	for _, sha := range []string{"sha03", "sha04"} {
+		if !shas[sha] {
+			t.Errorf("Expected young excess release %s to survive the cleanup", sha)
+		}
+	}
+}
+
+func TestCleanupOldReleasesWithoutMinAge(t *testing.T) {
+	db := newTestDB(t)
+	seedHistory(t, db, 15, 3)
+
+	if err := db.CleanupOldReleases(0, 0); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	shas := storedSHAs(t, db)
+	if len(shas) != 10 {
+		t.Errorf("Expected the 10 most recent releases to be kept, got %d: %v", len(shas), shas)
+	}
+	if shas["sha04"] || !shas["sha05"] || !shas["sha14"] {
+		t.Errorf("Expected only the 10 most recent releases to be kept, got %v", shas)
+	}
+}
+
+func TestCleanupOldReleasesConfiguredKeepCount(t *testing.T) {
+	db := newTestDB(t)
+	seedHistory(t, db, 15, 15)
+
+	if err := db.CleanupOldReleases(5, 0); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	shas := storedSHAs(t, db)
+	if len(shas) != 5 || shas["sha09"] || !shas["sha10"] || !shas["sha14"] {
+		t.Errorf("Expected the 5 most recent releases to be kept, got %v", shas)
+	}
+
+	history, err := db.GetReleaseHistory("default", "web", "app", "acme", "prod", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Releases) != 3 || history.Releases[0].ImageSHA != "sha14" {
+		t.Errorf("Expected the 3 most recent releases, got %+v", history.Releases)
+	}
+}
+
+func TestGetReleaseHistoryPageUnknownComponent(t *testing.T) {
+	db := newTestDB(t)
+	seedHistory(t, db, 3, 3)
+
+	history, err := db.GetReleaseHistoryPage("default", "api", "app", "acme", "prod", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history != nil {
+		t.Errorf("Expected no history for a component never recorded, got %+v", history)
+	}
+
+	// An offset past the releases of a known component is an empty page, not a missing component
+	history, err = db.GetReleaseHistoryPage("default", "web", "app", "acme", "prod", 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history == nil || len(history.Releases) != 0 {
+		t.Errorf("Expected an empty page past the releases, got %+v", history)
+	}
+}
+
+func TestCleanupOldReleasesPartitionsByClientAndEnv(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().Add(-24 * time.Hour)
+
+	// The same component runs for three client/env pairs, each with 8 releases: 24 rows in total, none
+	// of which exceeds the 10 kept for its own client and environment
+	for _, owner := range [][2]string{{"acme", "prod"}, {"acme", "staging"}, {"globex", "prod"}} {
+		for i := 0; i < 8; i++ {
+			seen := start.Add(time.Duration(i) * time.Hour)
+			if err := db.UpsertRelease(&Release{
+				Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+				ImageTag: fmt.Sprintf("v%d", i), ImageSHA: fmt.Sprintf("%s-%s-%d", owner[0], owner[1], i),
+				ClientName: owner[0], EnvName: owner[1], FirstSeen: seen, LastSeen: seen,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := db.CleanupOldReleases(0, 0); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	if shas := storedSHAs(t, db); len(shas) != 24 {
+		t.Errorf("Expected every client and environment to keep its 8 releases, got %d: %v", len(shas), shas)
+	}
+}
+
+func TestRecordScalingEventOnlyOnChange(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// Replica counts seen by successive collections
+	var recorded []bool
+	for i, replicas := range []int{3, 3, 10, 10, 3} {
+		stored, err := db.RecordScalingEvent(&ScalingEvent{
+			Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ClientName: "acme", EnvName: "prod",
+			Replicas: replicas, RecordedAt: start.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Failed to record scaling event: %v", err)
+		}
+		recorded = append(recorded, stored)
+	}
+	if fmt.Sprint(recorded) != "[true false true false true]" {
+		t.Errorf("Expected events only when the replica count changes, got %v", recorded)
+	}
+
+	// Other workloads keep their own history
+	if stored, err := db.RecordScalingEvent(&ScalingEvent{
+		Namespace: "default", WorkloadName: "api", WorkloadType: "Deployment", ClientName: "acme", EnvName: "prod", Replicas: 3,
+	}); err != nil || !stored {
+		t.Errorf("Expected the first count of another workload to be recorded, got %v (%v)", stored, err)
+	}
+
+	events, err := db.GetScalingHistory("acme", "prod", "default", "web", 10)
+	if err != nil {
+		t.Fatalf("Failed to get scaling history: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 scaling events, got %+v", events)
+	}
+	if events[0].Replicas != 3 || events[0].PreviousReplicas == nil || *events[0].PreviousReplicas != 10 ||
+		events[1].Replicas != 10 || *events[1].PreviousReplicas != 3 ||
+		events[2].Replicas != 3 || events[2].PreviousReplicas != nil {
+		t.Errorf("Expected 3 → 10 → 3 most recent first, got %+v", events)
+	}
+	if !events[1].RecordedAt.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("Expected the scale-up to be recorded at the collection that saw it, got %v", events[1].RecordedAt)
+	}
+
+	if events, _ := db.GetScalingHistory("acme", "prod", "default", "web", 1); len(events) != 1 || events[0].Replicas != 3 {
+		t.Errorf("Expected the limit to keep the most recent event, got %+v", events)
+	}
+}
+
+func TestSlavePingStatusThresholds(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertSlavePing(&SlavePing{ClientName: "acme", EnvName: "prod", ClusterName: "eu-west-1", SlaveVersion: "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	backdate := func(age time.Duration) {
+		t.Helper()
+		if _, err := db.conn.Exec(`UPDATE slave_pings SET last_ping_time = ?`, time.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func() string {
+		t.Helper()
+		status, _, err := db.GetSlavePingStatus("acme", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pings, err := db.GetSlavePings()
+		if err != nil || len(pings) != 1 || pings[0].Status != status {
+			t.Fatalf("Expected GetSlavePings to agree with GetSlavePingStatus %q, got %+v (%v)", status, pings, err)
+		}
+		return status
+	}
+
+	// Default thresholds: warning after 10 minutes, offline after 15
+	backdate(12 * time.Minute)
+	if got := status(); got != "warning" {
+		t.Errorf("Expected warning 12 minutes after the last ping, got %q", got)
+	}
+
+	// Slaves collecting every half hour are still online after 12 minutes
+	db.SetPingThresholds(40*time.Minute, 60*time.Minute)
+	if got := status(); got != "online" {
+		t.Errorf("Expected online with a 40 minute warning threshold, got %q", got)
+	}
+	backdate(50 * time.Minute)
+	if got := status(); got != "warning" {
+		t.Errorf("Expected warning 50 minutes after the last ping, got %q", got)
+	}
+	backdate(2 * time.Hour)
+	if got := status(); got != "offline" {
+		t.Errorf("Expected offline 2 hours after the last ping, got %q", got)
+	}
+}
+
+func TestGetFleetOverview(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	record := func(clientName, envName, workload, sha string, seen time.Time) {
+		t.Helper()
+		if err := db.UpsertRelease(&Release{
+			Namespace: "default", WorkloadName: workload, WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: sha, ImageSHA: sha, ClientName: clientName, EnvName: envName,
+			FirstSeen: seen, LastSeen: seen,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// acme/prod: web deployed 2 hours ago over a release of 3 days ago, api unchanged for 3 days
+	record("acme", "prod", "web", "web1", now.Add(-72*time.Hour))
+	record("acme", "prod", "web", "web2", now.Add(-2*time.Hour))
+	record("acme", "prod", "api", "api1", now.Add(-72*time.Hour))
+	// initech/prod never pinged, globex/dev pinged without releases
+	record("initech", "prod", "web", "web1", now.Add(-time.Hour))
+	for _, ping := range []*SlavePing{{ClientName: "acme", EnvName: "prod"}, {ClientName: "globex", EnvName: "dev"}} {
+		if err := db.UpsertSlavePing(ping); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.conn.Exec(`UPDATE slave_pings SET last_ping_time = ? WHERE client_name = 'acme'`, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	overview, err := db.GetFleetOverview(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overview) != 3 {
+		t.Fatalf("Expected 3 environments, got %+v", overview)
+	}
+
+	expected := []struct {
+		client, env, status  string
+		components, deployed int
+		pinged, seen         bool
+	}{
+		{"acme", "prod", "offline", 2, 1, true, true},
+		{"globex", "dev", "online", 0, 0, true, false},
+		{"initech", "prod", "never", 1, 1, false, true},
+	}
+	for i, e := range expected {
+		env := overview[i]
+		if env.ClientName != e.client || env.EnvName != e.env || env.PingStatus != e.status ||
+			env.TotalComponents != e.components || env.DeployedRecently != e.deployed ||
+			(env.LastPing != nil) != e.pinged || (env.LastSeen != nil) != e.seen {
+			t.Errorf("Expected %+v, got %+v", e, env)
+		}
+	}
+	if lastSeen := overview[0].LastSeen; lastSeen == nil || lastSeen.Sub(now.Add(-2*time.Hour)).Abs() > time.Second {
+		t.Errorf("Expected acme/prod to be last seen 2 hours ago, got %v", lastSeen)
+	}
+}
+
+func TestCleanupOldReleasesPerClientRetention(t *testing.T) {
+	db := newTestDB(t)
+	// 15 releases of one component for each client, one day apart, the newest seen 12 hours ago
+	for _, clientName := range []string{"acme", "globex", "initech"} {
+		for i := 0; i < 15; i++ {
+			seen := time.Now().AddDate(0, 0, i-14).Add(-12 * time.Hour)
+			if err := db.UpsertRelease(&Release{
+				Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+				ImageTag: fmt.Sprintf("v%d", i), ImageSHA: fmt.Sprintf("%s%02d", clientName, i), ClientName: clientName, EnvName: "prod",
+				FirstSeen: seen, LastSeen: seen,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// acme keeps 3 releases, globex a week of history; initech has no policy
+	keepCount, keepDays := 3, 7
+	if err := db.SetClientRetention(&ClientRetention{ClientName: "acme", KeepCount: &keepCount}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetClientRetention(&ClientRetention{ClientName: "globex", KeepDays: &keepDays}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CleanupOldReleases(0, 0); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	kept := make(map[string]int)
+	for sha := range storedSHAs(t, db) {
+		kept[sha[:len(sha)-2]]++
+	}
+	if kept["acme"] != 3 || kept["globex"] != 7 || kept["initech"] != DefaultKeepReleases {
+		t.Errorf("Expected 3 acme, 7 globex and %d initech releases to be kept, got %v", DefaultKeepReleases, kept)
+	}
+
+	// The current release survives a policy that would prune everything
+	keepDays = 0
+	if err := db.SetClientRetention(&ClientRetention{ClientName: "globex", KeepDays: &keepDays}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CleanupOldReleases(0, 0); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if shas := storedSHAs(t, db); !shas["globex14"] || shas["globex13"] {
+		t.Errorf("Expected only the current globex release to be kept, got %v", shas)
+	}
+
+	// Removing the policy restores the default
+	retentions, err := db.GetClientRetentions()
+	if err != nil || len(retentions) != 2 || retentions[0].ClientName != "acme" || *retentions[0].KeepCount != 3 || retentions[0].KeepDays != nil {
+		t.Fatalf("Expected the acme and globex policies, got %+v (%v)", retentions, err)
+	}
+	if deleted, err := db.DeleteClientRetention("acme"); err != nil || !deleted {
+		t.Errorf("Expected the acme policy to be deleted, got %v (%v)", deleted, err)
+	}
+	if deleted, _ := db.DeleteClientRetention("initech"); deleted {
+		t.Error("Expected no policy to delete for initech")
+	}
+}
+
+func TestDetectRollback(t *testing.T) {
+	tests := []struct {
+		previous, tag string
+		expect        bool
+	}{
+		{"v1.2.3", "v1.2.2", true},
+		{"v1.2.3", "v1.3.0", false},
+		{"1.10.0", "1.9.0", true},       // numeric, not string, order
+		{"v2.0.0", "1.9.9", true},       // the v prefix is optional
+		{"v2", "v1.9", true},            // missing minor and patch are zero
+		{"v1.0.0", "v1.0.0-rc.1", true}, // a prerelease is lower than its release
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", false},
+		{"v1.0.0-beta", "v1.0.0-alpha", true},
+		{"v1.0.0+build.2", "v1.0.0+build.1", false}, // build metadata is ignored
+		{"v1.2.3", "v1.2.3", false},
+		{"release-b", "release-a", true}, // non-semver tags compare as strings
+		{"2024.06.1", "latest", false},
+		{"", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := detectRollback(tt.previous, tt.tag); got != tt.expect {
+			t.Errorf("detectRollback(%q, %q) = %v, want %v", tt.previous, tt.tag, got, tt.expect)
+		}
+	}
+}
+
+func TestUpsertReleaseFlagsRollbacks(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	record := func(tag, sha string, minute int) {
+		t.Helper()
+		seen := start.Add(time.Duration(minute) * time.Minute)
+		if err := db.UpsertRelease(&Release{
+			Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: tag, ImageSHA: sha, ClientName: "acme", EnvName: "prod", FirstSeen: seen, LastSeen: seen,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rollbacks := func() map[string]bool {
+		t.Helper()
+		history, err := db.GetReleaseHistory("default", "web", "app", "acme", "prod", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flags := make(map[string]bool)
+		for _, release := range history.Releases {
+			flags[release.ImageTag] = release.IsRollback
+		}
+		return flags
+	}
+
+	record("v1.0.0", "sha1", 0)
+	record("v1.1.0", "sha2", 1)
+	record("v1.0.1", "sha3", 2) // rolled back to a hotfix below v1.1.0
+	record("v1.0.1", "sha3", 3) // collected again
+	if flags := rollbacks(); flags["v1.0.0"] || flags["v1.1.0"] || !flags["v1.0.1"] {
+		t.Errorf("Expected only v1.0.1 to be flagged, got %v", flags)
+	}
+
+	// Returning to a release already stored flags its row; moving forward to v1.1.0 again is no rollback
+	record("v1.1.0", "sha2", 4)
+	record("v1.0.0", "sha1", 5)
+	if flags := rollbacks(); !flags["v1.0.0"] || flags["v1.1.0"] {
+		t.Errorf("Expected the return to v1.0.0 to be flagged, got %v", flags)
+	}
+}
+
+// countingConn counts the queries run on a SQLite connection
+type countingConn struct {
+	*sqlite3.SQLiteConn
+	queries *atomic.Int64
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries.Add(1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+// countingDriver opens SQLite connections counting their queries
+type countingDriver struct {
+	sqlite3.SQLiteDriver
+	queries atomic.Int64
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), queries: &d.queries}, nil
+}
+
+var (
+	queryCounter         = &countingDriver{}
+	registerQueryCounter sync.Once
+)
+
+// newQueryCountingDB returns a migrated test database whose queries are counted by queryCounter
+func newQueryCountingDB(b *testing.B) *DB {
+	b.Helper()
+	registerQueryCounter.Do(func() { sql.Register("sqlite3-counting", queryCounter) })
+
+	path := filepath.Join(b.TempDir(), "test.db")
+	db, err := New(path)
+	if err != nil {
+		b.Fatalf("Failed to create test database: %v", err)
+	}
+	db.conn.Close()
+	if db.conn, err = sql.Open("sqlite3-counting", path); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+// BenchmarkSlavePingStatuses compares reading the ping status of every client/environment one query at
+// a time, as /api/clients-environments used to, with reading all of them with GetSlavePings
+func BenchmarkSlavePingStatuses(b *testing.B) {
+	db := newQueryCountingDB(b)
+	const environments = 200
+	for i := 0; i < environments; i++ {
+		if err := db.UpsertSlavePing(&SlavePing{ClientName: fmt.Sprintf("client%03d", i/4), EnvName: fmt.Sprintf("env%d", i%4)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	run := func(b *testing.B, statuses func() (map[string]string, error)) {
+		queryCounter.queries.Store(0)
+		for i := 0; i < b.N; i++ {
+			got, err := statuses()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(got) != environments {
+				b.Fatalf("Expected %d statuses, got %d", environments, len(got))
+			}
+		}
+		b.ReportMetric(float64(queryCounter.queries.Load())/float64(b.N), "queries/op")
+	}
+
+	b.Run("per environment", func(b *testing.B) {
+		run(b, func() (map[string]string, error) {
+			statuses := make(map[string]string, environments)
+			for i := 0; i < environments; i++ {
+				clientName, envName := fmt.Sprintf("client%03d", i/4), fmt.Sprintf("env%d", i%4)
+				status, _, err := db.GetSlavePingStatus(clientName, envName)
+				if err != nil {
+					return nil, err
+				}
+				statuses[clientName+"/"+envName] = status
+			}
+			return statuses, nil
+		})
+	})
+	b.Run("all pings", func(b *testing.B) {
+		run(b, func() (map[string]string, error) {
+			pings, err := db.GetSlavePings()
+			if err != nil {
+				return nil, err
+			}
+			statuses := make(map[string]string, len(pings))
+			for _, ping := range pings {
+				statuses[ping.ClientName+"/"+ping.EnvName] = ping.Status
+			}
+			return statuses, nil
+		})
+	})
+}
+
+func TestNormalizeClientEnvNamesMergesDuplicates(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	// The un-normalized duplicate was seen more recently than the normalized row it collides with
+	for _, release := range []*Release{
+		{ClientName: "acme", EnvName: "prod", FirstSeen: now.Add(-48 * time.Hour), LastSeen: now.Add(-24 * time.Hour)},
+		{ClientName: "Acme ", EnvName: "prod", FirstSeen: now.Add(-2 * time.Hour), LastSeen: now},
+	} {
+		release.Namespace, release.WorkloadName, release.WorkloadType, release.ContainerName = "default", "web", "Deployment", "app"
+		release.ImageTag, release.ImageSHA = "v1", "sha-1"
+		if err := db.UpsertRelease(release); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, clientName := range []string{"acme", "ACME"} {
+		if err := db.UpsertSlavePing(&SlavePing{ClientName: clientName, EnvName: "prod"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.conn.Exec(`UPDATE slave_pings SET last_ping_time = ? WHERE client_name = 'acme'`, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NormalizeClientEnvNames(true); err != nil {
+		t.Fatalf("NormalizeClientEnvNames failed: %v", err)
+	}
+
+	history, err := db.GetReleaseHistory("default", "web", "app", "acme", "prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases := history.Releases
+	within := func(got, want time.Time) bool {
+		return got.Sub(want).Abs() < 2*time.Second
+	}
+	if len(releases) != 1 || !within(releases[0].LastSeen, now) || !within(releases[0].FirstSeen, now.Add(-48*time.Hour)) {
+		t.Errorf("Expected one release seen first 48 hours ago and last now, got %+v", releases)
+	}
+
+	pings, err := db.GetSlavePings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pings) != 1 || pings[0].Status != "online" || !within(pings[0].LastPingTime, now) {
+		t.Errorf("Expected one online slave keeping the latest ping, got %+v", pings)
+	}
+}
@@ -0,0 +1,290 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/version"
+)
+
+// Client reads release data from upstream masters so a federating master can merge it with its own
+type Client struct {
+	upstreams  []string
+	apiKey     string
+	cacheTTL   time.Duration
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// cacheEntry is an upstream response body kept until it expires
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// PingStatus is the health of a slave as reported by an upstream master
+type PingStatus struct {
+	Status   string     `json:"status"`
+	LastPing *time.Time `json:"last_ping,omitempty"`
+}
+
+// ClientsEnvironments is the merged clients/environments view of all upstream masters
+type ClientsEnvironments struct {
+	ClientsEnvironments map[string][]string
+	PingStatuses        map[string]map[string]PingStatus
+	TotalReleases       int
+}
+
+// New creates a federation client for the given upstream master URLs. apiKey is sent to every
+// upstream and should be an admin key so responses are not restricted to a single client.
+// Upstream responses are cached for cacheTTL (0 disables caching).
+func New(upstreams []string, apiKey string, cacheTTL time.Duration) *Client {
+	trimmed := make([]string, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if upstream = strings.TrimSuffix(strings.TrimSpace(upstream), "/"); upstream != "" {
+			trimmed = append(trimmed, upstream)
+		}
+	}
+
+	return &Client{
+		upstreams:  trimmed,
+		apiKey:     apiKey,
+		cacheTTL:   cacheTTL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		cache:      make(map[string]cacheEntry),
+	}
+}
+
+// CurrentReleases returns the current releases of a client/environment from all upstreams, queried concurrently.
+// Unreachable upstreams are logged and skipped so one failing region does not hide the others.
+func (c *Client) CurrentReleases(ctx context.Context, clientName, envName string) []database.CurrentRelease {
+	results := make([][]database.CurrentRelease, len(c.upstreams))
+	c.eachUpstream(func(i int, upstream string) {
+		upstreamReleases, err := c.upstreamCurrentReleases(ctx, upstream, clientName, envName)
+		if err != nil {
+			slog.Warn("Failed to get current releases from upstream master", "upstream", upstream, "client", clientName, "env", envName, "error", err)
+			return
+		}
+		results[i] = upstreamReleases
+	})
+
+	// Merge in upstream order so the result does not depend on which upstream answered first
+	var releases []database.CurrentRelease
+	for _, upstreamReleases := range results {
+		releases = append(releases, upstreamReleases...)
+	}
+
+	return releases
+}
+
+// eachUpstream calls fn for every upstream concurrently and waits for all calls to return
+func (c *Client) eachUpstream(fn func(i int, upstream string)) {
+	var wg sync.WaitGroup
+	for i, upstream := range c.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i, upstream)
+		}()
+	}
+	wg.Wait()
+}
+
+// currentReleasesPageSize is the number of releases requested per page, the largest page masters serve
+const currentReleasesPageSize = 1000
+
+// upstreamCurrentReleases fetches every page of the current releases of a client/environment from one upstream
+func (c *Client) upstreamCurrentReleases(ctx context.Context, upstream, clientName, envName string) ([]database.CurrentRelease, error) {
+	query := url.Values{}
+	query.Set("client_name", clientName)
+	query.Set("env_name", envName)
+	query.Set("limit", strconv.Itoa(currentReleasesPageSize))
+
+	var releases []database.CurrentRelease
+	for offset := 0; ; {
+		query.Set("offset", strconv.Itoa(offset))
+
+		var response struct {
+			Namespaces map[string][]database.CurrentRelease `json:"namespaces"`
+			Total      int                                  `json:"total"`
+		}
+		if err := c.get(ctx, upstream+"/api/releases/current?"+query.Encode(), &response); err != nil {
+			return nil, err
+		}
+
+		page := 0
+		for _, namespaceReleases := range response.Namespaces {
+			releases = append(releases, namespaceReleases...)
+			page += len(namespaceReleases)
+		}
+
+		// Older masters ignore limit and offset and return everything at once
+		offset += page
+		if page == 0 || offset >= response.Total {
+			return releases, nil
+		}
+	}
+}
+
+// clientsEnvironmentsResponse is the part of an upstream clients/environments response that is merged
+type clientsEnvironmentsResponse struct {
+	ClientsEnvironments map[string][]string              `json:"clients_environments"`
+	PingStatuses        map[string]map[string]PingStatus `json:"ping_statuses"`
+	Statistics          struct {
+		TotalReleases int `json:"total_releases"`
+	} `json:"statistics"`
+}
+
+// ClientsEnvironments returns the clients, environments and slave ping statuses known to all upstreams,
+// queried concurrently. Unreachable upstreams are logged and skipped.
+func (c *Client) ClientsEnvironments(ctx context.Context) ClientsEnvironments {
+	responses := make([]*clientsEnvironmentsResponse, len(c.upstreams))
+	c.eachUpstream(func(i int, upstream string) {
+		var response clientsEnvironmentsResponse
+		if err := c.get(ctx, upstream+"/api/clients-environments", &response); err != nil {
+			slog.Warn("Failed to get clients and environments from upstream master", "upstream", upstream, "error", err)
+			return
+		}
+		responses[i] = &response
+	})
+
+	merged := ClientsEnvironments{
+		ClientsEnvironments: make(map[string][]string),
+		PingStatuses:        make(map[string]map[string]PingStatus),
+	}
+	for _, response := range responses {
+		if response == nil {
+			continue
+		}
+
+		for clientName, envs := range response.ClientsEnvironments {
+			merged.ClientsEnvironments[clientName] = mergeEnvironments(merged.ClientsEnvironments[clientName], envs)
+		}
+		for clientName, statuses := range response.PingStatuses {
+			if merged.PingStatuses[clientName] == nil {
+				merged.PingStatuses[clientName] = make(map[string]PingStatus)
+			}
+			for envName, status := range statuses {
+				// Keep the most recent ping when several upstreams know the same environment
+				if existing, ok := merged.PingStatuses[clientName][envName]; ok && existing.LastPing != nil &&
+					(status.LastPing == nil || status.LastPing.Before(*existing.LastPing)) {
+					continue
+				}
+				merged.PingStatuses[clientName][envName] = status
+			}
+		}
+		merged.TotalReleases += response.Statistics.TotalReleases
+	}
+
+	return merged
+}
+
+// mergeEnvironments appends the environments missing from envs
+func mergeEnvironments(envs, more []string) []string {
+	for _, env := range more {
+		found := false
+		for _, existing := range envs {
+			if existing == env {
+				found = true
+				break
+			}
+		}
+		if !found {
+			envs = append(envs, env)
+		}
+	}
+	return envs
+}
+
+// get fetches and decodes an upstream JSON response, serving it from the cache while fresh
+func (c *Client) get(ctx context.Context, requestURL string, target interface{}) error {
+	body, err := c.fetch(ctx, requestURL)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// fetch returns the body of an upstream response, from the cache when possible
+func (c *Client) fetch(ctx context.Context, requestURL string) ([]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.cache[requestURL]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", version.UserAgent(version.SchemaVersion))
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if c.cacheTTL > 0 {
+		c.store(requestURL, body)
+	}
+
+	return body, nil
+}
+
+// maxCacheEntries bounds the cache, which is keyed by request URL and so grows with every client,
+// environment and page requested
+const maxCacheEntries = 1000
+
+// store caches an upstream response body, dropping expired entries first and the entry closest to
+// expiry when the cache is still full
+func (c *Client) store(requestURL string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.cache[requestURL]; !ok && len(c.cache) >= maxCacheEntries {
+		var oldestURL string
+		var oldest time.Time
+		for cachedURL, entry := range c.cache {
+			if !now.Before(entry.expires) {
+				delete(c.cache, cachedURL)
+				continue
+			}
+			if oldestURL == "" || entry.expires.Before(oldest) {
+				oldestURL, oldest = cachedURL, entry.expires
+			}
+		}
+		if len(c.cache) >= maxCacheEntries {
+			delete(c.cache, oldestURL)
+		}
+	}
+	c.cache[requestURL] = cacheEntry{body: body, expires: now.Add(c.cacheTTL)}
+}
@@ -0,0 +1,67 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientsEnvironmentsQueriesUpstreamsConcurrently(t *testing.T) {
+	// Each upstream only answers once both have been asked, so sequential calls would time out
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	allArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(allArrived)
+	}()
+
+	newUpstream := func(clientName string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			arrived.Done()
+			select {
+			case <-allArrived:
+			case <-time.After(2 * time.Second):
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			fmt.Fprintf(w, `{"clients_environments": {%q: ["prod"]}, "statistics": {"total_releases": 2}}`, clientName)
+		}))
+	}
+	eu, us := newUpstream("acme"), newUpstream("globex")
+	defer eu.Close()
+	defer us.Close()
+
+	client := New([]string{eu.URL, us.URL}, "admin-key", 0)
+	merged := client.ClientsEnvironments(context.Background())
+
+	if len(merged.ClientsEnvironments) != 2 || merged.TotalReleases != 4 {
+		t.Errorf("Expected both upstreams to be merged, got %+v", merged)
+	}
+}
+
+func TestCacheIsBounded(t *testing.T) {
+	client := New(nil, "", time.Minute)
+
+	for i := 0; i < maxCacheEntries+10; i++ {
+		client.store(fmt.Sprintf("http://upstream/api/releases/current?offset=%d", i), []byte("{}"))
+	}
+	if len(client.cache) != maxCacheEntries {
+		t.Errorf("Expected the cache to hold at most %d entries, got %d", maxCacheEntries, len(client.cache))
+	}
+
+	// Expired entries are dropped before fresh ones
+	for cachedURL, entry := range client.cache {
+		entry.expires = time.Now().Add(-time.Second)
+		client.cache[cachedURL] = entry
+	}
+	client.cache["http://upstream/fresh"] = cacheEntry{expires: time.Now().Add(time.Minute)}
+	client.store("http://upstream/new", []byte("{}"))
+	if _, ok := client.cache["http://upstream/fresh"]; !ok || len(client.cache) != 2 {
+		t.Errorf("Expected only the fresh entries to be kept, got %d entries", len(client.cache))
+	}
+}
@@ -2,28 +2,110 @@ package kubernetes
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/metrics"
+	"krelease-tracker/internal/notify"
+	"krelease-tracker/internal/registry"
+	"krelease-tracker/internal/tracing"
+	"krelease-tracker/internal/version"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
 
+// errNoRunningPods is returned when a workload exists but none of its pods is running,
+// e.g. because it is scaled to zero or its pods fail to schedule
+var errNoRunningPods = errors.New("no running pods found")
+
 // Client wraps the Kubernetes client
 type Client struct {
-	clientset  *kubernetes.Clientset
-	namespaces []string
-	mode       string
+	clientset kubernetes.Interface
+	mode      string
+
+	// namespaces are the configured namespaces, replaced by SetNamespaces when the configuration is reloaded
+	namespaces   []string
+	namespacesMu sync.RWMutex
+
+	// excludedNamespaces are skipped when the AllNamespaces wildcard or the namespace selector is expanded
+	excludedNamespaces []string
+	// namespaceSelector selects namespaces monitored in addition to the configured ones; nil disables it
+	namespaceSelector labels.Selector
+
+	// callSlots bounds the number of concurrent Kubernetes API calls made by the collector
+	callSlots chan struct{}
+
+	// concurrency is the number of namespaces collected at the same time
+	concurrency int
+	// writeMu serializes the database writes of namespaces collected concurrently
+	writeMu sync.Mutex
+
+	// notifier receives version change events detected during collection; nil disables them
+	notifier notify.Notifier
+
+	// metrics counts collections, collection errors and recorded releases; nil disables them
+	metrics *metrics.Metrics
+
+	// chartVersionLabel and appVersionLabel name the workload labels recorded as chart and app versions
+	chartVersionLabel string
+	appVersionLabel   string
+
+	// orderLabel names the workload label holding the position of a workload within its namespace
+	orderLabel string
+
+	// trackedAnnotations names the pod template annotations recorded with each release (e.g. git-commit)
+	trackedAnnotations []string
+
+	// registry reads the OCI labels recorded with each release from the image config; nil disables it
+	registry *registry.Client
+
+	// trackRestarts resolves the image SHA of restarted (e.g. crash-looping) containers that are not ready,
+	// so an image re-pushed under the same tag is recorded as a new release
+	trackRestarts bool
+
+	// trackScaling records a scaling event whenever the desired replica count of a workload changes
+	trackScaling bool
+
+	// lowercaseNames lowercases the client and environment names in addition to trimming them
+	lowercaseNames bool
+
+	// staleThreshold prunes components not seen for longer than this after each collection; 0 keeps them forever
+	staleThreshold time.Duration
+
+	// cleanupMinAge protects releases recorded more recently than this from the history cleanup
+	cleanupMinAge time.Duration
+	// historyRetention is the number of releases kept per component by the history cleanup
+	historyRetention int
+
+	// podSelectors are label selector templates tried before the built-in selectors when looking up
+	// the pods of a workload; {name} stands for the workload name
+	podSelectors []string
+
+	// acceptPhases lists extra pod phases whose containers may provide the image SHA
+	// when no running, ready container is found
+	acceptPhases map[corev1.PodPhase]bool
+
+	// diagnostics records the pod selectors tried for each workload during the last collection
+	diagnostics selectorDiagnostics
 }
 
 // New creates a new Kubernetes client
@@ -51,36 +133,236 @@ func New(inCluster bool, kubeconfigPath string, namespaces []string, mode string
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
+	return NewFromClientset(clientset, namespaces, mode), nil
+}
+
+// defaultMaxConcurrentCalls is the default limit of concurrent Kubernetes API calls
+const defaultMaxConcurrentCalls = 10
+
+// defaultCollectionConcurrency is the default number of namespaces collected at the same time
+const defaultCollectionConcurrency = 4
+
+// InitContainerPrefix marks init containers in recorded container names, e.g. "init:migrate"
+const InitContainerPrefix = "init:"
+
+// NewFromClientset creates a Kubernetes client around an existing clientset
+func NewFromClientset(clientset kubernetes.Interface, namespaces []string, mode string) *Client {
 	return &Client{
-		clientset:  clientset,
-		namespaces: namespaces,
-		mode:       mode,
-	}, nil
+		clientset:   clientset,
+		namespaces:  namespaces,
+		mode:        mode,
+		callSlots:   make(chan struct{}, defaultMaxConcurrentCalls),
+		concurrency: defaultCollectionConcurrency,
+	}
 }
 
-// CollectReleases discovers all workloads and their container images across monitored namespaces
-func (c *Client) CollectReleases(ctx context.Context, db *database.DB) error {
-	log.Printf("Starting collection across namespaces: %v", c.namespaces)
+// SetMaxConcurrentCalls limits the number of Kubernetes API calls the collector runs at the same time
+func (c *Client) SetMaxConcurrentCalls(max int) {
+	if max > 0 {
+		c.callSlots = make(chan struct{}, max)
+	}
+}
+
+// SetCollectionConcurrency sets the number of namespaces collected at the same time
+func (c *Client) SetCollectionConcurrency(concurrency int) {
+	if concurrency > 0 {
+		c.concurrency = concurrency
+	}
+}
+
+// limitCall runs a Kubernetes API call once a call slot is free, so the collector never has more
+// than the configured number of calls in flight regardless of how many namespaces it processes
+func limitCall[T any](ctx context.Context, c *Client, call func() (T, error)) (T, error) {
+	select {
+	case c.callSlots <- struct{}{}:
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+	defer func() { <-c.callSlots }()
+
+	return call()
+}
+
+// SetNotifier sets the notifier receiving version change events detected during collection
+func (c *Client) SetNotifier(notifier notify.Notifier) {
+	c.notifier = notifier
+}
+
+// SetMetrics sets the metrics updated during collection
+func (c *Client) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
+}
+
+// SetVersionLabels sets the workload label keys recorded as the chart and app versions of a release.
+// An empty key disables the corresponding version.
+func (c *Client) SetVersionLabels(chartVersionLabel, appVersionLabel string) {
+	c.chartVersionLabel = chartVersionLabel
+	c.appVersionLabel = appVersionLabel
+}
 
-	for _, namespace := range c.namespaces {
-		if err := c.collectNamespaceReleases(ctx, db, namespace); err != nil {
-			log.Printf("Error collecting releases from namespace %s: %v", namespace, err)
+// SetOrderLabel sets the workload label key holding the display order of a workload within its namespace.
+// An empty key disables it.
+func (c *Client) SetOrderLabel(orderLabel string) {
+	c.orderLabel = orderLabel
+}
+
+// SetTrackedAnnotations sets the pod template annotation keys recorded with each release
+func (c *Client) SetTrackedAnnotations(keys []string) {
+	c.trackedAnnotations = keys
+}
+
+// SetRegistry sets the client reading OCI labels of collected images from their registry
+func (c *Client) SetRegistry(r *registry.Client) {
+	c.registry = r
+}
+
+// SetTrackRestarts enables resolving the image SHA of restarted containers that are not ready.
+// A restarted container running a new image under the same tag is recorded as a tag reuse.
+func (c *Client) SetTrackRestarts(enabled bool) {
+	c.trackRestarts = enabled
+}
+
+// SetTrackScaling enables recording the desired replica count of Deployments, StatefulSets and
+// DaemonSets as scaling history whenever it changes between collections
+func (c *Client) SetTrackScaling(enabled bool) {
+	c.trackScaling = enabled
+}
+
+// SetLowercaseNames enables lowercasing the client and environment names recorded with releases
+func (c *Client) SetLowercaseNames(enabled bool) {
+	c.lowercaseNames = enabled
+}
+
+// SetStaleThreshold prunes components whose newest release was last seen longer ago than threshold
+// after each collection, so decommissioned workloads disappear. 0 disables pruning.
+func (c *Client) SetStaleThreshold(threshold time.Duration) {
+	c.staleThreshold = threshold
+}
+
+// SetCleanupMinAge keeps releases recorded less than minAge ago when trimming the release history
+// after each collection, even beyond the number of releases kept per component. 0 disables it.
+func (c *Client) SetCleanupMinAge(minAge time.Duration) {
+	c.cleanupMinAge = minAge
+}
+
+// SetHistoryRetention sets the number of releases kept per component when trimming the release history
+// after each collection, for clients without a retention policy. 0 keeps database.DefaultKeepReleases.
+func (c *Client) SetHistoryRetention(count int) {
+	c.historyRetention = count
+}
+
+// SetPodLabelSelectors sets label selector templates (e.g. "app.kubernetes.io/instance={name}") tried in
+// order before the built-in selectors when looking up the pods of a workload. {name} is replaced by the
+// workload name; invalid templates are logged and ignored.
+func (c *Client) SetPodLabelSelectors(templates []string) {
+	c.podSelectors = nil
+	for _, template := range templates {
+		if _, err := labels.Parse(podSelector(template, "name")); err != nil {
+			slog.Warn("Ignoring invalid pod label selector", "selector", template, "error", err)
 			continue
 		}
+		c.podSelectors = append(c.podSelectors, template)
+	}
+}
+
+// podSelector substitutes the workload name into a pod label selector template
+func podSelector(template, workloadName string) string {
+	return strings.ReplaceAll(template, "{name}", workloadName)
+}
+
+// SetSHAAcceptPhases sets the pod phases used as a fallback when no running, ready container
+// exposes the image SHA. Containers of pods in these phases are considered even if not ready,
+// and a digest pinned in the pod spec is used when the status carries no image ID.
+func (c *Client) SetSHAAcceptPhases(phases []string) {
+	c.acceptPhases = make(map[corev1.PodPhase]bool)
+	for _, phase := range phases {
+		c.acceptPhases[corev1.PodPhase(phase)] = true
+	}
+}
+
+// CollectReleases discovers all workloads and their container images across monitored namespaces
+func (c *Client) CollectReleases(ctx context.Context, db *database.DB) error {
+	return c.CollectNamespaces(ctx, db, c.Namespaces())
+}
+
+// CollectNamespaces discovers all workloads and their container images in the given namespaces.
+// AllNamespaces and LabelSelectedNamespaces are expanded into the matching namespaces at each call.
+func (c *Client) CollectNamespaces(ctx context.Context, db *database.DB, namespaces []string) (err error) {
+	ctx, span := tracing.Start(ctx, "collect releases")
+	defer func() { tracing.End(span, err) }()
+
+	c.metrics.CollectionRun()
+	namespaces, err = c.resolveNamespaces(ctx, namespaces)
+	if err != nil {
+		c.metrics.CollectionError()
+		return err
+	}
+	span.SetAttributes(attribute.Int("krelease.namespaces", len(namespaces)))
+	slog.Info("Starting collection", "namespaces", namespaces, "concurrency", c.concurrency)
+
+	// Namespaces are collected by a bounded pool of workers; an error only skips its namespace
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	var lastErr error
+	for i := 0; i < min(c.concurrency, len(namespaces)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for namespace := range queue {
+				if err := c.collectNamespaceReleases(ctx, db, namespace); err != nil {
+					slog.Error("Error collecting releases", "namespace", namespace, "error", err)
+					c.metrics.CollectionError()
+					mu.Lock()
+					failed, lastErr = failed+1, err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, namespace := range namespaces {
+		queue <- namespace
+	}
+	close(queue)
+	wg.Wait()
+
+	// A collection where every namespace failed (e.g. revoked RBAC) is a failure, and nothing is pruned
+	if len(namespaces) > 0 && failed == len(namespaces) {
+		return fmt.Errorf("failed to collect any of the %d namespaces: %w", len(namespaces), lastErr)
 	}
 
 	// Cleanup old releases after collection
-	if err := db.CleanupOldReleases(); err != nil {
-		log.Printf("Error cleaning up old releases: %v", err)
+	if err := db.CleanupOldReleases(c.historyRetention, c.cleanupMinAge); err != nil {
+		slog.Error("Error cleaning up old releases", "error", err)
 	}
 
-	log.Printf("Collection completed")
+	// Prune components that are no longer running anywhere
+	if c.staleThreshold > 0 {
+		if _, err := db.PruneStaleComponents(c.staleThreshold); err != nil {
+			slog.Error("Error pruning stale components", "error", err)
+		}
+	}
+
+	slog.Info("Collection completed")
 	return nil
 }
 
 // collectNamespaceReleases collects releases from a specific namespace
-func (c *Client) collectNamespaceReleases(ctx context.Context, db *database.DB, namespace string) error {
-	log.Printf("Collecting releases from namespace: %s", namespace)
+func (c *Client) collectNamespaceReleases(ctx context.Context, db *database.DB, namespace string) (err error) {
+	ctx, span := tracing.Start(ctx, "collect namespace", trace.WithAttributes(attribute.String("k8s.namespace.name", namespace)))
+	defer func() { tracing.End(span, err) }()
+
+	slog.Info("Collecting releases from namespace", "namespace", namespace)
+	c.diagnostics.resetNamespace(namespace)
+
+	// List the pods of the namespace once for this run instead of once per container
+	if cache, err := c.listNamespacePods(ctx, namespace); err != nil {
+		slog.Warn("Could not cache pods, listing them per workload", "namespace", namespace, "error", err)
+	} else {
+		ctx = withNamespacePods(ctx, cache)
+	}
 
 	// Collect from Deployments
 	if err := c.collectDeployments(ctx, db, namespace); err != nil {
@@ -97,6 +379,15 @@ func (c *Client) collectNamespaceReleases(ctx context.Context, db *database.DB,
 		return fmt.Errorf("failed to collect daemonsets: %w", err)
 	}
 
+	// Collect from Jobs and CronJobs (run-to-completion workloads)
+	if err := c.collectJobs(ctx, db, namespace); err != nil {
+		return fmt.Errorf("failed to collect jobs: %w", err)
+	}
+
+	if err := c.collectCronJobs(ctx, db, namespace); err != nil {
+		return fmt.Errorf("failed to collect cronjobs: %w", err)
+	}
+
 	// // Collect from ReplicaSets (standalone ones)
 	// if err := c.collectReplicaSets(ctx, db, namespace); err != nil {
 	// 	return fmt.Errorf("failed to collect replicasets: %w", err)
@@ -107,14 +398,16 @@ func (c *Client) collectNamespaceReleases(ctx context.Context, db *database.DB,
 
 // collectDeployments collects container images from Deployments
 func (c *Client) collectDeployments(ctx context.Context, db *database.DB, namespace string) error {
-	deployments, err := c.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	deployments, err := limitCall(ctx, c, func() (*appsv1.DeploymentList, error) {
+		return c.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	})
 	if err != nil {
 		return err
 	}
 
 	for _, deployment := range deployments.Items {
-		if err := c.processWorkload(ctx, db, namespace, deployment.Name, "Deployment", deployment.Spec.Template.Spec); err != nil {
-			log.Printf("Error processing deployment %s/%s: %v", namespace, deployment.Name, err)
+		if err := c.processWorkload(ctx, db, namespace, deployment.Name, "Deployment", workloadLabels(deployment.Labels, deployment.Spec.Template.Labels), deployment.Spec.Template.Annotations, deployment.Spec.Template.Spec, deploymentReplicas(&deployment)); err != nil {
+			slog.Error("Error processing workload", "namespace", namespace, "workload_type", "Deployment", "workload", deployment.Name, "error", err)
 		}
 	}
 
@@ -123,14 +416,16 @@ func (c *Client) collectDeployments(ctx context.Context, db *database.DB, namesp
 
 // collectStatefulSets collects container images from StatefulSets
 func (c *Client) collectStatefulSets(ctx context.Context, db *database.DB, namespace string) error {
-	statefulSets, err := c.clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	statefulSets, err := limitCall(ctx, c, func() (*appsv1.StatefulSetList, error) {
+		return c.clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	})
 	if err != nil {
 		return err
 	}
 
 	for _, statefulSet := range statefulSets.Items {
-		if err := c.processWorkload(ctx, db, namespace, statefulSet.Name, "StatefulSet", statefulSet.Spec.Template.Spec); err != nil {
-			log.Printf("Error processing statefulset %s/%s: %v", namespace, statefulSet.Name, err)
+		if err := c.processWorkload(ctx, db, namespace, statefulSet.Name, "StatefulSet", workloadLabels(statefulSet.Labels, statefulSet.Spec.Template.Labels), statefulSet.Spec.Template.Annotations, statefulSet.Spec.Template.Spec, statefulSetReplicas(&statefulSet)); err != nil {
+			slog.Error("Error processing workload", "namespace", namespace, "workload_type", "StatefulSet", "workload", statefulSet.Name, "error", err)
 		}
 	}
 
@@ -139,20 +434,183 @@ func (c *Client) collectStatefulSets(ctx context.Context, db *database.DB, names
 
 // collectDaemonSets collects container images from DaemonSets
 func (c *Client) collectDaemonSets(ctx context.Context, db *database.DB, namespace string) error {
-	daemonSets, err := c.clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	daemonSets, err := limitCall(ctx, c, func() (*appsv1.DaemonSetList, error) {
+		return c.clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	})
 	if err != nil {
 		return err
 	}
 
 	for _, daemonSet := range daemonSets.Items {
-		if err := c.processWorkload(ctx, db, namespace, daemonSet.Name, "DaemonSet", daemonSet.Spec.Template.Spec); err != nil {
-			log.Printf("Error processing daemonset %s/%s: %v", namespace, daemonSet.Name, err)
+		if err := c.processWorkload(ctx, db, namespace, daemonSet.Name, "DaemonSet", workloadLabels(daemonSet.Labels, daemonSet.Spec.Template.Labels), daemonSet.Spec.Template.Annotations, daemonSet.Spec.Template.Spec, daemonSetReplicas(&daemonSet)); err != nil {
+			slog.Error("Error processing workload", "namespace", namespace, "workload_type", "DaemonSet", "workload", daemonSet.Name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// collectJobs collects releases from standalone Jobs in a namespace.
+// Jobs created by a CronJob are collected through their CronJob instead.
+func (c *Client) collectJobs(ctx context.Context, db *database.DB, namespace string) error {
+	jobs, err := limitCall(ctx, c, func() (*batchv1.JobList, error) {
+		return c.clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs.Items {
+		if isOwnedBy(job.OwnerReferences, "CronJob") {
+			continue
+		}
+		if err := c.processWorkload(ctx, db, namespace, job.Name, "Job", workloadLabels(job.Labels, job.Spec.Template.Labels), job.Spec.Template.Annotations, job.Spec.Template.Spec, nil); err != nil {
+			slog.Error("Error processing workload", "namespace", namespace, "workload_type", "Job", "workload", job.Name, "error", err)
 		}
 	}
 
 	return nil
 }
 
+// collectCronJobs collects releases from CronJobs in a namespace
+func (c *Client) collectCronJobs(ctx context.Context, db *database.DB, namespace string) error {
+	cronJobs, err := limitCall(ctx, c, func() (*batchv1.CronJobList, error) {
+		return c.clientset.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, cronJob := range cronJobs.Items {
+		if err := c.processWorkload(ctx, db, namespace, cronJob.Name, "CronJob", workloadLabels(cronJob.Labels, cronJob.Spec.JobTemplate.Spec.Template.Labels), cronJob.Spec.JobTemplate.Spec.Template.Annotations, cronJob.Spec.JobTemplate.Spec.Template.Spec, nil); err != nil {
+			slog.Error("Error processing workload", "namespace", namespace, "workload_type", "CronJob", "workload", cronJob.Name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// workloadKinds lists the workload kinds collected, in their canonical spelling
+var workloadKinds = []string{"Deployment", "StatefulSet", "DaemonSet", "Job", "CronJob"}
+
+// WorkloadKind returns the canonical spelling of a workload kind matched case-insensitively
+// (e.g. "deployment" is "Deployment"), and false for kinds that are not collected
+func WorkloadKind(kind string) (string, bool) {
+	for _, known := range workloadKinds {
+		if strings.EqualFold(kind, known) {
+			return known, true
+		}
+	}
+	return "", false
+}
+
+// CollectWorkload collects the containers of a single workload, looking up only the named object
+func (c *Client) CollectWorkload(ctx context.Context, db *database.DB, namespace, kind, name string) error {
+	workloadType, ok := WorkloadKind(kind)
+	if !ok {
+		return fmt.Errorf("unsupported workload kind %q", kind)
+	}
+	monitored, err := c.MonitorsNamespace(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if !monitored {
+		return fmt.Errorf("namespace %q is not monitored", namespace)
+	}
+
+	slog.Info("Collecting releases of workload", "namespace", namespace, "workload_type", workloadType, "workload", name)
+	switch workloadType {
+	case "Deployment":
+		deployment, err := limitCall(ctx, c, func() (*appsv1.Deployment, error) {
+			return c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		return c.processWorkload(ctx, db, namespace, deployment.Name, workloadType, workloadLabels(deployment.Labels, deployment.Spec.Template.Labels), deployment.Spec.Template.Annotations, deployment.Spec.Template.Spec, deploymentReplicas(deployment))
+	case "StatefulSet":
+		statefulSet, err := limitCall(ctx, c, func() (*appsv1.StatefulSet, error) {
+			return c.clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		return c.processWorkload(ctx, db, namespace, statefulSet.Name, workloadType, workloadLabels(statefulSet.Labels, statefulSet.Spec.Template.Labels), statefulSet.Spec.Template.Annotations, statefulSet.Spec.Template.Spec, statefulSetReplicas(statefulSet))
+	case "DaemonSet":
+		daemonSet, err := limitCall(ctx, c, func() (*appsv1.DaemonSet, error) {
+			return c.clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		return c.processWorkload(ctx, db, namespace, daemonSet.Name, workloadType, workloadLabels(daemonSet.Labels, daemonSet.Spec.Template.Labels), daemonSet.Spec.Template.Annotations, daemonSet.Spec.Template.Spec, daemonSetReplicas(daemonSet))
+	case "Job":
+		job, err := limitCall(ctx, c, func() (*batchv1.Job, error) {
+			return c.clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		// Jobs created by a CronJob are collected through their CronJob instead
+		if isOwnedBy(job.OwnerReferences, "CronJob") {
+			return fmt.Errorf("job %s/%s is owned by a CronJob, collect the CronJob instead", namespace, name)
+		}
+		return c.processWorkload(ctx, db, namespace, job.Name, workloadType, workloadLabels(job.Labels, job.Spec.Template.Labels), job.Spec.Template.Annotations, job.Spec.Template.Spec, nil)
+	default:
+		cronJob, err := limitCall(ctx, c, func() (*batchv1.CronJob, error) {
+			return c.clientset.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		})
+		if err != nil {
+			return err
+		}
+		return c.processWorkload(ctx, db, namespace, cronJob.Name, workloadType, workloadLabels(cronJob.Labels, cronJob.Spec.JobTemplate.Spec.Template.Labels), cronJob.Spec.JobTemplate.Spec.Template.Annotations, cronJob.Spec.JobTemplate.Spec.Template.Spec, nil)
+	}
+}
+
+// replicaCounts are the desired and ready replicas of a workload
+type replicaCounts struct {
+	desired int
+	ready   int
+	// fromSpec is set when desired is spec.replicas, which the running pods observed take precedence over
+	fromSpec bool
+}
+
+// deploymentReplicas returns the replica counts of a Deployment; an unset spec.replicas defaults to 1
+func deploymentReplicas(deployment *appsv1.Deployment) *replicaCounts {
+	desired := 1
+	if deployment.Spec.Replicas != nil {
+		desired = int(*deployment.Spec.Replicas)
+	}
+	return &replicaCounts{desired: desired, ready: int(deployment.Status.ReadyReplicas), fromSpec: true}
+}
+
+// statefulSetReplicas returns the replica counts of a StatefulSet; an unset spec.replicas defaults to 1
+func statefulSetReplicas(statefulSet *appsv1.StatefulSet) *replicaCounts {
+	desired := 1
+	if statefulSet.Spec.Replicas != nil {
+		desired = int(*statefulSet.Spec.Replicas)
+	}
+	return &replicaCounts{desired: desired, ready: int(statefulSet.Status.ReadyReplicas), fromSpec: true}
+}
+
+// daemonSetReplicas returns the replica counts of a DaemonSet, which runs one pod per eligible node
+func daemonSetReplicas(daemonSet *appsv1.DaemonSet) *replicaCounts {
+	return &replicaCounts{
+		desired: int(daemonSet.Status.DesiredNumberScheduled),
+		ready:   int(daemonSet.Status.NumberReady),
+	}
+}
+
+// isOwnedBy reports whether the owner references contain an owner of the given kind
+func isOwnedBy(ownerRefs []metav1.OwnerReference, kind string) bool {
+	for _, ownerRef := range ownerRefs {
+		if ownerRef.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
 // // collectReplicaSets collects container images from standalone ReplicaSets
 // func (c *Client) collectReplicaSets(ctx context.Context, db *database.DB, namespace string) error {
 // 	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
@@ -170,136 +628,310 @@ func (c *Client) collectDaemonSets(ctx context.Context, db *database.DB, namespa
 // 			}
 // 		}
 
-// 		if err := c.processWorkload(ctx, db, namespace, replicaSet.Name, "ReplicaSet", replicaSet.Spec.Template.Spec); err != nil {
-// 			log.Printf("Error processing replicaset %s/%s: %v", namespace, replicaSet.Name, err)
+// 		if err := c.processWorkload(ctx, db, namespace, replicaSet.Name, "ReplicaSet", workloadLabels(replicaSet.Labels, replicaSet.Spec.Template.Labels), replicaSet.Spec.Template.Annotations, replicaSet.Spec.Template.Spec); err != nil {
+// 			slog.Error("Error processing workload", "namespace", namespace, "workload_type", "ReplicaSet", "workload", replicaSet.Name, "error", err)
 // 		}
 // 	}
 
 // 	return nil
 // }
 
+// workloadLabels merges the labels of a workload with those of its pod template, workload labels taking precedence
+func workloadLabels(labels, templateLabels map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(templateLabels))
+	for key, value := range templateLabels {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
+
+// trackedAnnotationValues returns the tracked annotations present in annotations, nil if none
+func (c *Client) trackedAnnotationValues(annotations map[string]string) database.Annotations {
+	var tracked database.Annotations
+	for _, key := range c.trackedAnnotations {
+		if value, ok := annotations[key]; ok {
+			if tracked == nil {
+				tracked = make(database.Annotations)
+			}
+			tracked[key] = value
+		}
+	}
+	return tracked
+}
+
+// labelValue returns the value of a label, or an empty string when the key is not configured
+func labelValue(labels map[string]string, key string) string {
+	if key == "" {
+		return ""
+	}
+	return labels[key]
+}
+
 // processWorkload processes a workload's pod spec and extracts container information
-func (c *Client) processWorkload(ctx context.Context, db *database.DB, namespace, workloadName, workloadType string, podSpec corev1.PodSpec) error {
+func (c *Client) processWorkload(ctx context.Context, db *database.DB, namespace, workloadName, workloadType string, labels, annotations map[string]string, podSpec corev1.PodSpec, replicas *replicaCounts) error {
 	now := time.Now()
 
-	// Process all containers (including init containers)
-	//allContainers := append(podSpec.Containers, podSpec.InitContainers...)
-
-	// For now, only process app containers
-	allContainers := podSpec.Containers
+	// Process all containers, recording init containers under a prefix so they never collide
+	// with an app container of the same name
+	allContainers := append([]corev1.Container(nil), podSpec.Containers...)
+	for _, initContainer := range podSpec.InitContainers {
+		initContainer.Name = InitContainerPrefix + initContainer.Name
+		allContainers = append(allContainers, initContainer)
+	}
 
 	// Get client and environment names from environment variables
-	clientName := os.Getenv("CLIENT_NAME")
+	clientName := database.NormalizeName(os.Getenv("CLIENT_NAME"), c.lowercaseNames)
 	if clientName == "" {
-		log.Printf("Error: CLIENT_NAME environment variable not set.")
+		slog.Error("CLIENT_NAME environment variable not set")
 		return fmt.Errorf("CLIENT_NAME environment variable not set")
 	}
-	envName := os.Getenv("ENV_NAME")
+	envName := database.NormalizeName(os.Getenv("ENV_NAME"), c.lowercaseNames)
 	if envName == "" {
-		log.Printf("Error: ENV_NAME environment variable not set.")
+		slog.Error("ENV_NAME environment variable not set")
 		return fmt.Errorf("ENV_NAME environment variable not set")
 	}
+	// Cluster name is optional and only used to tell apart environments spread over several clusters
+	clusterName := os.Getenv("CLUSTER_NAME")
+	logger := slog.With("client", clientName, "env", envName, "namespace", namespace, "workload", workloadName)
+
+	if c.trackScaling && replicas != nil {
+		c.writeMu.Lock()
+		_, err := db.RecordScalingEvent(&database.ScalingEvent{
+			Namespace:    namespace,
+			WorkloadName: workloadName,
+			WorkloadType: workloadType,
+			ClientName:   clientName,
+			EnvName:      envName,
+			Replicas:     replicas.desired,
+			RecordedAt:   now,
+		})
+		c.writeMu.Unlock()
+		if err != nil {
+			logger.Error("Failed to record scaling event", "error", err)
+		}
+	}
+
+	// Chart and app versions come from workload labels and apply to every container
+	chartVersion := labelValue(labels, c.chartVersionLabel)
+	appVersion := labelValue(labels, c.appVersionLabel)
+
+	// Tracked annotations come from the pod template and apply to every container
+	trackedAnnotations := c.trackedAnnotationValues(annotations)
+
+	displayOrder := 0
+	if value := labelValue(labels, c.orderLabel); value != "" {
+		var err error
+		if displayOrder, err = strconv.Atoi(value); err != nil {
+			logger.Warn("Ignoring invalid order label", "label", c.orderLabel, "value", value)
+		}
+	}
 
 	for _, container := range allContainers {
-		repo, name, tag := database.ParseImagePath(container.Image)
+		image := database.ParseImagePath(container.Image)
+		containerLog := logger.With("container", container.Name)
 
 		// Get the actual image SHA256 from running pods
-		imageSHA, err := c.getImageSHAFromPods(ctx, namespace, workloadName, workloadType, container.Name)
+		imageSHA, startedAt, running, err := c.getImageSHAFromPods(ctx, namespace, workloadName, workloadType, container.Name)
+		// Without running pods (scaled to zero, mid-rollout), an image pinned by digest still tells the SHA
+		if errors.Is(err, errNoRunningPods) && image.SHA != "" {
+			imageSHA, err = image.SHA, nil
+			containerLog.Info("No running pods, using the digest pinned in the spec")
+		}
 		if err != nil {
-			log.Printf("Error: Could not get image SHA for %s/%s/%s: %v", namespace, workloadName, container.Name, err)
+			containerLog.Error("Could not get image SHA", "error", err)
+			c.metrics.CollectionError()
 			// Do not Continue with empty SHA
-			// Skip this container
+			// Record the gap so it can be inspected via the API, then skip this container
+			category := database.CollectionErrorUnresolved
+			if errors.Is(err, errNoRunningPods) {
+				category = database.CollectionErrorNoRunningPods
+			}
+			c.writeMu.Lock()
+			recordErr := db.RecordCollectionError(&database.CollectionError{
+				Namespace:     namespace,
+				WorkloadName:  workloadName,
+				WorkloadType:  workloadType,
+				ContainerName: container.Name,
+				ClientName:    clientName,
+				EnvName:       envName,
+				Error:         err.Error(),
+				Category:      category,
+			})
+			c.writeMu.Unlock()
+			if recordErr != nil {
+				containerLog.Error("Failed to record collection error", "error", recordErr)
+			}
 			continue
 		}
 
+		// Registry errors only cost the labels of this collection, the release is recorded regardless
+		imageLabels, err := c.registry.ImageLabels(ctx, container.Image, imageSHA)
+		if err != nil {
+			containerLog.Warn("Could not read image labels", "error", err)
+		}
+
 		// Create release object for historical data
 		release := &database.Release{
 			Namespace:     namespace,
 			WorkloadName:  workloadName,
 			WorkloadType:  workloadType,
 			ContainerName: container.Name,
-			ImageRepo:     repo,
-			ImageName:     name,
-			ImageTag:      tag,
+			ImageRepo:     image.Repo,
+			ImageName:     image.Name,
+			ImageTag:      image.Tag,
 			ImageSHA:      imageSHA,
+			SpecDigest:    image.SHA,
 			ClientName:    clientName,
 			EnvName:       envName,
+			ClusterName:   clusterName,
+			ChartVersion:  chartVersion,
+			AppVersion:    appVersion,
+			DisplayOrder:  displayOrder,
+			ImageLabels:   imageLabels,
+			Annotations:   trackedAnnotations,
+			ReportedBy:    "krelease-tracker/" + version.Version + " (collector)",
+			Source:        database.SourceCollection,
 			FirstSeen:     now,
 			LastSeen:      now,
 		}
+		if !startedAt.IsZero() {
+			release.StartedAt = &startedAt
+		}
+		if replicas != nil {
+			counts := *replicas
+			// Autoscaled workloads run another number of pods than spec.replicas asks for
+			if counts.fromSpec && running > 0 {
+				counts.desired = running
+			}
+			release.Replicas, release.ReadyReplicas = &counts.desired, &counts.ready
+		}
 
-		// Always store in releases table for historical data
-		if err := db.UpsertRelease(release); err != nil {
-			return fmt.Errorf("failed to upsert release: %w", err)
+		if err := c.storeRelease(ctx, db, release, containerLog); err != nil {
+			return err
 		}
+	}
 
-		// In slave mode, also store in pending_releases table as queue
-		if c.mode == "slave" {
-			pendingRelease := &database.PendingRelease{
-				Namespace:     namespace,
-				WorkloadName:  workloadName,
-				WorkloadType:  workloadType,
-				ContainerName: container.Name,
-				ImageRepo:     repo,
-				ImageName:     name,
-				ImageTag:      tag,
-				ImageSHA:      imageSHA,
-				ClientName:    clientName,
-				EnvName:       envName,
-				FirstSeen:     now,
-				LastSeen:      now,
-			}
+	return nil
+}
 
-			if err := db.UpsertPendingRelease(pendingRelease); err != nil {
-				return fmt.Errorf("failed to upsert pending release: %w", err)
-			}
+// storeRelease records a collected release and, in slave mode, queues it for sync. Writes are
+// serialized across the namespaces collected concurrently, as sqlite has a single writer.
+func (c *Client) storeRelease(ctx context.Context, db *database.DB, release *database.Release, logger *slog.Logger) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// Remember the current release to detect version changes and tag reuse
+	var previous *database.ReleaseProvenance
+	if c.notifier != nil || c.trackRestarts {
+		var err error
+		if previous, err = db.GetReleaseProvenance(release.Namespace, release.WorkloadName, release.ContainerName, release.ClientName, release.EnvName); err != nil {
+			logger.Error("Failed to get current release", "error", err)
 		}
 	}
+	if notify.IsTagReuse(previous, release) {
+		logger.Warn("Tag reuse detected", "tag", release.ImageTag, "sha", release.ImageSHA, "previous_sha", previous.ImageSHA)
+	}
+
+	// Always store in releases table for historical data
+	if err := db.UpsertRelease(release); err != nil {
+		return fmt.Errorf("failed to upsert release: %w", err)
+	}
+	c.metrics.ReleaseUpserted()
+
+	notify.NotifyVersionChange(ctx, c.notifier, previous, release)
+
+	if err := db.ClearCollectionError(release.Namespace, release.WorkloadName, release.ContainerName, release.ClientName, release.EnvName); err != nil {
+		logger.Error("Failed to clear collection error", "error", err)
+	}
 
+	// In slave mode, also store in pending_releases table as queue
+	if c.mode == "slave" {
+		pendingRelease := &database.PendingRelease{
+			Namespace:     release.Namespace,
+			WorkloadName:  release.WorkloadName,
+			WorkloadType:  release.WorkloadType,
+			ContainerName: release.ContainerName,
+			ImageRepo:     release.ImageRepo,
+			ImageName:     release.ImageName,
+			ImageTag:      release.ImageTag,
+			ImageSHA:      release.ImageSHA,
+			SpecDigest:    release.SpecDigest,
+			ClientName:    release.ClientName,
+			EnvName:       release.EnvName,
+			ClusterName:   release.ClusterName,
+			ChartVersion:  release.ChartVersion,
+			AppVersion:    release.AppVersion,
+			DisplayOrder:  release.DisplayOrder,
+			ImageLabels:   release.ImageLabels,
+			Annotations:   release.Annotations,
+			Replicas:      release.Replicas,
+			ReadyReplicas: release.ReadyReplicas,
+			StartedAt:     release.StartedAt,
+			FirstSeen:     release.FirstSeen,
+			LastSeen:      release.LastSeen,
+		}
+
+		if err := db.UpsertPendingRelease(pendingRelease); err != nil {
+			return fmt.Errorf("failed to upsert pending release: %w", err)
+		}
+	}
 	return nil
 }
 
-// getImageSHAFromPods queries running pods to get the actual image SHA256 digest for a container
-func (c *Client) getImageSHAFromPods(ctx context.Context, namespace, workloadName, workloadType, containerName string) (string, error) {
-	// Create label selector based on workload type
-	var labelSelector string
+// getImageSHAFromPods queries running pods to get the actual image SHA256 digest for a container.
+// It also returns when the earliest container running that digest started (zero if unknown) and how many
+// of the workload's pods are running.
+func (c *Client) getImageSHAFromPods(ctx context.Context, namespace, workloadName, workloadType, containerName string) (sha string, startedAt time.Time, running int, err error) {
+	ctx, span := tracing.Start(ctx, "resolve image SHA", trace.WithAttributes(attribute.String("k8s.namespace.name", namespace),
+		attribute.String("krelease.workload.kind", workloadType), attribute.String("krelease.workload.name", workloadName),
+		attribute.String("k8s.container.name", containerName)))
+	defer func() { tracing.End(span, err) }()
+
+	// Configured selectors come first, then the label set on the workload type's pods by convention
+	var selectors []string
+	for _, template := range c.podSelectors {
+		selectors = append(selectors, podSelector(template, workloadName))
+	}
 	switch workloadType {
-	case "Deployment":
-		labelSelector = fmt.Sprintf("app=%s", workloadName)
-	case "StatefulSet":
-		labelSelector = fmt.Sprintf("app=%s", workloadName)
-	case "DaemonSet":
-		labelSelector = fmt.Sprintf("app=%s", workloadName)
+	case "Job":
+		// Set by the Job controller on every pod it creates
+		selectors = append(selectors, fmt.Sprintf("job-name=%s", workloadName))
 	default:
-		// Try common label patterns
-		labelSelector = fmt.Sprintf("app=%s", workloadName)
+		selectors = append(selectors, fmt.Sprintf("app=%s", workloadName))
 	}
+	// Try with workload name as the recommended name label
+	selectors = append(selectors, fmt.Sprintf("app.kubernetes.io/name=%s", workloadName))
 
-	// Query pods with the label selector
-	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to list pods: %w", err)
-	}
+	// Record every lookup so /api/admin/diagnostics/selectors can tell why no pods were found
+	var attempts []SelectorAttempt
+	defer func() {
+		c.diagnostics.record(namespace, workloadType, workloadName, attempts)
+	}()
 
-	// If no pods found with app label, try alternative selectors
-	if len(pods.Items) == 0 {
-		// Try with workload name as label value
-		labelSelector = fmt.Sprintf("app.kubernetes.io/name=%s", workloadName)
-		pods, err = c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-			LabelSelector: labelSelector,
-		})
+	// Query pods with each label selector until one matches
+	pods := &corev1.PodList{}
+	for _, labelSelector := range selectors {
+		var err error
+		pods, err = c.listPods(ctx, namespace, labelSelector)
 		if err != nil {
-			return "", fmt.Errorf("failed to list pods with alternative selector: %w", err)
+			attempts = append(attempts, SelectorAttempt{Selector: labelSelector, Error: err.Error()})
+			return "", time.Time{}, 0, fmt.Errorf("failed to list pods with selector %q: %w", labelSelector, err)
+		}
+		attempts = append(attempts, SelectorAttempt{Selector: labelSelector, Pods: len(pods.Items)})
+		if len(pods.Items) > 0 {
+			break
 		}
 	}
 
 	// If still no pods found, try without label selector but filter by owner reference
 	if len(pods.Items) == 0 {
-		allPods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		allPods, err := c.listPods(ctx, namespace, "")
 		if err != nil {
-			return "", fmt.Errorf("failed to list all pods: %w", err)
+			attempts = append(attempts, SelectorAttempt{Selector: ownerReferenceSelector, Error: err.Error()})
+			return "", time.Time{}, 0, fmt.Errorf("failed to list all pods: %w", err)
 		}
 
 		// Filter pods by owner reference
@@ -309,10 +941,26 @@ func (c *Client) getImageSHAFromPods(ctx context.Context, namespace, workloadNam
 					pods.Items = append(pods.Items, pod)
 					break
 				}
+				// Also check for Job ownership (for CronJobs)
+				if ownerRef.Kind == "Job" && workloadType == "CronJob" {
+					job, err := limitCall(ctx, c, func() (*batchv1.Job, error) {
+						return c.clientset.BatchV1().Jobs(namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+					})
+					if err == nil {
+						for _, jobOwnerRef := range job.OwnerReferences {
+							if jobOwnerRef.Kind == "CronJob" && jobOwnerRef.Name == workloadName {
+								pods.Items = append(pods.Items, pod)
+								break
+							}
+						}
+					}
+				}
 				// Also check for ReplicaSet ownership (for Deployments)
 				if ownerRef.Kind == "ReplicaSet" && workloadType == "Deployment" {
 					// Get the ReplicaSet to check its owner
-					rs, err := c.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+					rs, err := limitCall(ctx, c, func() (*appsv1.ReplicaSet, error) {
+						return c.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+					})
 					if err == nil {
 						for _, rsOwnerRef := range rs.OwnerReferences {
 							if rsOwnerRef.Kind == "Deployment" && rsOwnerRef.Name == workloadName {
@@ -324,10 +972,19 @@ func (c *Client) getImageSHAFromPods(ctx context.Context, namespace, workloadNam
 				}
 			}
 		}
+		attempts = append(attempts, SelectorAttempt{Selector: ownerReferenceSelector, Pods: len(pods.Items)})
 	}
 
 	if len(pods.Items) == 0 {
-		return "", fmt.Errorf("no running pods found for %s/%s", workloadType, workloadName)
+		return "", time.Time{}, 0, fmt.Errorf("%w for %s/%s", errNoRunningPods, workloadType, workloadName)
+	}
+
+	// Init containers have already completed (or run as sidecars) and are never ready
+	if initName, ok := strings.CutPrefix(containerName, InitContainerPrefix); ok {
+		if sha256 := getImageSHAFromInitContainers(pods.Items, initName); sha256 != "" {
+			return sha256, earliestContainerStart(pods.Items, initName, sha256), countRunningPods(pods.Items), nil
+		}
+		return "", time.Time{}, 0, fmt.Errorf("no started init container %s found in pods for %s/%s", initName, workloadType, workloadName)
 	}
 
 	// Look for a running pod with the specified container
@@ -350,13 +1007,201 @@ func (c *Client) getImageSHAFromPods(ctx context.Context, namespace, workloadNam
 				// Extract SHA256 digest from ImageID
 				sha256 := extractSHA256FromImageID(imageID)
 				if sha256 != "" {
-					return sha256, nil
+					return sha256, earliestContainerStart(pods.Items, containerName, sha256), countRunningPods(pods.Items), nil
 				}
 			}
 		}
 	}
 
-	return "", fmt.Errorf("no ready container %s found in running pods for %s/%s", containerName, workloadType, workloadName)
+	// Restarted containers are not ready while crash-looping but already run the image they restarted with
+	if c.trackRestarts {
+		if sha256 := getImageSHAFromRestartedContainers(pods.Items, containerName); sha256 != "" {
+			return sha256, earliestContainerStart(pods.Items, containerName, sha256), countRunningPods(pods.Items), nil
+		}
+	}
+
+	// Run-to-completion workloads usually have no running pod left, use the most recent completed container
+	if workloadType == "Job" || workloadType == "CronJob" {
+		if sha256 := getImageSHAFromCompletedPods(pods.Items, containerName); sha256 != "" {
+			return sha256, earliestContainerStart(pods.Items, containerName, sha256), countRunningPods(pods.Items), nil
+		}
+	}
+
+	// Fall back to pods in the configured phases, most recent first (e.g. new pods of a slow rollout)
+	if sha256 := c.getImageSHAFromAcceptedPods(pods.Items, containerName); sha256 != "" {
+		return sha256, earliestContainerStart(pods.Items, containerName, sha256), countRunningPods(pods.Items), nil
+	}
+
+	// Pods of a finished Job are expected not to run, anything else without a running pod is a ghost
+	if workloadType != "Job" && workloadType != "CronJob" && !hasRunningPod(pods.Items) {
+		return "", time.Time{}, 0, fmt.Errorf("%w for %s/%s (%d pods, none running)", errNoRunningPods, workloadType, workloadName, len(pods.Items))
+	}
+
+	return "", time.Time{}, 0, fmt.Errorf("no ready container %s found in running pods for %s/%s", containerName, workloadType, workloadName)
+}
+
+// hasRunningPod reports whether any of the pods is in the Running phase
+func hasRunningPod(pods []corev1.Pod) bool {
+	return countRunningPods(pods) > 0
+}
+
+// countRunningPods returns how many of the pods are in the Running phase
+func countRunningPods(pods []corev1.Pod) int {
+	running := 0
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning {
+			running++
+		}
+	}
+	return running
+}
+
+// earliestContainerStart returns when the first container with the given name started running the given
+// image digest, looking at running and terminated states of app and init containers (zero if unknown)
+func earliestContainerStart(pods []corev1.Pod, containerName, sha256 string) time.Time {
+	var earliest time.Time
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus(nil), pod.Status.ContainerStatuses...), pod.Status.InitContainerStatuses...)
+		for _, containerStatus := range statuses {
+			if containerStatus.Name != containerName || extractSHA256FromImageID(containerStatus.ImageID) != sha256 {
+				continue
+			}
+
+			var startedAt time.Time
+			switch {
+			case containerStatus.State.Running != nil:
+				startedAt = containerStatus.State.Running.StartedAt.Time
+			case containerStatus.State.Terminated != nil:
+				startedAt = containerStatus.State.Terminated.StartedAt.Time
+			}
+			if !startedAt.IsZero() && (earliest.IsZero() || startedAt.Before(earliest)) {
+				earliest = startedAt
+			}
+		}
+	}
+
+	return earliest
+}
+
+// getImageSHAFromCompletedPods looks for the image SHA of a container that terminated successfully,
+// checking the most recent pods first. Init containers are included since some Jobs only run those.
+func getImageSHAFromCompletedPods(pods []corev1.Pod, containerName string) string {
+	sorted := append([]corev1.Pod(nil), pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[j].CreationTimestamp.Before(&sorted[i].CreationTimestamp)
+	})
+
+	for _, pod := range sorted {
+		statuses := append(append([]corev1.ContainerStatus(nil), pod.Status.ContainerStatuses...), pod.Status.InitContainerStatuses...)
+		for _, containerStatus := range statuses {
+			if containerStatus.Name != containerName {
+				continue
+			}
+			terminated := containerStatus.State.Terminated
+			if terminated == nil || terminated.ExitCode != 0 {
+				continue
+			}
+			if sha256 := extractSHA256FromImageID(containerStatus.ImageID); sha256 != "" {
+				return sha256
+			}
+		}
+	}
+
+	return ""
+}
+
+// getImageSHAFromInitContainers looks for the image SHA of an init container that completed successfully
+// or is still running (sidecars), in running or succeeded pods, checking the most recent pods first
+func getImageSHAFromInitContainers(pods []corev1.Pod, containerName string) string {
+	sorted := append([]corev1.Pod(nil), pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[j].CreationTimestamp.Before(&sorted[i].CreationTimestamp)
+	})
+
+	for _, pod := range sorted {
+		if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, containerStatus := range pod.Status.InitContainerStatuses {
+			if containerStatus.Name != containerName {
+				continue
+			}
+			terminated := containerStatus.State.Terminated
+			if containerStatus.State.Running == nil && (terminated == nil || terminated.ExitCode != 0) {
+				continue
+			}
+			if sha256 := extractSHA256FromImageID(containerStatus.ImageID); sha256 != "" {
+				return sha256
+			}
+		}
+	}
+
+	return ""
+}
+
+// getImageSHAFromRestartedContainers looks for the image SHA of a container that restarted in a running pod,
+// checking the most recent pods first. Readiness is not required.
+func getImageSHAFromRestartedContainers(pods []corev1.Pod, containerName string) string {
+	sorted := append([]corev1.Pod(nil), pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[j].CreationTimestamp.Before(&sorted[i].CreationTimestamp)
+	})
+
+	for _, pod := range sorted {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Name != containerName || containerStatus.RestartCount == 0 {
+				continue
+			}
+			if sha256 := extractSHA256FromImageID(containerStatus.ImageID); sha256 != "" {
+				return sha256
+			}
+		}
+	}
+
+	return ""
+}
+
+// getImageSHAFromAcceptedPods looks for the image SHA of a container in pods whose phase is
+// accepted through SHA_ACCEPT_PHASES, without requiring the container to be ready
+func (c *Client) getImageSHAFromAcceptedPods(pods []corev1.Pod, containerName string) string {
+	if len(c.acceptPhases) == 0 {
+		return ""
+	}
+
+	var accepted []corev1.Pod
+	for _, pod := range pods {
+		if c.acceptPhases[pod.Status.Phase] {
+			accepted = append(accepted, pod)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[j].CreationTimestamp.Before(&accepted[i].CreationTimestamp)
+	})
+
+	for _, pod := range accepted {
+		// Prefer the image ID reported by the container runtime
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Name == containerName {
+				if sha256 := extractSHA256FromImageID(containerStatus.ImageID); sha256 != "" {
+					return sha256
+				}
+			}
+		}
+
+		// Otherwise use a digest pinned in the pod spec (image@sha256:...)
+		for _, container := range pod.Spec.Containers {
+			if container.Name == containerName {
+				if sha256 := extractSHA256FromImageID(container.Image); sha256 != "" {
+					return sha256
+				}
+			}
+		}
+	}
+
+	return ""
 }
 
 // extractSHA256FromImageID extracts the SHA256 digest from a Kubernetes ImageID
@@ -0,0 +1,1356 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/notify"
+	"krelease-tracker/internal/registry"
+)
+
+const testDigest = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// newTestDeployment returns a deployment and a running, ready pod backing it
+func newTestDeployment(namespace, name, image string) (*appsv1.Deployment, *corev1.Pod) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: image}},
+				},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-abc12",
+			Namespace: namespace,
+			Labels:    map[string]string{"app": name},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:    "app",
+				Ready:   true,
+				ImageID: "docker-pullable://registry.example.com/web@sha256:" + testDigest,
+			}},
+		},
+	}
+	return deployment, pod
+}
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCollectReleasesRecordsClusterName(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+	t.Setenv("CLUSTER_NAME", "eu-west-1")
+
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	client := NewFromClientset(fake.NewSimpleClientset(deployment, pod), []string{"default"}, "slave")
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatalf("Failed to get pending releases: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("Expected 1 pending release, got %d", len(pending))
+	}
+	if pending[0].ClusterName != "eu-west-1" {
+		t.Errorf("Expected pending release cluster name 'eu-west-1', got %q", pending[0].ClusterName)
+	}
+	if pending[0].ImageSHA != testDigest {
+		t.Errorf("Expected image SHA %q, got %q", testDigest, pending[0].ImageSHA)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatalf("Failed to get current releases: %v", err)
+	}
+	if len(current) != 1 || current[0].ClusterName != "eu-west-1" {
+		t.Errorf("Expected current release with cluster name 'eu-west-1', got %+v", current)
+	}
+
+	history, err := db.GetReleaseHistory("default", "web", "app", "acme", "prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Releases) != 1 || history.Releases[0].Source != database.SourceCollection {
+		t.Errorf("Expected the release to be recorded by collection, got %+v", history.Releases)
+	}
+}
+
+func TestCollectWorkloadCollectsOnlyTheNamedWorkload(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	web, webPod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	api, apiPod := newTestDeployment("default", "api", "registry.example.com/api:v2.0.0")
+	client := NewFromClientset(fake.NewSimpleClientset(web, webPod, api, apiPod), []string{"default"}, "master")
+	db := newTestDB(t)
+
+	if err := client.CollectWorkload(context.Background(), db, "default", "deployment", "web"); err != nil {
+		t.Fatalf("CollectWorkload failed: %v", err)
+	}
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].WorkloadName != "web" || current[0].WorkloadType != "Deployment" {
+		t.Errorf("Expected only the web deployment to be collected, got %+v", current)
+	}
+
+	for _, tt := range []struct{ namespace, kind, name string }{
+		{"default", "Deployment", "missing"},
+		{"default", "ReplicaSet", "web"},
+		{"kube-system", "Deployment", "web"},
+	} {
+		if err := client.CollectWorkload(context.Background(), db, tt.namespace, tt.kind, tt.name); err == nil {
+			t.Errorf("Expected an error collecting %s %s/%s", tt.kind, tt.namespace, tt.name)
+		}
+	}
+}
+
+func TestCollectReleasesWildcardNamespaces(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	namespace := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	web, webPod := newTestDeployment("team-a", "web", "registry.example.com/web:v1.2.3")
+	dns, dnsPod := newTestDeployment("kube-system", "coredns", "registry.example.com/coredns:v1.11.1")
+	clientset := fake.NewSimpleClientset(namespace("team-a"), namespace("kube-system"), web, webPod, dns, dnsPod)
+	client := NewFromClientset(clientset, []string{AllNamespaces}, "master")
+	client.SetExcludedNamespaces([]string{"kube-system"})
+	db := newTestDB(t)
+
+	collected := func() []string {
+		t.Helper()
+		if err := client.CollectReleases(context.Background(), db); err != nil {
+			t.Fatalf("CollectReleases failed: %v", err)
+		}
+		current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var namespaces []string
+		for _, release := range current {
+			namespaces = append(namespaces, release.Namespace)
+		}
+		return namespaces
+	}
+
+	if namespaces := collected(); len(namespaces) != 1 || namespaces[0] != "team-a" {
+		t.Errorf("Expected only team-a to be collected, got %v", namespaces)
+	}
+
+	// Namespaces created later are picked up by the next collection
+	api, apiPod := newTestDeployment("team-b", "api", "registry.example.com/api:v2.0.0")
+	for _, object := range []runtime.Object{namespace("team-b"), api, apiPod} {
+		if err := clientset.Tracker().Add(object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if namespaces := collected(); len(namespaces) != 2 {
+		t.Errorf("Expected team-a and team-b to be collected, got %v", namespaces)
+	}
+	if resolved, err := client.ResolvedNamespaces(context.Background()); err != nil || !reflect.DeepEqual(resolved, []string{"team-a", "team-b"}) {
+		t.Errorf("Expected the wildcard to resolve to team-a and team-b, got %v (%v)", resolved, err)
+	}
+
+	if err := client.CollectWorkload(context.Background(), db, "kube-system", "Deployment", "coredns"); err == nil {
+		t.Error("Expected an excluded namespace not to be monitored")
+	}
+	if err := client.CollectWorkload(context.Background(), db, "team-b", "Deployment", "api"); err != nil {
+		t.Errorf("Expected a discovered namespace to be monitored, got %v", err)
+	}
+}
+
+func TestCollectReleasesNamespaceLabelSelector(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	tracked := map[string]string{"tracked": "true"}
+	web, webPod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	api, apiPod := newTestDeployment("team-a", "api", "registry.example.com/api:v2.0.0")
+	worker, workerPod := newTestDeployment("team-b", "worker", "registry.example.com/worker:v3.0.0")
+	clientset := fake.NewSimpleClientset(namespace("default", nil), namespace("team-a", tracked), namespace("team-b", nil),
+		web, webPod, api, apiPod, worker, workerPod)
+	client := NewFromClientset(clientset, []string{"default"}, "master")
+	client.SetNamespaceLabelSelector("tracked=true")
+	db := newTestDB(t)
+
+	collected := func() map[string]bool {
+		t.Helper()
+		if err := client.CollectReleases(context.Background(), db); err != nil {
+			t.Fatalf("CollectReleases failed: %v", err)
+		}
+		current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		namespaces := make(map[string]bool)
+		for _, release := range current {
+			namespaces[release.Namespace] = true
+		}
+		return namespaces
+	}
+
+	// The explicit namespace and the selected one are both collected
+	if namespaces := collected(); len(namespaces) != 2 || !namespaces["default"] || !namespaces["team-a"] {
+		t.Errorf("Expected default and team-a to be collected, got %v", namespaces)
+	}
+	if err := client.CollectWorkload(context.Background(), db, "team-b", "Deployment", "worker"); err == nil {
+		t.Error("Expected an unlabelled namespace not to be monitored")
+	}
+
+	// Labelling a namespace adds it to the next collection
+	if _, err := clientset.CoreV1().Namespaces().Update(context.Background(), namespace("team-b", tracked), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if namespaces := collected(); !namespaces["team-b"] {
+		t.Errorf("Expected the newly labelled team-b to be collected, got %v", namespaces)
+	}
+	if err := client.CollectWorkload(context.Background(), db, "team-b", "Deployment", "worker"); err != nil {
+		t.Errorf("Expected a labelled namespace to be monitored, got %v", err)
+	}
+}
+
+func TestCollectReleasesConcurrentNamespaces(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	var objects []runtime.Object
+	namespaces := []string{"broken"}
+	for i := 0; i < 6; i++ {
+		namespace := fmt.Sprintf("team-%d", i)
+		deployment, pod := newTestDeployment(namespace, "web", "registry.example.com/web:v1.2.3")
+		objects = append(objects, deployment, pod)
+		namespaces = append(namespaces, namespace)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "broken" {
+			return true, nil, fmt.Errorf("forbidden")
+		}
+		return false, nil, nil
+	})
+	client := NewFromClientset(clientset, namespaces, "slave")
+	client.SetCollectionConcurrency(3)
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	// The failing namespace is skipped, every other namespace is recorded once
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 6 || len(pending) != 6 {
+		t.Errorf("Expected 6 current and pending releases, got %d and %d", len(current), len(pending))
+	}
+}
+
+func TestCollectReleasesFailsWhenEveryNamespaceFails(t *testing.T) {
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	clientset := fake.NewSimpleClientset(deployment, pod)
+	clientset.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("forbidden")
+	})
+	client := NewFromClientset(clientset, []string{"default", "payments"}, "slave")
+
+	// Revoked RBAC must not look like a successful collection to the health check
+	err := client.CollectReleases(context.Background(), newTestDB(t))
+	if err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("Expected the collection to fail when every namespace fails, got %v", err)
+	}
+}
+
+func TestCollectReleasesListsPodsOncePerNamespace(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	var objects []runtime.Object
+	for _, name := range []string{"web", "api", "worker"} {
+		deployment, pod := newTestDeployment("default", name, "registry.example.com/"+name+":v1")
+		objects = append(objects, deployment, pod)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	podLists := 0
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		podLists++
+		return false, nil, nil
+	})
+	client := NewFromClientset(clientset, []string{"default"}, "master")
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+	if podLists != 1 {
+		t.Errorf("Expected pods to be listed once for the namespace, got %d lists", podLists)
+	}
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 3 {
+		t.Fatalf("Expected 3 current releases, got %d", len(current))
+	}
+
+	// The next run lists the pods again and sees the new workload
+	deployment, pod := newTestDeployment("default", "cron", "registry.example.com/cron:v1")
+	if _, err := clientset.AppsV1().Deployments("default").Create(context.Background(), deployment, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+	if podLists != 2 {
+		t.Errorf("Expected pods to be listed again by the next run, got %d lists", podLists)
+	}
+	current, err = db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 4 {
+		t.Errorf("Expected 4 current releases after the new workload, got %d", len(current))
+	}
+}
+
+func TestCollectReleasesRecordsTrackedAnnotations(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	deployment.Spec.Template.Annotations = map[string]string{"git-commit": "0123abc", "kubectl.kubernetes.io/restartedAt": "2026-10-01T00:00:00Z"}
+	client := NewFromClientset(fake.NewSimpleClientset(deployment, pod), []string{"default"}, "slave")
+	client.SetTrackedAnnotations([]string{"git-commit", "ci/pipeline"})
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	// Only the tracked annotations present on the pod template are recorded
+	want := database.Annotations{"git-commit": "0123abc"}
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || !reflect.DeepEqual(current[0].Annotations, want) {
+		t.Errorf("Expected annotations %v in current releases, got %+v", want, current)
+	}
+	history, err := db.GetReleaseHistory("default", "web", "app", "acme", "prod", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Releases) != 1 || !reflect.DeepEqual(history.Releases[0].Annotations, want) {
+		t.Errorf("Expected annotations %v in the release history, got %+v", want, history.Releases)
+	}
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || !reflect.DeepEqual(pending[0].Annotations, want) {
+		t.Errorf("Expected annotations %v on the pending release, got %+v", want, pending)
+	}
+}
+
+func TestCollectReleasesRecordsVersionLabels(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	deployment.Labels = map[string]string{"helm.sh/chart": "web-4.5.6"}
+	deployment.Spec.Template.Labels = map[string]string{"app.kubernetes.io/version": "1.2.3", "helm.sh/chart": "web-4.5.5"}
+	unlabeled, unlabeledPod := newTestDeployment("default", "worker", "registry.example.com/worker:v2.0.0")
+	unlabeledPod.Name = "worker-abc12"
+
+	client := NewFromClientset(fake.NewSimpleClientset(deployment, pod, unlabeled, unlabeledPod), []string{"default"}, "slave")
+	client.SetVersionLabels("helm.sh/chart", "app.kubernetes.io/version")
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatalf("Failed to get current releases: %v", err)
+	}
+	versions := make(map[string][2]string)
+	for _, r := range current {
+		versions[r.WorkloadName] = [2]string{r.ChartVersion, r.AppVersion}
+	}
+	// Workload labels take precedence over pod template labels
+	if versions["web"] != [2]string{"web-4.5.6", "1.2.3"} {
+		t.Errorf("Expected chart web-4.5.6 and app 1.2.3 for web, got %v", versions["web"])
+	}
+	if versions["worker"] != [2]string{"", ""} {
+		t.Errorf("Expected no versions for unlabeled worker, got %v", versions["worker"])
+	}
+
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatalf("Failed to get pending releases: %v", err)
+	}
+	for _, r := range pending {
+		if r.WorkloadName == "web" && (r.ChartVersion != "web-4.5.6" || r.AppVersion != "1.2.3") {
+			t.Errorf("Expected versions on pending release, got chart %q app %q", r.ChartVersion, r.AppVersion)
+		}
+	}
+}
+
+func TestCollectReleasesRecordsDisplayOrder(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	web, webPod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	web.Labels = map[string]string{"krelease-tracker/order": "-5"}
+	proxy, proxyPod := newTestDeployment("default", "proxy", "registry.example.com/proxy:v1")
+	proxy.Labels = map[string]string{"krelease-tracker/order": "last"}
+	proxyPod.Labels = map[string]string{"app": "proxy"}
+
+	client := NewFromClientset(fake.NewSimpleClientset(web, webPod, proxy, proxyPod), []string{"default"}, "slave")
+	client.SetOrderLabel("krelease-tracker/order")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := make(map[string]int)
+	for _, r := range current {
+		orders[r.WorkloadName] = r.DisplayOrder
+	}
+	// Invalid values are ignored and fall back to the default order
+	if len(orders) != 2 || orders["web"] != -5 || orders["proxy"] != 0 {
+		t.Errorf("Expected web at -5 and proxy at 0, got %v", orders)
+	}
+
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range pending {
+		if r.WorkloadName == "web" && r.DisplayOrder != -5 {
+			t.Errorf("Expected display order on pending release, got %d", r.DisplayOrder)
+		}
+	}
+}
+
+func TestCollectReleasesIgnoresRegistryErrors(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// Nothing listens on the registry, so reading the image labels fails
+	web, webPod := newTestDeployment("default", "web", "127.0.0.1:1/web:v1")
+	client := NewFromClientset(fake.NewSimpleClientset(web, webPod), []string{"default"}, "slave")
+	client.SetRegistry(registry.New([]string{"org.opencontainers.image.revision"}))
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].ImageTag != "v1" || current[0].ImageLabels != nil {
+		t.Errorf("Expected the release to be recorded without labels, got %+v", current)
+	}
+}
+
+// newTestPod returns a pod of the "web" deployment in the given phase
+func newTestPod(name string, phase corev1.PodPhase, ready bool, imageID, specImage string, created time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			Labels:            map[string]string{"app": "web"},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: specImage}},
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:    "app",
+				Ready:   ready,
+				ImageID: imageID,
+			}},
+		},
+	}
+}
+
+func TestGetImageSHAFromPodsAcceptPhases(t *testing.T) {
+	otherDigest := "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		acceptPhases []string
+		pods         []*corev1.Pod
+		expectedSHA  string
+	}{
+		{
+			name: "Running and ready is always accepted",
+			pods: []*corev1.Pod{
+				newTestPod("web-1", corev1.PodRunning, true, "docker://sha256:"+testDigest, "web:v1", now),
+			},
+			expectedSHA: testDigest,
+		},
+		{
+			name: "Pending pod ignored by default",
+			pods: []*corev1.Pod{
+				newTestPod("web-1", corev1.PodPending, false, "", "web@sha256:"+testDigest, now),
+			},
+		},
+		{
+			name:         "Pending pod uses digest pinned in spec when accepted",
+			acceptPhases: []string{"Pending"},
+			pods: []*corev1.Pod{
+				newTestPod("web-1", corev1.PodPending, false, "", "web@sha256:"+testDigest, now),
+			},
+			expectedSHA: testDigest,
+		},
+		{
+			name:         "Not ready running pod accepted when Running is listed",
+			acceptPhases: []string{"Running"},
+			pods: []*corev1.Pod{
+				newTestPod("web-1", corev1.PodRunning, false, "docker://sha256:"+testDigest, "web:v1", now),
+			},
+			expectedSHA: testDigest,
+		},
+		{
+			name:         "Most recent accepted pod wins",
+			acceptPhases: []string{"Succeeded"},
+			pods: []*corev1.Pod{
+				newTestPod("web-old", corev1.PodSucceeded, false, "docker://sha256:"+otherDigest, "web:v1", now.Add(-time.Hour)),
+				newTestPod("web-new", corev1.PodSucceeded, false, "docker://sha256:"+testDigest, "web:v2", now),
+			},
+			expectedSHA: testDigest,
+		},
+		{
+			name:         "Phases not listed stay ignored",
+			acceptPhases: []string{"Pending"},
+			pods: []*corev1.Pod{
+				newTestPod("web-1", corev1.PodFailed, false, "docker://sha256:"+testDigest, "web:v1", now),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for _, pod := range tt.pods {
+				objects = append(objects, pod)
+			}
+			client := NewFromClientset(fake.NewSimpleClientset(objects...), []string{"default"}, "master")
+			client.SetSHAAcceptPhases(tt.acceptPhases)
+
+			sha, _, _, err := client.getImageSHAFromPods(context.Background(), "default", "web", "Deployment", "app")
+			if tt.expectedSHA == "" {
+				if err == nil {
+					t.Errorf("Expected no SHA, got %q", sha)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sha != tt.expectedSHA {
+				t.Errorf("Expected SHA %q, got %q", tt.expectedSHA, sha)
+			}
+		})
+	}
+}
+
+// newCompletedPod returns a succeeded pod whose "app" container terminated with the given exit code
+func newCompletedPod(name string, labels map[string]string, owners []metav1.OwnerReference, exitCode int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			Labels:          labels,
+			OwnerReferences: owners,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:    "app",
+				ImageID: "docker-pullable://registry.example.com/migrate@sha256:" + testDigest,
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: "Completed"},
+				},
+			}},
+		},
+	}
+}
+
+func TestCollectReleasesResolvesCompletedJobs(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	podTemplate := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "registry.example.com/migrate:v1.0.0"}},
+		},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"},
+		Spec:       batchv1.JobSpec{Template: podTemplate},
+	}
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default"},
+		Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: podTemplate}},
+		},
+	}
+	// A run of the CronJob: collected through the CronJob, not as a standalone Job
+	cronRun := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nightly-28000000",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "nightly"}},
+		},
+		Spec: batchv1.JobSpec{Template: podTemplate},
+	}
+
+	objects := []runtime.Object{
+		job, cronJob, cronRun,
+		newCompletedPod("migrate-x1", map[string]string{"job-name": "migrate"}, nil, 0),
+		newCompletedPod("nightly-28000000-y2", map[string]string{"job-name": "nightly-28000000"},
+			[]metav1.OwnerReference{{Kind: "Job", Name: "nightly-28000000"}}, 0),
+	}
+	client := NewFromClientset(fake.NewSimpleClientset(objects...), []string{"default"}, "master")
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	releases, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byWorkload := make(map[string]database.CurrentRelease)
+	for _, r := range releases {
+		byWorkload[r.WorkloadName] = r
+	}
+	if len(releases) != 2 {
+		t.Fatalf("Expected releases for the Job and the CronJob only, got %+v", releases)
+	}
+	for _, name := range []string{"migrate", "nightly"} {
+		if byWorkload[name].ImageSHA != testDigest {
+			t.Errorf("Expected %s to resolve SHA %q from its completed pod, got %+v", name, testDigest, byWorkload[name])
+		}
+	}
+}
+
+func TestCollectReleasesRecordsInitContainers(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	const initDigest = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	// The init container shares its name with the app container
+	deployment.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "app", Image: "registry.example.com/migrate:v0.9.0"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:    "app",
+		ImageID: "docker-pullable://registry.example.com/migrate@sha256:" + initDigest,
+		State:   corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+	}}
+
+	client := NewFromClientset(fake.NewSimpleClientset(deployment, pod), []string{"default"}, "slave")
+	db := newTestDB(t)
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	releases, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byContainer := make(map[string]database.CurrentRelease)
+	for _, r := range releases {
+		byContainer[r.ContainerName] = r
+	}
+	if len(releases) != 2 {
+		t.Fatalf("Expected the app and init containers as distinct components, got %+v", releases)
+	}
+	if r := byContainer["app"]; r.ImageTag != "v1.2.3" || r.ImageSHA != testDigest {
+		t.Errorf("Unexpected app container release %+v", r)
+	}
+	if r := byContainer["init:app"]; r.ImageTag != "v0.9.0" || r.ImageSHA != initDigest {
+		t.Errorf("Unexpected init container release %+v", r)
+	}
+
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("Expected both containers to be queued for sync, got %d", len(pending))
+	}
+}
+
+func TestCollectReleasesPrunesStaleComponents(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	client := NewFromClientset(fake.NewSimpleClientset(deployment, pod), []string{"default"}, "master")
+	client.SetStaleThreshold(24 * time.Hour)
+	db := newTestDB(t)
+
+	// web was deployed two days ago and is still running, legacy was removed from the cluster
+	for _, r := range []struct {
+		workload string
+		seen     time.Time
+	}{
+		{"web", time.Now().Add(-48 * time.Hour)},
+		{"legacy", time.Now().Add(-48 * time.Hour)},
+		{"legacy", time.Now().Add(-30 * time.Hour)},
+		{"recent", time.Now().Add(-time.Hour)},
+	} {
+		if err := db.UpsertRelease(&database.Release{
+			Namespace: "default", WorkloadName: r.workload, WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: "v1.0.0", ImageSHA: r.seen.Format(time.RFC3339), ClientName: "acme", EnvName: "prod",
+			FirstSeen: r.seen, LastSeen: r.seen,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	releases, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workloads := make(map[string]bool)
+	for _, r := range releases {
+		workloads[r.WorkloadName] = true
+	}
+	if !workloads["web"] || !workloads["recent"] || workloads["legacy"] {
+		t.Errorf("Expected only legacy to be pruned, got %+v", releases)
+	}
+
+	// The history of components that are still running is kept
+	history, err := db.GetReleaseHistory("default", "web", "app", "acme", "prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Releases) != 2 {
+		t.Errorf("Expected web to keep its old release, got %d releases", len(history.Releases))
+	}
+}
+
+func TestCollectReleasesCategorizesGhostWorkloads(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// idle is scaled to zero, web cannot be scheduled and api runs but is not ready yet
+	idle, _ := newTestDeployment("default", "idle", "registry.example.com/idle:v1")
+	web, _ := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	api, _ := newTestDeployment("default", "api", "registry.example.com/api:v1")
+	pending := newTestPod("web-abc12", corev1.PodPending, false, "", "registry.example.com/web:v1", time.Now())
+	notReady := newTestPod("api-abc12", corev1.PodRunning, false, "", "registry.example.com/api:v1", time.Now())
+	notReady.Labels = map[string]string{"app": "api"}
+
+	client := NewFromClientset(fake.NewSimpleClientset(idle, web, api, pending, notReady), []string{"default"}, "master")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	gaps, err := db.GetCollectionGaps("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	categories := make(map[string]string)
+	for _, g := range gaps {
+		categories[g.WorkloadName] = g.Category
+	}
+	expected := map[string]string{
+		"idle": database.CollectionErrorNoRunningPods,
+		"web":  database.CollectionErrorNoRunningPods,
+		"api":  database.CollectionErrorUnresolved,
+	}
+	for workload, category := range expected {
+		if categories[workload] != category {
+			t.Errorf("Expected %s to be categorized as %q, got %q", workload, category, categories[workload])
+		}
+	}
+
+	ghosts, err := db.GetGhostWorkloads("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ghosts) != 2 {
+		t.Errorf("Expected idle and web to be listed as ghosts, got %+v", ghosts)
+	}
+}
+
+func TestCollectReleasesUsesPinnedDigestWithoutPods(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// Both are scaled to zero, only pinned names its image digest
+	pinned, _ := newTestDeployment("default", "pinned", "registry.example.com/pinned:v1@sha256:"+testDigest)
+	tagged, _ := newTestDeployment("default", "tagged", "registry.example.com/tagged:v1")
+
+	client := NewFromClientset(fake.NewSimpleClientset(pinned, tagged), []string{"default"}, "master")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].WorkloadName != "pinned" || current[0].ImageSHA != testDigest || current[0].ImageTag != "v1" {
+		t.Errorf("Expected pinned to be recorded from its spec digest, got %+v", current)
+	}
+
+	gaps, err := db.GetCollectionGaps("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || gaps[0].WorkloadName != "tagged" {
+		t.Errorf("Expected only tagged to be reported as a gap, got %+v", gaps)
+	}
+}
+
+func TestCollectReleasesFlagsDigestMismatch(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// current runs the digest it pins; stale pins another digest than its pod runs
+	staleDigest := strings.Repeat("f", 64)
+	current, currentPod := newTestDeployment("default", "current", "registry.example.com/current:v1@sha256:"+testDigest)
+	stale, stalePod := newTestDeployment("default", "stale", "registry.example.com/stale:v2@sha256:"+staleDigest)
+
+	client := NewFromClientset(fake.NewSimpleClientset(current, currentPod, stale, stalePod), []string{"default"}, "slave")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	releases, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches := make(map[string]bool)
+	for _, release := range releases {
+		mismatches[release.WorkloadName] = release.DigestMismatch
+		if release.ImageSHA != testDigest || release.ImageTag == "" {
+			t.Errorf("Expected %s to record the running SHA and its tag, got %+v", release.WorkloadName, release)
+		}
+	}
+	if len(mismatches) != 2 || mismatches["current"] || !mismatches["stale"] {
+		t.Errorf("Expected only stale to be flagged, got %v", mismatches)
+	}
+
+	// The pinned digest is queued for master too
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, release := range pending {
+		if release.WorkloadName == "stale" && release.SpecDigest != staleDigest {
+			t.Errorf("Expected the pending release to carry the spec digest, got %+v", release)
+		}
+	}
+}
+
+func TestCollectReleasesTracksScaling(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	replicas := int32(3)
+	deployment.Spec.Replicas = &replicas
+	clientset := fake.NewSimpleClientset(deployment, pod)
+	client := NewFromClientset(clientset, []string{"default"}, "master")
+	client.SetTrackScaling(true)
+	db := newTestDB(t)
+
+	collect := func() {
+		t.Helper()
+		if err := client.CollectReleases(context.Background(), db); err != nil {
+			t.Fatalf("CollectReleases failed: %v", err)
+		}
+	}
+	collect()
+	collect()
+
+	replicas = 10
+	if _, err := clientset.AppsV1().Deployments("default").Update(context.Background(), deployment, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	collect()
+
+	events, err := db.GetScalingHistory("acme", "prod", "default", "web", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Replicas != 10 || events[1].Replicas != 3 || events[0].WorkloadType != "Deployment" {
+		t.Errorf("Expected the initial count and the scale-up to 10 to be recorded, got %+v", events)
+	}
+}
+
+func TestCollectReleasesRecordsReplicaCounts(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// web runs 3 replicas and only one is ready; the DaemonSet runs on 2 of 4 nodes
+	web, webPod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	replicas := int32(3)
+	web.Spec.Replicas = &replicas
+	web.Status.ReadyReplicas = 1
+	objects := []runtime.Object{web, webPod}
+	for _, name := range []string{"web-def34", "web-ghi56"} {
+		pod := webPod.DeepCopy()
+		pod.Name = name
+		objects = append(objects, pod)
+	}
+	agent := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec:       appsv1.DaemonSetSpec{Template: web.Spec.Template},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 4, NumberReady: 2},
+	}
+	agentPod := webPod.DeepCopy()
+	agentPod.Name, agentPod.Labels = "agent-x1", map[string]string{"app": "agent"}
+
+	client := NewFromClientset(fake.NewSimpleClientset(append(objects, agent, agentPod)...), []string{"default"}, "slave")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]string)
+	for _, release := range current {
+		if release.Replicas != nil && release.ReadyReplicas != nil {
+			counts[release.WorkloadName] = fmt.Sprintf("%d/%d", *release.ReadyReplicas, *release.Replicas)
+		}
+	}
+	if counts["web"] != "1/3" || counts["agent"] != "2/4" {
+		t.Errorf("Expected web 1/3 and agent 2/4 ready, got %v", counts)
+	}
+
+	// Replica counts are queued for master along with the release
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, release := range pending {
+		if release.Replicas == nil || release.ReadyReplicas == nil {
+			t.Errorf("Expected replica counts on pending release %s, got %+v", release.WorkloadName, release)
+		}
+	}
+}
+
+func TestCollectReleasesRecordsObservedReplicas(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// An autoscaler runs 2 pods of web while its spec still asks for 5; a third pod is still pending
+	web, pod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	replicas := int32(5)
+	web.Spec.Replicas = &replicas
+	web.Status.ReadyReplicas = 2
+	second := pod.DeepCopy()
+	second.Name = "web-def34"
+	pending := pod.DeepCopy()
+	pending.Name, pending.Status = "web-ghi56", corev1.PodStatus{Phase: corev1.PodPending}
+
+	client := NewFromClientset(fake.NewSimpleClientset(web, pod, second, pending), []string{"default"}, "master")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].Replicas == nil || *current[0].Replicas != 2 || *current[0].ReadyReplicas != 2 {
+		t.Errorf("Expected the 2 running pods to be recorded instead of spec.replicas, got %+v", current)
+	}
+}
+
+func TestCollectReleasesRecordsSelectorDiagnostics(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// web's pods carry no app label and are only found through their ReplicaSet
+	web, pod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	pod.Labels = map[string]string{"pod-template-hash": "abc12"}
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-abc12"}}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-abc12",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web"}},
+		},
+	}
+	idle, _ := newTestDeployment("default", "idle", "registry.example.com/idle:v1")
+
+	client := NewFromClientset(fake.NewSimpleClientset(web, pod, replicaSet, idle), []string{"default"}, "master")
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	diagnostics := make(map[string]SelectorDiagnostic)
+	for _, d := range client.SelectorDiagnostics() {
+		diagnostics[d.WorkloadName] = d
+	}
+
+	expected := []SelectorAttempt{
+		{Selector: "app=web", Pods: 0},
+		{Selector: "app.kubernetes.io/name=web", Pods: 0},
+		{Selector: "owner references", Pods: 1},
+	}
+	webDiagnostic := diagnostics["web"]
+	if len(webDiagnostic.Attempts) != len(expected) {
+		t.Fatalf("Expected %d selector attempts for web, got %+v", len(expected), webDiagnostic.Attempts)
+	}
+	for i, attempt := range expected {
+		if webDiagnostic.Attempts[i] != attempt {
+			t.Errorf("Expected attempt %d to be %+v, got %+v", i, attempt, webDiagnostic.Attempts[i])
+		}
+	}
+	if webDiagnostic.Matched != "owner references" || webDiagnostic.Pods != 1 {
+		t.Errorf("Expected web to be matched by owner references, got %+v", webDiagnostic)
+	}
+
+	// A workload without pods lists the whole failed chain
+	if idleDiagnostic := diagnostics["idle"]; idleDiagnostic.Matched != "" || len(idleDiagnostic.Attempts) != 3 {
+		t.Errorf("Expected idle to fail every selector, got %+v", idleDiagnostic)
+	}
+
+	// The SHA was resolved from the pod found by owner reference
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].WorkloadName != "web" || current[0].ImageSHA != testDigest {
+		t.Errorf("Expected web to be recorded, got %+v", current)
+	}
+}
+
+func TestCollectReleasesTriesConfiguredPodSelectors(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	// Helm charts label pods with the release name, not app=<name>
+	web, pod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	pod.Labels = map[string]string{"app.kubernetes.io/instance": "web"}
+
+	client := NewFromClientset(fake.NewSimpleClientset(web, pod), []string{"default"}, "master")
+	client.SetPodLabelSelectors([]string{"release={name},tier in (web", "team=ops,component={name}", "app.kubernetes.io/instance={name}"})
+	db := newTestDB(t)
+	if err := client.CollectReleases(context.Background(), db); err != nil {
+		t.Fatalf("CollectReleases failed: %v", err)
+	}
+
+	expected := []SelectorAttempt{
+		{Selector: "team=ops,component=web", Pods: 0},
+		{Selector: "app.kubernetes.io/instance=web", Pods: 1},
+	}
+	diagnostics := client.SelectorDiagnostics()
+	if len(diagnostics) != 1 || len(diagnostics[0].Attempts) != len(expected) {
+		t.Fatalf("Expected the invalid template to be skipped and the lookup to stop at the match, got %+v", diagnostics)
+	}
+	for i, attempt := range expected {
+		if diagnostics[0].Attempts[i] != attempt {
+			t.Errorf("Expected attempt %d to be %+v, got %+v", i, attempt, diagnostics[0].Attempts[i])
+		}
+	}
+
+	current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current[0].ImageSHA != testDigest {
+		t.Errorf("Expected web to be recorded from the pod found by the configured selector, got %+v", current)
+	}
+}
+
+func TestGetImageSHAFromCompletedPodsResolvesJobPods(t *testing.T) {
+	pod := newCompletedPod("migrate-x1", map[string]string{"job-name": "migrate"}, nil, 0)
+	if sha := getImageSHAFromCompletedPods([]corev1.Pod{*pod}, "app"); sha != testDigest {
+		t.Errorf("Expected SHA %q from the completed Job pod, got %q", testDigest, sha)
+	}
+
+	// Jobs that only run init containers are resolved too
+	pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses = pod.Status.ContainerStatuses, nil
+	if sha := getImageSHAFromCompletedPods([]corev1.Pod{*pod}, "app"); sha != testDigest {
+		t.Errorf("Expected SHA %q from the completed init container, got %q", testDigest, sha)
+	}
+}
+
+func TestGetImageSHAFromCompletedPodsIgnoresFailures(t *testing.T) {
+	pods := []corev1.Pod{*newCompletedPod("migrate-x1", nil, nil, 1)}
+	if sha := getImageSHAFromCompletedPods(pods, "app"); sha != "" {
+		t.Errorf("Expected no SHA from a failed container, got %q", sha)
+	}
+}
+
+func TestLimitCallCapsConcurrentCalls(t *testing.T) {
+	client := NewFromClientset(fake.NewSimpleClientset(), []string{"default"}, "master")
+	client.SetMaxConcurrentCalls(2)
+
+	// Counting fake call; the fake clientset serializes its reactors, so count around the call instead
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	countingCall := func() (*corev1.PodList, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return &corev1.PodList{}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := limitCall(context.Background(), client, countingCall); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight != 2 {
+		t.Errorf("Expected at most 2 concurrent Kubernetes calls (and the limit to be used), got %d", maxInFlight)
+	}
+}
+
+func TestLimitCallHonoursContextWhileWaiting(t *testing.T) {
+	client := NewFromClientset(fake.NewSimpleClientset(), []string{"default"}, "master")
+	client.SetMaxConcurrentCalls(1)
+	client.callSlots <- struct{}{} // occupy the only slot
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	_, err := limitCall(ctx, client, func() (*corev1.PodList, error) {
+		called = true
+		return &corev1.PodList{}, nil
+	})
+	if err == nil || called {
+		t.Errorf("Expected a cancelled context to abort the wait without calling, got err=%v called=%t", err, called)
+	}
+}
+
+// recordingNotifier collects the events it receives
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestCollectReleasesDetectsTagReuseOnRestart(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+	const repushedDigest = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+	deployment, pod := newTestDeployment("default", "web", "registry.example.com/web:v1.2.3")
+	db := newTestDB(t)
+	notifier := &recordingNotifier{}
+
+	collect := func(trackRestarts bool) {
+		t.Helper()
+		client := NewFromClientset(fake.NewSimpleClientset(deployment, pod), []string{"default"}, "master")
+		client.SetNotifier(notifier)
+		client.SetTrackRestarts(trackRestarts)
+		if err := client.CollectReleases(context.Background(), db); err != nil {
+			t.Fatalf("CollectReleases failed: %v", err)
+		}
+	}
+	countReleases := func() int {
+		t.Helper()
+		releases, err := db.GetReleasesAfterID(0, 100)
+		if err != nil {
+			t.Fatalf("Failed to get releases: %v", err)
+		}
+		return len(releases)
+	}
+
+	collect(true)
+	if countReleases() != 1 || len(notifier.events) != 0 {
+		t.Fatalf("Expected 1 release and no events after the first collection, got %d and %d", countReleases(), len(notifier.events))
+	}
+
+	// The tag is re-pushed and the container crash-loops on the new image
+	pod.Status.ContainerStatuses[0].Ready = false
+	pod.Status.ContainerStatuses[0].RestartCount = 3
+	pod.Status.ContainerStatuses[0].ImageID = "docker-pullable://registry.example.com/web@sha256:" + repushedDigest
+
+	// Without restart tracking the not-ready container provides no SHA
+	collect(false)
+	if countReleases() != 1 {
+		t.Errorf("Expected no new release without restart tracking, got %d releases", countReleases())
+	}
+
+	collect(true)
+	if countReleases() != 2 {
+		t.Fatalf("Expected the re-pushed image to be recorded as a new release, got %d releases", countReleases())
+	}
+	if len(notifier.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(notifier.events))
+	}
+	event := notifier.events[0]
+	if event.Type != notify.EventTagReuse || event.ImageTag != "v1.2.3" || event.ImageSHA != repushedDigest || event.PreviousSHA != testDigest {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestWatchWorkloadsCollectsChangedWorkloads(t *testing.T) {
+	t.Setenv("CLIENT_NAME", "acme")
+	t.Setenv("ENV_NAME", "prod")
+
+	web, webPod := newTestDeployment("default", "web", "registry.example.com/web:v1")
+	clientset := fake.NewSimpleClientset(web, webPod)
+	// Signal once each informer watches, so no event is created before the fake tracker sends it
+	watching := make(chan struct{}, 3)
+	clientset.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		watching <- struct{}{}
+		return true, w, err
+	})
+	client := NewFromClientset(clientset, []string{"default"}, "slave")
+	db := newTestDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := client.WatchWorkloads(ctx, db); err != nil {
+		t.Fatalf("WatchWorkloads failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-watching:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the informers to watch")
+		}
+	}
+
+	waitForReleases := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			current, err := db.GetCurrentReleasesFiltered("acme", "prod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(current) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d current releases, got %d", want, len(current))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Workloads present when the watch starts are left to the periodic collection
+	waitForReleases(0)
+
+	api, apiPod := newTestDeployment("default", "api", "registry.example.com/api:v1")
+	if _, err := clientset.CoreV1().Pods("default").Create(ctx, apiPod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.AppsV1().Deployments("default").Create(ctx, api, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForReleases(1)
+
+	web.Generation = 2
+	web.Spec.Template.Spec.Containers[0].Image = "registry.example.com/web:v2"
+	if _, err := clientset.AppsV1().Deployments("default").Update(ctx, web, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForReleases(2)
+
+	provenance, err := db.GetReleaseProvenance("default", "web", "app", "acme", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provenance == nil || provenance.ImageTag != "v2" {
+		t.Errorf("Expected the updated web release to be v2, got %+v", provenance)
+	}
+}
@@ -0,0 +1,93 @@
+package kubernetes
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ownerReferenceSelector names the owner reference scan in selector diagnostics
+const ownerReferenceSelector = "owner references"
+
+// SelectorAttempt is one pod lookup tried while resolving the image SHA of a workload
+type SelectorAttempt struct {
+	Selector string `json:"selector"` // label selector, or "owner references" for the scan of all pods in the namespace
+	Pods     int    `json:"pods"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SelectorDiagnostic records how the pods of a workload were looked up during its last collection
+type SelectorDiagnostic struct {
+	Namespace    string            `json:"namespace"`
+	WorkloadName string            `json:"workload_name"`
+	WorkloadType string            `json:"workload_type"`
+	Attempts     []SelectorAttempt `json:"attempts"`
+	Matched      string            `json:"matched,omitempty"` // selector that found pods, empty if every attempt failed
+	Pods         int               `json:"pods"`
+	CollectedAt  time.Time         `json:"collected_at"`
+}
+
+// selectorDiagnostics keeps the selector outcome of every workload seen by the last collection of its namespace
+type selectorDiagnostics struct {
+	mu        sync.Mutex
+	workloads map[string]SelectorDiagnostic
+}
+
+// record stores the selector attempts made for a workload, replacing those of earlier collections
+func (d *selectorDiagnostics) record(namespace, workloadType, workloadName string, attempts []SelectorAttempt) {
+	diagnostic := SelectorDiagnostic{
+		Namespace:    namespace,
+		WorkloadName: workloadName,
+		WorkloadType: workloadType,
+		Attempts:     append([]SelectorAttempt(nil), attempts...),
+		CollectedAt:  time.Now().UTC(),
+	}
+	for _, attempt := range attempts {
+		if attempt.Pods > 0 {
+			diagnostic.Matched = attempt.Selector
+			diagnostic.Pods = attempt.Pods
+			break
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.workloads == nil {
+		d.workloads = make(map[string]SelectorDiagnostic)
+	}
+	d.workloads[namespace+"/"+workloadType+"/"+workloadName] = diagnostic
+}
+
+// resetNamespace forgets the workloads of a namespace before it is collected again, so removed workloads disappear
+func (d *selectorDiagnostics) resetNamespace(namespace string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, diagnostic := range d.workloads {
+		if diagnostic.Namespace == namespace {
+			delete(d.workloads, key)
+		}
+	}
+}
+
+// SelectorDiagnostics returns the pod lookups made for every workload during the last collection of its
+// namespace, ordered by namespace, workload type and name
+func (c *Client) SelectorDiagnostics() []SelectorDiagnostic {
+	c.diagnostics.mu.Lock()
+	defer c.diagnostics.mu.Unlock()
+
+	diagnostics := make([]SelectorDiagnostic, 0, len(c.diagnostics.workloads))
+	for _, diagnostic := range c.diagnostics.workloads {
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	sort.Slice(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.WorkloadType != b.WorkloadType {
+			return a.WorkloadType < b.WorkloadType
+		}
+		return a.WorkloadName < b.WorkloadName
+	})
+	return diagnostics
+}
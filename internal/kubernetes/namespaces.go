@@ -0,0 +1,156 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// AllNamespaces in the monitored namespaces stands for every namespace of the cluster, listed again
+// at each collection so new namespaces are picked up
+const AllNamespaces = "*"
+
+// LabelSelectedNamespaces in the monitored namespaces stands for the namespaces matching the namespace
+// label selector, listed again at each collection
+const LabelSelectedNamespaces = "@label-selector"
+
+// IsMonitored reports whether namespace is collected given the configured namespaces and the namespaces
+// excluded from the AllNamespaces wildcard. Explicitly listed namespaces are always monitored.
+func IsMonitored(namespaces, excludedNamespaces []string, namespace string) bool {
+	wildcard := false
+	for _, ns := range namespaces {
+		if ns == namespace {
+			return true
+		}
+		wildcard = wildcard || ns == AllNamespaces
+	}
+	return wildcard && !excluded(excludedNamespaces, namespace)
+}
+
+// excluded reports whether namespace is in the excluded namespaces
+func excluded(excludedNamespaces []string, namespace string) bool {
+	for _, ns := range excludedNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// SetExcludedNamespaces sets namespaces skipped when the AllNamespaces wildcard or the namespace label
+// selector is expanded
+func (c *Client) SetExcludedNamespaces(namespaces []string) {
+	c.excludedNamespaces = namespaces
+}
+
+// SetNamespaceLabelSelector makes the collector also monitor the namespaces matching selector (e.g.
+// "tracked=true"), in addition to the configured namespaces. An invalid selector is logged and ignored.
+func (c *Client) SetNamespaceLabelSelector(selector string) {
+	c.namespaceSelector = nil
+	if selector == "" {
+		return
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		slog.Warn("Ignoring invalid namespace label selector", "selector", selector, "error", err)
+		return
+	}
+	c.namespaceSelector = parsed
+}
+
+// SetNamespaces replaces the configured namespaces, taking effect at the next collection
+func (c *Client) SetNamespaces(namespaces []string) {
+	c.namespacesMu.Lock()
+	defer c.namespacesMu.Unlock()
+	c.namespaces = namespaces
+}
+
+// configuredNamespaces returns the namespaces set at creation or by SetNamespaces
+func (c *Client) configuredNamespaces() []string {
+	c.namespacesMu.RLock()
+	defer c.namespacesMu.RUnlock()
+	return c.namespaces
+}
+
+// Namespaces returns the monitored namespaces, ending with LabelSelectedNamespaces when a namespace
+// label selector is set. Entries other than namespace names are expanded at each collection.
+func (c *Client) Namespaces() []string {
+	namespaces := c.configuredNamespaces()
+	if c.namespaceSelector == nil {
+		return namespaces
+	}
+	return append(append([]string(nil), namespaces...), LabelSelectedNamespaces)
+}
+
+// ResolvedNamespaces returns the namespaces currently monitored, with the AllNamespaces wildcard and
+// the namespace label selector expanded into the matching namespaces of the cluster
+func (c *Client) ResolvedNamespaces(ctx context.Context) ([]string, error) {
+	return c.resolveNamespaces(ctx, c.Namespaces())
+}
+
+// MonitorsNamespace reports whether namespace is collected: listed explicitly, covered by the
+// AllNamespaces wildcard or matching the namespace label selector, and not excluded
+func (c *Client) MonitorsNamespace(ctx context.Context, namespace string) (bool, error) {
+	if IsMonitored(c.configuredNamespaces(), c.excludedNamespaces, namespace) {
+		return true, nil
+	}
+	if c.namespaceSelector == nil || excluded(c.excludedNamespaces, namespace) {
+		return false, nil
+	}
+	ns, err := limitCall(ctx, c, func() (*corev1.Namespace, error) {
+		return c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+	return c.namespaceSelector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// resolveNamespaces expands the AllNamespaces wildcard and LabelSelectedNamespaces into the matching
+// namespaces currently in the cluster, minus the excluded ones. Explicit namespaces are always kept,
+// first and in their configured order, followed by the discovered ones sorted by name.
+func (c *Client) resolveNamespaces(ctx context.Context, namespaces []string) ([]string, error) {
+	resolved := make([]string, 0, len(namespaces))
+	seen := make(map[string]bool)
+	var selectors []string
+	for _, namespace := range namespaces {
+		switch {
+		case namespace == AllNamespaces:
+			selectors = append(selectors, "")
+		case namespace == LabelSelectedNamespaces:
+			if c.namespaceSelector != nil {
+				selectors = append(selectors, c.namespaceSelector.String())
+			}
+		case !seen[namespace]:
+			seen[namespace] = true
+			resolved = append(resolved, namespace)
+		}
+	}
+
+	var discovered []string
+	for _, selector := range selectors {
+		list, err := limitCall(ctx, c, func() (*corev1.NamespaceList, error) {
+			return c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: selector})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces: %w", err)
+		}
+		for _, namespace := range list.Items {
+			if !seen[namespace.Name] && !excluded(c.excludedNamespaces, namespace.Name) {
+				seen[namespace.Name] = true
+				discovered = append(discovered, namespace.Name)
+			}
+		}
+	}
+	sort.Strings(discovered)
+	return append(resolved, discovered...), nil
+}
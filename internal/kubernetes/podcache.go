@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespacePods holds the pods of a namespace listed once at the start of its collection, so the image
+// SHAs of all its containers are resolved without listing pods again
+type namespacePods struct {
+	namespace string
+	pods      []corev1.Pod
+}
+
+type namespacePodsKey struct{}
+
+// withNamespacePods returns a copy of ctx carrying the pods listed for one collection of a namespace
+func withNamespacePods(ctx context.Context, cache *namespacePods) context.Context {
+	return context.WithValue(ctx, namespacePodsKey{}, cache)
+}
+
+// namespacePodsFromContext returns the pods cached for namespace by the running collection, if any
+func namespacePodsFromContext(ctx context.Context, namespace string) (*namespacePods, bool) {
+	cache, ok := ctx.Value(namespacePodsKey{}).(*namespacePods)
+	if !ok || cache.namespace != namespace {
+		return nil, false
+	}
+	return cache, true
+}
+
+// listNamespacePods lists all pods of a namespace for the cache of one collection run
+func (c *Client) listNamespacePods(ctx context.Context, namespace string) (*namespacePods, error) {
+	list, err := limitCall(ctx, c, func() (*corev1.PodList, error) {
+		return c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	return &namespacePods{namespace: namespace, pods: list.Items}, nil
+}
+
+// listPods returns the pods of namespace matching labelSelector (all pods if empty), from the pods
+// cached by the running collection when there are some, otherwise from the API server
+func (c *Client) listPods(ctx context.Context, namespace, labelSelector string) (*corev1.PodList, error) {
+	cache, ok := namespacePodsFromContext(ctx, namespace)
+	if !ok {
+		return limitCall(ctx, c, func() (*corev1.PodList, error) {
+			return c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+		})
+	}
+
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	matched := &corev1.PodList{}
+	for _, pod := range cache.pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			matched.Items = append(matched.Items, pod)
+		}
+	}
+	return matched, nil
+}
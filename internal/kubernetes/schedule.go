@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectionGroup is a set of namespaces collected together on the same interval
+type CollectionGroup struct {
+	Interval   time.Duration
+	Namespaces []string
+}
+
+// GroupNamespaces splits namespaces into collection groups by interval. Namespaces without an
+// override use the default interval. Groups are ordered by interval, shortest first. When namespaces
+// holds the AllNamespaces wildcard, every overridden namespace is grouped by its interval as well.
+func GroupNamespaces(namespaces []string, defaultInterval time.Duration, overrides map[string]time.Duration) []CollectionGroup {
+	monitored := make(map[string]bool)
+	byInterval := make(map[time.Duration][]string)
+	for _, namespace := range namespaces {
+		monitored[namespace] = true
+		interval := defaultInterval
+		if override, ok := overrides[namespace]; ok && override > 0 {
+			interval = override
+		}
+		byInterval[interval] = append(byInterval[interval], namespace)
+	}
+
+	overridden := make([]string, 0, len(overrides))
+	for namespace := range overrides {
+		overridden = append(overridden, namespace)
+	}
+	sort.Strings(overridden)
+
+	// With the wildcard, overridden namespaces get their own group and are also collected with the wildcard
+	for _, namespace := range overridden {
+		if override := overrides[namespace]; monitored[AllNamespaces] && !monitored[namespace] && override > 0 {
+			monitored[namespace] = true
+			byInterval[override] = append(byInterval[override], namespace)
+			continue
+		}
+		if !monitored[namespace] {
+			slog.Warn("Ignoring collection interval for unmonitored namespace", "namespace", namespace)
+		}
+	}
+
+	groups := make([]CollectionGroup, 0, len(byInterval))
+	for interval, groupNamespaces := range byInterval {
+		groups = append(groups, CollectionGroup{Interval: interval, Namespaces: groupNamespaces})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Interval < groups[j].Interval })
+
+	return groups
+}
+
+// RunCollectionGroups runs one ticker per group, calling collect with the group's namespaces each
+// time its interval elapses. It blocks until ctx is cancelled.
+func RunCollectionGroups(ctx context.Context, groups []CollectionGroup, collect func(ctx context.Context, namespaces []string)) {
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group CollectionGroup) {
+			defer wg.Done()
+
+			ticker := time.NewTicker(group.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					collect(ctx, group.Namespaces)
+				}
+			}
+		}(group)
+	}
+	wg.Wait()
+}
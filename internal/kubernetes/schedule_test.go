@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupNamespaces(t *testing.T) {
+	overrides := map[string]time.Duration{
+		"prod":    time.Minute,
+		"payment": time.Minute,
+		"infra":   30 * time.Minute,
+		"unknown": 5 * time.Minute,
+	}
+	groups := GroupNamespaces([]string{"prod", "infra", "default", "payment"}, time.Hour, overrides)
+
+	expected := []CollectionGroup{
+		{Interval: time.Minute, Namespaces: []string{"prod", "payment"}},
+		{Interval: 30 * time.Minute, Namespaces: []string{"infra"}},
+		{Interval: time.Hour, Namespaces: []string{"default"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected groups %+v, got %+v", expected, groups)
+	}
+}
+
+func TestGroupNamespacesWildcard(t *testing.T) {
+	overrides := map[string]time.Duration{"prod": time.Minute, "payment": time.Minute}
+	groups := GroupNamespaces([]string{AllNamespaces}, time.Hour, overrides)
+
+	expected := []CollectionGroup{
+		{Interval: time.Minute, Namespaces: []string{"payment", "prod"}},
+		{Interval: time.Hour, Namespaces: []string{AllNamespaces}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected groups %+v, got %+v", expected, groups)
+	}
+}
+
+func TestRunCollectionGroupsTicksPerGroup(t *testing.T) {
+	groups := []CollectionGroup{
+		{Interval: 20 * time.Millisecond, Namespaces: []string{"prod"}},
+		{Interval: 100 * time.Millisecond, Namespaces: []string{"infra", "default"}},
+	}
+
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	ctx, cancel := context.WithTimeout(context.Background(), 450*time.Millisecond)
+	defer cancel()
+
+	RunCollectionGroups(ctx, groups, func(ctx context.Context, namespaces []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[strings.Join(namespaces, ",")]++
+	})
+
+	// 450ms allows ~22 ticks at 20ms and 4 ticks at 100ms; allow slack for scheduler jitter
+	if fast := runs["prod"]; fast < 10 || fast > 23 {
+		t.Errorf("Expected about 22 collections for the 20ms group, got %d", fast)
+	}
+	if slow := runs["infra,default"]; slow < 3 || slow > 4 {
+		t.Errorf("Expected 4 collections for the 100ms group, got %d", slow)
+	}
+	if len(runs) != 2 {
+		t.Errorf("Expected collections for 2 groups, got %v", runs)
+	}
+}
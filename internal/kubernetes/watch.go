@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"krelease-tracker/internal/database"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchedWorkload is what the watch needs from a Deployment, StatefulSet or DaemonSet
+type watchedWorkload struct {
+	namespace   string
+	name        string
+	generation  int64
+	labels      map[string]string
+	annotations map[string]string
+	podSpec     corev1.PodSpec
+	replicas    *replicaCounts
+}
+
+// changedFrom reports whether a workload update may change its recorded release: a new pod template
+// (generation) or pods becoming ready, which is when the image SHA of a rollout can be resolved
+func (w *watchedWorkload) changedFrom(old *watchedWorkload) bool {
+	return w.generation != old.generation || *w.replicas != *old.replicas
+}
+
+// WatchWorkloads starts informers collecting the Deployments, StatefulSets and DaemonSets of the monitored
+// namespaces as soon as they are added or change, and returns once their caches are synced. The informers
+// run until ctx is done. Workloads already present when the watch starts are left to the periodic
+// collection, which also keeps handling Jobs, CronJobs and the history cleanup. With the AllNamespaces
+// wildcard or a namespace label selector the whole cluster is watched and events are filtered with
+// MonitorsNamespace, so namespaces created later are picked up.
+func (c *Client) WatchWorkloads(ctx context.Context, db *database.DB) error {
+	namespaces := c.Namespaces()
+	filtered := false
+	for _, namespace := range namespaces {
+		if namespace == AllNamespaces || namespace == LabelSelectedNamespaces {
+			namespaces, filtered = []string{metav1.NamespaceAll}, true
+			break
+		}
+	}
+
+	var synced []cache.InformerSynced
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(c.clientset, 0, informers.WithNamespace(namespace))
+
+		handlers := map[cache.SharedIndexInformer]cache.ResourceEventHandler{
+			factory.Apps().V1().Deployments().Informer(): c.workloadEventHandler(ctx, db, "Deployment", filtered, func(obj any) (*watchedWorkload, bool) {
+				deployment, ok := obj.(*appsv1.Deployment)
+				if !ok {
+					return nil, false
+				}
+				return &watchedWorkload{deployment.Namespace, deployment.Name, deployment.Generation, workloadLabels(deployment.Labels, deployment.Spec.Template.Labels), deployment.Spec.Template.Annotations, deployment.Spec.Template.Spec, deploymentReplicas(deployment)}, true
+			}),
+			factory.Apps().V1().StatefulSets().Informer(): c.workloadEventHandler(ctx, db, "StatefulSet", filtered, func(obj any) (*watchedWorkload, bool) {
+				statefulSet, ok := obj.(*appsv1.StatefulSet)
+				if !ok {
+					return nil, false
+				}
+				return &watchedWorkload{statefulSet.Namespace, statefulSet.Name, statefulSet.Generation, workloadLabels(statefulSet.Labels, statefulSet.Spec.Template.Labels), statefulSet.Spec.Template.Annotations, statefulSet.Spec.Template.Spec, statefulSetReplicas(statefulSet)}, true
+			}),
+			factory.Apps().V1().DaemonSets().Informer(): c.workloadEventHandler(ctx, db, "DaemonSet", filtered, func(obj any) (*watchedWorkload, bool) {
+				daemonSet, ok := obj.(*appsv1.DaemonSet)
+				if !ok {
+					return nil, false
+				}
+				return &watchedWorkload{daemonSet.Namespace, daemonSet.Name, daemonSet.Generation, workloadLabels(daemonSet.Labels, daemonSet.Spec.Template.Labels), daemonSet.Spec.Template.Annotations, daemonSet.Spec.Template.Spec, daemonSetReplicas(daemonSet)}, true
+			}),
+		}
+		for informer, handler := range handlers {
+			if _, err := informer.AddEventHandler(handler); err != nil {
+				return fmt.Errorf("failed to watch workloads: %w", err)
+			}
+			synced = append(synced, informer.HasSynced)
+		}
+
+		factory.Start(ctx.Done())
+		go func() {
+			<-ctx.Done()
+			factory.Shutdown()
+		}()
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync workload informers: %w", ctx.Err())
+	}
+	slog.Info("Watching workloads", "namespaces", namespaces)
+	return nil
+}
+
+// workloadEventHandler processes the workloads of one kind when they are added after the initial list
+// or change in a way that may change their release
+func (c *Client) workloadEventHandler(ctx context.Context, db *database.DB, workloadType string, filtered bool, workload func(obj any) (*watchedWorkload, bool)) cache.ResourceEventHandler {
+	process := func(w *watchedWorkload) {
+		if filtered {
+			monitored, err := c.MonitorsNamespace(ctx, w.namespace)
+			if err != nil {
+				slog.Error("Error checking watched namespace", "namespace", w.namespace, "error", err)
+				return
+			}
+			if !monitored {
+				return
+			}
+		}
+		slog.Debug("Workload changed", "namespace", w.namespace, "workload_type", workloadType, "workload", w.name)
+		if err := c.processWorkload(ctx, db, w.namespace, w.name, workloadType, w.labels, w.annotations, w.podSpec, w.replicas); err != nil {
+			slog.Error("Error processing watched workload", "namespace", w.namespace, "workload_type", workloadType, "workload", w.name, "error", err)
+		}
+	}
+
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj any, isInInitialList bool) {
+			if w, ok := workload(obj); ok && !isInInitialList {
+				process(w)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			old, ok := workload(oldObj)
+			if !ok {
+				return
+			}
+			if w, ok := workload(newObj); ok && w.changedFrom(old) {
+				process(w)
+			}
+		},
+	}
+}
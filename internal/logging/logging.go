@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log output formats
+const (
+	FormatText = "text" // log package lines, as printed before leveled logging existed
+	FormatJSON = "json" // one JSON object per line with level, msg and fields, for log shippers like Loki
+)
+
+// ParseLevel parses a log level name (debug, info, warn or error, case-insensitive)
+func ParseLevel(level string) (slog.Level, bool) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return slog.LevelInfo, false
+	}
+	return parsed, true
+}
+
+// Setup configures the default slog logger used across the application. The text format keeps the
+// output of the log package and only drops records below level; the JSON format also routes lines
+// still written with the log package through the JSON handler, at the info level.
+func Setup(format, level string) {
+	setup(os.Stderr, format, level)
+}
+
+func setup(w io.Writer, format, level string) {
+	parsed, _ := ParseLevel(level)
+	if format == FormatJSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: parsed})))
+		return
+	}
+	slog.SetLogLoggerLevel(parsed)
+}
+
+// Fatal logs msg at the error level with the given fields and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger, e.g. one tagged with the ID of the request being served
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger if it carries none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected slog.Level
+		ok       bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"INFO", slog.LevelInfo, true},
+		{" warn ", slog.LevelWarn, true},
+		{"error", slog.LevelError, true},
+		{"verbose", slog.LevelInfo, false},
+	}
+	for _, tt := range tests {
+		level, ok := ParseLevel(tt.level)
+		if level != tt.expected || ok != tt.ok {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, %v", tt.level, level, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestSetupJSON(t *testing.T) {
+	previous, flags, output := slog.Default(), log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(flags)
+		log.SetOutput(output)
+	})
+
+	var buf bytes.Buffer
+	setup(&buf, FormatJSON, "warn")
+	slog.Info("dropped below the level")
+	slog.Warn("Collection failed", "client", "acme", "env", "prod", "namespace", "default")
+	log.Printf("plain line")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected only the warning to be logged, got %q", buf.String())
+	}
+	var record map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q: %v", lines[0], err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "Collection failed" || record["client"] != "acme" || record["env"] != "prod" || record["namespace"] != "default" {
+		t.Errorf("Unexpected record %v", record)
+	}
+
+	// Lines of the log package are routed through the JSON handler too
+	buf.Reset()
+	setup(&buf, FormatJSON, "info")
+	log.Printf("plain line")
+	if !strings.Contains(buf.String(), `"level":"INFO","msg":"plain line"`) {
+		t.Errorf("Expected the log package line as a JSON record, got %q", buf.String())
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("Expected the default logger for a context without logger")
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil)).With("request_id", "abc123")
+	FromContext(WithLogger(context.Background(), logger)).Info("Collection triggered via API")
+	if !strings.Contains(buf.String(), `"request_id":"abc123"`) {
+		t.Errorf("Expected the request ID of the context logger, got %q", buf.String())
+	}
+}
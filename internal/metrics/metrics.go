@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus counters of the tracker. All methods are safe to call on a nil
+// *Metrics, so components without metrics configured need no checks at their call sites.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	collections      prometheus.Counter
+	collectionErrors prometheus.Counter
+	releasesUpserted prometheus.Counter
+	syncSuccesses    prometheus.Counter
+	syncFailures     prometheus.Counter
+	pingSuccesses    prometheus.Counter
+	pingFailures     prometheus.Counter
+}
+
+// New creates the metrics and registers them in a dedicated registry
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		collections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_collections_total",
+			Help: "Number of collections run across the monitored namespaces.",
+		}),
+		collectionErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_collection_errors_total",
+			Help: "Number of namespaces or containers that could not be collected.",
+		}),
+		releasesUpserted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_releases_upserted_total",
+			Help: "Number of releases recorded by collection or through the API.",
+		}),
+		syncSuccesses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_sync_successes_total",
+			Help: "Number of pending releases synced to master.",
+		}),
+		syncFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_sync_failures_total",
+			Help: "Number of pending release sync attempts that failed.",
+		}),
+		pingSuccesses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_ping_successes_total",
+			Help: "Number of health pings accepted by master.",
+		}),
+		pingFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "krelease_ping_failures_total",
+			Help: "Number of health pings that failed.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.collections, m.collectionErrors, m.releasesUpserted,
+		m.syncSuccesses, m.syncFailures, m.pingSuccesses, m.pingFailures,
+	)
+	return m
+}
+
+// RegisterPendingReleases exposes the number of releases queued for sync, read from count at scrape time
+func (m *Metrics) RegisterPendingReleases(count func() (int, error)) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "krelease_pending_releases",
+		Help: "Number of releases queued for sync to master.",
+	}, func() float64 {
+		n, err := count()
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	}))
+}
+
+// RegisterDBStats exposes the connection pool statistics of the database, read from stats at scrape time
+func (m *Metrics) RegisterDBStats(stats func() sql.DBStats) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(&dbStatsCollector{stats: stats})
+}
+
+// dbStatsCollector reports the connection pool statistics of the database
+type dbStatsCollector struct {
+	stats func() sql.DBStats
+}
+
+var (
+	dbMaxOpenDesc = prometheus.NewDesc("krelease_db_max_open_connections",
+		"Maximum number of open database connections (0 is unlimited).", nil, nil)
+	dbOpenDesc = prometheus.NewDesc("krelease_db_open_connections",
+		"Number of open database connections, in use or idle.", nil, nil)
+	dbInUseDesc = prometheus.NewDesc("krelease_db_in_use_connections",
+		"Number of database connections in use.", nil, nil)
+	dbIdleDesc = prometheus.NewDesc("krelease_db_idle_connections",
+		"Number of idle database connections.", nil, nil)
+	dbWaitCountDesc = prometheus.NewDesc("krelease_db_wait_count_total",
+		"Number of times a query waited for a database connection.", nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc("krelease_db_wait_duration_seconds_total",
+		"Total time queries waited for a database connection.", nil, nil)
+	dbMaxLifetimeClosedDesc = prometheus.NewDesc("krelease_db_max_lifetime_closed_total",
+		"Number of database connections closed because they reached their maximum lifetime.", nil, nil)
+)
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbMaxOpenDesc
+	ch <- dbOpenDesc
+	ch <- dbInUseDesc
+	ch <- dbIdleDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+	ch <- dbMaxLifetimeClosedDesc
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbOpenDesc, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUseDesc, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdleDesc, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(dbMaxLifetimeClosedDesc, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// CollectionRun counts a collection
+func (m *Metrics) CollectionRun() {
+	if m != nil {
+		m.collections.Inc()
+	}
+}
+
+// CollectionError counts a namespace or container that could not be collected
+func (m *Metrics) CollectionError() {
+	if m != nil {
+		m.collectionErrors.Inc()
+	}
+}
+
+// ReleaseUpserted counts a recorded release
+func (m *Metrics) ReleaseUpserted() {
+	if m != nil {
+		m.releasesUpserted.Inc()
+	}
+}
+
+// SyncResult counts the outcome of syncing one pending release
+func (m *Metrics) SyncResult(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.syncFailures.Inc()
+	} else {
+		m.syncSuccesses.Inc()
+	}
+}
+
+// PingResult counts the outcome of a health ping
+func (m *Metrics) PingResult(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.pingFailures.Inc()
+	} else {
+		m.pingSuccesses.Inc()
+	}
+}
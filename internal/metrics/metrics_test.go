@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	return rr.Body.String()
+}
+
+func TestMetricsCountCallSites(t *testing.T) {
+	m := New()
+	m.RegisterPendingReleases(func() (int, error) { return 3, nil })
+
+	m.CollectionRun()
+	m.CollectionError()
+	m.ReleaseUpserted()
+	m.ReleaseUpserted()
+	m.SyncResult(nil)
+	m.SyncResult(errors.New("master unavailable"))
+	m.SyncResult(nil)
+	m.PingResult(errors.New("master unavailable"))
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		"krelease_collections_total 1",
+		"krelease_collection_errors_total 1",
+		"krelease_releases_upserted_total 2",
+		"krelease_pending_releases 3",
+		"krelease_sync_successes_total 2",
+		"krelease_sync_failures_total 1",
+		"krelease_ping_successes_total 0",
+		"krelease_ping_failures_total 1",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestDBStatsMetrics(t *testing.T) {
+	m := New()
+	m.RegisterDBStats(func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 4, OpenConnections: 3, InUse: 2, Idle: 1, WaitCount: 7, WaitDuration: 1500 * time.Millisecond}
+	})
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		"krelease_db_max_open_connections 4",
+		"krelease_db_open_connections 3",
+		"krelease_db_in_use_connections 2",
+		"krelease_db_idle_connections 1",
+		"krelease_db_wait_count_total 7",
+		"krelease_db_wait_duration_seconds_total 1.5",
+		"krelease_db_max_lifetime_closed_total 0",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestNilMetricsAreNoOps(t *testing.T) {
+	var m *Metrics
+	m.CollectionRun()
+	m.CollectionError()
+	m.ReleaseUpserted()
+	m.SyncResult(nil)
+	m.PingResult(nil)
+	m.RegisterPendingReleases(func() (int, error) { return 0, nil })
+	m.RegisterDBStats(func() sql.DBStats { return sql.DBStats{} })
+}
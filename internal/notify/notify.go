@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"krelease-tracker/internal/database"
+)
+
+// Event types
+const (
+	// EventVersionChange is emitted when a component starts running a different image
+	EventVersionChange = "version_change"
+	// EventTagReuse is emitted when a component runs a different image under the same tag,
+	// e.g. after the tag was re-pushed to the registry
+	EventTagReuse = "tag_reuse"
+	// EventTest is a sample event sent on demand to check that notifications are delivered
+	EventTest = "test"
+)
+
+// Event describes a release event sent to notifiers
+type Event struct {
+	Type          string    `json:"type"`
+	ClientName    string    `json:"client_name"`
+	EnvName       string    `json:"env_name"`
+	ClusterName   string    `json:"cluster_name,omitempty"`
+	Namespace     string    `json:"namespace"`
+	WorkloadName  string    `json:"workload_name"`
+	WorkloadType  string    `json:"workload_type"`
+	ContainerName string    `json:"container_name"`
+	ImageTag      string    `json:"image_tag"`
+	ImageSHA      string    `json:"image_sha"`
+	PreviousTag   string    `json:"previous_tag,omitempty"`
+	PreviousSHA   string    `json:"previous_sha,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// Notifier delivers release events to an external system
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// DeliveryReporter is implemented by notifiers that also report the HTTP status code returned by the
+// receiving endpoint (0 when no response was received)
+type DeliveryReporter interface {
+	Deliver(ctx context.Context, event Event) (statusCode int, err error)
+}
+
+// TestEvent returns the sample event sent to check that notifications are delivered
+func TestEvent(now time.Time) Event {
+	return Event{
+		Type:          EventTest,
+		ClientName:    "krelease-tracker",
+		EnvName:       "test",
+		Namespace:     "default",
+		WorkloadName:  "test-webhook",
+		WorkloadType:  "Deployment",
+		ContainerName: "app",
+		ImageTag:      "v1.0.1",
+		ImageSHA:      "sha256:0000000000000000000000000000000000000000000000000000000000000001",
+		PreviousTag:   "v1.0.0",
+		PreviousSHA:   "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		Timestamp:     now.UTC(),
+	}
+}
+
+// IsTagReuse reports whether a release runs a different image than the previous one under the same tag
+func IsTagReuse(previous *database.ReleaseProvenance, release *database.Release) bool {
+	return previous != nil && previous.ImageTag == release.ImageTag && previous.ImageSHA != release.ImageSHA
+}
+
+// VersionChange returns the version change event for a stored release, given the release that was
+// current before it. No event is returned for components seen for the first time or whose tag and
+// SHA did not change. A new SHA under the same tag is reported as a tag reuse.
+func VersionChange(previous *database.ReleaseProvenance, release *database.Release) (Event, bool) {
+	if previous == nil || (previous.ImageTag == release.ImageTag && previous.ImageSHA == release.ImageSHA) {
+		return Event{}, false
+	}
+
+	eventType := EventVersionChange
+	if IsTagReuse(previous, release) {
+		eventType = EventTagReuse
+	}
+
+	return Event{
+		Type:          eventType,
+		ClientName:    release.ClientName,
+		EnvName:       release.EnvName,
+		ClusterName:   release.ClusterName,
+		Namespace:     release.Namespace,
+		WorkloadName:  release.WorkloadName,
+		WorkloadType:  release.WorkloadType,
+		ContainerName: release.ContainerName,
+		ImageTag:      release.ImageTag,
+		ImageSHA:      release.ImageSHA,
+		PreviousTag:   previous.ImageTag,
+		PreviousSHA:   previous.ImageSHA,
+		Timestamp:     release.LastSeen.UTC(),
+	}, true
+}
+
+// NotifyVersionChange sends a version change event to the notifier if the stored release differs
+// from the previous one. Delivery failures are logged and never fail the caller.
+func NotifyVersionChange(ctx context.Context, notifier Notifier, previous *database.ReleaseProvenance, release *database.Release) {
+	if notifier == nil {
+		return
+	}
+
+	event, changed := VersionChange(previous, release)
+	if !changed {
+		return
+	}
+
+	if err := notifier.Notify(ctx, event); err != nil {
+		slog.Warn("Failed to send notification", "type", event.Type, "namespace", release.Namespace, "workload", release.WorkloadName,
+			"container", release.ContainerName, "error", err)
+	}
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"krelease-tracker/internal/version"
+)
+
+// OTLPExporter emits release events as OpenTelemetry log records over OTLP/HTTP (JSON encoding)
+type OTLPExporter struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewOTLPExporter creates an exporter sending to the given OTLP/HTTP endpoint.
+// The endpoint is the collector base URL (e.g. "http://otel-collector:4318"); "/v1/logs" is appended
+// unless the URL already ends with it.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/logs") {
+		endpoint += "/v1/logs"
+	}
+
+	return &OTLPExporter{
+		endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// otlpAttribute is an OTLP key/value attribute with a string value
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func attribute(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = value
+	return a
+}
+
+// Notify sends the event as a single OTLP log record
+func (e *OTLPExporter) Notify(ctx context.Context, event Event) error {
+	_, err := e.Deliver(ctx, event)
+	return err
+}
+
+// Deliver sends the event like Notify and also returns the HTTP status code of the collector
+func (e *OTLPExporter) Deliver(ctx context.Context, event Event) (int, error) {
+	timestamp := strconv.FormatInt(event.Timestamp.UnixNano(), 10)
+	observed := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	attributes := []otlpAttribute{
+		attribute("event.name", "krelease."+event.Type),
+		attribute("krelease.client", event.ClientName),
+		attribute("krelease.env", event.EnvName),
+		attribute("k8s.namespace.name", event.Namespace),
+		attribute("krelease.workload.name", event.WorkloadName),
+		attribute("krelease.workload.kind", event.WorkloadType),
+		attribute("k8s.container.name", event.ContainerName),
+		attribute("krelease.version", event.ImageTag),
+		attribute("krelease.digest", event.ImageSHA),
+	}
+	if event.ClusterName != "" {
+		attributes = append(attributes, attribute("k8s.cluster.name", event.ClusterName))
+	}
+	if event.PreviousTag != "" {
+		attributes = append(attributes, attribute("krelease.previous_version", event.PreviousTag))
+	}
+	if event.PreviousSHA != "" {
+		attributes = append(attributes, attribute("krelease.previous_digest", event.PreviousSHA))
+	}
+
+	body := fmt.Sprintf("%s/%s/%s in %s/%s changed from %s to %s",
+		event.Namespace, event.WorkloadName, event.ContainerName, event.ClientName, event.EnvName, event.PreviousTag, event.ImageTag)
+
+	payload := map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{
+						attribute("service.name", "krelease-tracker"),
+						attribute("service.version", version.Version),
+					},
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "krelease-tracker"},
+						"logRecords": []interface{}{
+							map[string]interface{}{
+								"timeUnixNano":         timestamp,
+								"observedTimeUnixNano": observed,
+								"severityNumber":       9,
+								"severityText":         "INFO",
+								"body":                 map[string]string{"stringValue": body},
+								"attributes":           attributes,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal OTLP payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "krelease-tracker/"+version.Version)
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send OTLP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("OTLP endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"krelease-tracker/internal/database"
+)
+
+func TestVersionChange(t *testing.T) {
+	release := &database.Release{Namespace: "default", WorkloadName: "web", ContainerName: "app", ImageTag: "v2", ImageSHA: "sha-2"}
+
+	if _, changed := VersionChange(nil, release); changed {
+		t.Error("Expected no event for a first-seen release")
+	}
+	if _, changed := VersionChange(&database.ReleaseProvenance{ImageTag: "v2", ImageSHA: "sha-2"}, release); changed {
+		t.Error("Expected no event for an unchanged release")
+	}
+
+	event, changed := VersionChange(&database.ReleaseProvenance{ImageTag: "v2", ImageSHA: "sha-1"}, release)
+	if !changed || event.Type != EventTagReuse {
+		t.Errorf("Expected a tag reuse event for a new SHA under the same tag, got %+v", event)
+	}
+
+	event, changed = VersionChange(&database.ReleaseProvenance{ImageTag: "v1", ImageSHA: "sha-1"}, release)
+	if !changed {
+		t.Fatal("Expected an event for a new version")
+	}
+	if event.Type != EventVersionChange || event.PreviousTag != "v1" || event.ImageTag != "v2" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestOTLPExporterNotify(t *testing.T) {
+	var path string
+	var payload struct {
+		ResourceLogs []struct {
+			ScopeLogs []struct {
+				LogRecords []struct {
+					TimeUnixNano string          `json:"timeUnixNano"`
+					Attributes   []otlpAttribute `json:"attributes"`
+				} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode OTLP payload: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	event := Event{
+		Type:          EventVersionChange,
+		ClientName:    "acme",
+		EnvName:       "prod",
+		Namespace:     "default",
+		WorkloadName:  "web",
+		WorkloadType:  "Deployment",
+		ContainerName: "app",
+		ImageTag:      "v2",
+		ImageSHA:      "sha-2",
+		PreviousTag:   "v1",
+		Timestamp:     time.Unix(1700000000, 0),
+	}
+	if err := NewOTLPExporter(collector.URL).Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if path != "/v1/logs" {
+		t.Errorf("Expected request to /v1/logs, got %q", path)
+	}
+	if len(payload.ResourceLogs) != 1 || len(payload.ResourceLogs[0].ScopeLogs) != 1 || len(payload.ResourceLogs[0].ScopeLogs[0].LogRecords) != 1 {
+		t.Fatalf("Expected exactly one log record, got %+v", payload)
+	}
+	record := payload.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if record.TimeUnixNano != "1700000000000000000" {
+		t.Errorf("Unexpected timeUnixNano %q", record.TimeUnixNano)
+	}
+
+	attributes := make(map[string]string)
+	for _, a := range record.Attributes {
+		attributes[a.Key] = a.Value.StringValue
+	}
+	expected := map[string]string{
+		"event.name":                "krelease.version_change",
+		"krelease.client":           "acme",
+		"k8s.namespace.name":        "default",
+		"krelease.version":          "v2",
+		"krelease.previous_version": "v1",
+	}
+	for key, value := range expected {
+		if attributes[key] != value {
+			t.Errorf("Expected attribute %s=%q, got %q", key, value, attributes[key])
+		}
+	}
+	if _, ok := attributes["k8s.cluster.name"]; ok {
+		t.Error("Expected no cluster attribute when the cluster name is empty")
+	}
+}
+
+func TestOTLPExporterNotifyError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	if err := NewOTLPExporter(collector.URL+"/v1/logs").Notify(context.Background(), Event{Type: EventVersionChange}); err == nil {
+		t.Error("Expected an error for a non-2xx response")
+	}
+}
@@ -6,55 +6,130 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
+
+	"krelease-tracker/internal/metrics"
+	"krelease-tracker/internal/version"
 )
 
 // Client handles sending health pings to master
 type Client struct {
-	masterURL    string
-	apiKey       string
-	clientName   string
-	envName      string
-	slaveVersion string
-	proxyURL     string
-	tlsInsecure  bool
+	masterURL     string
+	apiKey        string
+	clientName    string
+	envName       string
+	slaveVersion  string
+	proxyURL      string
+	tlsInsecure   bool
+	schemaVersion int
+	extraHeaders  map[string]string
+	clusterName   string
+	metrics       *metrics.Metrics // counts ping successes and failures; nil disables them
+
+	// namespaces returns the watched namespaces and collectionInterval (minutes) is the collection
+	// interval, both reported to master
+	namespaces         func(ctx context.Context) ([]string, error)
+	collectionInterval int
 }
 
 // New creates a new ping client
 func New(masterURL, apiKey, clientName, envName, slaveVersion, proxyURL string, tlsInsecure bool) *Client {
 	return &Client{
-		masterURL:    masterURL,
-		apiKey:       apiKey,
-		clientName:   clientName,
-		envName:      envName,
-		slaveVersion: slaveVersion,
-		proxyURL:     proxyURL,
-		tlsInsecure:  tlsInsecure,
+		masterURL:     masterURL,
+		apiKey:        apiKey,
+		clientName:    clientName,
+		envName:       envName,
+		slaveVersion:  slaveVersion,
+		proxyURL:      proxyURL,
+		tlsInsecure:   tlsInsecure,
+		schemaVersion: version.SchemaVersion,
+	}
+}
+
+// SetExtraHeaders sets additional HTTP headers added to every request sent to master
+func (c *Client) SetExtraHeaders(headers map[string]string) {
+	c.extraHeaders = headers
+}
+
+// SetClusterName sets the cluster name reported with every ping
+func (c *Client) SetClusterName(clusterName string) {
+	c.clusterName = clusterName
+}
+
+// SetCollectionInfo sets the function returning the watched namespaces and the collection interval in
+// minutes reported with every ping, so master can show how each slave is configured. namespaces is
+// called at each ping so reloaded and newly created namespaces are reported.
+func (c *Client) SetCollectionInfo(namespaces func(ctx context.Context) ([]string, error), collectionInterval int) {
+	c.namespaces = namespaces
+	c.collectionInterval = collectionInterval
+}
+
+// SetMetrics sets the metrics updated by every ping
+func (c *Client) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
+}
+
+// SetSchemaVersion overrides the payload schema version sent to master (e.g. to talk to an older master)
+func (c *Client) SetSchemaVersion(schemaVersion int) {
+	if schemaVersion > 0 {
+		c.schemaVersion = schemaVersion
 	}
 }
 
 // PingRequest represents the ping payload
 type PingRequest struct {
-	ClientName   string `json:"client_name"`
-	EnvName      string `json:"env_name"`
-	SlaveVersion string `json:"slave_version,omitempty"`
-	Timestamp    string `json:"timestamp,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	ClientName    string `json:"client_name"`
+	EnvName       string `json:"env_name"`
+	ClusterName   string `json:"cluster_name,omitempty"`
+	SlaveVersion  string `json:"slave_version,omitempty"`
+	Timestamp     string `json:"timestamp,omitempty"`
+
+	Namespaces         []string `json:"namespaces,omitempty"`          // namespaces the slave watches
+	CollectionInterval int      `json:"collection_interval,omitempty"` // collection interval of the slave in minutes
+	Status             string   `json:"status,omitempty"`              // "offline" when the slave is shutting down
 }
 
 // SendPing sends a health ping to the master
 func (c *Client) SendPing(ctx context.Context) error {
+	err := c.sendPing(ctx, "")
+	c.metrics.PingResult(err)
+	return err
+}
+
+// SendOffline tells the master that the slave is shutting down, so it is reported offline right away
+// instead of once its pings are overdue
+func (c *Client) SendOffline(ctx context.Context) error {
+	return c.sendPing(ctx, "offline")
+}
+
+func (c *Client) sendPing(ctx context.Context, status string) error {
 	if c.masterURL == "" {
 		return fmt.Errorf("master URL not configured")
 	}
 
 	pingData := PingRequest{
-		ClientName:   c.clientName,
-		EnvName:      c.envName,
-		SlaveVersion: c.slaveVersion,
-		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		SchemaVersion: c.schemaVersion,
+		ClientName:    c.clientName,
+		EnvName:       c.envName,
+		ClusterName:   c.clusterName,
+		SlaveVersion:  c.slaveVersion,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+
+		CollectionInterval: c.collectionInterval,
+		Status:             status,
+	}
+	if c.namespaces != nil {
+		// The ping still tells master the slave is alive when the namespaces cannot be listed
+		namespaces, err := c.namespaces(ctx)
+		if err != nil {
+			slog.Warn("Could not resolve watched namespaces for ping", "error", err)
+		}
+		pingData.Namespaces = namespaces
 	}
 
 	jsonData, err := json.Marshal(pingData)
@@ -68,7 +143,12 @@ func (c *Client) SendPing(ctx context.Context) error {
 		return fmt.Errorf("failed to create ping request: %w", err)
 	}
 
+	// Extra headers are applied first so they cannot clobber the headers the master relies on
+	for name, value := range c.extraHeaders {
+		req.Header.Set(name, value)
+	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent(c.schemaVersion))
 	if c.apiKey != "" {
 		req.Header.Set("X-API-Key", c.apiKey)
 	}
@@ -83,7 +163,7 @@ func (c *Client) SendPing(ctx context.Context) error {
 			return fmt.Errorf("failed to parse proxy URL: %w", err)
 		}
 		transport.Proxy = http.ProxyURL(proxyURL)
-		log.Println("Using proxy for ping")
+		slog.Info("Using proxy for ping")
 	}
 
 	// Configure TLS settings if insecure mode is enabled
@@ -91,7 +171,7 @@ func (c *Client) SendPing(ctx context.Context) error {
 		transport.TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: true,
 		}
-		log.Printf("TLS certificate verification disabled for ping (insecure mode)")
+		slog.Warn("TLS certificate verification disabled for ping (insecure mode)")
 	}
 
 	// Send request
@@ -106,7 +186,8 @@ func (c *Client) SendPing(ctx context.Context) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("master returned status %d", resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("master returned status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
 	}
 
 	return nil
@@ -115,32 +196,32 @@ func (c *Client) SendPing(ctx context.Context) error {
 // StartPingWorker starts a background worker that periodically sends pings
 func (c *Client) StartPingWorker(ctx context.Context, interval time.Duration) {
 	if c.masterURL == "" {
-		log.Println("Ping worker disabled - MASTER_URL not configured")
+		slog.Info("Ping worker disabled - MASTER_URL not configured")
 		return
 	}
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	log.Printf("Starting ping worker with interval %v to master %s", interval, c.masterURL)
+	slog.Info("Starting ping worker", "interval", interval.String(), "master", c.masterURL)
 
 	// Send initial ping
 	if err := c.SendPing(ctx); err != nil {
-		log.Printf("Initial ping failed: %v", err)
+		slog.Warn("Initial ping failed", "client", c.clientName, "env", c.envName, "error", err)
 	} else {
-		log.Printf("Initial ping sent successfully to master")
+		slog.Info("Initial ping sent successfully to master", "client", c.clientName, "env", c.envName)
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Ping worker stopped")
+			slog.Info("Ping worker stopped")
 			return
 		case <-ticker.C:
 			if err := c.SendPing(ctx); err != nil {
-				log.Printf("Ping failed: %v", err)
+				slog.Warn("Ping failed", "client", c.clientName, "env", c.envName, "error", err)
 			} else {
-				log.Printf("Ping sent successfully to master (%s/%s)", c.clientName, c.envName)
+				slog.Info("Ping sent successfully to master", "client", c.clientName, "env", c.envName)
 			}
 		}
 	}
@@ -160,7 +241,7 @@ func (c *Client) SendPingWithRetry(ctx context.Context, maxRetries int) error {
 		if i < maxRetries {
 			// Wait before retry with exponential backoff
 			waitTime := time.Duration(i+1) * 5 * time.Second
-			log.Printf("Ping attempt %d failed, retrying in %v: %v", i+1, waitTime, err)
+			slog.Warn("Ping attempt failed, retrying", "attempt", i+1, "retry_in", waitTime.String(), "error", err)
 
 			select {
 			case <-ctx.Done():
@@ -0,0 +1,56 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendPingSendsExtraHeaders(t *testing.T) {
+	var gotHeaders http.Header
+	var gotPing PingRequest
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header.Clone()
+		json.NewDecoder(r.Body).Decode(&gotPing)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "test-api-key", "acme", "prod", "v1.0.0", "", false)
+	client.SetExtraHeaders(map[string]string{"X-Tenant-ID": "acme"})
+	namespaces := []string{"default", "payments"}
+	client.SetCollectionInfo(func(context.Context) ([]string, error) { return namespaces, nil }, 30)
+
+	if err := client.SendPing(context.Background()); err != nil {
+		t.Fatalf("Unexpected ping error: %v", err)
+	}
+
+	if gotHeaders.Get("X-Tenant-ID") != "acme" {
+		t.Errorf("Expected X-Tenant-ID header 'acme', got %q", gotHeaders.Get("X-Tenant-ID"))
+	}
+	if gotHeaders.Get("X-API-Key") != "test-api-key" {
+		t.Errorf("Expected X-API-Key header to be preserved, got %q", gotHeaders.Get("X-API-Key"))
+	}
+	if gotPing.ClientName != "acme" || gotPing.EnvName != "prod" || len(gotPing.Namespaces) != 2 || gotPing.CollectionInterval != 30 || gotPing.Status != "" {
+		t.Errorf("Unexpected ping payload: %+v", gotPing)
+	}
+
+	// Namespaces reloaded since the last ping are reported
+	namespaces = []string{"default", "payments", "billing"}
+	if err := client.SendPing(context.Background()); err != nil {
+		t.Fatalf("Unexpected ping error: %v", err)
+	}
+	if len(gotPing.Namespaces) != 3 || gotPing.Namespaces[2] != "billing" {
+		t.Errorf("Expected the reloaded namespaces, got %+v", gotPing.Namespaces)
+	}
+
+	// The shutdown ping marks the slave offline
+	if err := client.SendOffline(context.Background()); err != nil {
+		t.Fatalf("Unexpected offline ping error: %v", err)
+	}
+	if gotPing.Status != "offline" {
+		t.Errorf("Expected offline status in the shutdown ping, got %+v", gotPing)
+	}
+}
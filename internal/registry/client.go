@@ -0,0 +1,307 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"krelease-tracker/internal/version"
+)
+
+// Media types of the manifests read from registries
+const (
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerSchema = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// manifestAccept lists the manifest media types understood by the client, sent as the Accept header
+var manifestAccept = strings.Join([]string{mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerList, mediaTypeDockerSchema}, ", ")
+
+// failureRetry is how long a failed lookup is remembered before the registry is queried again
+const failureRetry = 30 * time.Minute
+
+// maxResponseSize bounds the manifests and config blobs read from a registry
+const maxResponseSize = 4 << 20
+
+// Client reads OCI labels (e.g. org.opencontainers.image.revision) from the config blob of images.
+// Images are addressed by digest, so labels are cached for the lifetime of the client. Only registries
+// allowing anonymous pulls are supported. All methods are safe to call on a nil *Client.
+type Client struct {
+	labels     []string
+	httpClient *http.Client
+
+	// scheme is the URL scheme used to reach registries; tests serve plain HTTP
+	scheme string
+
+	mu    sync.Mutex
+	cache map[string]cachedLabels
+}
+
+// cachedLabels is the outcome of a lookup; failed lookups expire so they are retried
+type cachedLabels struct {
+	labels  map[string]string
+	failed  bool
+	expires time.Time
+}
+
+// New creates a client keeping only the given label keys of each image
+func New(labels []string) *Client {
+	return &Client{
+		labels:     labels,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		scheme:     "https",
+		cache:      make(map[string]cachedLabels),
+	}
+}
+
+// ImageLabels returns the configured labels of the image running digest, where image is the container
+// image reference (e.g. "ghcr.io/acme/web:v1.2.3"). Labels absent from the image are omitted. A lookup
+// that failed recently is not retried and returns no labels.
+func (c *Client) ImageLabels(ctx context.Context, image, digest string) (map[string]string, error) {
+	if c == nil || len(c.labels) == 0 || digest == "" {
+		return nil, nil
+	}
+	if !strings.Contains(digest, ":") {
+		digest = "sha256:" + digest
+	}
+	host, repository := parseReference(image)
+	key := host + "/" + repository + "@" + digest
+
+	c.mu.Lock()
+	cached, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && (!cached.failed || time.Now().Before(cached.expires)) {
+		return cached.labels, nil
+	}
+
+	allLabels, err := c.fetchLabels(ctx, host, repository, digest)
+	if err != nil {
+		c.mu.Lock()
+		c.cache[key] = cachedLabels{failed: true, expires: time.Now().Add(failureRetry)}
+		c.mu.Unlock()
+		return nil, fmt.Errorf("failed to read labels of %s: %w", key, err)
+	}
+
+	labels := make(map[string]string)
+	for _, name := range c.labels {
+		if value, ok := allLabels[name]; ok {
+			labels[name] = value
+		}
+	}
+
+	c.mu.Lock()
+	c.cache[key] = cachedLabels{labels: labels}
+	c.mu.Unlock()
+	return labels, nil
+}
+
+// fetchLabels resolves the manifest of digest, following an image index to its linux/amd64 image,
+// and returns the labels of the image config
+func (c *Client) fetchLabels(ctx context.Context, host, repository, digest string) (map[string]string, error) {
+	session := &session{client: c, host: host, repository: repository}
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Config    struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		body, mediaType, err := session.get(ctx, "manifests/"+digest, manifestAccept)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+		if manifest.MediaType == "" {
+			manifest.MediaType = mediaType
+		}
+		if manifest.MediaType != mediaTypeOCIIndex && manifest.MediaType != mediaTypeDockerList {
+			break
+		}
+
+		// Multi-platform image: read the linux/amd64 image, or the first one that is not an attestation
+		digest = ""
+		for _, entry := range manifest.Manifests {
+			if entry.Platform.OS == "linux" && entry.Platform.Architecture == "amd64" {
+				digest = entry.Digest
+				break
+			}
+			if digest == "" && entry.Platform.OS != "unknown" {
+				digest = entry.Digest
+			}
+		}
+		if digest == "" {
+			return nil, fmt.Errorf("image index has no image manifest")
+		}
+		manifest.MediaType, manifest.Manifests = "", nil
+	}
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest has no config blob")
+	}
+
+	body, _, err := session.get(ctx, "blobs/"+manifest.Config.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(body, &config); err != nil {
+		return nil, fmt.Errorf("invalid image config: %w", err)
+	}
+	return config.Config.Labels, nil
+}
+
+// session reads from one repository, reusing the anonymous token obtained on the first 401 response
+type session struct {
+	client     *Client
+	host       string
+	repository string
+	token      string
+}
+
+// get fetches a path below /v2/<repository>/ and returns the body and its content type
+func (s *session) get(ctx context.Context, path, accept string) ([]byte, string, error) {
+	requestURL := s.client.scheme + "://" + s.host + "/v2/" + s.repository + "/" + path
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("User-Agent", "krelease-tracker/"+version.Version)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+
+		resp, err := s.client.httpClient.Do(req)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query registry: %w", err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read registry response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if s.token, err = s.client.anonymousToken(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("registry returned status %d for %s", resp.StatusCode, path)
+		}
+		return body, resp.Header.Get("Content-Type"), nil
+	}
+}
+
+// anonymousToken requests a pull token from the token service named by a Bearer challenge
+func (c *Client) anonymousToken(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry requires unsupported authentication %q", scheme)
+	}
+
+	query := url.Values{}
+	var realm string
+	for _, param := range splitChallenge(params) {
+		name, value, _ := strings.Cut(param, "=")
+		value = strings.Trim(value, `"`)
+		switch strings.TrimSpace(name) {
+		case "realm":
+			realm = value
+		case "service", "scope":
+			query.Set(strings.TrimSpace(name), value)
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("registry authentication challenge has no realm")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("User-Agent", "krelease-tracker/"+version.Version)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return token.Token, nil
+}
+
+// splitChallenge splits the parameters of an authentication challenge on the commas outside quotes
+func splitChallenge(params string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i, r := range params {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, params[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, params[start:])
+}
+
+// parseReference returns the registry host and repository of an image reference, applying the
+// Docker Hub defaults (e.g. "nginx:1.25" is registry-1.docker.io, library/nginx)
+func parseReference(image string) (host, repository string) {
+	image, _, _ = strings.Cut(image, "@")
+	if slash := strings.LastIndex(image, "/"); strings.LastIndex(image, ":") > slash {
+		image = image[:strings.LastIndex(image, ":")]
+	}
+
+	host, repository, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, repository = "docker.io", image
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	return host, repository
+}
@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestRegistry serves a multi-platform image whose amd64 config carries OCI labels, behind an
+// anonymous token service like Docker Hub's
+func newTestRegistry(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:acme/web:pull" {
+				t.Errorf("Unexpected token scope %q", r.URL.Query().Get("scope"))
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "anonymous"})
+			return
+		}
+
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test-registry",scope="repository:acme/web:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/acme/web/manifests/sha256:index":
+			w.Header().Set("Content-Type", mediaTypeOCIIndex)
+			w.Write([]byte(`{"schemaVersion": 2, "mediaType": "` + mediaTypeOCIIndex + `", "manifests": [
+				{"digest": "sha256:attestation", "platform": {"os": "unknown", "architecture": "unknown"}},
+				{"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64"}},
+				{"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}}
+			]}`))
+		case "/v2/acme/web/manifests/sha256:amd64":
+			w.Header().Set("Content-Type", mediaTypeOCIManifest)
+			w.Write([]byte(`{"schemaVersion": 2, "config": {"digest": "sha256:config"}}`))
+		case "/v2/acme/web/blobs/sha256:config":
+			w.Write([]byte(`{"architecture": "amd64", "config": {"Labels": {
+				"org.opencontainers.image.revision": "0123abc",
+				"org.opencontainers.image.source": "https://github.com/acme/web",
+				"maintainer": "ops@acme.example"
+			}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestImageLabels(t *testing.T) {
+	server, requests := newTestRegistry(t)
+	client := New([]string{"org.opencontainers.image.revision", "org.opencontainers.image.source", "org.opencontainers.image.created"})
+	client.scheme = "http"
+	image := strings.TrimPrefix(server.URL, "http://") + "/acme/web:v1.2.3"
+
+	labels, err := client.ImageLabels(context.Background(), image, "sha256:index")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(labels) != 2 || labels["org.opencontainers.image.revision"] != "0123abc" || labels["org.opencontainers.image.source"] != "https://github.com/acme/web" {
+		t.Errorf("Expected only the configured labels present on the image, got %v", labels)
+	}
+
+	// Labels of a digest never change, so the registry is queried once
+	seen := requests.Load()
+	if labels, err := client.ImageLabels(context.Background(), image, "sha256:index"); err != nil || len(labels) != 2 {
+		t.Errorf("Expected cached labels, got %v (%v)", labels, err)
+	}
+	if requests.Load() != seen {
+		t.Errorf("Expected cached labels not to query the registry again")
+	}
+}
+
+func TestImageLabelsErrors(t *testing.T) {
+	server, requests := newTestRegistry(t)
+	client := New([]string{"org.opencontainers.image.revision"})
+	client.scheme = "http"
+	image := strings.TrimPrefix(server.URL, "http://") + "/acme/web:v1.2.3"
+
+	if _, err := client.ImageLabels(context.Background(), image, "unknown"); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("Expected the missing manifest to be reported, got %v", err)
+	}
+
+	// A failed lookup is not retried right away
+	seen := requests.Load()
+	if labels, err := client.ImageLabels(context.Background(), image, "unknown"); err != nil || labels != nil {
+		t.Errorf("Expected a recent failure to return no labels, got %v (%v)", labels, err)
+	}
+	if requests.Load() != seen {
+		t.Errorf("Expected a recent failure not to query the registry again")
+	}
+
+	// Unreachable registries are reported as errors
+	if _, err := client.ImageLabels(context.Background(), "127.0.0.1:1/acme/web:v1", "sha256:index"); err == nil {
+		t.Error("Expected an error for an unreachable registry")
+	}
+
+	// A nil client is disabled
+	var disabled *Client
+	if labels, err := disabled.ImageLabels(context.Background(), image, "sha256:index"); labels != nil || err != nil {
+		t.Errorf("Expected a nil client to return nothing, got %v (%v)", labels, err)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image, host, repository string
+	}{
+		{"nginx", "registry-1.docker.io", "library/nginx"},
+		{"nginx:1.25", "registry-1.docker.io", "library/nginx"},
+		{"bitnami/redis:7.2", "registry-1.docker.io", "bitnami/redis"},
+		{"docker.io/library/nginx:1.25", "registry-1.docker.io", "library/nginx"},
+		{"ghcr.io/acme/web:v1.2.3", "ghcr.io", "acme/web"},
+		{"localhost:5000/web", "localhost:5000", "web"},
+		{"registry.example.com:5000/team/web:v1@sha256:abc", "registry.example.com:5000", "team/web"},
+	}
+	for _, tt := range tests {
+		host, repository := parseReference(tt.image)
+		if host != tt.host || repository != tt.repository {
+			t.Errorf("parseReference(%q) = %q, %q; want %q, %q", tt.image, host, repository, tt.host, tt.repository)
+		}
+	}
+}
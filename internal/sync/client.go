@@ -3,86 +3,434 @@ package sync
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/metrics"
+	"krelease-tracker/internal/tracing"
+	"krelease-tracker/internal/version"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Client handles syncing pending releases to master
 type Client struct {
-	masterURL   string
-	apiKey      string
-	db          *database.DB
-	proxyURL    string
-	tlsInsecure bool
+	masterURL     string
+	apiKey        string
+	db            *database.DB
+	proxyURL      string
+	tlsInsecure   bool
+	schemaVersion int
+	extraHeaders  map[string]string
+
+	// failedRetention is how long failed releases are kept before being purged (0 keeps them forever)
+	failedRetention time.Duration
+
+	// maxRetries is the number of retries of a request failing transiently, waiting retryDelay
+	// before the first retry and doubling it before each next one
+	maxRetries int
+	retryDelay time.Duration
+
+	// maxAttempts is the number of rejected sync attempts after which a pending release is moved to
+	// failed_releases (0 keeps retrying it forever)
+	maxAttempts int
+
+	// dedupWindow is how long a release synced to master is not sent again while only its timestamps
+	// change (0 sends every pending release); lastSynced remembers what was sent for each (component, SHA)
+	dedupWindow time.Duration
+	lastSynced  map[string]syncedRelease
+
+	// metrics counts sync successes and failures; nil disables them
+	metrics *metrics.Metrics
+
+	// onSynced is called after each successful run of the sync worker; nil disables it
+	onSynced func()
 }
 
 // New creates a new sync client
 func New(masterURL, apiKey string, db *database.DB, proxyURL string, tlsInsecure bool) *Client {
 	return &Client{
-		masterURL:   masterURL,
-		apiKey:      apiKey,
-		db:          db,
-		proxyURL:    proxyURL,
-		tlsInsecure: tlsInsecure,
+		masterURL:     masterURL,
+		apiKey:        apiKey,
+		db:            db,
+		proxyURL:      proxyURL,
+		tlsInsecure:   tlsInsecure,
+		schemaVersion: version.SchemaVersion,
+		retryDelay:    5 * time.Second,
+		lastSynced:    make(map[string]syncedRelease),
+	}
+}
+
+// SetExtraHeaders sets additional HTTP headers added to every request sent to master
+func (c *Client) SetExtraHeaders(headers map[string]string) {
+	c.extraHeaders = headers
+}
+
+// SetSchemaVersion overrides the payload schema version sent to master (e.g. to talk to an older master)
+func (c *Client) SetSchemaVersion(schemaVersion int) {
+	if schemaVersion > 0 {
+		c.schemaVersion = schemaVersion
 	}
 }
 
-// SyncPendingReleases sends all pending releases to master and removes them on success
-func (c *Client) SyncPendingReleases(ctx context.Context) error {
+// SetOnSynced sets a function called after each successful run of the sync worker, e.g. to report its health
+func (c *Client) SetOnSynced(onSynced func()) {
+	c.onSynced = onSynced
+}
+
+// SetMetrics sets the metrics updated by every synced release
+func (c *Client) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
+}
+
+// SetMaxRetries sets how many times a sync request failing with a network error or a 5xx is retried
+func (c *Client) SetMaxRetries(maxRetries int) {
+	c.maxRetries = max(0, maxRetries)
+}
+
+// SetMaxAttempts sets after how many rejected sync attempts a pending release is moved to failed_releases
+func (c *Client) SetMaxAttempts(maxAttempts int) {
+	c.maxAttempts = max(0, maxAttempts)
+}
+
+// SetDedupWindow sets how long a release synced to master is not sent again while only its timestamps change
+func (c *Client) SetDedupWindow(window time.Duration) {
+	c.dedupWindow = max(0, window)
+}
+
+// SetFailedRetention sets how long failed releases are kept in the database before being purged
+func (c *Client) SetFailedRetention(retention time.Duration) {
+	c.failedRetention = retention
+}
+
+// PurgeFailedReleases removes failed releases older than the configured retention
+func (c *Client) PurgeFailedReleases() error {
+	if c.failedRetention <= 0 {
+		return nil
+	}
+
+	purged, err := c.db.PurgeFailedReleases(time.Now().Add(-c.failedRetention))
+	if err != nil {
+		return fmt.Errorf("failed to purge failed releases: %w", err)
+	}
+	if purged > 0 {
+		slog.Info("Purged old failed releases", "count", purged, "older_than", c.failedRetention.String())
+	}
+	return nil
+}
+
+// syncBatchSize is the number of pending releases sent to master per batch request
+const syncBatchSize = 100
+
+// errBatchUnsupported is returned by syncBatch when master predates the batch collect endpoint
+var errBatchUnsupported = errors.New("master does not support batch collect")
+
+// SyncPendingReleases sends all pending releases to master and removes them on success. It returns an
+// error when no batch reached master, so a run against an unreachable master is not reported as a success.
+func (c *Client) SyncPendingReleases(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "sync pending releases")
+	defer func() { tracing.End(span, err) }()
+
 	pendingReleases, err := c.db.GetPendingReleases()
 	if err != nil {
 		return fmt.Errorf("failed to get pending releases: %w", err)
 	}
 
+	pendingReleases = c.collapsePending(pendingReleases)
 	if len(pendingReleases) == 0 {
-		log.Println("No pending releases to sync")
+		slog.Info("No pending releases to sync")
 		return nil
 	}
 
-	log.Printf("Syncing %d pending releases to master", len(pendingReleases))
+	span.SetAttributes(attribute.Int("krelease.pending_releases", len(pendingReleases)))
+	slog.Info("Syncing pending releases to master", "count", len(pendingReleases))
 
+	// reached is set once master answered a batch, even if it rejected some of its releases
+	reached := false
+	var lastErr error
+	for start := 0; start < len(pendingReleases); start += syncBatchSize {
+		batch := pendingReleases[start:min(start+syncBatchSize, len(pendingReleases))]
+
+		var results []error
+		err := c.withRetry(ctx, func() error {
+			var err error
+			results, err = c.syncBatch(ctx, batch)
+			return err
+		})
+		if errors.Is(err, errBatchUnsupported) {
+			slog.Warn("Master does not support batch sync, syncing pending releases one by one")
+			if err := c.syncReleasesIndividually(ctx, pendingReleases[start:]); err != nil && !reached {
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+			for i := range batch {
+				c.metrics.SyncResult(err)
+				c.recordFailure(&batch[i], err)
+			}
+			slog.Error("Failed to sync batch of releases", "count", len(batch), "error", err)
+			continue
+		}
+
+		reached = true
+		for i := range batch {
+			c.metrics.SyncResult(results[i])
+			if results[i] != nil {
+				slog.Error("Failed to sync release", append(releaseAttrs(&batch[i]), "error", results[i])...)
+				c.recordFailure(&batch[i], results[i])
+				continue
+			}
+			c.markSynced(&batch[i])
+			c.removeSynced(batch[i].ID)
+		}
+	}
+
+	if !reached {
+		return fmt.Errorf("failed to sync any of the %d pending releases: %w", len(pendingReleases), lastErr)
+	}
+	return nil
+}
+
+// syncReleasesIndividually sends pending releases one request each, for masters without the batch endpoint.
+// It returns the last error when none of them could be synced.
+func (c *Client) syncReleasesIndividually(ctx context.Context, pendingReleases []database.PendingRelease) error {
+	synced := false
+	var lastErr error
 	for _, release := range pendingReleases {
-		if err := c.syncSingleRelease(ctx, &release); err != nil {
-			log.Printf("Failed to sync release %d: %v", release.ID, err)
+		err := c.withRetry(ctx, func() error {
+			return c.syncSingleRelease(ctx, &release)
+		})
+		c.metrics.SyncResult(err)
+		if err != nil {
+			slog.Error("Failed to sync release", append(releaseAttrs(&release), "error", err)...)
+			c.recordFailure(&release, err)
+			lastErr = err
 			continue
 		}
+		c.markSynced(&release)
+		c.removeSynced(release.ID)
+		synced = true
+	}
+
+	if !synced && lastErr != nil {
+		return fmt.Errorf("failed to sync any of the %d pending releases: %w", len(pendingReleases), lastErr)
+	}
+	return nil
+}
+
+// recordFailure counts a sync attempt master rejected and moves the release to failed_releases once it
+// reaches the configured maximum. Transient failures are not counted, so an outage of master does not
+// fail releases that would sync once it is back.
+func (c *Client) recordFailure(release *database.PendingRelease, err error) {
+	if c.maxAttempts <= 0 || isTransient(err) || ctxErr(err) {
+		return
+	}
+
+	attempts, dbErr := c.db.RecordSyncFailure(release.ID, err.Error())
+	if dbErr != nil {
+		slog.Error("Failed to record sync failure", append(releaseAttrs(release), "error", dbErr)...)
+		return
+	}
+	if attempts < c.maxAttempts {
+		return
+	}
+
+	if dbErr := c.db.MoveToFailedReleases(release.ID); dbErr != nil {
+		slog.Error("Failed to move release to failed releases", append(releaseAttrs(release), "error", dbErr)...)
+		return
+	}
+	slog.Warn("Moved release to failed releases after rejected sync attempts", append(releaseAttrs(release), "attempts", attempts)...)
+}
+
+// releaseAttrs returns the log fields identifying a pending release
+func releaseAttrs(release *database.PendingRelease) []any {
+	return []any{
+		"release_id", release.ID,
+		"client", release.ClientName,
+		"env", release.EnvName,
+		"namespace", release.Namespace,
+		"workload", release.WorkloadName,
+		"container", release.ContainerName,
+	}
+}
+
+// ctxErr reports whether err comes from the sync being cancelled or timing out
+func ctxErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// withRetry runs send, retrying it with exponential backoff while it fails transiently
+func (c *Client) withRetry(ctx context.Context, send func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil || attempt >= c.maxRetries || !isTransient(err) {
+			return err
+		}
+
+		waitTime := c.retryDelay << attempt
+		slog.Warn("Sync attempt failed, retrying", "attempt", attempt+1, "retry_in", waitTime.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitTime):
+		}
+	}
+}
+
+// statusError is returned when master answers a sync request with an unexpected status
+type statusError struct {
+	statusCode int
+	body       []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("master returned status %d: %s", e.statusCode, bytes.TrimSpace(e.body))
+}
+
+// isTransient reports whether a failed request may succeed when retried: network errors, 5xx responses and
+// 409, answered while master still processes an earlier attempt with the same Idempotency-Key, are transient,
+// while other 4xx responses report a request master will keep rejecting
+func isTransient(err error) bool {
+	if ctxErr(err) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.statusCode >= 500 || status.statusCode == http.StatusConflict
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// removeSynced removes a pending release once master has recorded it
+func (c *Client) removeSynced(id int) {
+	if err := c.db.DeletePendingRelease(id); err != nil {
+		slog.Error("Failed to delete pending release", "release_id", id, "error", err)
+	} else {
+		slog.Info("Successfully synced and removed pending release", "release_id", id)
+	}
+}
+
+// syncedRelease is what was last sent to master for a (component, SHA)
+type syncedRelease struct {
+	fingerprint string
+	syncedAt    time.Time
+}
+
+// pendingKey identifies the (component, SHA) of a pending release
+func pendingKey(release *database.PendingRelease) string {
+	return strings.Join([]string{release.ClientName, release.EnvName, release.Namespace, release.WorkloadName, release.ContainerName, release.ImageSHA}, "/")
+}
+
+// fingerprint summarizes the payload of a release without its timestamps, which change on every collection
+func (c *Client) fingerprint(release *database.PendingRelease) string {
+	payload := c.releasePayload(release)
+	delete(payload, "released_at")
+	data, _ := json.Marshal(payload)
+	return string(data)
+}
+
+// markSynced remembers a release sent to master, so unchanged copies are collapsed within the dedup window
+func (c *Client) markSynced(release *database.PendingRelease) {
+	if c.dedupWindow > 0 {
+		c.lastSynced[pendingKey(release)] = syncedRelease{fingerprint: c.fingerprint(release), syncedAt: time.Now()}
+	}
+}
+
+// collapsePending keeps one pending release per (component, SHA), the most recently seen, and with a dedup
+// window drops releases master received less than the window ago that only differ by their timestamps.
+// Rows left out are removed from the queue; their component is queued again by the next collection.
+func (c *Client) collapsePending(pendingReleases []database.PendingRelease) []database.PendingRelease {
+	latest := make(map[string]int, len(pendingReleases))
+	for i := range pendingReleases {
+		key := pendingKey(&pendingReleases[i])
+		if j, ok := latest[key]; !ok || pendingReleases[i].LastSeen.After(pendingReleases[j].LastSeen) {
+			latest[key] = i
+		}
+	}
 
-		// Remove from pending releases on successful sync
+	now := time.Now()
+	for key, synced := range c.lastSynced {
+		if now.Sub(synced.syncedAt) >= c.dedupWindow {
+			delete(c.lastSynced, key)
+		}
+	}
+
+	kept := make([]database.PendingRelease, 0, len(latest))
+	for i := range pendingReleases {
+		release := &pendingReleases[i]
+		key := pendingKey(release)
+		redundant := latest[key] != i
+		if synced, ok := c.lastSynced[key]; ok && !redundant {
+			redundant = synced.fingerprint == c.fingerprint(release)
+		}
+		if !redundant {
+			kept = append(kept, *release)
+			continue
+		}
 		if err := c.db.DeletePendingRelease(release.ID); err != nil {
-			log.Printf("Failed to delete pending release %d: %v", release.ID, err)
-		} else {
-			log.Printf("Successfully synced and removed pending release %d", release.ID)
+			slog.Error("Failed to delete redundant pending release", append(releaseAttrs(release), "error", err)...)
 		}
 	}
 
-	return nil
+	if dropped := len(pendingReleases) - len(kept); dropped > 0 {
+		slog.Info("Collapsed redundant pending releases", "count", dropped)
+	}
+	return kept
 }
 
-// syncSingleRelease sends a single release to the master
-func (c *Client) syncSingleRelease(ctx context.Context, release *database.PendingRelease) error {
-	// Convert PendingRelease to the format expected by the manual collect API
-	requestBody := map[string]interface{}{
-		"image_tag":   release.ImageTag,
-		"image_sha":   release.ImageSHA,
-		"image_repo":  release.ImageRepo,
-		"image_name":  release.ImageName,
-		"client_name": release.ClientName,
-		"env_name":    release.EnvName,
-		"released_at": release.LastSeen.UTC(),
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+// releasePayload converts a pending release to the body expected by the manual collect API
+func (c *Client) releasePayload(release *database.PendingRelease) map[string]interface{} {
+	payload := map[string]interface{}{
+		"schema_version": c.schemaVersion,
+		"image_tag":      release.ImageTag,
+		"image_sha":      release.ImageSHA,
+		"image_repo":     release.ImageRepo,
+		"image_name":     release.ImageName,
+		"client_name":    release.ClientName,
+		"env_name":       release.EnvName,
+		"cluster_name":   release.ClusterName,
+		"chart_version":  release.ChartVersion,
+		"app_version":    release.AppVersion,
+		"display_order":  release.DisplayOrder,
+		"released_at":    release.LastSeen.UTC(),
+	}
+	if release.SpecDigest != "" {
+		payload["spec_digest"] = release.SpecDigest
+	}
+	if release.StartedAt != nil {
+		payload["started_at"] = release.StartedAt.UTC()
+	}
+	if len(release.ImageLabels) > 0 {
+		payload["image_labels"] = release.ImageLabels
+	}
+	if len(release.Annotations) > 0 {
+		payload["annotations"] = release.Annotations
 	}
+	if release.Replicas != nil {
+		payload["replicas"] = *release.Replicas
+	}
+	if release.ReadyReplicas != nil {
+		payload["ready_replicas"] = *release.ReadyReplicas
+	}
+	return payload
+}
 
+// syncSingleRelease sends a single release to the master
+func (c *Client) syncSingleRelease(ctx context.Context, release *database.PendingRelease) error {
 	// Build the URL for the manual collect endpoint
 	requestURL := fmt.Sprintf("%s/api/collect/%s/%s/%s/%s",
 		c.masterURL,
@@ -92,16 +440,120 @@ func (c *Client) syncSingleRelease(ctx context.Context, release *database.Pendin
 		release.ContainerName,
 	)
 
+	resp, err := c.send(ctx, "PUT", requestURL, idempotencyKey(c.releaseIdentity(release)), c.releasePayload(release))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{statusCode: resp.StatusCode, body: body}
+	}
+
+	return nil
+}
+
+// syncBatch sends releases to the batch collect endpoint of master in one request. It returns the
+// outcome of every release, in order, or an error if the request as a whole failed.
+func (c *Client) syncBatch(ctx context.Context, releases []database.PendingRelease) ([]error, error) {
+	items := make([]map[string]interface{}, len(releases))
+	identities := make([]string, len(releases))
+	for i := range releases {
+		identities[i] = c.releaseIdentity(&releases[i])
+		item := c.releasePayload(&releases[i])
+		item["namespace"] = releases[i].Namespace
+		item["workload_kind"] = releases[i].WorkloadType
+		item["workload_name"] = releases[i].WorkloadName
+		item["container_name"] = releases[i].ContainerName
+		items[i] = item
+	}
+
+	resp, err := c.send(ctx, "POST", c.masterURL+"/api/collect/batch", idempotencyKey(identities...), items)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Older masters route the path to nothing, or to the single-item endpoint with another method
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, errBatchUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &statusError{statusCode: resp.StatusCode, body: body}
+	}
+
+	var response struct {
+		Results []struct {
+			Index  int    `json:"index"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode batch response: %w", err)
+	}
+
+	// Releases missing from the response are kept queued for the next sync
+	results := make([]error, len(releases))
+	for i := range results {
+		results[i] = errors.New("not reported by master")
+	}
+	for _, result := range response.Results {
+		if result.Index < 0 || result.Index >= len(results) {
+			continue
+		}
+		if result.Status == "success" {
+			results[result.Index] = nil
+		} else {
+			results[result.Index] = fmt.Errorf("master rejected release: %s", result.Error)
+		}
+	}
+	return results, nil
+}
+
+// releaseIdentity identifies what a release tells master: its component, SHA and payload without timestamps.
+// Retries of a release re-collected in between, whose released_at moved, keep the same identity.
+func (c *Client) releaseIdentity(release *database.PendingRelease) string {
+	return pendingKey(release) + "\n" + c.fingerprint(release)
+}
+
+// idempotencyKey derives the Idempotency-Key of a request from the identities of the releases it sends, so
+// master answers a retry of a request it already processed without recording the releases again
+func idempotencyKey(identities ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(identities, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// send marshals payload and sends it to master with the authentication, proxy and TLS settings of the client.
+// A non-empty idempotencyKey is sent as the Idempotency-Key header.
+func (c *Client) send(ctx context.Context, method, requestURL, idempotencyKey string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "PUT", requestURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	// Extra headers are applied first so they cannot clobber the headers the master relies on
+	for name, value := range c.extraHeaders {
+		req.Header.Set(name, value)
+	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent(c.schemaVersion))
+	req.Header.Set("X-Release-Source", database.SourceSync)
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
 	if c.apiKey != "" {
 		req.Header.Set("X-API-Key", c.apiKey)
 	}
+	tracing.Inject(ctx, req.Header)
 
 	// Create HTTP client with custom transport for proxy and TLS settings
 	transport := &http.Transport{}
@@ -110,10 +562,10 @@ func (c *Client) syncSingleRelease(ctx context.Context, release *database.Pendin
 	if c.proxyURL != "" {
 		proxyURL, err := url.Parse(c.proxyURL)
 		if err != nil {
-			return fmt.Errorf("failed to parse proxy URL: %w", err)
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
 		}
 		transport.Proxy = http.ProxyURL(proxyURL)
-		log.Println("Using proxy for sync")
+		slog.Info("Using proxy for sync")
 	}
 
 	// Configure TLS settings if insecure mode is enabled
@@ -121,7 +573,7 @@ func (c *Client) syncSingleRelease(ctx context.Context, release *database.Pendin
 		transport.TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: true,
 		}
-		log.Println("TLS certificate verification disabled (insecure mode)")
+		slog.Warn("TLS certificate verification disabled (insecure mode)")
 	}
 
 	// Send request
@@ -131,15 +583,9 @@ func (c *Client) syncSingleRelease(ctx context.Context, release *database.Pendin
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("master returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-
-	return nil
+	return resp, nil
 }
 
 // StartSyncWorker starts a background worker that periodically syncs pending releases
@@ -147,16 +593,23 @@ func (c *Client) StartSyncWorker(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	log.Printf("Starting sync worker with interval %v", interval)
+	slog.Info("Starting sync worker", "interval", interval.String())
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Sync worker stopped")
+			slog.Info("Sync worker stopped")
 			return
 		case <-ticker.C:
-			if err := c.SyncPendingReleases(ctx); err != nil {
-				log.Printf("Sync failed: %v", err)
+			// A sync in progress is finished rather than aborted when ctx is done, so releases the
+			// master already accepted are not left pending
+			if err := c.SyncPendingReleases(context.WithoutCancel(ctx)); err != nil {
+				slog.Error("Sync failed", "error", err)
+			} else if c.onSynced != nil {
+				c.onSynced()
+			}
+			if err := c.PurgeFailedReleases(); err != nil {
+				slog.Error("Failed release cleanup failed", "error", err)
 			}
 		}
 	}
@@ -1,9 +1,18 @@
 package sync
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"krelease-tracker/internal/database"
+	"krelease-tracker/internal/version"
 )
 
 func TestNew(t *testing.T) {
@@ -47,3 +56,520 @@ func TestNewWithoutProxyAndTLS(t *testing.T) {
 		t.Errorf("Expected tlsInsecure to be false, got true")
 	}
 }
+
+func TestSyncSingleReleaseSendsSchemaVersion(t *testing.T) {
+	var gotUserAgent, gotSource string
+	var gotBody map[string]interface{}
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.Header.Get("User-Agent")
+		gotSource = r.Header.Get("X-Release-Source")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", nil, "", false)
+	release := &database.PendingRelease{
+		Namespace:     "default",
+		WorkloadName:  "web",
+		WorkloadType:  "Deployment",
+		ContainerName: "app",
+		ImageTag:      "v1.0.0",
+		ImageSHA:      "abc123",
+		LastSeen:      time.Now(),
+	}
+
+	if err := client.syncSingleRelease(context.Background(), release); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+
+	if gotBody["schema_version"] != float64(version.SchemaVersion) {
+		t.Errorf("Expected schema_version %d, got %v", version.SchemaVersion, gotBody["schema_version"])
+	}
+	if !strings.HasPrefix(gotUserAgent, "krelease-tracker/"+version.Version) {
+		t.Errorf("Expected krelease-tracker User-Agent, got %q", gotUserAgent)
+	}
+	if gotSource != database.SourceSync {
+		t.Errorf("Expected X-Release-Source %q, got %q", database.SourceSync, gotSource)
+	}
+}
+
+func TestSyncSingleReleaseSendsIdempotencyKey(t *testing.T) {
+	var keys []string
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", nil, "", false)
+	release := &database.PendingRelease{
+		Namespace: "default", WorkloadName: "web", WorkloadType: "Deployment", ContainerName: "app",
+		ImageTag: "v1.0.0", ImageSHA: "abc123", LastSeen: time.Now(),
+	}
+	send := func() {
+		t.Helper()
+		if err := client.syncSingleRelease(context.Background(), release); err != nil {
+			t.Fatalf("Unexpected sync error: %v", err)
+		}
+	}
+	send()
+	// A release re-collected in between only moves its timestamps and keeps its key
+	release.LastSeen = release.LastSeen.Add(time.Minute)
+	send()
+	release.ImageTag, release.ImageSHA = "v1.1.0", "def456"
+	send()
+
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Expected the same Idempotency-Key for a retried release, got %q", keys)
+	}
+	if keys[2] == keys[0] {
+		t.Errorf("Expected a new Idempotency-Key for a new release, got %q", keys)
+	}
+}
+
+func TestSyncSingleReleaseReportsMasterRejection(t *testing.T) {
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported schema_version 2", http.StatusBadRequest)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", nil, "", false)
+	err := client.syncSingleRelease(context.Background(), &database.PendingRelease{LastSeen: time.Now()})
+	if err == nil || !strings.Contains(err.Error(), "unsupported schema_version 2") {
+		t.Errorf("Expected master rejection message in error, got %v", err)
+	}
+}
+
+func TestSyncSingleReleaseSendsExtraHeaders(t *testing.T) {
+	var gotHeaders http.Header
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "test-api-key", nil, "", false)
+	client.SetExtraHeaders(map[string]string{
+		"X-Tenant-ID": "acme",
+		"X-API-Key":   "must-not-override",
+	})
+
+	if err := client.syncSingleRelease(context.Background(), &database.PendingRelease{LastSeen: time.Now()}); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+
+	if gotHeaders.Get("X-Tenant-ID") != "acme" {
+		t.Errorf("Expected X-Tenant-ID header 'acme', got %q", gotHeaders.Get("X-Tenant-ID"))
+	}
+	if gotHeaders.Get("X-API-Key") != "test-api-key" {
+		t.Errorf("Expected extra headers not to override X-API-Key, got %q", gotHeaders.Get("X-API-Key"))
+	}
+}
+
+func TestSyncSingleReleaseSendsClusterName(t *testing.T) {
+	var gotBody map[string]interface{}
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", nil, "", false)
+	release := &database.PendingRelease{
+		Namespace:     "default",
+		WorkloadName:  "web",
+		WorkloadType:  "Deployment",
+		ContainerName: "app",
+		ImageTag:      "v1.0.0",
+		ImageSHA:      "abc123",
+		ClusterName:   "eu-west-1",
+		LastSeen:      time.Now(),
+	}
+
+	if err := client.syncSingleRelease(context.Background(), release); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+
+	if gotBody["cluster_name"] != "eu-west-1" {
+		t.Errorf("Expected cluster_name 'eu-west-1', got %v", gotBody["cluster_name"])
+	}
+}
+
+func TestPurgeFailedReleasesHonoursRetention(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for name, failedAt := range map[string]time.Time{
+		"old":    now.AddDate(0, 0, -40),
+		"recent": now.AddDate(0, 0, -5),
+	} {
+		if err := db.InsertFailedRelease(&database.FailedRelease{
+			Namespace: "default", WorkloadName: name, WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: "v1", ImageSHA: "abc123", SyncAttempts: 5, LastError: "master returned status 400",
+			FirstSeen: failedAt, LastSeen: failedAt, FailedAt: failedAt,
+		}); err != nil {
+			t.Fatalf("Failed to insert failed release: %v", err)
+		}
+	}
+
+	client := New("https://master.example.com", "", db, "", false)
+
+	// Without a retention nothing is purged
+	if err := client.PurgeFailedReleases(); err != nil {
+		t.Fatalf("Unexpected purge error: %v", err)
+	}
+	if failed, _ := db.GetFailedReleases(); len(failed) != 2 {
+		t.Fatalf("Expected 2 failed releases without retention, got %d", len(failed))
+	}
+
+	client.SetFailedRetention(30 * 24 * time.Hour)
+	if err := client.PurgeFailedReleases(); err != nil {
+		t.Fatalf("Unexpected purge error: %v", err)
+	}
+
+	failed, err := db.GetFailedReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].WorkloadName != "recent" {
+		t.Errorf("Expected only the release within retention to be kept, got %+v", failed)
+	}
+}
+
+// seedPendingReleases queues one pending release per workload name
+func seedPendingReleases(t *testing.T, db *database.DB, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := db.UpsertPendingRelease(&database.PendingRelease{
+			Namespace: "default", WorkloadName: name, WorkloadType: "Deployment", ContainerName: "app",
+			ImageTag: "v1.0.0", ImageSHA: "sha-" + name, ClientName: "acme", EnvName: "prod",
+			FirstSeen: time.Now(), LastSeen: time.Now(),
+		}); err != nil {
+			t.Fatalf("Failed to queue pending release: %v", err)
+		}
+	}
+}
+
+func TestSyncPendingReleasesSendsBatches(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	names := make([]string, syncBatchSize+5)
+	for i := range names {
+		names[i] = fmt.Sprintf("web-%03d", i)
+	}
+	seedPendingReleases(t, db, names...)
+
+	var batchSizes []int
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/collect/batch" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var items []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			t.Errorf("Invalid batch body: %v", err)
+		}
+		batchSizes = append(batchSizes, len(items))
+
+		// Master rejects web-001 and does not report the last item of the batch
+		var results []map[string]interface{}
+		for i, item := range items[:len(items)-1] {
+			if item["namespace"] != "default" || item["workload_kind"] != "Deployment" || item["container_name"] != "app" {
+				t.Errorf("Expected the component path in batch items, got %v", item)
+			}
+			status := "success"
+			if item["workload_name"] == "web-001" {
+				status = "error"
+			}
+			results = append(results, map[string]interface{}{"index": i, "status": status, "error": "rejected"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", db, "", false)
+	if err := client.SyncPendingReleases(context.Background()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+
+	if len(batchSizes) != 2 || batchSizes[0] != syncBatchSize || batchSizes[1] != 5 {
+		t.Errorf("Expected batches of %d and 5 releases, got %v", syncBatchSize, batchSizes)
+	}
+
+	pending, err := db.GetPendingReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := map[string]bool{}
+	for _, release := range pending {
+		remaining[release.WorkloadName] = true
+	}
+	want := []string{"web-001", names[syncBatchSize-1], names[len(names)-1]}
+	if len(remaining) != len(want) {
+		t.Errorf("Expected only %v to stay queued, got %v", want, remaining)
+	}
+	for _, name := range want {
+		if !remaining[name] {
+			t.Errorf("Expected %s to stay queued, got %v", name, remaining)
+		}
+	}
+}
+
+func TestSyncPendingReleasesFallsBackToSingleRequests(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+	seedPendingReleases(t, db, "web", "worker")
+
+	var singles int
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.NotFound(w, r)
+			return
+		}
+		singles++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", db, "", false)
+	if err := client.SyncPendingReleases(context.Background()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+
+	if singles != 2 {
+		t.Errorf("Expected one PUT per release against an older master, got %d", singles)
+	}
+	if pending, _ := db.GetPendingReleases(); len(pending) != 0 {
+		t.Errorf("Expected synced releases to be removed, got %+v", pending)
+	}
+}
+
+func TestSyncPendingReleasesRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int // status returned by master for each request, then 200
+		wantRequests  int
+		wantRemaining int
+		wantErr       bool // no batch reached master
+	}{
+		{"server errors are retried", []int{http.StatusServiceUnavailable, http.StatusBadGateway}, 3, 0, false},
+		{"retries stop at the limit", []int{500, 500, 500, 500, 500}, 3, 1, true},
+		{"in-flight idempotency conflicts are retried", []int{http.StatusConflict}, 2, 0, false},
+		{"client errors are not retried", []int{http.StatusUnauthorized}, 1, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("Failed to create test database: %v", err)
+			}
+			defer db.Close()
+			seedPendingReleases(t, db, "web")
+
+			var requests int
+			master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= len(tt.statuses) {
+					http.Error(w, "unavailable", tt.statuses[requests-1])
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"results": []map[string]interface{}{{"index": 0, "status": "success"}},
+				})
+			}))
+			defer master.Close()
+
+			client := New(master.URL, "", db, "", false)
+			client.SetMaxRetries(2)
+			client.retryDelay = time.Millisecond
+			if err := client.SyncPendingReleases(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("Expected sync error %t, got %v", tt.wantErr, err)
+			}
+
+			if requests != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, requests)
+			}
+			if pending, _ := db.GetPendingReleases(); len(pending) != tt.wantRemaining {
+				t.Errorf("Expected %d pending releases left, got %d", tt.wantRemaining, len(pending))
+			}
+		})
+	}
+}
+
+func TestSyncRetriesNetworkErrors(t *testing.T) {
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	master.Close()
+
+	client := New(master.URL, "", nil, "", false)
+	client.SetMaxRetries(2)
+	client.retryDelay = time.Millisecond
+
+	attempts := 0
+	err := client.withRetry(context.Background(), func() error {
+		attempts++
+		return client.syncSingleRelease(context.Background(), &database.PendingRelease{LastSeen: time.Now()})
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("Expected 3 attempts ending in an error against an unreachable master, got %d (%v)", attempts, err)
+	}
+}
+
+func TestSyncPendingReleasesMovesRejectedReleasesToFailed(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+	seedPendingReleases(t, db, "web")
+
+	unavailable := true
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unavailable {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []map[string]interface{}{{"index": 0, "status": "error", "error": "invalid image_sha"}},
+		})
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", db, "", false)
+	client.SetMaxAttempts(2)
+
+	// Outages of master do not count against the release
+	for i := 0; i < 3; i++ {
+		client.SyncPendingReleases(context.Background())
+	}
+	if pending, _ := db.GetPendingReleases(); len(pending) != 1 || pending[0].SyncAttempts != 0 {
+		t.Fatalf("Expected transient failures not to be counted, got %+v", pending)
+	}
+
+	unavailable = false
+	client.SyncPendingReleases(context.Background())
+	pending, _ := db.GetPendingReleases()
+	if len(pending) != 1 || pending[0].SyncAttempts != 1 || !strings.Contains(pending[0].LastError, "invalid image_sha") {
+		t.Fatalf("Expected one rejected attempt to be recorded, got %+v", pending)
+	}
+
+	client.SyncPendingReleases(context.Background())
+	if pending, _ := db.GetPendingReleases(); len(pending) != 0 {
+		t.Errorf("Expected the release to leave the queue after 2 rejected attempts, got %+v", pending)
+	}
+	failed, err := db.GetFailedReleases()
+	if err != nil {
+		t.Fatalf("Failed to get failed releases: %v", err)
+	}
+	if len(failed) != 1 || failed[0].WorkloadName != "web" || failed[0].SyncAttempts != 2 {
+		t.Errorf("Expected the release to be moved to failed releases, got %+v", failed)
+	}
+
+	// The next collection does not queue the failed release again
+	seedPendingReleases(t, db, "web")
+	if pending, _ := db.GetPendingReleases(); len(pending) != 0 {
+		t.Errorf("Expected a failed release not to be queued again, got %+v", pending)
+	}
+}
+
+func TestSyncPendingReleasesCollapsesUnchangedReleases(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	var synced []string
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var items []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			t.Errorf("Invalid batch body: %v", err)
+		}
+		var results []map[string]interface{}
+		for i, item := range items {
+			synced = append(synced, fmt.Sprintf("%s:%s", item["workload_name"], item["image_tag"]))
+			results = append(results, map[string]interface{}{"index": i, "status": "success"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer master.Close()
+
+	client := New(master.URL, "", db, "", false)
+	client.SetDedupWindow(time.Hour)
+	runSync := func() []string {
+		t.Helper()
+		synced = nil
+		if err := client.SyncPendingReleases(context.Background()); err != nil {
+			t.Fatalf("Unexpected sync error: %v", err)
+		}
+		return synced
+	}
+
+	seedPendingReleases(t, db, "web", "api")
+	if got := runSync(); len(got) != 2 {
+		t.Fatalf("Expected both releases to be synced, got %v", got)
+	}
+
+	// The next collections queue the same releases with newer timestamps, and a new one
+	time.Sleep(10 * time.Millisecond)
+	seedPendingReleases(t, db, "web", "api", "worker")
+	if got := runSync(); fmt.Sprint(got) != "[worker:v1.0.0]" {
+		t.Errorf("Expected only the new release to be synced within the dedup window, got %v", got)
+	}
+	if count, _ := db.CountPendingReleases(); count != 0 {
+		t.Errorf("Expected collapsed releases to leave the queue, got %d pending", count)
+	}
+
+	// Once the window is over unchanged releases are sent again, refreshing master's last_seen
+	for key, release := range client.lastSynced {
+		release.syncedAt = time.Now().Add(-time.Hour)
+		client.lastSynced[key] = release
+	}
+	seedPendingReleases(t, db, "web")
+	if got := runSync(); fmt.Sprint(got) != "[web:v1.0.0]" {
+		t.Errorf("Expected the release to be re-sent after the dedup window, got %v", got)
+	}
+}
+
+func TestCollapsePendingKeepsLatestPerComponentSHA(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	release := func(id int, name, sha string, seen time.Time) database.PendingRelease {
+		return database.PendingRelease{
+			ID: id, Namespace: "default", WorkloadName: name, WorkloadType: "Deployment", ContainerName: "app",
+			ImageSHA: sha, ClientName: "acme", EnvName: "prod", LastSeen: seen,
+		}
+	}
+	kept := New("", "", db, "", false).collapsePending([]database.PendingRelease{
+		release(1, "web", "sha-a", now.Add(-2*time.Minute)),
+		release(2, "web", "sha-b", now.Add(-time.Minute)),
+		release(3, "web", "sha-a", now),
+		release(4, "api", "sha-a", now.Add(-time.Minute)),
+	})
+
+	var ids []int
+	for _, r := range kept {
+		ids = append(ids, r.ID)
+	}
+	if fmt.Sprint(ids) != "[2 3 4]" {
+		t.Errorf("Expected one release per component and SHA, the latest, in queue order, got %v", ids)
+	}
+}
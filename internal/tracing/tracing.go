@@ -0,0 +1,107 @@
+// Package tracing sets up the OpenTelemetry tracer provider that records spans of collections, syncs and
+// HTTP requests and exports them to an OTLP/HTTP collector. Until Setup is called spans are not recorded.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"krelease-tracker/internal/version"
+)
+
+// instrumentationName names the tracer of the tracker's spans
+const instrumentationName = "krelease-tracker"
+
+// provider is the tracer provider set by Setup; nil while tracing is disabled
+var provider atomic.Pointer[sdktrace.TracerProvider]
+
+// Setup exports spans to the OTLP/HTTP collector at endpoint (e.g. "http://otel-collector:4318");
+// "/v1/traces" is appended unless the URL already ends with it. An empty endpoint leaves tracing disabled.
+// Spans are sampled as configured by OTEL_TRACES_SAMPLER, following the caller's decision and recording
+// every new trace by default, and trace context is propagated with W3C traceparent and baggage headers.
+func Setup(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName("krelease-tracker"), semconv.ServiceVersion(version.Version)),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults above
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	provider.Store(tp)
+	return nil
+}
+
+// Shutdown exports the spans still queued and disables tracing
+func Shutdown(ctx context.Context) error {
+	tp := provider.Swap(nil)
+	if tp == nil {
+		return nil
+	}
+	if err := tp.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to export remaining spans: %w", err)
+	}
+	return nil
+}
+
+// Enabled reports whether spans are recorded
+func Enabled() bool {
+	return provider.Load() != nil
+}
+
+// Start starts a span named name as a child of the span of ctx, if any. The returned context carries
+// the new span, which is not recording while tracing is disabled.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// StartServer starts the span of an incoming request, continuing the trace of the caller when its
+// headers carry one
+func StartServer(ctx context.Context, name string, header http.Header, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	return Start(ctx, name, append(opts, trace.WithSpanKind(trace.SpanKindServer))...)
+}
+
+// End ends span, marked as failed when err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject adds the trace context of ctx to the headers of an outgoing request, so the receiver
+// continues the trace
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDisabledTracingIsNoop(t *testing.T) {
+	ctx, span := Start(context.Background(), "collect releases", trace.WithAttributes(attribute.String("k8s.namespace.name", "default")))
+	if span.IsRecording() || Enabled() {
+		t.Fatal("Expected no recording span while tracing is disabled")
+	}
+	span.SetAttributes(attribute.Int("krelease.namespaces", 1))
+	End(span, errors.New("ignored"))
+
+	header := http.Header{}
+	Inject(ctx, header)
+	if header.Get("traceparent") != "" {
+		t.Errorf("Expected no traceparent while tracing is disabled, got %q", header.Get("traceparent"))
+	}
+}
+
+func TestSpansAreExported(t *testing.T) {
+	var mu sync.Mutex
+	var path string
+	var spans []*tracepb.Span
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read OTLP payload: %v", err)
+		}
+		var payload collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Failed to decode OTLP payload: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		path = r.URL.Path
+		for _, resource := range payload.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				spans = append(spans, scope.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	if err := Setup(collector.URL); err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// A request of a caller continuing its trace, with a child span that fails
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := StartServer(context.Background(), "GET /api/releases/current", header,
+		trace.WithAttributes(attribute.String("http.request.method", "GET")))
+	childCtx, child := Start(ctx, "collect namespace", trace.WithAttributes(attribute.String("k8s.namespace.name", "payments")))
+
+	outgoing := http.Header{}
+	Inject(childCtx, outgoing)
+	if !strings.HasPrefix(outgoing.Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("Expected the trace to be propagated, got %q", outgoing.Get("traceparent"))
+	}
+
+	End(child, errors.New("forbidden"))
+	server.SetAttributes(attribute.Int("http.response.status_code", 200))
+	End(server, nil)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Failed to shut down tracing: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path != "/v1/traces" {
+		t.Errorf("Expected spans to be sent to /v1/traces, got %q", path)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 exported spans, got %+v", spans)
+	}
+	childSpan, serverSpan := spans[0], spans[1]
+	if hex.EncodeToString(serverSpan.TraceId) != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		hex.EncodeToString(serverSpan.ParentSpanId) != "00f067aa0ba902b7" || serverSpan.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("Expected the server span to continue the caller's trace, got %+v", serverSpan)
+	}
+	if string(childSpan.TraceId) != string(serverSpan.TraceId) || string(childSpan.ParentSpanId) != string(serverSpan.SpanId) {
+		t.Errorf("Expected the child span to be a child of the server span, got %+v", childSpan)
+	}
+	if childSpan.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR || childSpan.Status.GetMessage() != "forbidden" {
+		t.Errorf("Expected the child span to be marked as failed, got %+v", childSpan.Status)
+	}
+	if serverSpan.Status.GetCode() != tracepb.Status_STATUS_CODE_UNSET {
+		t.Errorf("Expected no status on a successful span, got %+v", serverSpan.Status)
+	}
+	if len(childSpan.Attributes) != 1 || childSpan.Attributes[0].Key != "k8s.namespace.name" ||
+		childSpan.Attributes[0].Value.GetStringValue() != "payments" {
+		t.Errorf("Expected the namespace attribute, got %+v", childSpan.Attributes)
+	}
+}
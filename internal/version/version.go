@@ -0,0 +1,42 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Version is the application version reported in health checks, pings and the User-Agent
+const Version = "1.0.0"
+
+// SchemaVersion is the sync/ping payload schema version produced and understood by this build.
+// Bump it whenever the wire format changes in a way an older master cannot handle.
+const SchemaVersion = 1
+
+// MinSchemaVersion is the oldest payload schema version a master still accepts
+const MinSchemaVersion = 1
+
+// ErrUnsupportedSchemaVersion is returned by CheckSchemaVersion for payloads of a schema version this
+// build cannot handle
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema_version")
+
+// UserAgent returns the User-Agent header value used for outgoing master requests
+func UserAgent(schemaVersion int) string {
+	return fmt.Sprintf("krelease-tracker/%s (schema %d)", Version, schemaVersion)
+}
+
+// CheckSchemaVersion validates the schema version of an incoming payload.
+// A zero version means the payload predates versioning and is handled as MinSchemaVersion.
+func CheckSchemaVersion(schemaVersion int) error {
+	if schemaVersion == 0 {
+		return nil
+	}
+	if schemaVersion < MinSchemaVersion {
+		return fmt.Errorf("%w %d: this master accepts schema versions %d to %d, upgrade the slave",
+			ErrUnsupportedSchemaVersion, schemaVersion, MinSchemaVersion, SchemaVersion)
+	}
+	if schemaVersion > SchemaVersion {
+		return fmt.Errorf("%w %d: this master accepts schema versions %d to %d, upgrade the master or set SYNC_SCHEMA_VERSION=%d on the slave",
+			ErrUnsupportedSchemaVersion, schemaVersion, MinSchemaVersion, SchemaVersion, SchemaVersion)
+	}
+	return nil
+}
@@ -103,6 +103,47 @@ class EnvironmentComparison {
         return fetchOptions;
     }
 
+    // Fetch all current releases of an environment, following the API's pages
+    async fetchCurrentReleases(envName) {
+        const pageSize = 1000;
+        let merged = null;
+
+        for (let offset = 0; ; offset += pageSize) {
+            const params = new URLSearchParams();
+            params.append('client_name', this.selectedClient);
+            params.append('env_name', envName);
+            params.append('limit', pageSize);
+            params.append('offset', offset);
+
+            const url = `${this.basePath}/api/releases/current?${params}`;
+            const response = await fetch(url, this.getFetchOptions());
+            if (!response.ok) {
+                return { response, data: null };
+            }
+
+            const data = await response.json();
+            if (!merged) {
+                merged = data;
+            } else {
+                Object.entries(data.namespaces || {}).forEach(([namespace, releases]) => {
+                    merged.namespaces[namespace] = (merged.namespaces[namespace] || []).concat(releases);
+                });
+                (data.ordered_namespaces || []).forEach(nsData => {
+                    const existing = merged.ordered_namespaces.find(ns => ns.name === nsData.name);
+                    if (existing) {
+                        existing.releases = existing.releases.concat(nsData.releases);
+                    } else {
+                        merged.ordered_namespaces.push(nsData);
+                    }
+                });
+            }
+
+            if (offset + pageSize >= (data.total || 0)) {
+                return { response, data: merged };
+            }
+        }
+    }
+
     // Show loading state
     showLoading() {
         document.getElementById('loading').style.display = 'block';
@@ -202,19 +243,13 @@ class EnvironmentComparison {
     async fetchEnvironmentData() {
         const fetchPromises = this.environments.map(async (envName) => {
             try {
-                const params = new URLSearchParams();
-                params.append('client_name', this.selectedClient);
-                params.append('env_name', envName);
-
-                const url = `${this.basePath}/api/releases/current?${params}`;
-                const response = await fetch(url, this.getFetchOptions());
+                const { response, data } = await this.fetchCurrentReleases(envName);
 
                 if (!response.ok) {
                     console.warn(`Failed to fetch releases for environment ${envName}:`, response.status);
                     return;
                 }
 
-                const data = await response.json();
                 this.environmentData[envName] = data;
             } catch (error) {
                 console.warn(`Error fetching releases for environment ${envName}:`, error);
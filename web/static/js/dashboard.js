@@ -104,6 +104,47 @@ class Dashboard {
         return fetchOptions;
     }
 
+    // Fetch all current releases of an environment, following the API's pages
+    async fetchCurrentReleases(envName) {
+        const pageSize = 1000;
+        let merged = null;
+
+        for (let offset = 0; ; offset += pageSize) {
+            const params = new URLSearchParams();
+            params.append('client_name', this.selectedClient);
+            params.append('env_name', envName);
+            params.append('limit', pageSize);
+            params.append('offset', offset);
+
+            const url = `${this.basePath}/api/releases/current?${params}`;
+            const response = await fetch(url, this.getFetchOptions());
+            if (!response.ok) {
+                return { response, data: null };
+            }
+
+            const data = await response.json();
+            if (!merged) {
+                merged = data;
+            } else {
+                Object.entries(data.namespaces || {}).forEach(([namespace, releases]) => {
+                    merged.namespaces[namespace] = (merged.namespaces[namespace] || []).concat(releases);
+                });
+                (data.ordered_namespaces || []).forEach(nsData => {
+                    const existing = merged.ordered_namespaces.find(ns => ns.name === nsData.name);
+                    if (existing) {
+                        existing.releases = existing.releases.concat(nsData.releases);
+                    } else {
+                        merged.ordered_namespaces.push(nsData);
+                    }
+                });
+            }
+
+            if (offset + pageSize >= (data.total || 0)) {
+                return { response, data: merged };
+            }
+        }
+    }
+
     // Handle authentication errors
     handleAuthError(error, response) {
         if (response && response.status === 401) {
@@ -767,20 +808,13 @@ class Dashboard {
         }
 
         try {
-            const params = new URLSearchParams();
-            params.append('client_name', this.selectedClient);
-            params.append('env_name', this.selectedEnvironment);
-
-            const url = `${this.basePath}/api/releases/current?${params}`;
-            const response = await fetch(url, this.getFetchOptions());
+            const { response, data } = await this.fetchCurrentReleases(this.selectedEnvironment);
 
             if (!response.ok) {
                 this.handleAuthError(null, response);
                 return;
             }
 
-            const data = await response.json();
-
             // Extract releases from the nested structure
             let releasesArray = [];
             if (Array.isArray(data)) {
@@ -895,12 +929,7 @@ class Dashboard {
         this.hideSuccess();
 
         try {
-            const params = new URLSearchParams();
-            params.append('client_name', this.selectedClient);
-            params.append('env_name', this.selectedEnvironment);
-
-            const url = `${this.basePath}/api/releases/current?${params}`;
-            const response = await fetch(url, this.getFetchOptions());
+            const { response, data } = await this.fetchCurrentReleases(this.selectedEnvironment);
 
             if (!response.ok) {
                 if (this.handleAuthError(null, response)) {
@@ -909,7 +938,6 @@ class Dashboard {
                 throw new Error(`HTTP ${response.status}: ${response.statusText}`);
             }
 
-            const data = await response.json();
             this.processReleasesData(data);
             this.updateStats(data);
             this.renderTable();
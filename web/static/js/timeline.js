@@ -86,6 +86,47 @@ class Timeline {
         return fetchOptions;
     }
 
+    // Fetch all current releases of an environment, following the API's pages
+    async fetchCurrentReleases(envName) {
+        const pageSize = 1000;
+        let merged = null;
+
+        for (let offset = 0; ; offset += pageSize) {
+            const params = new URLSearchParams();
+            params.append('client_name', this.selectedClient);
+            params.append('env_name', envName);
+            params.append('limit', pageSize);
+            params.append('offset', offset);
+
+            const url = `${this.basePath}/api/releases/current?${params}`;
+            const response = await fetch(url, this.getFetchOptions());
+            if (!response.ok) {
+                return { response, data: null };
+            }
+
+            const data = await response.json();
+            if (!merged) {
+                merged = data;
+            } else {
+                Object.entries(data.namespaces || {}).forEach(([namespace, releases]) => {
+                    merged.namespaces[namespace] = (merged.namespaces[namespace] || []).concat(releases);
+                });
+                (data.ordered_namespaces || []).forEach(nsData => {
+                    const existing = merged.ordered_namespaces.find(ns => ns.name === nsData.name);
+                    if (existing) {
+                        existing.releases = existing.releases.concat(nsData.releases);
+                    } else {
+                        merged.ordered_namespaces.push(nsData);
+                    }
+                });
+            }
+
+            if (offset + pageSize >= (data.total || 0)) {
+                return { response, data: merged };
+            }
+        }
+    }
+
     // Handle authentication errors
     handleAuthError(error, response) {
         if (response && response.status === 401) {
@@ -249,12 +290,7 @@ class Timeline {
         this.hideError();
 
         try {
-            const params = new URLSearchParams();
-            params.append('client_name', this.selectedClient);
-            params.append('env_name', this.selectedEnvironment);
-
-            const url = `${this.basePath}/api/releases/current?${params}`;
-            const response = await fetch(url, this.getFetchOptions());
+            const { response, data } = await this.fetchCurrentReleases(this.selectedEnvironment);
             if (!response.ok) {
                 if (this.handleAuthError(null, response)) {
                     return;
@@ -262,7 +298,6 @@ class Timeline {
                 throw new Error(`HTTP ${response.status}: ${response.statusText}`);
             }
 
-            const data = await response.json();
             this.processComponentsData(data);
             this.populateNamespaceSelect();
         } catch (error) {
@@ -747,6 +782,9 @@ class Timeline {
     }
 
     getChangeType(release, index) {
+        // Flagged by the server when the tag is lower than the one it replaced
+        if (release.is_rollback) return 'rollback';
+
         if (index === 0) return 'latest';
 
         const previousRelease = this.releases[index - 1];
@@ -776,6 +814,8 @@ class Timeline {
                 return '🏷️ Tag Update';
             case 'new-deployment':
                 return '🆕 New';
+            case 'rollback':
+                return '⏪ Rollback';
             case 'latest':
             default:
                 return '';